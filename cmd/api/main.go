@@ -1,16 +1,62 @@
 package main
 
 import (
+	"authentication-jwt/internal/lifecycle"
+	"authentication-jwt/internal/logging"
 	"authentication-jwt/internal/server"
-	"fmt"
-	"net/http"
+	"authentication-jwt/internal/tracing"
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const defaultShutdownTimeout = 30 * time.Second
+
 func main() {
-	server := server.NewServer()
+	if err := logging.Setup(); err != nil {
+		logging.Fatal("Failed to configure logging", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(ctx, os.Args[2:]); err != nil {
+			logging.Fatal("Migration failed", "error", err)
+		}
+		return
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			logging.Fatal("Invalid SHUTDOWN_TIMEOUT", "error", err)
+		}
+		shutdownTimeout = timeout
+	}
+
+	lc := lifecycle.New()
+	if value := os.Getenv("SHUTDOWN_DRAIN_DELAY"); value != "" {
+		delay, err := time.ParseDuration(value)
+		if err != nil {
+			logging.Fatal("Invalid SHUTDOWN_DRAIN_DELAY", "error", err)
+		}
+		lc.SetDrainDelay(delay)
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		logging.Fatal("Failed to configure tracing", "error", err)
+	}
+	// Registered first so spans of the shutdown itself are flushed last.
+	lc.OnStop("tracing", shutdownTracing)
+
+	server := server.NewServer(lc)
 
-	err := server.ListenAndServe()
-	if err != nil && err != http.ErrServerClosed {
-		panic(fmt.Sprintf("Failed to start server: %v", err))
+	if err := lc.Serve(ctx, server, shutdownTimeout); err != nil {
+		logging.Fatal("Server stopped with an error", "error", err)
 	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/repositories/sqlstore"
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// migrate runs the migrate command: "migrate" (or "migrate up") applies the
+// pending migrations of the database chosen with DATABASE_DRIVER, "migrate
+// status" lists them without applying anything.
+func migrate(ctx context.Context, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	if command != "up" && command != "status" {
+		return fmt.Errorf("unknown migrate command %q, want up or status", command)
+	}
+
+	var statuses []repositories.MigrationStatus
+	switch driver := os.Getenv("DATABASE_DRIVER"); driver {
+	case "", "mongodb":
+		connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		db, err := database.Connect(connectCtx, os.Getenv("DATABASE_URI"), os.Getenv("DATABASE_NAME"))
+		if err != nil {
+			return err
+		}
+		defer db.Disconnect(context.Background())
+
+		if command == "up" {
+			if err := repositories.Migrate(ctx, db); err != nil {
+				return err
+			}
+		}
+
+		statuses, err = repositories.GetMigrationStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+	case string(sqlstore.Postgres), string(sqlstore.SQLite):
+		store, err := sqlstore.Connect(ctx, sqlstore.Dialect(driver), os.Getenv("SQL_DATABASE_URL"))
+		if err != nil {
+			return err
+		}
+		defer store.Close(context.Background())
+
+		if command == "up" {
+			if err := store.Migrate(ctx); err != nil {
+				return err
+			}
+		}
+
+		statuses, err = store.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown database driver %q", driver)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED AT\tDESCRIPTION")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status.Version, appliedAt, status.Description)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,193 @@
+// Package accounts purges the accounts deleted by their users once their
+// grace period is over.
+package accounts
+
+import (
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultGracePeriod is how long a deleted account can be restored before
+	// it is purged.
+	DefaultGracePeriod = 30 * 24 * time.Hour
+
+	purgeInterval  = time.Hour
+	purgeBatchSize = 100
+)
+
+// LoadGracePeriod reads the grace period of deleted accounts from
+// ACCOUNT_DELETION_GRACE_PERIOD, a duration such as "720h". Zero purges the
+// accounts right away.
+func LoadGracePeriod() (time.Duration, error) {
+	value := os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD")
+	if value == "" {
+		return DefaultGracePeriod, nil
+	}
+
+	gracePeriod, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD: %w", err)
+	}
+
+	if gracePeriod < 0 {
+		return 0, errors.New("ACCOUNT_DELETION_GRACE_PERIOD can't be negative")
+	}
+
+	return gracePeriod, nil
+}
+
+// Purger hard deletes accounts with everything tied to them: sessions,
+// one-time tokens and codes, group memberships, the mail sent to them and
+// their domain events and webhook deliveries.
+// Their audit events are kept, with the user erased from them.
+type Purger struct {
+	userRepository            repositories.UserRepositoryInterface
+	refreshTokenRepository    repositories.RefreshTokenRepositoryInterface
+	oneTimeTokenRepository    repositories.OneTimeTokenRepositoryInterface
+	groupRepository           repositories.GroupRepositoryInterface
+	auditLogRepository        repositories.AuditLogRepositoryInterface
+	mailOutboxRepository      repositories.MailOutboxRepositoryInterface
+	eventOutboxRepository     repositories.EventOutboxRepositoryInterface
+	webhookDeliveryRepository repositories.WebhookDeliveryRepositoryInterface
+	eventBus                  *events.Bus
+	gracePeriod               time.Duration
+}
+
+func NewPurger(userRepository repositories.UserRepositoryInterface, refreshTokenRepository repositories.RefreshTokenRepositoryInterface, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface, groupRepository repositories.GroupRepositoryInterface, auditLogRepository repositories.AuditLogRepositoryInterface, mailOutboxRepository repositories.MailOutboxRepositoryInterface, eventOutboxRepository repositories.EventOutboxRepositoryInterface, webhookDeliveryRepository repositories.WebhookDeliveryRepositoryInterface, eventBus *events.Bus, gracePeriod time.Duration) *Purger {
+	return &Purger{
+		userRepository:            userRepository,
+		refreshTokenRepository:    refreshTokenRepository,
+		oneTimeTokenRepository:    oneTimeTokenRepository,
+		groupRepository:           groupRepository,
+		auditLogRepository:        auditLogRepository,
+		mailOutboxRepository:      mailOutboxRepository,
+		eventOutboxRepository:     eventOutboxRepository,
+		webhookDeliveryRepository: webhookDeliveryRepository,
+		eventBus:                  eventBus,
+		gracePeriod:               gracePeriod,
+	}
+}
+
+// GracePeriod is how long deleted accounts are kept before being purged.
+func (p *Purger) GracePeriod() time.Duration {
+	return p.gracePeriod
+}
+
+// Run purges the accounts whose grace period is over until the context is
+// cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.PurgeDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDue purges the accounts deleted more than the grace period ago.
+// Accounts failing to be purged are tried again on the next run.
+func (p *Purger) PurgeDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		before := time.Now().Add(-p.gracePeriod)
+		users, err := p.userRepository.ListDeleted(ctx, before, purgeBatchSize)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list deleted accounts", "error", err)
+			return
+		}
+
+		failed := false
+		for _, user := range users {
+			// The user may have restored the account since it was listed.
+			err := p.purge(ctx, user, "self_service", func(ctx context.Context) error {
+				return p.userRepository.DeleteDeleted(ctx, user.ID.Hex(), before)
+			})
+			if errors.Is(err, repositories.ErrNotFound) {
+				slog.InfoContext(ctx, "Skipped purging a restored account", "user_id", user.ID.Hex())
+				continue
+			}
+
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to purge account", "user_id", user.ID.Hex(), "error", err)
+				failed = true
+			}
+		}
+
+		// The failed accounts would be listed again.
+		if failed || len(users) < purgeBatchSize {
+			return
+		}
+	}
+}
+
+// Purge deletes the user and everything tied to it in one transaction, along
+// with the UserDeleted event. source tells how the account was deleted.
+func (p *Purger) Purge(ctx context.Context, user *models.User, source string) error {
+	return p.purge(ctx, user, source, func(ctx context.Context) error {
+		return p.userRepository.Delete(ctx, user.ID.Hex())
+	})
+}
+
+// purge runs Purge with deleteUser deleting the user. It is called first, so
+// that nothing else is deleted when it fails, even without a transaction.
+func (p *Purger) purge(ctx context.Context, user *models.User, source string, deleteUser func(ctx context.Context) error) error {
+	return p.eventBus.Commit(ctx, func(ctx context.Context) error {
+		if err := deleteUser(ctx); err != nil {
+			return err
+		}
+
+		if err := p.refreshTokenRepository.DeleteByUser(ctx, user.ID); err != nil {
+			return err
+		}
+
+		for _, purpose := range models.OneTimeTokenPurposes {
+			if err := p.oneTimeTokenRepository.DeleteByUser(ctx, user.ID, purpose); err != nil {
+				return err
+			}
+		}
+
+		if err := p.groupRepository.RemoveMember(ctx, user.ID); err != nil {
+			return err
+		}
+
+		// Failed logins and registrations have no actor, only the email or
+		// username that was tried.
+		var identifiers []string
+		for _, identifier := range []string{user.Email, user.PendingEmail, user.Username} {
+			if identifier != "" {
+				identifiers = append(identifiers, identifier)
+			}
+		}
+		if err := p.auditLogRepository.AnonymizeUser(ctx, user.ID.Hex(), identifiers); err != nil {
+			return err
+		}
+
+		for _, recipient := range []string{user.Email, user.PendingEmail} {
+			if recipient == "" {
+				continue
+			}
+			if err := p.mailOutboxRepository.DeleteByRecipient(ctx, recipient); err != nil {
+				return err
+			}
+		}
+
+		// The UserDeleted event is appended after this, and still delivered.
+		if err := p.eventOutboxRepository.DeleteByUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return p.webhookDeliveryRepository.DeleteByUser(ctx, user.ID)
+	}, models.NewDomainEvent(models.EventUserDeleted, user, map[string]string{"source": source}))
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator checks a login identifier and password and returns the local
+// user they belong to. It returns ErrInvalidCredentials when the credentials
+// are rejected.
+type Authenticator interface {
+	Authenticate(ctx context.Context, identifier, password string) (*models.User, error)
+}
+
+// PasswordAuthenticator checks the bcrypt password stored in the users
+// collection. The identifier is the email or the username of the user.
+type PasswordAuthenticator struct {
+	userRepository repositories.UserRepositoryInterface
+}
+
+func NewPasswordAuthenticator(userRepository repositories.UserRepositoryInterface) *PasswordAuthenticator {
+	return &PasswordAuthenticator{
+		userRepository: userRepository,
+	}
+}
+
+func (a *PasswordAuthenticator) Authenticate(ctx context.Context, identifier, password string) (*models.User, error) {
+	var user *models.User
+	var err error
+	if strings.Contains(identifier, "@") {
+		user, err = a.userRepository.FindByEmail(ctx, identifier)
+	} else {
+		user, err = a.userRepository.FindByUsername(ctx, identifier)
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !CheckPasswordHash(ctx, password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// ChainAuthenticator tries each authenticator in order until one accepts the
+// credentials.
+type ChainAuthenticator []Authenticator
+
+func (chain ChainAuthenticator) Authenticate(ctx context.Context, identifier, password string) (*models.User, error) {
+	for _, authenticator := range chain {
+		user, err := authenticator.Authenticate(ctx, identifier, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		return user, err
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+// LoadAuthenticator builds the authenticator selected by AUTH_BACKENDS, a
+// comma separated list of "local" and "ldap" tried in order. It defaults to
+// "local".
+func LoadAuthenticator(userRepository repositories.UserRepositoryInterface) (Authenticator, error) {
+	backends := splitList(os.Getenv("AUTH_BACKENDS"))
+	if len(backends) == 0 {
+		backends = []string{"local"}
+	}
+
+	chain := ChainAuthenticator{}
+	for _, backend := range backends {
+		switch strings.ToLower(backend) {
+		case "local":
+			chain = append(chain, NewPasswordAuthenticator(userRepository))
+		case "ldap":
+			authenticator, err := NewLDAPAuthenticator(LoadLDAPConfig(), userRepository)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, authenticator)
+		default:
+			return nil, fmt.Errorf("unknown authentication backend %q", backend)
+		}
+	}
+
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+
+	return chain, nil
+}
+
+// ExternalUsername derives a username for a just-in-time provisioned user,
+// passing models.CheckUsername and padded to the minimum length accepted by
+// models.User. Providers often send an email as the preferred username, only
+// its local part is kept.
+func ExternalUsername(preferredUsername, email string) string {
+	username := preferredUsername
+	if username == "" {
+		username = email
+	}
+	username, _, _ = strings.Cut(username, "@")
+
+	if len(username) < 6 || models.IsReservedUsername(username) {
+		suffix, _ := RandomString(6)
+		username = username + "_" + suffix
+	}
+
+	return username
+}
+
+// inDomains tells whether the domain of the email is one of domains. Their
+// subdomains aren't included.
+func inDomains(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := email[at+1:]
+	return slices.ContainsFunc(domains, func(trusted string) bool {
+		return strings.EqualFold(trusted, domain)
+	})
+}
+
+// CreateExternalUser stores a just-in-time provisioned user. The username
+// derived from the provider may be taken by another account, in which case a
+// random suffix is appended until it is unique.
+func CreateExternalUser(ctx context.Context, userRepository repositories.UserRepositoryInterface, user *models.User) error {
+	username := user.Username
+	for attempt := 0; ; attempt++ {
+		err := userRepository.Create(ctx, user)
+		if !errors.Is(err, repositories.ErrDuplicateUsername) || attempt == 3 {
+			return err
+		}
+
+		suffix, err := RandomString(6)
+		if err != nil {
+			return err
+		}
+		user.Username = username + "_" + suffix
+	}
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	ldapProvider = "ldap"
+
+	// defaultLDAPTimeout bounds the connection and every request to the LDAP
+	// server, so that an unresponsive server doesn't hold logins forever.
+	defaultLDAPTimeout = 10 * time.Second
+)
+
+type LDAPConfig struct {
+	URL               string
+	BindDN            string
+	BindPassword      string
+	BaseDN            string
+	UserFilter        string
+	EmailAttribute    string
+	UsernameAttribute string
+	GroupAttribute    string
+	GroupRoles        map[string]string
+	JITProvisioning   bool
+	// LinkByEmail links an LDAP entry to the local account with the same
+	// email. Without it, entries are only matched to the accounts they were
+	// linked to, and accounts with the same email can't log in through LDAP.
+	LinkByEmail bool
+	Timeout     time.Duration
+}
+
+// LoadLDAPConfig reads the LDAP_* environment variables. LDAP_GROUP_ROLES maps
+// group DNs to roles as "groupDN:role" pairs separated by ";".
+func LoadLDAPConfig() LDAPConfig {
+	cfg := LDAPConfig{
+		URL:               os.Getenv("LDAP_URL"),
+		BindDN:            os.Getenv("LDAP_BIND_DN"),
+		BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:            os.Getenv("LDAP_BASE_DN"),
+		UserFilter:        os.Getenv("LDAP_USER_FILTER"),
+		EmailAttribute:    os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		UsernameAttribute: os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+		GroupAttribute:    os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		GroupRoles:        map[string]string{},
+		JITProvisioning:   os.Getenv("LDAP_JIT_PROVISIONING") == "true",
+		LinkByEmail:       os.Getenv("LDAP_LINK_BY_EMAIL") == "true",
+	}
+
+	for _, mapping := range strings.Split(os.Getenv("LDAP_GROUP_ROLES"), ";") {
+		index := strings.LastIndex(mapping, ":")
+		if index <= 0 {
+			continue
+		}
+		group := strings.ToLower(strings.TrimSpace(mapping[:index]))
+		cfg.GroupRoles[group] = strings.TrimSpace(mapping[index+1:])
+	}
+
+	return cfg
+}
+
+// LDAPAuthenticator authenticates against an LDAP or Active Directory server.
+// It searches the user entry with a service account, binds as that entry with
+// the given password and maps the entry onto a local user.
+type LDAPAuthenticator struct {
+	config         LDAPConfig
+	userRepository repositories.UserRepositoryInterface
+}
+
+func NewLDAPAuthenticator(cfg LDAPConfig, userRepository repositories.UserRepositoryInterface) (*LDAPAuthenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required for the ldap backend")
+	}
+
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(mail=%s)"
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = "uid"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultLDAPTimeout
+	}
+
+	return &LDAPAuthenticator{
+		config:         cfg,
+		userRepository: userRepository,
+	}, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, identifier, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which many servers
+	// accept for any DN.
+	if identifier == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: a.config.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(a.config.Timeout)
+
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind LDAP service account: %w", err)
+		}
+	}
+
+	search := ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // size limit, more than one entry is ambiguous
+		0,
+		false,
+		strings.ReplaceAll(a.config.UserFilter, "%s", ldap.EscapeFilter(identifier)),
+		[]string{a.config.EmailAttribute, a.config.UsernameAttribute, a.config.GroupAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(search)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search LDAP user: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("failed to bind LDAP user: %w", err)
+	}
+
+	return a.resolveUser(ctx, entry)
+}
+
+func (a *LDAPAuthenticator) resolveUser(ctx context.Context, entry *ldap.Entry) (*models.User, error) {
+	email := entry.GetAttributeValue(a.config.EmailAttribute)
+	roles := a.mapRoles(entry.GetAttributeValues(a.config.GroupAttribute))
+
+	user, err := a.userRepository.FindByIdentity(ctx, ldapProvider, entry.DN)
+	if errors.Is(err, repositories.ErrNotFound) && email != "" && a.config.LinkByEmail {
+		user, err = a.userRepository.FindByEmail(ctx, email)
+	}
+
+	if errors.Is(err, repositories.ErrNotFound) {
+		if !a.config.JITProvisioning || email == "" {
+			return nil, ErrInvalidCredentials
+		}
+
+		username := ExternalUsername(entry.GetAttributeValue(a.config.UsernameAttribute), email)
+
+		user, err = models.NewExternalUser(username, email)
+		if err != nil {
+			return nil, err
+		}
+		user.LinkIdentity(ldapProvider, entry.DN)
+		user.Roles = roles
+
+		if err := CreateExternalUser(ctx, a.userRepository, user); err != nil {
+			// The email belongs to an account not linked to the entry.
+			if errors.Is(err, repositories.ErrDuplicateEmail) {
+				return nil, ErrInvalidCredentials
+			}
+			return nil, err
+		}
+
+		return user, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.HasIdentity(ldapProvider, entry.DN) || (len(a.config.GroupRoles) > 0 && !slices.Equal(user.Roles, roles)) {
+		err := repositories.UpdateUser(ctx, a.userRepository, user, func(user *models.User) error {
+			user.LinkIdentity(ldapProvider, entry.DN)
+			if len(a.config.GroupRoles) > 0 {
+				user.Roles = roles
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (a *LDAPAuthenticator) mapRoles(groups []string) []string {
+	roles := []string{}
+	for _, group := range groups {
+		role, ok := a.config.GroupRoles[strings.ToLower(group)]
+		if ok && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
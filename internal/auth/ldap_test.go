@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories/memory"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapEntry is an entry of the fake directory, found by its mail.
+type ldapEntry struct {
+	dn       string
+	password string
+	mail     string
+	uid      string
+	groups   []string
+}
+
+// fakeLDAPServer answers the binds and searches of LDAPAuthenticator. When
+// hang is set, it accepts connections and never answers.
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  []ldapEntry
+	hang     bool
+}
+
+func newFakeLDAPServer(t *testing.T, hang bool, entries ...ldapEntry) *fakeLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeLDAPServer{listener: listener, entries: entries, hang: hang}
+	go server.serve()
+	return server
+}
+
+func (s *fakeLDAPServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if s.hang {
+			continue
+		}
+
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := request.Children[1].Data.String(), request.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if dn == "cn=service,dc=example,dc=com" && password == "service" {
+				code = ldap.LDAPResultSuccess
+			}
+			for _, entry := range s.entries {
+				if dn == entry.dn && password == entry.password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			for _, entry := range s.entries {
+				if filter == fmt.Sprintf("(mail=%s)", ldap.EscapeFilter(entry.mail)) {
+					conn.Write(ldapSearchEntry(messageID, entry).Bytes())
+				}
+			}
+			conn.Write(ldapResponse(messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func ldapEnvelope(messageID int64, response *ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	envelope.AppendChild(response)
+	return envelope
+}
+
+func ldapResponse(messageID int64, tag ber.Tag, code int) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return ldapEnvelope(messageID, response)
+}
+
+func ldapSearchEntry(messageID int64, entry ldapEntry) *ber.Packet {
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range map[string][]string{"mail": {entry.mail}, "uid": {entry.uid}, "memberOf": entry.groups} {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Name"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+	response.AppendChild(attributes)
+	return ldapEnvelope(messageID, response)
+}
+
+func newTestLDAPAuthenticator(t *testing.T, server *fakeLDAPServer, cfg LDAPConfig) (*LDAPAuthenticator, *memory.UserRepository) {
+	t.Helper()
+	cfg.URL = server.url()
+	cfg.BindDN = "cn=service,dc=example,dc=com"
+	cfg.BindPassword = "service"
+	cfg.BaseDN = "dc=example,dc=com"
+	cfg.GroupRoles = map[string]string{"cn=admins,dc=example,dc=com": "admin"}
+
+	userRepository := memory.NewUserRepository()
+	authenticator, err := NewLDAPAuthenticator(cfg, userRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticator, userRepository
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	alice := ldapEntry{
+		dn:       "uid=alice,dc=example,dc=com",
+		password: "correct-horse",
+		mail:     "alice@example.com",
+		uid:      "alice01",
+		groups:   []string{"cn=admins,dc=example,dc=com"},
+	}
+
+	t.Run("JITProvisioning", func(t *testing.T) {
+		authenticator, userRepository := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, false, alice), LDAPConfig{JITProvisioning: true})
+
+		user, err := authenticator.Authenticate(ctx, alice.mail, alice.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "alice01" || !user.HasIdentity(ldapProvider, alice.dn) || len(user.Roles) != 1 || user.Roles[0] != "admin" {
+			t.Errorf("provisioned user = %+v", user)
+		}
+
+		again, err := authenticator.Authenticate(ctx, alice.mail, alice.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.ID != user.ID {
+			t.Errorf("second login resolved user %s, want %s", again.ID.Hex(), user.ID.Hex())
+		}
+
+		if _, err := userRepository.FindByIdentity(ctx, ldapProvider, alice.dn); err != nil {
+			t.Errorf("FindByIdentity = %v", err)
+		}
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		authenticator, _ := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, false, alice), LDAPConfig{JITProvisioning: true})
+
+		for _, credentials := range [][2]string{{alice.mail, "wrong-password"}, {alice.mail, ""}, {"nobody@example.com", alice.password}} {
+			if _, err := authenticator.Authenticate(ctx, credentials[0], credentials[1]); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Authenticate(%q, %q) = %v, want ErrInvalidCredentials", credentials[0], credentials[1], err)
+			}
+		}
+	})
+
+	t.Run("WithoutJITProvisioning", func(t *testing.T) {
+		authenticator, _ := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, false, alice), LDAPConfig{})
+
+		if _, err := authenticator.Authenticate(ctx, alice.mail, alice.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate of an unknown user = %v, want ErrInvalidCredentials", err)
+		}
+	})
+
+	t.Run("ExistingEmail", func(t *testing.T) {
+		for _, cfg := range []LDAPConfig{{}, {JITProvisioning: true}} {
+			authenticator, userRepository := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, false, alice), cfg)
+			local, err := models.NewUser("alice_local", alice.mail, "local-password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := userRepository.Create(ctx, local); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := authenticator.Authenticate(ctx, alice.mail, alice.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Authenticate of an unlinked account with JITProvisioning %t = %v, want ErrInvalidCredentials", cfg.JITProvisioning, err)
+			}
+
+			found, err := userRepository.FindById(ctx, local.ID.Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found.HasIdentity(ldapProvider, alice.dn) {
+				t.Errorf("entry linked to the local account with JITProvisioning %t", cfg.JITProvisioning)
+			}
+		}
+	})
+
+	t.Run("LinkByEmail", func(t *testing.T) {
+		authenticator, userRepository := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, false, alice), LDAPConfig{LinkByEmail: true})
+		local, err := models.NewUser("alice_local", alice.mail, "local-password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := userRepository.Create(ctx, local); err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := authenticator.Authenticate(ctx, alice.mail, alice.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != local.ID || !user.HasIdentity(ldapProvider, alice.dn) {
+			t.Errorf("linked user = %+v, want %s", user, local.ID.Hex())
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		authenticator, _ := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, true, alice), LDAPConfig{JITProvisioning: true, Timeout: 100 * time.Millisecond})
+
+		start := time.Now()
+		_, err := authenticator.Authenticate(ctx, alice.mail, alice.password)
+		if err == nil || errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate against a hanging server = %v, want an error", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Authenticate took %s", elapsed)
+		}
+	})
+}
+
+func TestChainAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	alice := ldapEntry{dn: "uid=alice,dc=example,dc=com", password: "correct-horse", mail: "alice@example.com", uid: "alice01"}
+	authenticator, userRepository := newTestLDAPAuthenticator(t, newFakeLDAPServer(t, false, alice), LDAPConfig{JITProvisioning: true})
+
+	hash, err := HashPassword(ctx, "local-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := models.NewUser("bob_local", "bob@example.com", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userRepository.Create(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := ChainAuthenticator{NewPasswordAuthenticator(userRepository), authenticator}
+
+	if user, err := chain.Authenticate(ctx, "bob@example.com", "local-password"); err != nil || user.ID != local.ID {
+		t.Errorf("Authenticate of a local user = %v, %v", user, err)
+	}
+	if user, err := chain.Authenticate(ctx, alice.mail, alice.password); err != nil || !user.HasIdentity(ldapProvider, alice.dn) {
+		t.Errorf("Authenticate of an LDAP user = %v, %v", user, err)
+	}
+	if _, err := chain.Authenticate(ctx, "bob@example.com", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate with a wrong password = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLoadLDAPConfig(t *testing.T) {
+	t.Setenv("LDAP_GROUP_ROLES", "CN=Admins,DC=example,DC=com:admin; cn=support,dc=example,dc=com:support;invalid")
+
+	cfg := LoadLDAPConfig()
+	expected := map[string]string{"cn=admins,dc=example,dc=com": "admin", "cn=support,dc=example,dc=com": "support"}
+	if len(cfg.GroupRoles) != len(expected) {
+		t.Errorf("GroupRoles = %v, want %v", cfg.GroupRoles, expected)
+	}
+	for group, role := range expected {
+		if cfg.GroupRoles[group] != role {
+			t.Errorf("GroupRoles[%q] = %q, want %q", group, cfg.GroupRoles[group], role)
+		}
+	}
+}
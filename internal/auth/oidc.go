@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// TrustedDomains are the email domains the provider is authoritative for.
+	// Logging in with a verified email of one of them links the identity to
+	// the existing account with that email.
+	TrustedDomains []string
+}
+
+// OIDCProvider is a connector to an upstream OpenID Connect identity provider
+// such as Google or Microsoft.
+type OIDCProvider struct {
+	Name           string
+	config         oauth2.Config
+	verifier       *oidc.IDTokenVerifier
+	trustedDomains []string
+}
+
+// OIDCIdentity holds the claims read from a verified ID token.
+type OIDCIdentity struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+func NewOIDCProvider(ctx context.Context, cfg OIDCProviderConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %w", cfg.Name, err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	return &OIDCProvider{
+		Name: cfg.Name,
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:       provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		trustedDomains: cfg.TrustedDomains,
+	}, nil
+}
+
+// LoadOIDCProviders reads the providers listed in OIDC_PROVIDERS. Each provider
+// is configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID,
+// OIDC_<NAME>_CLIENT_SECRET, OIDC_<NAME>_REDIRECT_URL, OIDC_<NAME>_SCOPES and
+// OIDC_<NAME>_TRUSTED_DOMAINS.
+func LoadOIDCProviders(ctx context.Context) (map[string]*OIDCProvider, error) {
+	providers := map[string]*OIDCProvider{}
+
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		cfg := OIDCProviderConfig{
+			Name:           name,
+			Issuer:         os.Getenv(prefix + "ISSUER"),
+			ClientID:       os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:   os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:    os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:         splitList(os.Getenv(prefix + "SCOPES")),
+			TrustedDomains: splitList(os.Getenv(prefix + "TRUSTED_DOMAINS")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %s requires %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		provider, err := NewOIDCProvider(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+
+	return providers, nil
+}
+
+// AuthCodeURL returns the URL the browser is redirected to. The state and
+// nonce are bound to the browser by the caller, and the verifier is used for
+// PKCE.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange trades the authorization code for tokens and verifies the ID token
+// against the expected nonce.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, verifier string) (*OIDCIdentity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	var identity OIDCIdentity
+	if err := idToken.Claims(&identity); err != nil {
+		return nil, fmt.Errorf("failed to parse id_token claims: %w", err)
+	}
+
+	return &identity, nil
+}
+
+// TrustsEmail tells whether the provider is authoritative for the domain of
+// the email, so that its accounts can be linked by email.
+func (p *OIDCProvider) TrustsEmail(email string) bool {
+	return inDomains(email, p.trustedDomains)
+}
+
+// RandomString returns a URL-safe random string built from n random bytes.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// testIssuer is an OpenID Connect provider issuing ID tokens signed with its
+// own key, and checking the PKCE verifier on the code exchange.
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *testIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                i.server.URL,
+		"authorization_endpoint":                i.server.URL + "/authorize",
+		"token_endpoint":                        i.server.URL + "/token",
+		"jwks_uri":                              i.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (i *testIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(value *big.Int) string { return base64.RawURLEncoding.EncodeToString(value.Bytes()) }
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(i.key.N),
+			"e":   encode(big.NewInt(int64(i.key.E))),
+		}},
+	})
+}
+
+func (i *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	code := r.PostFormValue("code")
+	query, ok := i.codes[code]
+	delete(i.codes, code)
+	i.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != query.Get("code_challenge") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            i.server.URL,
+		"aud":            "client",
+		"sub":            "subject",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"nonce":          query.Get("nonce"),
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(i.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+// authorize issues a code for the authorization request, as the provider
+// would once the user logged in.
+func (i *testIssuer) authorize(t *testing.T, authCodeURL string) string {
+	t.Helper()
+	parsed, err := url.Parse(authCodeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := RandomString(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.mu.Lock()
+	i.codes[code] = parsed.Query()
+	i.mu.Unlock()
+	return code
+}
+
+func newTestOIDCProvider(t *testing.T, issuer *testIssuer) *OIDCProvider {
+	t.Helper()
+	provider, err := NewOIDCProvider(context.Background(), OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       issuer.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/mock/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	provider := newTestOIDCProvider(t, newTestIssuer(t))
+
+	parsed, err := url.Parse(provider.AuthCodeURL("state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	challenge := sha256.Sum256([]byte("verifier"))
+	expected := map[string]string{
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"client_id":             "client",
+		"scope":                 "openid email profile",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range expected {
+		if query.Get(key) != value {
+			t.Errorf("expected %s %q, got %q", key, value, query.Get(key))
+		}
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestOIDCProvider(t, issuer)
+	ctx := context.Background()
+
+	t.Run("Verified", func(t *testing.T) {
+		code := issuer.authorize(t, provider.AuthCodeURL("state", "nonce", "verifier"))
+
+		identity, err := provider.Exchange(ctx, code, "nonce", "verifier")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Subject != "subject" || identity.Email != "alice@example.com" || !identity.EmailVerified {
+			t.Errorf("unexpected identity %+v", identity)
+		}
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		code := issuer.authorize(t, provider.AuthCodeURL("state", "nonce", "verifier"))
+
+		if _, err := provider.Exchange(ctx, code, "other-nonce", "verifier"); err == nil {
+			t.Error("expected a nonce mismatch")
+		}
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		code := issuer.authorize(t, provider.AuthCodeURL("state", "nonce", "verifier"))
+
+		if _, err := provider.Exchange(ctx, code, "nonce", "other-verifier"); err == nil {
+			t.Error("expected the exchange to fail")
+		}
+	})
+
+	t.Run("ReusedCode", func(t *testing.T) {
+		code := issuer.authorize(t, provider.AuthCodeURL("state", "nonce", "verifier"))
+
+		if _, err := provider.Exchange(ctx, code, "nonce", "verifier"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := provider.Exchange(ctx, code, "nonce", "verifier"); err == nil {
+			t.Error("expected a used code to be refused")
+		}
+	})
+}
+
+func TestLoadOIDCProviders(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	t.Run("Configured", func(t *testing.T) {
+		t.Setenv("OIDC_PROVIDERS", "Mock")
+		t.Setenv("OIDC_MOCK_ISSUER", issuer.server.URL)
+		t.Setenv("OIDC_MOCK_CLIENT_ID", "client")
+
+		providers, err := LoadOIDCProviders(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if providers["mock"] == nil || providers["mock"].Name != "mock" {
+			t.Errorf("expected the mock provider, got %v", providers)
+		}
+	})
+
+	t.Run("MissingIssuer", func(t *testing.T) {
+		t.Setenv("OIDC_PROVIDERS", "mock")
+		t.Setenv("OIDC_MOCK_ISSUER", "")
+		t.Setenv("OIDC_MOCK_CLIENT_ID", "client")
+
+		if _, err := LoadOIDCProviders(context.Background()); err == nil {
+			t.Error("expected an error without OIDC_MOCK_ISSUER")
+		}
+	})
+}
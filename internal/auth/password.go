@@ -1,16 +1,31 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"authentication-jwt/internal/metrics"
+	"authentication-jwt/internal/tracing"
+	"context"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func HashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracing.Start(ctx, "bcrypt.hash")
+	defer metrics.ObserveSince(metrics.PasswordHashDuration.WithLabelValues("hash"), time.Now())
 
-func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	tracing.End(span, err)
 	if err != nil {
 		return "", err
 	}
 	return string(hashedPassword), nil
 }
 
-func CheckPasswordHash(password, hash string) bool {
+func CheckPasswordHash(ctx context.Context, password, hash string) bool {
+	_, span := tracing.Start(ctx, "bcrypt.compare")
+	defer span.End()
+	defer metrics.ObserveSince(metrics.PasswordHashDuration.WithLabelValues("compare"), time.Now())
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
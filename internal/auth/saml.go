@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+type SAMLConfig struct {
+	RootURL           string
+	EntityID          string
+	KeyFile           string
+	CertFile          string
+	IDPMetadataURL    string
+	IDPMetadataFile   string
+	EmailAttribute    string
+	UsernameAttribute string
+	// TrustedDomains are the email domains the IdP is authoritative for.
+	// Logging in with an email of one of them links the identity to the
+	// existing account with that email.
+	TrustedDomains []string
+}
+
+// SAMLServiceProvider is the SAML 2.0 service provider used for enterprise
+// SSO. It validates signatures, audience, NotOnOrAfter and InResponseTo for
+// every assertion it accepts.
+type SAMLServiceProvider struct {
+	sp                saml.ServiceProvider
+	emailAttribute    string
+	usernameAttribute string
+	trustedDomains    []string
+}
+
+// SAMLAssertion holds the subject and attributes of a validated assertion.
+type SAMLAssertion struct {
+	NameID   string
+	Email    string
+	Username string
+}
+
+func NewSAMLServiceProvider(cfg SAMLConfig) (*SAMLServiceProvider, error) {
+	keyPair, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SAML key pair: %w", err)
+	}
+
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SAML key must be an RSA private key")
+	}
+
+	certificate := keyPair.Leaf
+	if certificate == nil {
+		return nil, errors.New("failed to parse SAML certificate")
+	}
+
+	idpMetadata, err := loadIDPMetadata(cfg.IDPMetadataURL, cfg.IDPMetadataFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rootURL, err := url.Parse(cfg.RootURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML root URL: %w", err)
+	}
+
+	emailAttribute := cfg.EmailAttribute
+	if emailAttribute == "" {
+		emailAttribute = "email"
+	}
+
+	usernameAttribute := cfg.UsernameAttribute
+	if usernameAttribute == "" {
+		usernameAttribute = "uid"
+	}
+
+	return &SAMLServiceProvider{
+		sp: saml.ServiceProvider{
+			EntityID:          cfg.EntityID,
+			Key:               key,
+			Certificate:       certificate,
+			MetadataURL:       *rootURL.JoinPath("/api/auth/saml/metadata"),
+			AcsURL:            *rootURL.JoinPath("/api/auth/saml/acs"),
+			IDPMetadata:       idpMetadata,
+			AuthnNameIDFormat: saml.PersistentNameIDFormat,
+		},
+		emailAttribute:    emailAttribute,
+		usernameAttribute: usernameAttribute,
+		trustedDomains:    cfg.TrustedDomains,
+	}, nil
+}
+
+// LoadSAMLServiceProvider builds the service provider from the SAML_*
+// environment variables. It returns nil when SAML_SP_ROOT_URL is not set.
+func LoadSAMLServiceProvider() (*SAMLServiceProvider, error) {
+	rootURL := os.Getenv("SAML_SP_ROOT_URL")
+	if rootURL == "" {
+		return nil, nil
+	}
+
+	return NewSAMLServiceProvider(SAMLConfig{
+		RootURL:           rootURL,
+		EntityID:          os.Getenv("SAML_SP_ENTITY_ID"),
+		KeyFile:           os.Getenv("SAML_SP_KEY_FILE"),
+		CertFile:          os.Getenv("SAML_SP_CERT_FILE"),
+		IDPMetadataURL:    os.Getenv("SAML_IDP_METADATA_URL"),
+		IDPMetadataFile:   os.Getenv("SAML_IDP_METADATA_FILE"),
+		EmailAttribute:    os.Getenv("SAML_ATTRIBUTE_EMAIL"),
+		UsernameAttribute: os.Getenv("SAML_ATTRIBUTE_USERNAME"),
+		TrustedDomains:    splitList(os.Getenv("SAML_TRUSTED_DOMAINS")),
+	})
+}
+
+func loadIDPMetadata(metadataURL, metadataFile string) (*saml.EntityDescriptor, error) {
+	var data []byte
+	var err error
+
+	switch {
+	case metadataFile != "":
+		data, err = os.ReadFile(metadataFile)
+	case metadataURL != "":
+		client := http.Client{Timeout: 10 * time.Second}
+		var resp *http.Response
+		resp, err = client.Get(metadataURL)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("failed to fetch IdP metadata: status %d", resp.StatusCode)
+			}
+			data, err = io.ReadAll(resp.Body)
+		}
+	default:
+		return nil, errors.New("SAML_IDP_METADATA_URL or SAML_IDP_METADATA_FILE is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load IdP metadata: %w", err)
+	}
+
+	var metadata saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse IdP metadata: %w", err)
+	}
+
+	return &metadata, nil
+}
+
+func (p *SAMLServiceProvider) Metadata() ([]byte, error) {
+	return xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+}
+
+// TrustsEmail tells whether the IdP is authoritative for the domain of the
+// email, so that its accounts can be linked by email.
+func (p *SAMLServiceProvider) TrustsEmail(email string) bool {
+	return inDomains(email, p.trustedDomains)
+}
+
+// AuthnRequestURL builds an AuthnRequest for the HTTP-Redirect binding and
+// returns the IdP URL along with the request ID that the response must
+// reference in InResponseTo.
+func (p *SAMLServiceProvider) AuthnRequestURL() (string, string, error) {
+	req, err := p.sp.MakeAuthenticationRequest(
+		p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	redirectURL, err := req.Redirect(req.ID, &p.sp)
+	if err != nil {
+		return "", "", err
+	}
+
+	return redirectURL.String(), req.ID, nil
+}
+
+// ParseResponse validates the SAMLResponse posted to the ACS endpoint. The
+// assertion must answer the given request ID.
+func (p *SAMLServiceProvider) ParseResponse(r *http.Request, requestID string) (*SAMLAssertion, error) {
+	assertion, err := p.sp.ParseResponse(r, []string{requestID})
+	if err != nil {
+		var invalidResponse *saml.InvalidResponseError
+		if errors.As(err, &invalidResponse) {
+			return nil, fmt.Errorf("invalid SAML response: %w", invalidResponse.PrivateErr)
+		}
+		return nil, err
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, errors.New("SAML assertion has no NameID")
+	}
+
+	result := &SAMLAssertion{NameID: assertion.Subject.NameID.Value}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if len(attribute.Values) == 0 {
+				continue
+			}
+			switch p.emailAttribute {
+			case attribute.Name, attribute.FriendlyName:
+				result.Email = attribute.Values[0].Value
+			}
+			switch p.usernameAttribute {
+			case attribute.Name, attribute.FriendlyName:
+				result.Username = attribute.Values[0].Value
+			}
+		}
+	}
+
+	return result, nil
+}
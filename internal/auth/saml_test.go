@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+const samlTestRootURL = "http://localhost:8080"
+
+// newSAMLKeyPair makes an RSA key with a self-signed certificate.
+func newSAMLKeyPair(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certificate
+}
+
+func newSAMLTestIdP(t *testing.T) *saml.IdentityProvider {
+	t.Helper()
+	key, certificate := newSAMLKeyPair(t, "idp")
+	return &saml.IdentityProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: url.URL{Scheme: "https", Host: "idp.example.com", Path: "/metadata"},
+		SSOURL:      url.URL{Scheme: "https", Host: "idp.example.com", Path: "/sso"},
+	}
+}
+
+// newSAMLTestServiceProvider writes the key pair of the service provider and
+// the metadata of the IdP to files, as they are configured.
+func newSAMLTestServiceProvider(t *testing.T, idp *saml.IdentityProvider) *SAMLServiceProvider {
+	t.Helper()
+	dir := t.TempDir()
+	key, certificate := newSAMLKeyPair(t, "sp")
+
+	metadata, err := xml.Marshal(idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"sp.key":       pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		"sp.crt":       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}),
+		"idp-metadata": metadata,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serviceProvider, err := NewSAMLServiceProvider(SAMLConfig{
+		RootURL:         samlTestRootURL,
+		EntityID:        samlTestRootURL + "/api/auth/saml/metadata",
+		KeyFile:         filepath.Join(dir, "sp.key"),
+		CertFile:        filepath.Join(dir, "sp.crt"),
+		IDPMetadataFile: filepath.Join(dir, "idp-metadata"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serviceProvider
+}
+
+// postSAMLResponse makes the request posting the response of the IdP to the
+// ACS, for an assertion answering requestID and addressed to audience.
+func postSAMLResponse(t *testing.T, idp *saml.IdentityProvider, requestID, audience string) *http.Request {
+	t.Helper()
+	now := time.Now()
+	acsURL := samlTestRootURL + "/api/auth/saml/acs"
+	req := &saml.IdpAuthnRequest{
+		IDP:                     idp,
+		Request:                 saml.AuthnRequest{ID: requestID},
+		ServiceProviderMetadata: &saml.EntityDescriptor{EntityID: audience},
+		SPSSODescriptor:         &saml.SPSSODescriptor{},
+		ACSEndpoint:             &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: acsURL},
+		Now:                     now,
+	}
+	req.Assertion = &saml.Assertion{
+		ID:           fmt.Sprintf("id-%d", now.UnixNano()),
+		IssueInstant: now,
+		Version:      "2.0",
+		Issuer:       saml.Issuer{Value: idp.MetadataURL.String()},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Format: string(saml.PersistentNameIDFormat), Value: "alice-saml"},
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: requestID,
+					NotOnOrAfter: now.Add(saml.MaxIssueDelay),
+					Recipient:    acsURL,
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore:            now.Add(-saml.MaxClockSkew),
+			NotOnOrAfter:         now.Add(saml.MaxIssueDelay),
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: audience}}},
+		},
+		AttributeStatements: []saml.AttributeStatement{{
+			Attributes: []saml.Attribute{
+				{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: "alice@corp.example.com"}}},
+				{Name: "uid", Values: []saml.AttributeValue{{Type: "xs:string", Value: "alice01"}}},
+			},
+		}},
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := url.Values{"SAMLResponse": {form.SAMLResponse}, "RelayState": {requestID}}
+	r := httptest.NewRequest(http.MethodPost, acsURL, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// The ACS handler has parsed the form when reading RelayState.
+	if err := r.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestSAMLAuthnRequestURL(t *testing.T) {
+	serviceProvider := newSAMLTestServiceProvider(t, newSAMLTestIdP(t))
+
+	redirectURL, requestID, err := serviceProvider.AuthnRequestURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Host != "idp.example.com" || location.Path != "/sso" {
+		t.Errorf("expected the SSO URL of the IdP, got %s", redirectURL)
+	}
+	if requestID == "" || location.Query().Get("RelayState") != requestID {
+		t.Errorf("expected RelayState %q, got %q", requestID, location.Query().Get("RelayState"))
+	}
+	if location.Query().Get("SAMLRequest") == "" {
+		t.Error("expected a SAMLRequest")
+	}
+}
+
+func TestSAMLParseResponse(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	serviceProvider := newSAMLTestServiceProvider(t, idp)
+	audience := samlTestRootURL + "/api/auth/saml/metadata"
+
+	t.Run("Valid", func(t *testing.T) {
+		_, requestID, err := serviceProvider.AuthnRequestURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertion, err := serviceProvider.ParseResponse(postSAMLResponse(t, idp, requestID, audience), requestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := SAMLAssertion{NameID: "alice-saml", Email: "alice@corp.example.com", Username: "alice01"}
+		if *assertion != expected {
+			t.Errorf("expected %+v, got %+v", expected, *assertion)
+		}
+	})
+
+	t.Run("OtherRequest", func(t *testing.T) {
+		_, requestID, err := serviceProvider.AuthnRequestURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := serviceProvider.ParseResponse(postSAMLResponse(t, idp, "other-request", audience), requestID); err == nil {
+			t.Error("expected a response to another request to be refused")
+		}
+	})
+
+	t.Run("WrongAudience", func(t *testing.T) {
+		_, requestID, err := serviceProvider.AuthnRequestURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := serviceProvider.ParseResponse(postSAMLResponse(t, idp, requestID, "https://other.example.com"), requestID); err == nil {
+			t.Error("expected an assertion for another audience to be refused")
+		}
+	})
+
+	t.Run("UntrustedIdP", func(t *testing.T) {
+		_, requestID, err := serviceProvider.AuthnRequestURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := serviceProvider.ParseResponse(postSAMLResponse(t, newSAMLTestIdP(t), requestID, audience), requestID); err == nil {
+			t.Error("expected a response signed by another key to be refused")
+		}
+	})
+}
@@ -1,16 +1,35 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
+// CheckSigningKeys reports whether the secrets signing the access and refresh
+// tokens are configured. Tokens signed with an empty key would be accepted by
+// anyone.
+func CheckSigningKeys() error {
+	if os.Getenv("JWT_SECRET") == "" {
+		return errors.New("JWT_SECRET is not set")
+	}
+	if os.Getenv("JWT_SECRET_REFRESH") == "" {
+		return errors.New("JWT_SECRET_REFRESH is not set")
+	}
+	return nil
+}
+
 func GenerateAccessToken(userID string) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["sub"] = userID
+	// The issue time has microseconds, so that a token issued right after the
+	// user logged out everywhere isn't taken as issued before.
+	claims["iat"] = float64(jwt.TimeFunc().UnixMicro()) / 1e6
 	claims["exp"] = jwt.TimeFunc().Add(15 * time.Minute).Unix() // Token valid for 15 minutes
 
 	secret := os.Getenv("JWT_SECRET")
@@ -23,9 +42,17 @@ func GenerateAccessToken(userID string) (string, error) {
 }
 
 func GenerateRefreshToken(userID string) (string, error) {
+	// Every refresh token is stored, so the random ID keeps two of them issued
+	// in the same second from being equal.
+	jti, err := RandomString(16)
+	if err != nil {
+		return "", err
+	}
+
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["sub"] = userID
+	claims["jti"] = jti
 	claims["exp"] = jwt.TimeFunc().Add(7 * 24 * time.Hour).Unix() // Token valid for 7 days
 
 	secret := os.Getenv("JWT_SECRET_REFRESH")
@@ -37,6 +64,40 @@ func GenerateRefreshToken(userID string) (string, error) {
 	return tokenString, nil
 }
 
+// GenerateMFAToken issues the short-lived token that identifies a user who
+// passed the first factor and still has to complete MFA. The "typ" claim keeps
+// it from being accepted as an access token.
+func GenerateMFAToken(userID string) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["sub"] = userID
+	claims["typ"] = "mfa"
+	claims["exp"] = jwt.TimeFunc().Add(10 * time.Minute).Unix() // Token valid for 10 minutes
+
+	secret := os.Getenv("JWT_SECRET")
+	tokenString, err := token.SignedString([]byte(secret))
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// ValidateMFAToken returns the user ID of a token issued by GenerateMFAToken.
+func ValidateMFAToken(tokenString string) (string, error) {
+	_, claims, err := ValidateAccessToken(tokenString, os.Getenv("JWT_SECRET"))
+	if err != nil {
+		return "", err
+	}
+
+	userID, ok := claims["sub"].(string)
+	if claims["typ"] != "mfa" || !ok || userID == "" {
+		return "", jwt.NewValidationError("invalid token type", jwt.ValidationErrorClaimsInvalid)
+	}
+
+	return userID, nil
+}
+
 func ValidateAccessToken(tokenString, secretKey string) (*jwt.Token, jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -60,3 +121,10 @@ func ValidateAccessToken(tokenString, secretKey string) (*jwt.Token, jwt.MapClai
 
 	return token, clains, nil
 }
+
+// HashToken hashes a high-entropy secret (magic links, verification links)
+// for storage. Secrets are looked up by hash so a plain SHA-256 is enough.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,14 +1,20 @@
 package database
 
 import (
+	"authentication-jwt/internal/logging"
+	"authentication-jwt/internal/metrics"
+	"authentication-jwt/internal/tracing"
 	"context"
-	"log"
+	"errors"
 	"os"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload" // Automatically load environment variables from .env file
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 )
 
 type Database struct {
@@ -24,18 +30,99 @@ func NewDatabase() *Database {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOptions := options.Client().ApplyURI(dbURI)
+	db, err := Connect(ctx, dbURI, dbName)
+	if err != nil {
+		logging.Fatal("Failed to connect to database", "error", err)
+	}
+
+	return db
+}
+
+// Connect opens a connection to the named database and checks it is
+// reachable.
+func Connect(ctx context.Context, uri, name string) (*Database, error) {
+	clientOptions := options.Client().ApplyURI(uri).SetMonitor(combineMonitors(metrics.CommandMonitor(), tracing.CommandMonitor()))
 
 	client, err := mongo.Connect(clientOptions)
 	if err != nil {
-		log.Fatalf("Failed to create database client: %v", err)
+		return nil, err
 	}
 
 	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return nil, err
 	}
 
 	return &Database{
-		Client: client.Database(dbName),
+		Client: client.Database(name),
+	}, nil
+}
+
+// Ping checks that the primary is reachable.
+func (d *Database) Ping(ctx context.Context) error {
+	return d.Client.Client().Ping(ctx, readpref.Primary())
+}
+
+// ErrNoReplicaSet is returned by CheckTransactions when MongoDB is a
+// standalone server.
+var ErrNoReplicaSet = errors.New("MongoDB is a standalone server, but transactions need a replica set: run mongod with --replSet and rs.initiate() it, a single node is enough (see docker-compose.yaml)")
+
+// CheckTransactions fails unless MongoDB runs as a replica set or behind
+// mongos, as transactions need. On a standalone server every change that
+// publishes a domain event would fail.
+func (d *Database) CheckTransactions(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := d.Client.Client().Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return err
 	}
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return ErrNoReplicaSet
+	}
+	return nil
+}
+
+// Disconnect closes the connections to the database.
+func (d *Database) Disconnect(ctx context.Context) error {
+	return d.Client.Client().Disconnect(ctx)
+}
+
+// combineMonitors calls every monitor on each command event.
+func combineMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, monitor := range monitors {
+				monitor.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, monitor := range monitors {
+				monitor.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, monitor := range monitors {
+				monitor.Failed(ctx, e)
+			}
+		},
+	}
+}
+
+// WithTransaction runs fn in a transaction. The repositories called with the
+// context passed to fn take part in it. Transactions need MongoDB running as
+// a replica set (see docker-compose.yaml).
+func (d *Database) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := d.Client.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
 }
@@ -0,0 +1,130 @@
+// Package events dispatches the domain events written to the event outbox to
+// the in-process subscribers.
+package events
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/retry"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+var dispatchPolicy = retry.Policy{
+	PollInterval: time.Second,
+	Lease:        time.Minute,
+	MaxAttempts:  20,
+	BaseBackoff:  5 * time.Second,
+	MaxBackoff:   time.Hour,
+}
+
+// Handler handles an event. Delivery is at least once, so handlers must be
+// idempotent: an event is handled again if the process stops before the
+// delivery is recorded.
+type Handler func(ctx context.Context, event *models.DomainEvent) error
+
+// Transactor runs a function in a database transaction.
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type subscriber struct {
+	name       string
+	eventTypes []string
+	handler    Handler
+}
+
+// Bus commits state changes together with their events and dispatches the
+// events to the subscribers.
+type Bus struct {
+	transactor  Transactor
+	repository  repositories.EventOutboxRepositoryInterface
+	policy      retry.Policy
+	subscribers []subscriber
+}
+
+func NewBus(transactor Transactor, repository repositories.EventOutboxRepositoryInterface) *Bus {
+	return &Bus{
+		transactor: transactor,
+		repository: repository,
+		policy:     dispatchPolicy,
+	}
+}
+
+// Subscribe registers a handler for the given event types, or for every event
+// if none is given. The name identifies the subscriber in the outbox and must
+// stay stable. Subscribers must be registered before Run is called.
+func (b *Bus) Subscribe(name string, handler Handler, eventTypes ...string) {
+	b.subscribers = append(b.subscribers, subscriber{
+		name:       name,
+		eventTypes: eventTypes,
+		handler:    handler,
+	})
+}
+
+// Commit runs the state change and writes the events to the outbox in the
+// same transaction, so events are recorded if and only if the change is.
+func (b *Bus) Commit(ctx context.Context, change func(ctx context.Context) error, events ...*models.DomainEvent) error {
+	return b.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := change(ctx); err != nil {
+			return err
+		}
+		return b.repository.Append(ctx, events...)
+	})
+}
+
+// Run dispatches the events until the context is cancelled.
+func (b *Bus) Run(ctx context.Context) {
+	retry.Run(ctx, b.policy, "event", b.repository.ClaimDue, b.dispatch)
+}
+
+func (b *Bus) dispatchDue(ctx context.Context) {
+	retry.Drain(ctx, b.policy, "event", b.repository.ClaimDue, b.dispatch)
+}
+
+func (b *Bus) dispatch(ctx context.Context, event *models.DomainEvent) {
+	var errs []error
+	for _, subscriber := range b.subscribers {
+		if slices.Contains(event.Delivered, subscriber.name) {
+			continue
+		}
+
+		if len(subscriber.eventTypes) > 0 && !slices.Contains(subscriber.eventTypes, event.Type) {
+			continue
+		}
+
+		if err := subscriber.handler(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", subscriber.name, err))
+			continue
+		}
+
+		if err := b.repository.MarkDelivered(ctx, event.ID, subscriber.name); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", subscriber.name, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		if err := b.repository.MarkDispatched(ctx, event.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark event as dispatched", "event_id", event.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	err := errors.Join(errs...)
+	if b.policy.Exhausted(event.Attempts) {
+		slog.ErrorContext(ctx, "Giving up on event", "event_id", event.ID.Hex(), "event_type", event.Type, "attempts", event.Attempts, "error", err)
+		if err := b.repository.MarkFailed(ctx, event.ID, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark event as failed", "event_id", event.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	slog.WarnContext(ctx, "Failed to dispatch event", "event_id", event.ID.Hex(), "event_type", event.Type, "attempts", event.Attempts, "error", err)
+	if err := b.repository.MarkRetry(ctx, event.ID, b.policy.NextAttempt(event.Attempts), err.Error()); err != nil {
+		slog.ErrorContext(ctx, "Failed to reschedule event", "event_id", event.ID.Hex(), "error", err)
+	}
+}
@@ -0,0 +1,220 @@
+package events
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/repositories/memory"
+	"authentication-jwt/internal/retry"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type transactionKey struct{}
+
+// recordingTransactor marks the context of the transaction.
+type recordingTransactor struct{}
+
+func (recordingTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, transactionKey{}, true))
+}
+
+// transactionalOutbox fails the appends made outside of a transaction.
+type transactionalOutbox struct {
+	*memory.EventOutboxRepository
+}
+
+func (o transactionalOutbox) Append(ctx context.Context, events ...*models.DomainEvent) error {
+	if ctx.Value(transactionKey{}) == nil {
+		return errors.New("append outside of the transaction")
+	}
+	return o.EventOutboxRepository.Append(ctx, events...)
+}
+
+// testPolicy retries failed events right away, so dispatchDue makes every
+// attempt.
+var testPolicy = retry.Policy{Lease: time.Minute, MaxAttempts: 3}
+
+func newTestBus(t *testing.T) (*Bus, *memory.EventOutboxRepository) {
+	t.Helper()
+	outbox := memory.NewEventOutboxRepository()
+	bus := NewBus(recordingTransactor{}, transactionalOutbox{outbox})
+	bus.policy = testPolicy
+	return bus, outbox
+}
+
+func newTestEvent(eventType string) *models.DomainEvent {
+	return models.NewDomainEvent(eventType, &models.User{ID: bson.NewObjectID(), Username: "alice01", Email: "alice@example.com"}, nil)
+}
+
+// stored returns the event as stored in the outbox.
+func stored(t *testing.T, outbox repositories.EventOutboxRepositoryInterface, event *models.DomainEvent) *models.DomainEvent {
+	t.Helper()
+	events, err := outbox.ListByUser(context.Background(), event.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range events {
+		if stored.ID == event.ID {
+			return stored
+		}
+	}
+	t.Fatalf("event %s not in the outbox", event.ID.Hex())
+	return nil
+}
+
+func TestCommit(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("InTransaction", func(t *testing.T) {
+		bus, outbox := newTestBus(t)
+		event := newTestEvent(models.EventUserLoggedIn)
+
+		var changedInTransaction bool
+		err := bus.Commit(ctx, func(ctx context.Context) error {
+			changedInTransaction = ctx.Value(transactionKey{}) != nil
+			return nil
+		}, event)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !changedInTransaction {
+			t.Error("change not run in the transaction")
+		}
+		if stored := stored(t, outbox, event); stored.Status != models.EventStatusPending {
+			t.Errorf("event status = %s, want pending", stored.Status)
+		}
+	})
+
+	t.Run("ChangeFailed", func(t *testing.T) {
+		bus, outbox := newTestBus(t)
+		event := newTestEvent(models.EventUserLoggedIn)
+
+		failure := errors.New("conflict")
+		err := bus.Commit(ctx, func(ctx context.Context) error { return failure }, event)
+		if !errors.Is(err, failure) {
+			t.Errorf("Commit = %v, want the error of the change", err)
+		}
+
+		events, err := outbox.ListByUser(ctx, event.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 0 {
+			t.Errorf("events of a failed change appended: %v", events)
+		}
+	})
+}
+
+func TestDispatch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Subscribers", func(t *testing.T) {
+		bus, outbox := newTestBus(t)
+		var all, logins, deletions int
+		bus.Subscribe("all", func(ctx context.Context, event *models.DomainEvent) error { all++; return nil })
+		bus.Subscribe("logins", func(ctx context.Context, event *models.DomainEvent) error { logins++; return nil }, models.EventUserLoggedIn)
+		bus.Subscribe("deletions", func(ctx context.Context, event *models.DomainEvent) error { deletions++; return nil }, models.EventUserDeleted)
+
+		event := newTestEvent(models.EventUserLoggedIn)
+		if err := bus.Commit(ctx, func(ctx context.Context) error { return nil }, event); err != nil {
+			t.Fatal(err)
+		}
+		bus.dispatchDue(ctx)
+
+		if all != 1 || logins != 1 || deletions != 0 {
+			t.Errorf("handled by all %d, logins %d, deletions %d times", all, logins, deletions)
+		}
+		if stored := stored(t, outbox, event); stored.Status != models.EventStatusDispatched || stored.DispatchedAt == nil {
+			t.Errorf("event status = %s, want dispatched", stored.Status)
+		}
+
+		// Dispatched events aren't handled again.
+		bus.dispatchDue(ctx)
+		if all != 1 {
+			t.Errorf("dispatched event handled %d times", all)
+		}
+	})
+
+	t.Run("Retried", func(t *testing.T) {
+		bus, outbox := newTestBus(t)
+		var succeeding, failing int
+		bus.Subscribe("succeeding", func(ctx context.Context, event *models.DomainEvent) error { succeeding++; return nil })
+		bus.Subscribe("flaky", func(ctx context.Context, event *models.DomainEvent) error {
+			failing++
+			if failing < 2 {
+				return errors.New("endpoint down")
+			}
+			return nil
+		})
+
+		event := newTestEvent(models.EventUserRegistered)
+		if err := bus.Commit(ctx, func(ctx context.Context) error { return nil }, event); err != nil {
+			t.Fatal(err)
+		}
+		bus.dispatchDue(ctx)
+
+		// Only the subscriber that failed is retried.
+		if succeeding != 1 || failing != 2 {
+			t.Errorf("handled by succeeding %d, flaky %d times", succeeding, failing)
+		}
+		stored := stored(t, outbox, event)
+		if stored.Status != models.EventStatusDispatched || stored.Attempts != 2 {
+			t.Errorf("event status = %s after %d attempts, want dispatched after 2", stored.Status, stored.Attempts)
+		}
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		bus, outbox := newTestBus(t)
+		bus.policy.BaseBackoff = time.Hour
+		bus.policy.MaxBackoff = time.Hour
+		bus.Subscribe("failing", func(ctx context.Context, event *models.DomainEvent) error { return errors.New("endpoint down") })
+
+		event := newTestEvent(models.EventUserRegistered)
+		if err := bus.Commit(ctx, func(ctx context.Context) error { return nil }, event); err != nil {
+			t.Fatal(err)
+		}
+		bus.dispatchDue(ctx)
+
+		stored := stored(t, outbox, event)
+		if stored.Status != models.EventStatusPending || stored.Attempts != 1 || stored.LastError == "" {
+			t.Errorf("event status = %s after %d attempts, last error %q", stored.Status, stored.Attempts, stored.LastError)
+		}
+		if wait := time.Until(stored.NextAttemptAt); wait < 59*time.Minute {
+			t.Errorf("next attempt in %s, want an hour", wait)
+		}
+	})
+
+	t.Run("DeadLettered", func(t *testing.T) {
+		bus, outbox := newTestBus(t)
+		attempts := 0
+		bus.Subscribe("failing", func(ctx context.Context, event *models.DomainEvent) error {
+			attempts++
+			return errors.New("endpoint down")
+		})
+
+		event := newTestEvent(models.EventUserRegistered)
+		if err := bus.Commit(ctx, func(ctx context.Context) error { return nil }, event); err != nil {
+			t.Fatal(err)
+		}
+		bus.dispatchDue(ctx)
+
+		if attempts != testPolicy.MaxAttempts {
+			t.Errorf("handled %d times, want %d", attempts, testPolicy.MaxAttempts)
+		}
+		stored := stored(t, outbox, event)
+		if stored.Status != models.EventStatusFailed || stored.LastError == "" {
+			t.Errorf("event status = %s, last error %q, want failed", stored.Status, stored.LastError)
+		}
+
+		// Failed events aren't retried.
+		bus.dispatchDue(ctx)
+		if attempts != testPolicy.MaxAttempts {
+			t.Errorf("failed event handled again")
+		}
+	})
+}
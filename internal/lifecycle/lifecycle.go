@@ -0,0 +1,133 @@
+// Package lifecycle runs the HTTP server and the background workers, and shuts
+// them down in order when the process is asked to stop.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type hook struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Lifecycle tracks what has to be stopped on shutdown. Workers started with
+// Go are cancelled once the server has drained, then the stop hooks run in the
+// reverse order of their registration.
+type Lifecycle struct {
+	workersCtx    context.Context
+	cancelWorkers context.CancelFunc
+	workers       sync.WaitGroup
+	hooks         []hook
+	draining      atomic.Bool
+	drainDelay    time.Duration
+}
+
+func New() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{
+		workersCtx:    ctx,
+		cancelWorkers: cancel,
+	}
+}
+
+// Go runs a background worker until shutdown. The worker must return soon
+// after its context is cancelled.
+func (l *Lifecycle) Go(name string, run func(ctx context.Context)) {
+	l.workers.Add(1)
+	go func() {
+		defer l.workers.Done()
+		run(l.workersCtx)
+		slog.Info("Worker stopped", "worker", name)
+	}()
+}
+
+// SetDrainDelay sets how long the server keeps serving after shutdown starts,
+// so the load balancer sees readiness fail before connections are refused.
+func (l *Lifecycle) SetDrainDelay(delay time.Duration) {
+	l.drainDelay = delay
+}
+
+// Draining reports whether shutdown has started.
+func (l *Lifecycle) Draining() bool {
+	return l.draining.Load()
+}
+
+// OnStop registers a function releasing a resource, such as a database
+// connection, on shutdown.
+func (l *Lifecycle) OnStop(name string, stop func(ctx context.Context) error) {
+	l.hooks = append(l.hooks, hook{name: name, stop: stop})
+}
+
+// Serve runs the server until ctx is cancelled or the server fails, then
+// shuts everything down within timeout: readiness starts failing, in-flight
+// requests are drained, the workers are stopped and the stop hooks are run.
+func (l *Lifecycle) Serve(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "addr", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down", "timeout", timeout.String())
+	case err = <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	l.draining.Store(true)
+	if err == nil && l.drainDelay > 0 {
+		select {
+		case <-time.After(l.drainDelay):
+		case <-shutdownCtx.Done():
+		}
+	}
+
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		slog.Error("Failed to drain in-flight requests", "error", shutdownErr)
+		err = errors.Join(err, shutdownErr)
+	}
+
+	return errors.Join(err, l.stop(shutdownCtx))
+}
+
+func (l *Lifecycle) stop(ctx context.Context) error {
+	l.cancelWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		l.workers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("Timed out waiting for the workers to stop")
+		err = ctx.Err()
+	}
+
+	for i := len(l.hooks) - 1; i >= 0; i-- {
+		hook := l.hooks[i]
+		if hookErr := hook.stop(ctx); hookErr != nil {
+			slog.Error("Failed to stop", "component", hook.name, "error", hookErr)
+			err = errors.Join(err, hookErr)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,105 @@
+// Package logging configures the structured application logger. Records are
+// written as JSON, carry the attributes attached to the request context and
+// have secrets redacted.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Setup installs the default logger from LOG_LEVEL ("debug", "info",
+// "warn" or "error", default "info") and LOG_FORMAT ("json", the default, or
+// "text"). The standard log package is routed through it as well.
+func Setup() error {
+	level := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", value)
+		}
+	}
+
+	format := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	if format != "" && format != "json" && format != "text" {
+		return fmt.Errorf("invalid LOG_FORMAT %q", format)
+	}
+
+	slog.SetDefault(slog.New(NewHandler(os.Stdout, format, level)))
+	return nil
+}
+
+// NewHandler builds the handler used by Setup writing to w.
+func NewHandler(w io.Writer, format string, level slog.Leveler) slog.Handler {
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if format == "text" {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return &contextHandler{handler}
+}
+
+// With returns a copy of ctx whose log records carry the given attributes,
+// in addition to those already attached to it.
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := append(attrsFromContext(ctx), argsToAttrs(args)...)
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// Fatal logs the message at error level and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func attrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	// Copy so contexts derived from the same parent don't share the array.
+	return append([]slog.Attr(nil), attrs...)
+}
+
+func argsToAttrs(args []any) []slog.Attr {
+	var record slog.Record
+	record.Add(args...)
+
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	return attrs
+}
+
+// contextHandler adds the attributes attached to the context with With to
+// every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := attrsFromContext(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are the attribute keys, and key suffixes such as
+// "refresh_token", whose values are never written to the logs.
+var sensitiveKeys = []string{
+	"password",
+	"hash",
+	"secret",
+	"token",
+	"cookie",
+	"authorization",
+	"api_key",
+	"body",
+}
+
+// exactSensitiveKeys are sensitive only as the whole key: one-time codes and
+// email bodies, which carry codes and links. "status_code" and the like are
+// fine.
+var exactSensitiveKeys = []string{
+	"code",
+	"text",
+	"html",
+}
+
+// jwtExpression matches JSON web tokens wherever they appear in a value.
+var jwtExpression = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+// bcryptExpression matches bcrypt hashes wherever they appear in a value.
+var bcryptExpression = regexp.MustCompile(`\$2[abxy]?\$\d{2}\$[./A-Za-z0-9]{53}`)
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if isSensitive(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(attr.Value.String()))
+	case slog.KindAny:
+		// Errors and other values are rendered as text by the handler, so
+		// they are scrubbed the same way.
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, redactString(err.Error()))
+		}
+	}
+	return attr
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	if slices.Contains(exactSensitiveKeys, key) {
+		return true
+	}
+
+	for _, sensitive := range sensitiveKeys {
+		if key == sensitive || strings.HasSuffix(key, "_"+sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactString(value string) string {
+	value = jwtExpression.ReplaceAllString(value, redacted)
+	return bcryptExpression.ReplaceAllString(value, redacted)
+}
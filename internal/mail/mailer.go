@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes messages to the application log instead of sending them.
+// Only the recipient and subject are logged: the bodies hold one-time codes
+// and links, use FileMailer to read them in development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	slog.InfoContext(ctx, "Email", "to", message.To, "subject", message.Subject)
+	return nil
+}
+
+// LoadMailer builds the mailer selected by MAIL_DRIVER: "smtp", or "log" and
+// "file" for development. There is no default, so a deployment can't end up
+// not sending its mail by mistake.
+func LoadMailer() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "":
+		return nil, errors.New(`MAIL_DRIVER is required: "smtp", or "log" or "file" in development`)
+	case "log":
+		return NewLogMailer(), nil
+	case "file":
+		dir := os.Getenv("MAIL_FILE_DIR")
+		if dir == "" {
+			dir = "./tmp/mail"
+		}
+		return NewFileMailer(dir, from)
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return NewSMTPMailer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	err := NewLogMailer().Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Your login code",
+		Text:    "Your code is 482913",
+		HTML:    "<p>Your code is <b>482913</b></p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(logs.String(), "482913") {
+		t.Errorf("the body was logged: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "alice@example.com") || !strings.Contains(logs.String(), "Your login code") {
+		t.Errorf("the recipient and subject weren't logged: %s", logs.String())
+	}
+}
+
+func TestLoadMailer(t *testing.T) {
+	t.Setenv("MAIL_DRIVER", "")
+	if _, err := LoadMailer(); err == nil {
+		t.Error("LoadMailer without MAIL_DRIVER succeeded")
+	}
+
+	t.Setenv("MAIL_DRIVER", "log")
+	if mailer, err := LoadMailer(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := mailer.(*LogMailer); !ok {
+		t.Errorf("LoadMailer with MAIL_DRIVER=log = %T", mailer)
+	}
+}
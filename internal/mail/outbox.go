@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/retry"
+	"context"
+	"log/slog"
+	"time"
+)
+
+var outboxPolicy = retry.Policy{
+	PollInterval: 5 * time.Second,
+	Lease:        2 * time.Minute,
+	MaxAttempts:  8,
+	BaseBackoff:  30 * time.Second,
+	MaxBackoff:   time.Hour,
+}
+
+// Outbox is a Mailer that stores messages instead of sending them. A worker
+// started with Run delivers them through the underlying mailer, retrying with
+// exponential backoff, so a mail server outage doesn't fail the request that
+// triggered the email.
+type Outbox struct {
+	mailer     Mailer
+	repository repositories.MailOutboxRepositoryInterface
+	policy     retry.Policy
+}
+
+func NewOutbox(mailer Mailer, repository repositories.MailOutboxRepositoryInterface) *Outbox {
+	return &Outbox{
+		mailer:     mailer,
+		repository: repository,
+		policy:     outboxPolicy,
+	}
+}
+
+func (o *Outbox) Send(ctx context.Context, message Message) error {
+	return o.repository.Enqueue(ctx, models.NewMailMessage(message.To, message.Subject, message.Text, message.HTML))
+}
+
+// Run delivers due messages until the context is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	retry.Run(ctx, o.policy, "outbox message", o.repository.ClaimDue, o.deliver)
+}
+
+func (o *Outbox) deliver(ctx context.Context, message *models.MailMessage) {
+	err := o.mailer.Send(ctx, Message{
+		To:      message.To,
+		Subject: message.Subject,
+		Text:    message.Text,
+		HTML:    message.HTML,
+	})
+	if err == nil {
+		if err := o.repository.MarkSent(ctx, message.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark outbox message as sent", "message_id", message.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	if o.policy.Exhausted(message.Attempts) {
+		slog.ErrorContext(ctx, "Giving up on outbox message", "message_id", message.ID.Hex(), "attempts", message.Attempts, "error", err)
+		if err := o.repository.MarkFailed(ctx, message.ID, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark outbox message as failed", "message_id", message.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	slog.WarnContext(ctx, "Failed to send outbox message", "message_id", message.ID.Hex(), "attempts", message.Attempts, "error", err)
+	if err := o.repository.MarkRetry(ctx, message.ID, o.policy.NextAttempt(message.Attempts), err.Error()); err != nil {
+		slog.ErrorContext(ctx, "Failed to reschedule outbox message", "message_id", message.ID.Hex(), "error", err)
+	}
+}
@@ -0,0 +1,26 @@
+package mail
+
+import "context"
+
+// Service renders a localized template and hands the message to a mailer.
+type Service struct {
+	mailer    Mailer
+	templates *Templates
+}
+
+func NewService(mailer Mailer, templates *Templates) *Service {
+	return &Service{
+		mailer:    mailer,
+		templates: templates,
+	}
+}
+
+func (s *Service) Send(ctx context.Context, to, locale, template string, data map[string]any) error {
+	message, err := s.templates.Render(template, locale, data)
+	if err != nil {
+		return err
+	}
+
+	message.To = to
+	return s.mailer.Send(ctx, message)
+}
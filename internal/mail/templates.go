@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var supportedLocales = []language.Tag{
+	language.English, // default
+	language.BrazilianPortuguese,
+}
+
+var localeMatcher = language.NewMatcher(supportedLocales)
+
+// MatchLocale picks the supported locale that best matches an Accept-Language
+// header or a stored user locale.
+func MatchLocale(preference string) string {
+	tags, _, _ := language.ParseAcceptLanguage(preference)
+	_, index, _ := localeMatcher.Match(tags...)
+	return supportedLocales[index].String()
+}
+
+// Templates renders the localized subject, text and HTML bodies of the
+// emails. Each template lives in templates/<locale>/<name>.{subject,txt,html}.tmpl
+// and the HTML body is wrapped in templates/layout.html.tmpl.
+type Templates struct{}
+
+func NewTemplates() *Templates {
+	return &Templates{}
+}
+
+func (t *Templates) Render(name, locale string, data map[string]any) (Message, error) {
+	locale = MatchLocale(locale)
+
+	values := map[string]any{"Locale": locale}
+	for key, value := range data {
+		values[key] = value
+	}
+
+	subject, err := renderText(fmt.Sprintf("templates/%s/%s.subject.tmpl", locale, name), values)
+	if err != nil {
+		return Message{}, err
+	}
+	values["Subject"] = strings.TrimSpace(subject)
+
+	text, err := renderText(fmt.Sprintf("templates/%s/%s.txt.tmpl", locale, name), values)
+	if err != nil {
+		return Message{}, err
+	}
+
+	html, err := renderHTML(fmt.Sprintf("templates/%s/%s.html.tmpl", locale, name), values)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject),
+		Text:    text,
+		HTML:    html,
+	}, nil
+}
+
+func renderText(path string, data map[string]any) (string, error) {
+	tmpl, err := texttemplate.ParseFS(templateFS, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", path, err)
+	}
+
+	return buf.String(), nil
+}
+
+func renderHTML(path string, data map[string]any) (string, error) {
+	tmpl, err := htmltemplate.ParseFS(templateFS, "templates/layout.html.tmpl", path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", path, err)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// CommandMonitor times the MongoDB commands. The collection is only known
+// from the started event, so it is kept until the command finishes.
+func CommandMonitor() *event.CommandMonitor {
+	var collections sync.Map
+
+	finished := func(e event.CommandFinishedEvent, outcome string) {
+		collection, ok := collections.LoadAndDelete(e.RequestID)
+		if !ok {
+			return
+		}
+		DatabaseOperationDuration.WithLabelValues(collection.(string), e.CommandName, outcome).Observe(e.Duration.Seconds())
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			// Commands such as "find" or "insert" name the collection in
+			// their first element; the others are not operations of the
+			// repositories.
+			element, err := e.Command.IndexErr(0)
+			if err != nil || element.Key() != e.CommandName {
+				return
+			}
+
+			collection, ok := element.Value().StringValueOK()
+			if !ok {
+				return
+			}
+			collections.Store(e.RequestID, collection)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent, OutcomeSuccess)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent, OutcomeFailure)
+		},
+	}
+}
@@ -2,48 +2,120 @@ package middlewares
 
 import (
 	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/logging"
+	"authentication-jwt/internal/metrics"
+	"authentication-jwt/internal/models"
 	"authentication-jwt/internal/repositories"
-	"log"
+	"authentication-jwt/internal/tracing"
+	"context"
+	"errors"
+	"math"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// AuthError is why an access token was rejected, with the response it gets.
+type AuthError struct {
+	// Reason labels the failure in the metrics and traces.
+	Reason  string
+	Status  int
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// Authenticate checks the access token and returns the user it was issued to,
+// who must still be allowed in. It fails with an *AuthError, except when the
+// user can't be loaded.
+func Authenticate(ctx context.Context, userRepository repositories.UserRepositoryInterface, accessToken string) (*models.User, error) {
+	_, validateSpan := tracing.Start(ctx, "AuthMiddleware.validate_token")
+	secret := os.Getenv("JWT_SECRET")
+	_, clains, err := auth.ValidateAccessToken(accessToken, secret)
+	validateSpan.End()
+	if err != nil {
+		return nil, &AuthError{Reason: "invalid", Status: http.StatusUnauthorized, Message: "Invalid or expired token"}
+	}
+
+	userID, ok := clains["sub"].(string)
+	if _, typed := clains["typ"]; !ok || typed || userID == "" {
+		return nil, &AuthError{Reason: "invalid_claims", Status: http.StatusUnauthorized, Message: "Invalid token claims"}
+	}
+
+	loadCtx, loadSpan := tracing.Start(ctx, "AuthMiddleware.load_user")
+	user, err := userRepository.FindById(loadCtx, userID)
+	tracing.End(loadSpan, err)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, &AuthError{Reason: "user_not_found", Status: http.StatusNotFound, Message: "User not found"}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled {
+		return nil, &AuthError{Reason: "disabled", Status: http.StatusForbidden, Message: "Account is disabled"}
+	}
+
+	if user.DeletedAt != nil {
+		return nil, &AuthError{Reason: "deleted", Status: http.StatusForbidden, Message: "Account is scheduled for deletion"}
+	}
+
+	// Tokens issued before the user logged out everywhere are no longer
+	// valid.
+	iat, _ := clains["iat"].(float64)
+	issuedAt := time.UnixMicro(int64(math.Round(iat * 1e6)))
+	if user.LoggedOutAt != nil && !issuedAt.After(*user.LoggedOutAt) {
+		return nil, &AuthError{Reason: "revoked", Status: http.StatusUnauthorized, Message: "Invalid or expired token"}
+	}
+
+	return user, nil
+}
+
 func AuthMiddleware(userRepository repositories.UserRepositoryInterface) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		accessToken, err := c.Cookie("access_token")
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		// The span covers the checks only, not the handler.
+		ctx, span := tracing.Start(c.Request.Context(), "AuthMiddleware")
+
+		// reject counts the failure and records its reason on the span.
+		reject := func(reason string) {
+			metrics.TokenValidationFailures.WithLabelValues(reason).Inc()
+			span.SetAttributes(attribute.String("auth.failure_reason", reason))
+			span.End()
 			c.Abort()
-			return
 		}
 
-		secret := os.Getenv("JWT_SECRET")
-		_, clains, err := auth.ValidateAccessToken(accessToken, secret)
+		accessToken, err := c.Cookie("access_token")
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+			reject("missing")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
 
-		userID, ok := clains["sub"].(string)
-		if !ok || userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+		user, err := Authenticate(ctx, userRepository, accessToken)
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			reject(authErr.Reason)
+			c.JSON(authErr.Status, gin.H{"error": authErr.Message})
 			return
 		}
 
-		log.Default().Println("Authenticated user ID:", userID)
-
-		user, err := userRepository.FindById(c.Request.Context(), userID)
-		if err != nil || user == nil {
-			c.JSON(404, gin.H{"error": "User not found"})
-			c.Abort()
+		if err != nil {
+			reject("error")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
 			return
 		}
+		span.End()
 
+		userID := user.ID.Hex()
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", userID))
 		c.Set("userID", userID)
+		c.Set("userRoles", user.Roles)
 		c.Next()
 	}
 }
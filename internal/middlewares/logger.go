@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"authentication-jwt/internal/logging"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LoggerMiddleware attaches the request ID and trace ID to the request
+// context, so every record logged while handling the request carries them,
+// and logs the request once it is handled. It must run after
+// RequestIDMiddleware and TracingMiddleware. Only the path is
+// logged: query strings may hold one-time tokens.
+func LoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx := logging.With(c.Request.Context(), "request_id", c.GetString("requestID"))
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			ctx = logging.With(ctx, "trace_id", spanContext.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		level := slog.LevelInfo
+		switch {
+		case c.Writer.Status() >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case c.Writer.Status() >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if errs := c.Errors.String(); errs != "" {
+			attrs = append(attrs, "errors", errs)
+		}
+
+		slog.Log(c.Request.Context(), level, "Request handled", attrs...)
+	}
+}
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"authentication-jwt/internal/scim"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProvisioningMiddleware authenticates SCIM clients with the bearer token
+// dedicated to provisioning. User session cookies are not accepted here.
+func ProvisioningMiddleware(token string) gin.HandlerFunc {
+	expected := sha256.Sum256([]byte(token))
+
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		actual := sha256.Sum256([]byte(bearer))
+
+		if !ok || subtle.ConstantTimeCompare(expected[:], actual[:]) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			scim.WriteJSON(c.Writer, http.StatusUnauthorized, scim.NewError(http.StatusUnauthorized, "", "Invalid provisioning token"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"authentication-jwt/internal/auth"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDExpression = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestIDMiddleware tags every request with an ID, reusing the one set by a
+// proxy in X-Request-ID when it looks sane. The ID is echoed in the response
+// and stored in the context as "requestID".
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDExpression.MatchString(requestID) {
+			requestID, _ = auth.RandomString(16)
+		}
+
+		c.Set("requestID", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+const (
+	AuditActionRegister          = "user.register"
+	AuditActionLogin             = "user.login"
+	AuditActionPasswordChange    = "user.password_change"
+	AuditActionMFAEnable         = "user.mfa_enable"
+	AuditActionMFADisable        = "user.mfa_disable"
+	AuditActionProfileUpdate     = "user.profile_update"
+	AuditActionEmailChange       = "user.email_change"
+	AuditActionAccountDelete     = "user.account_delete"
+	AuditActionAccountRestore    = "user.account_restore"
+	AuditActionDataExport        = "user.data_export"
+	AuditActionRefresh           = "session.refresh"
+	AuditActionLogout            = "session.logout"
+	AuditActionRevokeAllSessions = "session.revoke_all"
+	AuditActionAuditQuery        = "admin.audit_query"
+)
+
+// AuditDeletedUser takes the place of the ID of a deleted user in the audit
+// log.
+const AuditDeletedUser = "deleted_user"
+
+// AuditPersonalDetails are the details holding personal data, erased from the
+// events of a deleted user.
+var AuditPersonalDetails = []string{"email", "identifier", "previous_email"}
+
+// AuditIdentifyingDetails are the details naming the user an event is about
+// when it has no actor or target, as failed logins and registrations.
+var AuditIdentifyingDetails = []string{"email", "identifier"}
+
+// AuditEvent is an entry of the append-only audit log. ActorID is who did
+// it (a user ID, or "scim" for provisioning clients) and TargetID what it was
+// done to.
+type AuditEvent struct {
+	ID        bson.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Action    string            `json:"action" bson:"action"`
+	Outcome   string            `json:"outcome" bson:"outcome"`
+	ActorID   string            `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	TargetID  string            `json:"target_id,omitempty" bson:"target_id,omitempty"`
+	IPAddress string            `json:"ip_address" bson:"ip_address"`
+	UserAgent string            `json:"user_agent" bson:"user_agent"`
+	RequestID string            `json:"request_id" bson:"request_id"`
+	Details   map[string]string `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at" bson:"created_at"`
+}
+
+// Anonymize removes the user from the event. Their ID is replaced by
+// AuditDeletedUser and the personal details are erased, as well as the IP
+// address and user agent of the requests they made.
+func (e *AuditEvent) Anonymize(userID string) {
+	if e.ActorID == userID {
+		e.ActorID = AuditDeletedUser
+		e.IPAddress = ""
+		e.UserAgent = ""
+	}
+
+	if e.TargetID == userID {
+		e.TargetID = AuditDeletedUser
+	}
+
+	for _, key := range AuditPersonalDetails {
+		delete(e.Details, key)
+	}
+}
+
+// Names tells whether one of the identifying details is one of the
+// identifiers, ignoring case.
+func (e *AuditEvent) Names(identifiers []string) bool {
+	for _, key := range AuditIdentifyingDetails {
+		value, ok := e.Details[key]
+		if ok && slices.ContainsFunc(identifiers, func(identifier string) bool { return strings.EqualFold(value, identifier) }) {
+			return true
+		}
+	}
+	return false
+}
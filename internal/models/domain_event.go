@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Domain events, written to the event outbox together with the state change
+// they describe.
+const (
+	EventUserRegistered  = "UserRegistered"
+	EventUserLoggedIn    = "UserLoggedIn"
+	EventPasswordChanged = "PasswordChanged"
+	EventEmailChanged    = "EmailChanged"
+	EventSessionRevoked  = "SessionRevoked"
+	EventUserDeleted     = "UserDeleted"
+)
+
+const (
+	EventStatusPending    = "pending"
+	EventStatusDispatched = "dispatched"
+	EventStatusFailed     = "failed"
+)
+
+// DomainEvent is an event waiting in the outbox to be dispatched to the
+// subscribers. Delivered lists the subscribers that already handled it, so a
+// retry only runs the ones that failed.
+type DomainEvent struct {
+	ID            bson.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Type          string            `json:"type" bson:"type"`
+	UserID        bson.ObjectID     `json:"user_id" bson:"user_id"`
+	Data          map[string]string `json:"data" bson:"data"`
+	OccurredAt    time.Time         `json:"occurred_at" bson:"occurred_at"`
+	Status        string            `json:"status" bson:"status"`
+	Delivered     []string          `json:"delivered" bson:"delivered"`
+	Attempts      int               `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastError     string            `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	DispatchedAt  *time.Time        `json:"dispatched_at,omitempty" bson:"dispatched_at,omitempty"`
+}
+
+// NewDomainEvent creates an event about the user. The username and email are
+// copied into the data, as the user may be gone when the event is handled.
+func NewDomainEvent(eventType string, user *User, data map[string]string) *DomainEvent {
+	eventData := map[string]string{
+		"username": user.Username,
+		"email":    user.Email,
+	}
+	for key, value := range data {
+		eventData[key] = value
+	}
+
+	now := time.Now()
+	return &DomainEvent{
+		ID:            bson.NewObjectID(),
+		Type:          eventType,
+		UserID:        user.ID,
+		Data:          eventData,
+		OccurredAt:    now,
+		Status:        EventStatusPending,
+		Delivered:     []string{},
+		NextAttemptAt: now,
+	}
+}
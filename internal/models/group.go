@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Group struct {
+	ID          bson.ObjectID   `json:"id" bson:"_id,omitempty"`
+	DisplayName string          `json:"display_name" bson:"display_name"`
+	ExternalID  string          `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	Members     []bson.ObjectID `json:"members" bson:"members"`
+	CreatedAt   time.Time       `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at" bson:"updated_at"`
+}
+
+func (g *Group) Validate() error {
+	if g.DisplayName == "" {
+		return errors.New("Display name is required")
+	}
+	return nil
+}
+
+func NewGroup(displayName string) (*Group, error) {
+	group := &Group{
+		ID:          bson.NewObjectID(),
+		DisplayName: displayName,
+		Members:     []bson.ObjectID{},
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := group.Validate(); err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+func (g *Group) AddMember(userID bson.ObjectID) {
+	if !slices.Contains(g.Members, userID) {
+		g.Members = append(g.Members, userID)
+	}
+}
+
+func (g *Group) RemoveMember(userID bson.ObjectID) {
+	g.Members = slices.DeleteFunc(g.Members, func(member bson.ObjectID) bool {
+		return member == userID
+	})
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	MailStatusPending = "pending"
+	MailStatusSent    = "sent"
+	MailStatusFailed  = "failed"
+)
+
+// MailMessage is an email waiting in the outbox to be delivered.
+type MailMessage struct {
+	ID            bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	To            string        `json:"to" bson:"to"`
+	Subject       string        `json:"subject" bson:"subject"`
+	Text          string        `json:"-" bson:"text"`
+	HTML          string        `json:"-" bson:"html,omitempty"`
+	Status        string        `json:"status" bson:"status"`
+	Attempts      int           `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time     `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastError     string        `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt     time.Time     `json:"created_at" bson:"created_at"`
+	SentAt        *time.Time    `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
+
+func NewMailMessage(to, subject, text, html string) *MailMessage {
+	now := time.Now()
+	return &MailMessage{
+		ID:            bson.NewObjectID(),
+		To:            to,
+		Subject:       subject,
+		Text:          text,
+		HTML:          html,
+		Status:        MailStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
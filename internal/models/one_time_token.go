@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	PurposeMagicLink             = "magic_link"
+	PurposeLoginCode             = "login_code"
+	PurposeMFACode               = "mfa_code"
+	PurposeEmailVerificationCode = "email_verification_code"
+	PurposeRevokeSessions        = "revoke_sessions"
+	PurposeEmailChange           = "email_change"
+	PurposeReauthenticationCode  = "reauthentication_code"
+	PurposeAccountDeletionCode   = "account_deletion_code"
+	PurposeAccountRestore        = "account_restore"
+)
+
+var OneTimeTokenPurposes = []string{
+	PurposeMagicLink,
+	PurposeLoginCode,
+	PurposeMFACode,
+	PurposeEmailVerificationCode,
+	PurposeRevokeSessions,
+	PurposeEmailChange,
+	PurposeReauthenticationCode,
+	PurposeAccountDeletionCode,
+	PurposeAccountRestore,
+}
+
+// OneTimeToken is a short-lived secret sent to the user out of band. Only the
+// hash of the secret is stored, and BindingHash ties it to the browser that
+// requested it.
+type OneTimeToken struct {
+	ID          bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      bson.ObjectID `json:"user_id" bson:"user_id"`
+	Purpose     string        `json:"purpose" bson:"purpose"`
+	TokenHash   string        `json:"-" bson:"token_hash"`
+	BindingHash string        `json:"-" bson:"binding_hash,omitempty"`
+	Attempts    int           `json:"attempts" bson:"attempts"`
+	CreatedAt   time.Time     `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time     `json:"expires_at" bson:"expires_at"`
+}
+
+func NewOneTimeToken(userID bson.ObjectID, purpose, tokenHash, bindingHash string, expiresAt time.Time) *OneTimeToken {
+	return &OneTimeToken{
+		ID:          bson.NewObjectID(),
+		UserID:      userID,
+		Purpose:     purpose,
+		TokenHash:   tokenHash,
+		BindingHash: bindingHash,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+	}
+}
+
+func (t *OneTimeToken) IsExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
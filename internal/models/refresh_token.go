@@ -12,7 +12,10 @@ type RefreshToken struct {
 	Token     string        `json:"token" bson:"token"`
 	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
 	ExpiresAt time.Time     `json:"expires_at" bson:"expires_at"`
-	UpdatedAt time.Time     `json:"updated_at" bson:"expires_at"`
+	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+	// RevokedAt is set once the token has been exchanged for a new one. Using
+	// it again means it leaked.
+	RevokedAt *time.Time `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
 }
 
 func NewRefreshToken(token string, userID bson.ObjectID, expiresAt time.Time) *RefreshToken {
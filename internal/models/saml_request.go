@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// SAMLRequest is an AuthnRequest waiting for its response. It is consumed by
+// the first assertion that answers it, so a response can't be replayed.
+type SAMLRequest struct {
+	ID        string    `json:"id" bson:"_id"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+func NewSAMLRequest(id string, expiresAt time.Time) *SAMLRequest {
+	return &SAMLRequest{
+		ID:        id,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+func (r *SAMLRequest) IsExpired() bool {
+	return time.Now().After(r.ExpiresAt)
+}
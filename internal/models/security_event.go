@@ -0,0 +1,102 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Security events users are notified about by email.
+const (
+	SecurityEventNewDeviceLogin       = "new_device_login"
+	SecurityEventPasswordChanged      = "password_changed"
+	SecurityEventMFAEnabled           = "mfa_enabled"
+	SecurityEventMFADisabled          = "mfa_disabled"
+	SecurityEventEmailChangeRequested = "email_change_requested"
+	SecurityEventEmailChanged         = "email_changed"
+	SecurityEventRefreshTokenReuse    = "refresh_token_reuse"
+)
+
+var SecurityEvents = []string{
+	SecurityEventNewDeviceLogin,
+	SecurityEventPasswordChanged,
+	SecurityEventMFAEnabled,
+	SecurityEventMFADisabled,
+	SecurityEventEmailChangeRequested,
+	SecurityEventEmailChanged,
+	SecurityEventRefreshTokenReuse,
+}
+
+// MandatorySecurityEvents are always notified, whatever the user preferences.
+// Email change requests are among them, so that a stolen session can't move
+// the account to another address unnoticed.
+var MandatorySecurityEvents = []string{
+	SecurityEventEmailChangeRequested,
+	SecurityEventRefreshTokenReuse,
+}
+
+// MaxKnownDevices is how many devices are remembered per user.
+const MaxKnownDevices = 20
+
+// Device is a browser or client the user has logged in from, identified by a
+// hash of its user agent.
+type Device struct {
+	ID         string    `json:"id" bson:"id"`
+	UserAgent  string    `json:"user_agent" bson:"user_agent"`
+	IPAddress  string    `json:"ip_address" bson:"ip_address"`
+	LastSeenAt time.Time `json:"last_seen_at" bson:"last_seen_at"`
+}
+
+// WantsNotification reports whether the user should be emailed about the
+// event. Notifications are on unless the user turned them off.
+func (u *User) WantsNotification(event string) bool {
+	if slices.Contains(MandatorySecurityEvents, event) {
+		return true
+	}
+
+	enabled, ok := u.Notifications[event]
+	return !ok || enabled
+}
+
+// NotificationSettings returns the preference of every security event.
+func (u *User) NotificationSettings() map[string]bool {
+	settings := map[string]bool{}
+	for _, event := range SecurityEvents {
+		settings[event] = u.WantsNotification(event)
+	}
+	return settings
+}
+
+func NewDevice(id, userAgent, ipAddress string) Device {
+	return Device{
+		ID:         id,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastSeenAt: time.Now(),
+	}
+}
+
+// SeeDevice records a login from the device and reports whether it was
+// unknown. The first device of a user isn't reported as new.
+func (u *User) SeeDevice(device Device) bool {
+	for i := range u.KnownDevices {
+		if u.KnownDevices[i].ID == device.ID {
+			u.KnownDevices[i].IPAddress = device.IPAddress
+			u.KnownDevices[i].LastSeenAt = device.LastSeenAt
+			return false
+		}
+	}
+
+	isNew := len(u.KnownDevices) > 0
+
+	u.KnownDevices = append(u.KnownDevices, device)
+
+	// Forget the devices not seen for the longest time.
+	if len(u.KnownDevices) > MaxKnownDevices {
+		slices.SortFunc(u.KnownDevices, func(a, b Device) int {
+			return b.LastSeenAt.Compare(a.LastSeenAt)
+		})
+		u.KnownDevices = u.KnownDevices[:MaxKnownDevices]
+	}
+
+	return isNew
+}
@@ -1,24 +1,141 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/mail"
+	"slices"
 	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"golang.org/x/text/unicode/norm"
 )
 
+var (
+	ErrLastLoginMethod  = errors.New("cannot remove the last login method")
+	ErrReservedUsername = errors.New("username is reserved")
+)
+
+// ReservedUsernames can't be taken by users, so nobody can pass for the
+// service or its staff. They are compared case-insensitively.
+var ReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "security",
+	"postmaster", "hostmaster", "webmaster", "abuse", "noreply", "no-reply",
+	"moderator", "staff", "official", "anonymous", "nobody", "everyone",
+}
+
 type User struct {
-	ID        bson.ObjectID `json:"id" bson:"_id,omitempty"`
-	Username  string        `json:"username" bson:"username"`
-	Password  string        `json:"password,omitempty" bson:"password"`
-	Email     string        `json:"email" bson:"email"`
-	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+	ID              bson.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Username        string          `json:"username" bson:"username"`
+	Password        string          `json:"password,omitempty" bson:"password"`
+	Email           string          `json:"email" bson:"email"`
+	PendingEmail    string          `json:"pending_email,omitempty" bson:"pending_email,omitempty"`
+	EmailVerified   bool            `json:"email_verified" bson:"email_verified"`
+	EmailMFAEnabled bool            `json:"email_mfa_enabled" bson:"email_mfa_enabled"`
+	GivenName       string          `json:"given_name,omitempty" bson:"given_name,omitempty"`
+	FamilyName      string          `json:"family_name,omitempty" bson:"family_name,omitempty"`
+	ExternalID      string          `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	Locale          string          `json:"locale,omitempty" bson:"locale,omitempty"`
+	Disabled        bool            `json:"disabled" bson:"disabled"`
+	Identities      []Identity      `json:"identities,omitempty" bson:"identities"`
+	Roles           []string        `json:"roles,omitempty" bson:"roles"`
+	KnownDevices    []Device        `json:"-" bson:"known_devices"`
+	Notifications   map[string]bool `json:"-" bson:"notifications,omitempty"`
+	LoggedOutAt     *time.Time      `json:"-" bson:"logged_out_at,omitempty"`
+	// DeletedAt is set when the user deletes their account, which is purged
+	// once the grace period is over.
+	DeletedAt *time.Time `json:"-" bson:"deleted_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" bson:"updated_at"`
+}
+
+// LogValue keeps the password hash and other personal data out of the logs
+// when a user is logged.
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", u.ID.Hex()),
+		slog.String("username", u.Username),
+	)
+}
+
+// Identity is an external login (social provider, enterprise SSO, ...) linked
+// to the user.
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+func (u *User) HasIdentity(provider, subject string) bool {
+	for _, identity := range u.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) LinkIdentity(provider, subject string) {
+	if u.HasIdentity(provider, subject) {
+		return
+	}
+
+	u.Identities = append(u.Identities, Identity{
+		Provider: provider,
+		Subject:  subject,
+		LinkedAt: time.Now(),
+	})
+}
+
+// UnlinkIdentity removes a linked identity, refusing to remove the last way
+// the user has to log in.
+func (u *User) UnlinkIdentity(provider, subject string) error {
+	index := -1
+	for i, identity := range u.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return fmt.Errorf("identity %s not linked", provider)
+	}
+
+	if u.LoginMethodCount() <= 1 {
+		return ErrLastLoginMethod
+	}
+
+	u.Identities = append(u.Identities[:index], u.Identities[index+1:]...)
+	return nil
+}
+
+func (u *User) HasRole(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// LoginMethodCount counts the local password and every linked identity.
+func (u *User) LoginMethodCount() int {
+	count := len(u.Identities)
+	if u.Password != "" {
+		count++
+	}
+	return count
 }
 
 func (u *User) Validate() error {
+	return u.validate(true)
+}
+
+// ValidateProfile validates the user without requiring a local password, as
+// for users that log in through an external identity.
+func (u *User) ValidateProfile() error {
+	return u.validate(false)
+}
+
+func (u *User) validate(requirePassword bool) error {
 	errorMessage := []string{}
 
 	if len(u.Username) < 6 {
@@ -34,7 +151,7 @@ func (u *User) Validate() error {
 		errorMessage = append(errorMessage, "Invalid email format")
 	}
 
-	if len(u.Password) < 8 {
+	if requirePassword && len(u.Password) < 8 {
 		errorMessage = append(errorMessage, "Password must be at least 8 characters long")
 	}
 
@@ -45,11 +162,46 @@ func (u *User) Validate() error {
 	return nil
 }
 
+// IsReservedUsername tells whether the username is in ReservedUsernames.
+func IsReservedUsername(username string) bool {
+	return slices.ContainsFunc(ReservedUsernames, func(reserved string) bool {
+		return strings.EqualFold(reserved, username)
+	})
+}
+
+// CheckUsername checks a username chosen for a new account. Existing accounts
+// keep theirs even if they no longer pass. Usernames can't contain "@" so the
+// login can tell them from emails.
+func CheckUsername(username string) error {
+	if IsReservedUsername(username) {
+		return ErrReservedUsername
+	}
+
+	if strings.Contains(username, "@") {
+		return errors.New("Username can't contain @")
+	}
+
+	return nil
+}
+
+// NormalizeEmail returns the email as stored: in Unicode NFKC, without
+// surrounding spaces and with a lower case domain. The local part keeps its
+// case, the repositories compare emails case-insensitively.
+func NormalizeEmail(email string) string {
+	email = norm.NFKC.String(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return email
+	}
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
 func NewUser(username, email, password string) (*User, error) {
 	user := &User{
 		ID:        bson.NewObjectID(),
 		Username:  username,
-		Email:     email,
+		Email:     NormalizeEmail(email),
 		Password:  password,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -59,23 +211,77 @@ func NewUser(username, email, password string) (*User, error) {
 		return nil, err
 	}
 
+	if err := CheckUsername(username); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// NewExternalUser creates a user authenticated by an upstream identity
+// provider. These users have no local password.
+func NewExternalUser(username, email string) (*User, error) {
+	user := &User{
+		ID:        bson.NewObjectID(),
+		Username:  username,
+		Email:     NormalizeEmail(email),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if err := user.validate(false); err != nil {
+		return nil, err
+	}
+
+	if err := CheckUsername(username); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
 type UserResponse struct {
-	ID        string `json:"id"`
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	PendingEmail    string     `json:"pending_email,omitempty"`
+	EmailVerified   bool       `json:"email_verified"`
+	EmailMFAEnabled bool       `json:"email_mfa_enabled"`
+	GivenName       string     `json:"given_name,omitempty"`
+	FamilyName      string     `json:"family_name,omitempty"`
+	Locale          string     `json:"locale,omitempty"`
+	HasPassword     bool       `json:"has_password"`
+	Identities      []Identity `json:"identities"`
+	Roles           []string   `json:"roles"`
+	CreatedAt       string     `json:"created_at"`
+	UpdatedAt       string     `json:"updated_at"`
 }
 
 func (u *User) ToResponse() UserResponse {
+	identities := u.Identities
+	if identities == nil {
+		identities = []Identity{}
+	}
+
+	roles := u.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
 	return UserResponse{
-		ID:        u.ID.Hex(),
-		Username:  u.Username,
-		Email:     u.Email,
-		CreatedAt: u.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: u.UpdatedAt.Format(time.RFC3339),
+		ID:              u.ID.Hex(),
+		Username:        u.Username,
+		Email:           u.Email,
+		PendingEmail:    u.PendingEmail,
+		EmailVerified:   u.EmailVerified,
+		EmailMFAEnabled: u.EmailMFAEnabled,
+		GivenName:       u.GivenName,
+		FamilyName:      u.FamilyName,
+		Locale:          u.Locale,
+		HasPassword:     u.Password != "",
+		Identities:      identities,
+		Roles:           roles,
+		CreatedAt:       u.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       u.UpdatedAt.Format(time.RFC3339),
 	}
 }
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Events delivered to webhooks.
+const (
+	WebhookEventUserCreated         = "user.created"
+	WebhookEventUserLogin           = "user.login"
+	WebhookEventUserDeleted         = "user.deleted"
+	WebhookEventUserPasswordChanged = "user.password_changed"
+	WebhookEventUserEmailChanged    = "user.email_changed"
+	WebhookEventSessionsRevoked     = "user.sessions_revoked"
+
+	// WebhookEventAll subscribes a webhook to every event.
+	WebhookEventAll = "*"
+)
+
+var WebhookEvents = []string{
+	WebhookEventUserCreated,
+	WebhookEventUserLogin,
+	WebhookEventUserDeleted,
+	WebhookEventUserPasswordChanged,
+	WebhookEventUserEmailChanged,
+	WebhookEventSessionsRevoked,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+// Webhook is an external endpoint notified of the events it subscribes to.
+// Payloads are signed with Secret.
+type Webhook struct {
+	ID          bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	URL         string        `json:"url" bson:"url"`
+	Description string        `json:"description,omitempty" bson:"description,omitempty"`
+	Events      []string      `json:"events" bson:"events"`
+	Secret      string        `json:"-" bson:"secret"`
+	Active      bool          `json:"active" bson:"active"`
+	CreatedAt   time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+func NewWebhook(webhookURL, description, secret string, events []string) (*Webhook, error) {
+	webhook := &Webhook{
+		ID:          bson.NewObjectID(),
+		URL:         webhookURL,
+		Description: description,
+		Events:      events,
+		Secret:      secret,
+		Active:      true,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := webhook.Validate(); err != nil {
+		return nil, err
+	}
+
+	return webhook, nil
+}
+
+func (w *Webhook) Validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("URL must be an absolute http or https URL")
+	}
+
+	if len(w.Events) == 0 {
+		return errors.New("at least one event is required")
+	}
+
+	for _, event := range w.Events {
+		if event != WebhookEventAll && !slices.Contains(WebhookEvents, event) {
+			return errors.New("unknown event: " + event)
+		}
+	}
+
+	return nil
+}
+
+func (w *Webhook) Subscribes(event string) bool {
+	return w.Active && (slices.Contains(w.Events, WebhookEventAll) || slices.Contains(w.Events, event))
+}
+
+// WebhookDelivery is an event queued for delivery to a webhook. Deliveries
+// that ran out of attempts are kept as dead letters until redelivered.
+type WebhookDelivery struct {
+	ID             bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	WebhookID      bson.ObjectID `json:"webhook_id" bson:"webhook_id"`
+	UserID         bson.ObjectID `json:"user_id" bson:"user_id"`
+	EventID        string        `json:"event_id" bson:"event_id"`
+	Event          string        `json:"event" bson:"event"`
+	Payload        string        `json:"payload" bson:"payload"`
+	Status         string        `json:"status" bson:"status"`
+	Attempts       int           `json:"attempts" bson:"attempts"`
+	NextAttemptAt  time.Time     `json:"next_attempt_at" bson:"next_attempt_at"`
+	LastStatusCode int           `json:"last_status_code,omitempty" bson:"last_status_code,omitempty"`
+	LastError      string        `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	CreatedAt      time.Time     `json:"created_at" bson:"created_at"`
+	DeliveredAt    *time.Time    `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// NewWebhookDelivery queues the event about the user for the webhook. The
+// user is kept so that the deliveries go away with their account.
+func NewWebhookDelivery(webhookID, userID bson.ObjectID, eventID, event, payload string) *WebhookDelivery {
+	now := time.Now()
+	return &WebhookDelivery{
+		ID:            bson.NewObjectID(),
+		WebhookID:     webhookID,
+		UserID:        userID,
+		EventID:       eventID,
+		Event:         event,
+		Payload:       payload,
+		Status:        WebhookDeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type AuditFilter struct {
+	Action   string
+	Outcome  string
+	ActorID  string
+	TargetID string
+	// Identifiers matches the events naming one of them in their details, as
+	// failed logins do, compared case-insensitively.
+	Identifiers []string
+	Since       time.Time
+	Until       time.Time
+}
+
+// AuditLogRepositoryInterface is append-only: events can't be changed or
+// removed through it, except to erase a deleted user with AnonymizeUser.
+// Besides the events done by or to the user, AnonymizeUser erases the ones
+// naming them by one of the identifiers (their emails and username).
+type AuditLogRepositoryInterface interface {
+	Append(ctx context.Context, event *models.AuditEvent) error
+	List(ctx context.Context, filter AuditFilter, before bson.ObjectID, limit int) ([]*models.AuditEvent, error)
+	AnonymizeUser(ctx context.Context, userID string, identifiers []string) error
+}
+
+type AuditLogRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAuditLogRepository(db *database.Database) *AuditLogRepository {
+	return &AuditLogRepository{
+		collection: db.Client.Collection("audit_log"),
+	}
+}
+
+func (r *AuditLogRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	_, err := r.collection.InsertOne(ctx, event)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+// List returns the newest events matching the filter. Pages are walked with
+// the ID of the last event of the previous page as before; a zero ID starts
+// from the newest event.
+func (r *AuditLogRepository) List(ctx context.Context, filter AuditFilter, before bson.ObjectID, limit int) ([]*models.AuditEvent, error) {
+	query := bson.M{}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.Outcome != "" {
+		query["outcome"] = filter.Outcome
+	}
+	if filter.ActorID != "" {
+		query["actor_id"] = filter.ActorID
+	}
+	if filter.TargetID != "" {
+		query["target_id"] = filter.TargetID
+	}
+	if len(filter.Identifiers) > 0 {
+		query["$or"] = namingAny(filter.Identifiers)
+	}
+
+	createdAt := bson.M{}
+	if !filter.Since.IsZero() {
+		createdAt["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		createdAt["$lt"] = filter.Until
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	if !before.IsZero() {
+		query["_id"] = bson.M{"$lt": before}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(limit))
+	if len(filter.Identifiers) > 0 {
+		opts.SetCollation(caseInsensitive)
+	}
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// AnonymizeUser applies models.AuditEvent.Anonymize to the events of the
+// user and to the events naming them.
+func (r *AuditLogRepository) AnonymizeUser(ctx context.Context, userID string, identifiers []string) error {
+	personalDetails := bson.M{}
+	for _, key := range models.AuditPersonalDetails {
+		personalDetails["details."+key] = ""
+	}
+
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"actor_id": userID},
+		bson.M{"$set": bson.M{"actor_id": models.AuditDeletedUser, "ip_address": "", "user_agent": ""}, "$unset": personalDetails},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateMany(ctx,
+		bson.M{"target_id": userID},
+		bson.M{"$set": bson.M{"target_id": models.AuditDeletedUser}, "$unset": personalDetails},
+	)
+	if err != nil || len(identifiers) == 0 {
+		return err
+	}
+
+	_, err = r.collection.UpdateMany(ctx,
+		bson.M{"$or": namingAny(identifiers)},
+		bson.M{"$unset": personalDetails},
+		options.UpdateMany().SetCollation(caseInsensitive),
+	)
+	return err
+}
+
+// namingAny matches the events naming one of the identifiers, with the
+// caseInsensitive collation.
+func namingAny(identifiers []string) bson.A {
+	naming := bson.A{}
+	for _, key := range models.AuditIdentifyingDetails {
+		naming = append(naming, bson.M{"details." + key: bson.M{"$in": identifiers}})
+	}
+	return naming
+}
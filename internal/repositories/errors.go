@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// The errors returned by the repositories of every backend, so callers can
+// tell the cases apart without knowing the database driver.
+var (
+	// ErrNotFound is returned when the document looked up, updated or deleted
+	// doesn't exist. ClaimDue returns nil instead when nothing is due.
+	ErrNotFound          = errors.New("not found")
+	ErrDuplicateEmail    = errors.New("email already registered")
+	ErrDuplicateUsername = errors.New("username already taken")
+	// ErrConflict is returned when a write violates any other uniqueness
+	// constraint.
+	ErrConflict = errors.New("conflict")
+	// ErrStale is returned when updating a document changed since it was
+	// read, which would undo the other change. Read it again and reapply.
+	ErrStale = errors.New("modified concurrently")
+)
+
+// mongoError maps the errors of the MongoDB driver to the repository errors.
+func mongoError(err error) error {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		// The message names the violated index, e.g. "index: email_1 dup key".
+		switch message := err.Error(); {
+		case strings.Contains(message, "index: email_1 "):
+			return ErrDuplicateEmail
+		case strings.Contains(message, "index: username_1 "):
+			return ErrDuplicateUsername
+		default:
+			return fmt.Errorf("%w: %v", ErrConflict, err)
+		}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Dispatched events are kept for a week for troubleshooting.
+const dispatchedEventRetention = 7 * 24 * time.Hour
+
+type EventOutboxRepositoryInterface interface {
+	Append(ctx context.Context, events ...*models.DomainEvent) error
+	ClaimDue(ctx context.Context, lease time.Duration) (*models.DomainEvent, error)
+	MarkDelivered(ctx context.Context, id bson.ObjectID, subscriber string) error
+	MarkDispatched(ctx context.Context, id bson.ObjectID) error
+	MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error
+	MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error
+	ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.DomainEvent, error)
+	DeleteByUser(ctx context.Context, userID bson.ObjectID) error
+}
+
+type EventOutboxRepository struct {
+	collection *mongo.Collection
+}
+
+func NewEventOutboxRepository(db *database.Database) *EventOutboxRepository {
+	return &EventOutboxRepository{
+		collection: db.Client.Collection("event_outbox"),
+	}
+}
+
+// Append writes the events to the outbox. Called inside a transaction, they
+// are only stored if the state change they describe is.
+func (r *EventOutboxRepository) Append(ctx context.Context, events ...*models.DomainEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	_, err := r.collection.InsertMany(ctx, events)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+// ClaimDue atomically picks the oldest pending event that is due and pushes
+// its next attempt forward by the lease, so another dispatcher won't pick it
+// up while it is being handled.
+func (r *EventOutboxRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.DomainEvent, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status":          models.EventStatusPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"next_attempt_at": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var event models.DomainEvent
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil // Nothing due
+		}
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (r *EventOutboxRepository) MarkDelivered(ctx context.Context, id bson.ObjectID, subscriber string) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$addToSet": bson.M{"delivered": subscriber}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *EventOutboxRepository) MarkDispatched(ctx context.Context, id bson.ObjectID) error {
+	update := bson.M{
+		"$set":   bson.M{"status": models.EventStatusDispatched, "dispatched_at": time.Now()},
+		"$unset": bson.M{"last_error": ""},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *EventOutboxRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	update := bson.M{"$set": bson.M{"next_attempt_at": nextAttemptAt, "last_error": lastError}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *EventOutboxRepository) MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error {
+	update := bson.M{"$set": bson.M{"status": models.EventStatusFailed, "last_error": lastError}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListByUser returns the events about the user still in the outbox, newest
+// first.
+func (r *EventOutboxRepository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.DomainEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*models.DomainEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeleteByUser removes the events about the user, whether dispatched or not.
+func (r *EventOutboxRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	return nil
+}
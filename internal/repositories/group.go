@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type GroupFilter struct {
+	DisplayName string
+	ExternalID  string
+}
+
+type GroupRepositoryInterface interface {
+	Create(ctx context.Context, group *models.Group) error
+	FindById(ctx context.Context, id string) (*models.Group, error)
+	List(ctx context.Context, filter GroupFilter, offset, limit int) ([]*models.Group, int64, error)
+	Update(ctx context.Context, group *models.Group) error
+	Delete(ctx context.Context, id string) error
+	RemoveMember(ctx context.Context, userID bson.ObjectID) error
+	ListByMember(ctx context.Context, userID bson.ObjectID) ([]*models.Group, error)
+}
+
+type GroupRepository struct {
+	collection *mongo.Collection
+}
+
+func NewGroupRepository(db *database.Database) *GroupRepository {
+	return &GroupRepository{
+		collection: db.Client.Collection("groups"),
+	}
+}
+
+func (r *GroupRepository) Create(ctx context.Context, group *models.Group) error {
+	_, err := r.collection.InsertOne(ctx, group)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+func (r *GroupRepository) FindById(ctx context.Context, id string) (*models.Group, error) {
+	var group models.Group
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&group)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &group, nil
+}
+
+func (r *GroupRepository) List(ctx context.Context, filter GroupFilter, offset, limit int) ([]*models.Group, int64, error) {
+	query := bson.M{}
+	if filter.DisplayName != "" {
+		query["display_name"] = filter.DisplayName
+	}
+	if filter.ExternalID != "" {
+		query["external_id"] = filter.ExternalID
+	}
+
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groups := []*models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, 0, err
+	}
+
+	return groups, total, nil
+}
+
+func (r *GroupRepository) Update(ctx context.Context, group *models.Group) error {
+	if group.ID.IsZero() {
+		return errors.New("group ID is required for update")
+	}
+
+	group.UpdatedAt = time.Now()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": group.ID}, bson.M{"$set": group})
+	if err != nil {
+		return mongoError(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *GroupRepository) Delete(ctx context.Context, id string) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *GroupRepository) RemoveMember(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"members": userID},
+		bson.M{"$pull": bson.M{"members": userID}, "$set": bson.M{"updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *GroupRepository) ListByMember(ctx context.Context, userID bson.ObjectID) ([]*models.Group, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"members": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []*models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Sent messages are kept for a week for troubleshooting, without their
+// bodies, which may hold links and codes.
+const sentMailRetention = 7 * 24 * time.Hour
+
+type MailOutboxRepositoryInterface interface {
+	Enqueue(ctx context.Context, message *models.MailMessage) error
+	ClaimDue(ctx context.Context, lease time.Duration) (*models.MailMessage, error)
+	MarkSent(ctx context.Context, id bson.ObjectID) error
+	MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error
+	MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error
+	ListByRecipient(ctx context.Context, recipient string) ([]*models.MailMessage, error)
+	DeleteByRecipient(ctx context.Context, recipient string) error
+}
+
+type MailOutboxRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMailOutboxRepository(db *database.Database) *MailOutboxRepository {
+	return &MailOutboxRepository{
+		collection: db.Client.Collection("mail_outbox"),
+	}
+}
+
+func (r *MailOutboxRepository) Enqueue(ctx context.Context, message *models.MailMessage) error {
+	_, err := r.collection.InsertOne(ctx, message)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+// ClaimDue atomically picks the oldest pending message that is due and pushes
+// its next attempt forward by the lease, so another worker won't pick it up
+// while it is being sent. If the worker dies, the message is retried once the
+// lease expires.
+func (r *MailOutboxRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.MailMessage, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status":          models.MailStatusPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"next_attempt_at": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var message models.MailMessage
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&message)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil // Nothing due
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func (r *MailOutboxRepository) MarkSent(ctx context.Context, id bson.ObjectID) error {
+	update := bson.M{
+		"$set":   bson.M{"status": models.MailStatusSent, "sent_at": time.Now()},
+		"$unset": bson.M{"last_error": "", "text": "", "html": ""},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *MailOutboxRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	update := bson.M{"$set": bson.M{"next_attempt_at": nextAttemptAt, "last_error": lastError}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *MailOutboxRepository) MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error {
+	update := bson.M{"$set": bson.M{"status": models.MailStatusFailed, "last_error": lastError}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListByRecipient returns the messages sent or still to send to the
+// recipient, newest first.
+func (r *MailOutboxRepository) ListByRecipient(ctx context.Context, recipient string) ([]*models.MailMessage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"to": recipient}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []*models.MailMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// DeleteByRecipient deletes the messages sent or still to send to the
+// recipient.
+func (r *MailOutboxRepository) DeleteByRecipient(ctx context.Context, recipient string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"to": recipient})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"bytes"
+	"context"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type AuditLogRepository struct {
+	events table[models.AuditEvent]
+}
+
+func NewAuditLogRepository() *AuditLogRepository {
+	return &AuditLogRepository{}
+}
+
+func (r *AuditLogRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	return r.events.insert(event, nil)
+}
+
+// List returns the newest events matching the filter, older than before
+// unless it is zero.
+func (r *AuditLogRepository) List(ctx context.Context, filter repositories.AuditFilter, before bson.ObjectID, limit int) ([]*models.AuditEvent, error) {
+	events := r.events.filter(func(event *models.AuditEvent) bool {
+		return (filter.Action == "" || event.Action == filter.Action) &&
+			(filter.Outcome == "" || event.Outcome == filter.Outcome) &&
+			(filter.ActorID == "" || event.ActorID == filter.ActorID) &&
+			(filter.TargetID == "" || event.TargetID == filter.TargetID) &&
+			(len(filter.Identifiers) == 0 || event.Names(filter.Identifiers)) &&
+			(filter.Since.IsZero() || !event.CreatedAt.Before(filter.Since)) &&
+			(filter.Until.IsZero() || event.CreatedAt.Before(filter.Until)) &&
+			(before.IsZero() || bytes.Compare(event.ID[:], before[:]) < 0)
+	})
+	slices.SortFunc(events, func(a, b *models.AuditEvent) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+
+	return page(events, 0, limit), nil
+}
+
+// AnonymizeUser applies models.AuditEvent.Anonymize to the events of the
+// user and to the events naming them.
+func (r *AuditLogRepository) AnonymizeUser(ctx context.Context, userID string, identifiers []string) error {
+	r.events.update(
+		func(event *models.AuditEvent) bool {
+			return event.ActorID == userID || event.TargetID == userID || event.Names(identifiers)
+		},
+		func(event *models.AuditEvent) { event.Anonymize(userID) },
+	)
+	return nil
+}
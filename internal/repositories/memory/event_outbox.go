@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"bytes"
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type EventOutboxRepository struct {
+	events table[models.DomainEvent]
+}
+
+func NewEventOutboxRepository() *EventOutboxRepository {
+	return &EventOutboxRepository{}
+}
+
+func (r *EventOutboxRepository) Append(ctx context.Context, events ...*models.DomainEvent) error {
+	for _, event := range events {
+		if err := r.events.insert(event, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimDue picks the oldest pending event that is due and pushes its next
+// attempt forward by the lease.
+func (r *EventOutboxRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.DomainEvent, error) {
+	now := time.Now()
+	return r.events.claim(
+		func(event *models.DomainEvent) bool {
+			return event.Status == models.EventStatusPending && !event.NextAttemptAt.After(now)
+		},
+		func(a, b *models.DomainEvent) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) },
+		func(event *models.DomainEvent) {
+			event.NextAttemptAt = now.Add(lease)
+			event.Attempts++
+		},
+	), nil
+}
+
+func (r *EventOutboxRepository) MarkDelivered(ctx context.Context, id bson.ObjectID, subscriber string) error {
+	r.events.update(byEventID(id), func(event *models.DomainEvent) {
+		if !slices.Contains(event.Delivered, subscriber) {
+			event.Delivered = append(event.Delivered, subscriber)
+		}
+	})
+	return nil
+}
+
+func (r *EventOutboxRepository) MarkDispatched(ctx context.Context, id bson.ObjectID) error {
+	now := time.Now()
+	r.events.update(byEventID(id), func(event *models.DomainEvent) {
+		event.Status = models.EventStatusDispatched
+		event.DispatchedAt = &now
+		event.LastError = ""
+	})
+	return nil
+}
+
+func (r *EventOutboxRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	r.events.update(byEventID(id), func(event *models.DomainEvent) {
+		event.NextAttemptAt = nextAttemptAt
+		event.LastError = lastError
+	})
+	return nil
+}
+
+func (r *EventOutboxRepository) MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error {
+	r.events.update(byEventID(id), func(event *models.DomainEvent) {
+		event.Status = models.EventStatusFailed
+		event.LastError = lastError
+	})
+	return nil
+}
+
+// ListByUser returns the events about the user still in the outbox, newest
+// first.
+func (r *EventOutboxRepository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.DomainEvent, error) {
+	events := r.events.filter(func(event *models.DomainEvent) bool { return event.UserID == userID })
+	slices.SortFunc(events, func(a, b *models.DomainEvent) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+	return events, nil
+}
+
+// DeleteByUser removes the events about the user, whether dispatched or not.
+func (r *EventOutboxRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	r.events.remove(func(event *models.DomainEvent) bool { return event.UserID == userID })
+	return nil
+}
+
+func byEventID(id bson.ObjectID) func(event *models.DomainEvent) bool {
+	return func(event *models.DomainEvent) bool { return event.ID == id }
+}
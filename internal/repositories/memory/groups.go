@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type GroupRepository struct {
+	groups table[models.Group]
+}
+
+func NewGroupRepository() *GroupRepository {
+	return &GroupRepository{}
+}
+
+func (r *GroupRepository) Create(ctx context.Context, group *models.Group) error {
+	return r.groups.insert(group, func(stored *models.Group) error {
+		if stored.ID == group.ID {
+			return fmt.Errorf("%w: group %s already exists", repositories.ErrConflict, group.ID.Hex())
+		}
+		return nil
+	})
+}
+
+func (r *GroupRepository) FindById(ctx context.Context, id string) (*models.Group, error) {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.groups.find(func(group *models.Group) bool { return group.ID == objID })
+}
+
+func (r *GroupRepository) List(ctx context.Context, filter repositories.GroupFilter, offset, limit int) ([]*models.Group, int64, error) {
+	groups := r.groups.filter(func(group *models.Group) bool {
+		return (filter.DisplayName == "" || group.DisplayName == filter.DisplayName) &&
+			(filter.ExternalID == "" || group.ExternalID == filter.ExternalID)
+	})
+	slices.SortFunc(groups, func(a, b *models.Group) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+
+	return page(groups, offset, limit), int64(len(groups)), nil
+}
+
+func (r *GroupRepository) Update(ctx context.Context, group *models.Group) error {
+	if group.ID.IsZero() {
+		return errors.New("group ID is required for update")
+	}
+
+	group.UpdatedAt = time.Now()
+
+	return r.groups.replace(func(stored *models.Group) bool { return stored.ID == group.ID }, group, nil)
+}
+
+func (r *GroupRepository) Delete(ctx context.Context, id string) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	if removed := r.groups.remove(func(group *models.Group) bool { return group.ID == objID }); len(removed) == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+func (r *GroupRepository) RemoveMember(ctx context.Context, userID bson.ObjectID) error {
+	now := time.Now()
+	r.groups.update(
+		func(group *models.Group) bool { return slices.Contains(group.Members, userID) },
+		func(group *models.Group) {
+			group.RemoveMember(userID)
+			group.UpdatedAt = now
+		},
+	)
+	return nil
+}
+
+func (r *GroupRepository) ListByMember(ctx context.Context, userID bson.ObjectID) ([]*models.Group, error) {
+	return r.groups.filter(func(group *models.Group) bool { return slices.Contains(group.Members, userID) }), nil
+}
@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"bytes"
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type MailOutboxRepository struct {
+	messages table[models.MailMessage]
+}
+
+func NewMailOutboxRepository() *MailOutboxRepository {
+	return &MailOutboxRepository{}
+}
+
+func (r *MailOutboxRepository) Enqueue(ctx context.Context, message *models.MailMessage) error {
+	return r.messages.insert(message, nil)
+}
+
+// ClaimDue picks the oldest pending message that is due and pushes its next
+// attempt forward by the lease.
+func (r *MailOutboxRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.MailMessage, error) {
+	now := time.Now()
+	return r.messages.claim(
+		func(message *models.MailMessage) bool {
+			return message.Status == models.MailStatusPending && !message.NextAttemptAt.After(now)
+		},
+		func(a, b *models.MailMessage) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) },
+		func(message *models.MailMessage) {
+			message.NextAttemptAt = now.Add(lease)
+			message.Attempts++
+		},
+	), nil
+}
+
+func (r *MailOutboxRepository) MarkSent(ctx context.Context, id bson.ObjectID) error {
+	now := time.Now()
+	r.messages.update(byMessageID(id), func(message *models.MailMessage) {
+		message.Status = models.MailStatusSent
+		message.SentAt = &now
+		message.LastError = ""
+		message.Text = ""
+		message.HTML = ""
+	})
+	return nil
+}
+
+func (r *MailOutboxRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	r.messages.update(byMessageID(id), func(message *models.MailMessage) {
+		message.NextAttemptAt = nextAttemptAt
+		message.LastError = lastError
+	})
+	return nil
+}
+
+func (r *MailOutboxRepository) MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error {
+	r.messages.update(byMessageID(id), func(message *models.MailMessage) {
+		message.Status = models.MailStatusFailed
+		message.LastError = lastError
+	})
+	return nil
+}
+
+// ListByRecipient returns the messages sent or still to send to the
+// recipient, newest first.
+func (r *MailOutboxRepository) ListByRecipient(ctx context.Context, recipient string) ([]*models.MailMessage, error) {
+	messages := r.messages.filter(func(message *models.MailMessage) bool { return message.To == recipient })
+	slices.SortFunc(messages, func(a, b *models.MailMessage) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+	return messages, nil
+}
+
+func (r *MailOutboxRepository) DeleteByRecipient(ctx context.Context, recipient string) error {
+	r.messages.remove(func(message *models.MailMessage) bool { return message.To == recipient })
+	return nil
+}
+
+func byMessageID(id bson.ObjectID) func(message *models.MailMessage) bool {
+	return func(message *models.MailMessage) bool { return message.ID == id }
+}
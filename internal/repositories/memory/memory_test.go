@@ -0,0 +1,22 @@
+package memory_test
+
+import (
+	"authentication-jwt/internal/repositories/memory"
+	"authentication-jwt/internal/repositories/repotest"
+	"testing"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	repotest.RunAll(t, repotest.Repositories{
+		Users:             memory.NewUserRepository(),
+		RefreshTokens:     memory.NewRefreshTokenRepository(),
+		EventOutbox:       memory.NewEventOutboxRepository(),
+		Groups:            memory.NewGroupRepository(),
+		OneTimeTokens:     memory.NewOneTimeTokenRepository(),
+		MailOutbox:        memory.NewMailOutboxRepository(),
+		AuditLog:          memory.NewAuditLogRepository(),
+		Webhooks:          memory.NewWebhookRepository(),
+		WebhookDeliveries: memory.NewWebhookDeliveryRepository(),
+		SAMLRequests:      memory.NewSAMLRequestRepository(),
+	})
+}
@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type OneTimeTokenRepository struct {
+	tokens table[models.OneTimeToken]
+}
+
+func NewOneTimeTokenRepository() *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{}
+}
+
+// Create stores the token. Expired tokens are deleted on the way, as MongoDB
+// does with its TTL index.
+func (r *OneTimeTokenRepository) Create(ctx context.Context, token *models.OneTimeToken) error {
+	now := time.Now()
+	r.tokens.remove(func(stored *models.OneTimeToken) bool { return stored.ExpiresAt.Before(now) })
+
+	return r.tokens.insert(token, func(stored *models.OneTimeToken) error {
+		if stored.ID == token.ID || (stored.Purpose == token.Purpose && stored.TokenHash == token.TokenHash) {
+			return fmt.Errorf("%w: one-time token already exists", repositories.ErrConflict)
+		}
+		return nil
+	})
+}
+
+// Consume deletes and returns the token matching the hash and the browser
+// binding, so a token can be used only once.
+func (r *OneTimeTokenRepository) Consume(ctx context.Context, purpose, tokenHash, bindingHash string) (*models.OneTimeToken, error) {
+	consumed := r.tokens.remove(func(token *models.OneTimeToken) bool {
+		return token.Purpose == purpose && token.TokenHash == tokenHash && (bindingHash == "" || token.BindingHash == bindingHash)
+	})
+	if len(consumed) == 0 {
+		return nil, repositories.ErrNotFound
+	}
+	return consumed[0], nil
+}
+
+func (r *OneTimeTokenRepository) FindLatest(ctx context.Context, userID bson.ObjectID, purpose string) (*models.OneTimeToken, error) {
+	var latest *models.OneTimeToken
+	for _, token := range r.tokens.filter(func(token *models.OneTimeToken) bool {
+		return token.UserID == userID && token.Purpose == purpose
+	}) {
+		if latest == nil || token.CreatedAt.After(latest.CreatedAt) {
+			latest = token
+		}
+	}
+	if latest == nil {
+		return nil, repositories.ErrNotFound
+	}
+	return latest, nil
+}
+
+func (r *OneTimeTokenRepository) ClaimAttempt(ctx context.Context, userID bson.ObjectID, purpose string, maxAttempts int) (*models.OneTimeToken, error) {
+	token := r.tokens.claim(
+		func(token *models.OneTimeToken) bool {
+			return token.UserID == userID && token.Purpose == purpose && token.Attempts < maxAttempts
+		},
+		func(a, b *models.OneTimeToken) int { return b.CreatedAt.Compare(a.CreatedAt) },
+		func(token *models.OneTimeToken) { token.Attempts++ },
+	)
+	if token == nil {
+		return nil, repositories.ErrNotFound
+	}
+	return token, nil
+}
+
+func (r *OneTimeTokenRepository) Delete(ctx context.Context, id bson.ObjectID) error {
+	if len(r.tokens.remove(func(token *models.OneTimeToken) bool { return token.ID == id })) == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+func (r *OneTimeTokenRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID, purpose string) error {
+	r.tokens.remove(func(token *models.OneTimeToken) bool { return token.UserID == userID && token.Purpose == purpose })
+	return nil
+}
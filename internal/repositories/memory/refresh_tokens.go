@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type RefreshTokenRepository struct {
+	tokens table[models.RefreshToken]
+}
+
+func NewRefreshTokenRepository() *RefreshTokenRepository {
+	return &RefreshTokenRepository{}
+}
+
+// Create stores the refresh token of a new session. The expired tokens are
+// deleted on the way, as MongoDB does with its TTL index.
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	now := time.Now()
+	r.tokens.remove(func(stored *models.RefreshToken) bool { return stored.ExpiresAt.Before(now) })
+
+	return r.tokens.insert(token, func(stored *models.RefreshToken) error {
+		if stored.ID == token.ID || stored.Token == token.Token {
+			return fmt.Errorf("%w: refresh token already exists", repositories.ErrConflict)
+		}
+		return nil
+	})
+}
+
+func (r *RefreshTokenRepository) FindByToken(ctx context.Context, token string) (*models.RefreshToken, error) {
+	return r.tokens.find(func(stored *models.RefreshToken) bool { return stored.Token == token })
+}
+
+// Revoke marks the token as exchanged. It reports false if the token was
+// already revoked, so two concurrent refreshes can't both succeed.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id bson.ObjectID) (bool, error) {
+	now := time.Now()
+	revoked := r.tokens.update(
+		func(stored *models.RefreshToken) bool { return stored.ID == id && stored.RevokedAt == nil },
+		func(stored *models.RefreshToken) {
+			stored.RevokedAt = &now
+			stored.UpdatedAt = now
+		},
+	)
+	return revoked == 1, nil
+}
+
+func (r *RefreshTokenRepository) Delete(ctx context.Context, token string) error {
+	if removed := r.tokens.remove(func(stored *models.RefreshToken) bool { return stored.Token == token }); len(removed) == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	r.tokens.remove(func(stored *models.RefreshToken) bool { return stored.UserID == userID })
+	return nil
+}
+
+// ListByUser returns the refresh tokens of the user, oldest first.
+func (r *RefreshTokenRepository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.RefreshToken, error) {
+	return r.tokens.filter(func(stored *models.RefreshToken) bool { return stored.UserID == userID }), nil
+}
@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"fmt"
+	"time"
+)
+
+type SAMLRequestRepository struct {
+	requests table[models.SAMLRequest]
+}
+
+func NewSAMLRequestRepository() *SAMLRequestRepository {
+	return &SAMLRequestRepository{}
+}
+
+// Create stores the pending request. Expired requests are deleted on the
+// way, as MongoDB does with its TTL index.
+func (r *SAMLRequestRepository) Create(ctx context.Context, request *models.SAMLRequest) error {
+	now := time.Now()
+	r.requests.remove(func(stored *models.SAMLRequest) bool { return stored.ExpiresAt.Before(now) })
+
+	return r.requests.insert(request, func(stored *models.SAMLRequest) error {
+		if stored.ID == request.ID {
+			return fmt.Errorf("%w: SAML request %s already exists", repositories.ErrConflict, request.ID)
+		}
+		return nil
+	})
+}
+
+// Consume removes the pending request and returns it, so only the first
+// response referencing it is accepted.
+func (r *SAMLRequestRepository) Consume(ctx context.Context, id string) (*models.SAMLRequest, error) {
+	consumed := r.requests.remove(func(request *models.SAMLRequest) bool { return request.ID == id })
+	if len(consumed) == 0 {
+		return nil, repositories.ErrNotFound
+	}
+	return consumed[0], nil
+}
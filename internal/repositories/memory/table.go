@@ -0,0 +1,173 @@
+// Package memory implements the repositories in memory, for the tests that
+// need a working backend without a database. Nothing is persisted.
+package memory
+
+import (
+	"authentication-jwt/internal/repositories"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// table is a thread-safe list of documents in insertion order. Documents are
+// copied in and out through BSON, as MongoDB would store them, so callers
+// can't change the stored ones and get the same precision as from MongoDB.
+type table[T any] struct {
+	mu   sync.RWMutex
+	docs []*T
+}
+
+func clone[T any](doc *T) *T {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("memory: cannot copy %T: %v", doc, err))
+	}
+
+	var copied T
+	if err := bson.Unmarshal(data, &copied); err != nil {
+		panic(fmt.Sprintf("memory: cannot copy %T: %v", doc, err))
+	}
+	return &copied
+}
+
+// insert stores a copy of doc, unless a stored document conflicts with it.
+func (t *table[T]) insert(doc *T, conflicts func(stored *T) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if conflicts != nil {
+		for _, stored := range t.docs {
+			if err := conflicts(stored); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.docs = append(t.docs, clone(doc))
+	return nil
+}
+
+// find returns a copy of the first document matching.
+func (t *table[T]) find(match func(doc *T) bool) (*T, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, doc := range t.docs {
+		if match(doc) {
+			return clone(doc), nil
+		}
+	}
+	return nil, repositories.ErrNotFound
+}
+
+// filter returns copies of the documents matching, in insertion order.
+func (t *table[T]) filter(match func(doc *T) bool) []*T {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	docs := []*T{}
+	for _, doc := range t.docs {
+		if match(doc) {
+			docs = append(docs, clone(doc))
+		}
+	}
+	return docs
+}
+
+// update applies change to the stored documents matching and returns how
+// many were changed.
+func (t *table[T]) update(match func(doc *T) bool, change func(doc *T)) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	updated := 0
+	for _, doc := range t.docs {
+		if match(doc) {
+			change(doc)
+			updated++
+		}
+	}
+	return updated
+}
+
+// claim applies change to the first document of the order matching, and
+// returns a copy of it after the change.
+func (t *table[T]) claim(match func(doc *T) bool, compare func(a, b *T) int, change func(doc *T)) *T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var claimed *T
+	for _, doc := range t.docs {
+		if match(doc) && (claimed == nil || compare(doc, claimed) < 0) {
+			claimed = doc
+		}
+	}
+	if claimed == nil {
+		return nil
+	}
+
+	change(claimed)
+	return clone(claimed)
+}
+
+// replace stores a copy of doc in place of the document matching, unless
+// another stored document conflicts with it.
+func (t *table[T]) replace(match func(doc *T) bool, doc *T, conflicts func(stored *T) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index := slices.IndexFunc(t.docs, match)
+	if index == -1 {
+		return repositories.ErrNotFound
+	}
+
+	if conflicts != nil {
+		for i, stored := range t.docs {
+			if err := conflicts(stored); i != index && err != nil {
+				return err
+			}
+		}
+	}
+
+	t.docs[index] = clone(doc)
+	return nil
+}
+
+// remove deletes the documents matching and returns them.
+func (t *table[T]) remove(match func(doc *T) bool) []*T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var removed []*T
+	t.docs = slices.DeleteFunc(t.docs, func(doc *T) bool {
+		if match(doc) {
+			removed = append(removed, doc)
+			return true
+		}
+		return false
+	})
+	return removed
+}
+
+// page returns the documents from offset, at most limit of them.
+func page[T any](docs []*T, offset, limit int) []*T {
+	if offset >= len(docs) {
+		return []*T{}
+	}
+	docs = docs[offset:]
+	if limit < len(docs) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// Transactor runs the function given as is: the memory repositories apply
+// each change immediately and can't roll it back.
+type Transactor struct{}
+
+func (Transactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type UserRepository struct {
+	users table[models.User]
+}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{}
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	// Update matches on UpdatedAt, kept at the precision stored.
+	user.UpdatedAt = user.UpdatedAt.Truncate(time.Millisecond)
+
+	return r.users.insert(user, userConflicts(user))
+}
+
+func (r *UserRepository) FindById(ctx context.Context, id string) (*models.User, error) {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.users.find(func(user *models.User) bool { return user.ID == objID })
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	email = models.NormalizeEmail(email)
+	return r.users.find(func(user *models.User) bool { return strings.EqualFold(user.Email, email) })
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.users.find(func(user *models.User) bool { return strings.EqualFold(user.Username, username) })
+}
+
+func (r *UserRepository) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.users.find(func(user *models.User) bool { return user.HasIdentity(provider, subject) })
+}
+
+func (r *UserRepository) List(ctx context.Context, filter repositories.UserFilter, offset, limit int) ([]*models.User, int64, error) {
+	users := r.users.filter(func(user *models.User) bool {
+		return (filter.Username == "" || strings.EqualFold(user.Username, filter.Username)) &&
+			(filter.Email == "" || strings.EqualFold(user.Email, models.NormalizeEmail(filter.Email))) &&
+			(filter.ExternalID == "" || user.ExternalID == filter.ExternalID)
+	})
+	slices.SortFunc(users, func(a, b *models.User) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+
+	return page(users, offset, limit), int64(len(users)), nil
+}
+
+func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
+	if user.ID.IsZero() {
+		return errors.New("user ID is required for update")
+	}
+
+	previous := user.UpdatedAt
+	user.UpdatedAt = repositories.NextUpdatedAt(previous)
+
+	err := r.users.replace(func(stored *models.User) bool {
+		return stored.ID == user.ID && stored.UpdatedAt.Equal(previous)
+	}, user, userConflicts(user))
+	if errors.Is(err, repositories.ErrNotFound) {
+		if _, findErr := r.users.find(func(stored *models.User) bool { return stored.ID == user.ID }); findErr == nil {
+			err = repositories.ErrStale
+		}
+	}
+	if err != nil {
+		user.UpdatedAt = previous
+	}
+	return err
+}
+
+func (r *UserRepository) SeeDevice(ctx context.Context, userID bson.ObjectID, device models.Device) (bool, error) {
+	isNew := false
+	updated := r.users.update(
+		func(user *models.User) bool { return user.ID == userID },
+		func(user *models.User) { isNew = user.SeeDevice(device) },
+	)
+	if updated == 0 {
+		return false, repositories.ErrNotFound
+	}
+	return isNew, nil
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	if removed := r.users.remove(func(user *models.User) bool { return user.ID == objID }); len(removed) == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteDeleted deletes the user only if they deleted their account before the
+// given time, and returns ErrNotFound otherwise, as when it was restored since.
+func (r *UserRepository) DeleteDeleted(ctx context.Context, id string, before time.Time) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	removed := r.users.remove(func(user *models.User) bool {
+		return user.ID == objID && user.DeletedAt != nil && user.DeletedAt.Before(before)
+	})
+	if len(removed) == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// userConflicts reports a stored user already having the email, username or
+// ID of user, as the unique indexes of the databases do. Emails and usernames
+// are compared case-insensitively.
+func userConflicts(user *models.User) func(stored *models.User) error {
+	return func(stored *models.User) error {
+		switch {
+		case strings.EqualFold(stored.Email, user.Email):
+			return repositories.ErrDuplicateEmail
+		case strings.EqualFold(stored.Username, user.Username):
+			return repositories.ErrDuplicateUsername
+		case stored.ID == user.ID:
+			return fmt.Errorf("%w: user %s already exists", repositories.ErrConflict, user.ID.Hex())
+		default:
+			return nil
+		}
+	}
+}
+
+// ListDeleted returns the users who deleted their account before the given
+// time, oldest deletion first.
+func (r *UserRepository) ListDeleted(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	users := r.users.filter(func(user *models.User) bool {
+		return user.DeletedAt != nil && user.DeletedAt.Before(before)
+	})
+	slices.SortFunc(users, func(a, b *models.User) int { return a.DeletedAt.Compare(*b.DeletedAt) })
+
+	return page(users, 0, limit), nil
+}
@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type WebhookDeliveryRepository struct {
+	deliveries table[models.WebhookDelivery]
+}
+
+func NewWebhookDeliveryRepository() *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{}
+}
+
+// Create queues the delivery unless the event was already queued for the
+// webhook.
+func (r *WebhookDeliveryRepository) Create(ctx context.Context, delivery *models.WebhookDelivery) error {
+	errQueued := fmt.Errorf("event %s already queued", delivery.EventID)
+	err := r.deliveries.insert(delivery, func(stored *models.WebhookDelivery) error {
+		if stored.WebhookID == delivery.WebhookID && stored.EventID == delivery.EventID {
+			return errQueued
+		}
+		return nil
+	})
+	if err == errQueued {
+		return nil
+	}
+	return err
+}
+
+func (r *WebhookDeliveryRepository) FindById(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.deliveries.find(byDeliveryID(objID))
+}
+
+// List returns the deliveries matching the filter, newest first.
+func (r *WebhookDeliveryRepository) List(ctx context.Context, filter repositories.WebhookDeliveryFilter, offset, limit int) ([]*models.WebhookDelivery, int64, error) {
+	deliveries := r.deliveries.filter(func(delivery *models.WebhookDelivery) bool {
+		return (filter.WebhookID.IsZero() || delivery.WebhookID == filter.WebhookID) &&
+			(filter.UserID.IsZero() || delivery.UserID == filter.UserID) &&
+			(filter.Status == "" || delivery.Status == filter.Status)
+	})
+	slices.SortFunc(deliveries, func(a, b *models.WebhookDelivery) int { return bytes.Compare(b.ID[:], a.ID[:]) })
+
+	return page(deliveries, offset, limit), int64(len(deliveries)), nil
+}
+
+// ClaimDue picks the oldest pending delivery that is due and pushes its next
+// attempt forward by the lease.
+func (r *WebhookDeliveryRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	return r.deliveries.claim(
+		func(delivery *models.WebhookDelivery) bool {
+			return delivery.Status == models.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now)
+		},
+		func(a, b *models.WebhookDelivery) int { return a.NextAttemptAt.Compare(b.NextAttemptAt) },
+		func(delivery *models.WebhookDelivery) {
+			delivery.NextAttemptAt = now.Add(lease)
+			delivery.Attempts++
+		},
+	), nil
+}
+
+func (r *WebhookDeliveryRepository) MarkDelivered(ctx context.Context, id bson.ObjectID, statusCode int) error {
+	now := time.Now()
+	r.deliveries.update(byDeliveryID(id), func(delivery *models.WebhookDelivery) {
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.LastStatusCode = statusCode
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	})
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, statusCode int, lastError string) error {
+	r.deliveries.update(byDeliveryID(id), func(delivery *models.WebhookDelivery) {
+		delivery.NextAttemptAt = nextAttemptAt
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = lastError
+	})
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) MarkDead(ctx context.Context, id bson.ObjectID, statusCode int, lastError string) error {
+	r.deliveries.update(byDeliveryID(id), func(delivery *models.WebhookDelivery) {
+		delivery.Status = models.WebhookDeliveryDead
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = lastError
+	})
+	return nil
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts.
+func (r *WebhookDeliveryRepository) Redeliver(ctx context.Context, id bson.ObjectID) error {
+	now := time.Now()
+	r.deliveries.update(byDeliveryID(id), func(delivery *models.WebhookDelivery) {
+		delivery.Status = models.WebhookDeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = now
+	})
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) DeleteByWebhook(ctx context.Context, webhookID bson.ObjectID) error {
+	r.deliveries.remove(func(delivery *models.WebhookDelivery) bool { return delivery.WebhookID == webhookID })
+	return nil
+}
+
+// DeleteByUser removes the deliveries of the events about the user, whatever
+// their status.
+func (r *WebhookDeliveryRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	r.deliveries.remove(func(delivery *models.WebhookDelivery) bool { return delivery.UserID == userID })
+	return nil
+}
+
+func byDeliveryID(id bson.ObjectID) func(delivery *models.WebhookDelivery) bool {
+	return func(delivery *models.WebhookDelivery) bool { return delivery.ID == id }
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type WebhookRepository struct {
+	webhooks table[models.Webhook]
+}
+
+func NewWebhookRepository() *WebhookRepository {
+	return &WebhookRepository{}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	return r.webhooks.insert(webhook, func(stored *models.Webhook) error {
+		if stored.ID == webhook.ID {
+			return fmt.Errorf("%w: webhook %s already exists", repositories.ErrConflict, webhook.ID.Hex())
+		}
+		return nil
+	})
+}
+
+func (r *WebhookRepository) FindById(ctx context.Context, id string) (*models.Webhook, error) {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.webhooks.find(func(webhook *models.Webhook) bool { return webhook.ID == objID })
+}
+
+// FindSubscribed returns the active webhooks subscribed to the event.
+func (r *WebhookRepository) FindSubscribed(ctx context.Context, event string) ([]*models.Webhook, error) {
+	return r.webhooks.filter(func(webhook *models.Webhook) bool { return webhook.Subscribes(event) }), nil
+}
+
+func (r *WebhookRepository) List(ctx context.Context) ([]*models.Webhook, error) {
+	webhooks := r.webhooks.filter(func(*models.Webhook) bool { return true })
+	slices.SortFunc(webhooks, func(a, b *models.Webhook) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) Update(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID.IsZero() {
+		return errors.New("webhook ID is required for update")
+	}
+
+	webhook.UpdatedAt = time.Now()
+
+	return r.webhooks.replace(func(stored *models.Webhook) bool { return stored.ID == webhook.ID }, webhook, nil)
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, id string) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	if removed := r.webhooks.remove(func(webhook *models.Webhook) bool { return webhook.ID == objID }); len(removed) == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Migration is a versioned change to the MongoDB collections: new indexes or
+// data backfills. Applied migrations are recorded in schema_migrations and
+// never run again, so a released migration must not be edited; changes go
+// in a new one. Two instances starting together may run the same migration,
+// so every migration must be safe to run twice.
+type Migration struct {
+	Version     string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationStatus tells whether a migration was applied, and when.
+type MigrationStatus struct {
+	Version     string
+	Description string
+	AppliedAt   *time.Time
+}
+
+type migrationRecord struct {
+	Version     string    `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrations are applied in order.
+var Migrations = []Migration{
+	{
+		Version:     "0001",
+		Description: "indexes of the tokens, outboxes, audit log and webhook deliveries",
+		Up:          createInitialIndexes,
+	},
+	{
+		Version:     "0002",
+		Description: "backfill the creation time of users and add unique email and username indexes",
+		Up:          createUserIndexes,
+	},
+	{
+		Version:     "0003",
+		Description: "normalize emails and make the email and username indexes case-insensitive",
+		Up:          createCaseInsensitiveUserIndexes,
+	},
+	{
+		Version:     "0004",
+		Description: "index the deletion time of users",
+		Up:          createDeletedUserIndex,
+	},
+	{
+		Version:     "0005",
+		Description: "expire the sent mail, drop its bodies and index the recipients",
+		Up:          expireSentMail,
+	},
+	{
+		Version:     "0006",
+		Description: "index the emails and usernames named by audit events",
+		Up:          createAuditIdentifierIndexes,
+	},
+	{
+		Version:     "0007",
+		Description: "backfill the user of webhook deliveries and index the events and deliveries by user",
+		Up:          indexEventsByUser,
+	},
+}
+
+// indexNotFound is the code of the error returned when dropping a missing
+// index.
+const indexNotFound = 27
+
+// Migrate applies the migrations not recorded in schema_migrations yet.
+func Migrate(ctx context.Context, db *database.Database) error {
+	statuses, err := GetMigrationStatus(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	records := db.Client.Collection("schema_migrations")
+	for i, migration := range Migrations {
+		if statuses[i].AppliedAt != nil {
+			continue
+		}
+
+		if err := migration.Up(ctx, db.Client); err != nil {
+			return fmt.Errorf("migration %s: %w", migration.Version, err)
+		}
+
+		record := migrationRecord{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}
+		_, err := records.ReplaceOne(ctx, bson.M{"_id": record.Version}, record, options.Replace().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", migration.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// GetMigrationStatus lists every migration with the time it was applied.
+func GetMigrationStatus(ctx context.Context, db *database.Database) ([]MigrationStatus, error) {
+	cursor, err := db.Client.Collection("schema_migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		applied[record.Version] = record.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, len(Migrations))
+	for i, migration := range Migrations {
+		statuses[i] = MigrationStatus{Version: migration.Version, Description: migration.Description}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// createInitialIndexes creates the indexes the repositories used to create
+// when built. Indexes that already exist with the same options are left
+// alone.
+func createInitialIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		"refresh_tokens": {
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "token", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "token", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"one_time_tokens": {
+			{
+				Keys:    bson.D{{Key: "purpose", Value: 1}, {Key: "token_hash", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"saml_requests": {
+			{
+				Keys:    bson.D{{Key: "expires_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(0),
+			},
+		},
+		"mail_outbox": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		},
+		"event_outbox": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+			{
+				Keys:    bson.D{{Key: "dispatched_at", Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(int32(dispatchedEventRetention.Seconds())),
+			},
+		},
+		"audit_log": {
+			{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "_id", Value: -1}}},
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		},
+		"webhook_deliveries": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+			{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}}},
+			{
+				Keys:    bson.D{{Key: "webhook_id", Value: 1}, {Key: "event_id", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("indexes on %s: %w", collection, err)
+		}
+	}
+	return nil
+}
+
+// createUserIndexes makes emails and usernames unique. Users created before
+// created_at was recorded get the creation time of their ObjectID.
+func createUserIndexes(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+
+	_, err := users.UpdateMany(ctx,
+		bson.M{"created_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"created_at": bson.M{"$toDate": "$_id"}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = users.UpdateMany(ctx,
+		bson.M{"updated_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"updated_at": "$created_at"}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range []string{"email", "username"} {
+		if err := checkDuplicates(ctx, users, field, "$"+field); err != nil {
+			return err
+		}
+	}
+
+	_, err = users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	return err
+}
+
+// createCaseInsensitiveUserIndexes stores the emails in the form of
+// models.NormalizeEmail and replaces the unique indexes on email and username
+// with case-insensitive ones.
+func createCaseInsensitiveUserIndexes(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+
+	cursor, err := users.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return err
+	}
+
+	var stored []struct {
+		ID    bson.ObjectID `bson:"_id"`
+		Email string        `bson:"email"`
+	}
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+
+	for _, user := range stored {
+		email := models.NormalizeEmail(user.Email)
+		if email == user.Email {
+			continue
+		}
+		if _, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email": email}}); err != nil {
+			return fmt.Errorf("normalizing the email of user %s: %w", user.ID.Hex(), err)
+		}
+	}
+
+	for _, field := range []string{"email", "username"} {
+		if err := checkDuplicates(ctx, users, field, bson.M{"$toLower": "$" + field}); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range []string{"email", "username"} {
+		err := users.Indexes().DropOne(ctx, field+"_1")
+		var serverErr mongo.ServerError
+		if err != nil && !(errors.As(err, &serverErr) && serverErr.HasErrorCode(indexNotFound)) {
+			return err
+		}
+	}
+
+	_, err = users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(caseInsensitive),
+		},
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetUnique(true).SetCollation(caseInsensitive),
+		},
+	})
+	return err
+}
+
+// createDeletedUserIndex lets the purge of deleted accounts find them without
+// scanning the users. Only deleted users are indexed.
+func createDeletedUserIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// expireSentMail drops the bodies of the messages already sent, which are
+// no longer needed, and lets MongoDB delete them after the retention period.
+// The recipient index serves the purge of deleted accounts.
+func expireSentMail(ctx context.Context, db *mongo.Database) error {
+	mailOutbox := db.Collection("mail_outbox")
+
+	_, err := mailOutbox.UpdateMany(ctx,
+		bson.M{"status": models.MailStatusSent},
+		bson.M{"$unset": bson.M{"text": "", "html": ""}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = mailOutbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sent_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(sentMailRetention.Seconds())),
+		},
+		{Keys: bson.D{{Key: "to", Value: 1}}},
+	})
+	return err
+}
+
+// createAuditIdentifierIndexes lets the purge of deleted accounts find the
+// events naming them, as failed logins, without scanning the audit log.
+func createAuditIdentifierIndexes(ctx context.Context, db *mongo.Database) error {
+	var indexes []mongo.IndexModel
+	for _, key := range models.AuditIdentifyingDetails {
+		indexes = append(indexes, mongo.IndexModel{
+			Keys:    bson.D{{Key: "details." + key, Value: 1}},
+			Options: options.Index().SetSparse(true).SetCollation(caseInsensitive),
+		})
+	}
+
+	_, err := db.Collection("audit_log").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+// indexEventsByUser lets the purge of deleted accounts find their events and
+// webhook deliveries. Deliveries didn't record the user, which is read back
+// from the "user_id" of their payload.
+func indexEventsByUser(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("event_outbox").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	deliveries := db.Collection("webhook_deliveries")
+	match := bson.M{"$regexFind": bson.M{"input": "$payload", "regex": `"user_id":"([0-9a-f]{24})"`}}
+	_, err = deliveries.UpdateMany(ctx,
+		bson.M{"user_id": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"user_id": bson.M{"$let": bson.M{
+			"vars": bson.M{"match": match},
+			"in": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$$match", nil}},
+				"$$REMOVE",
+				bson.M{"$toObjectId": bson.M{"$arrayElemAt": bson.A{"$$match.captures", 0}}},
+			}},
+		}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = deliveries.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	return err
+}
+
+// checkDuplicates fails with the values of the field duplicated once turned
+// into key, which have to be resolved by hand before a unique index can be
+// created.
+func checkDuplicates(ctx context.Context, collection *mongo.Collection, field string, key any) error {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": key, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: 10}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var duplicates []struct {
+		Value any `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(duplicates))
+	for i, duplicate := range duplicates {
+		values[i] = fmt.Sprint(duplicate.Value)
+	}
+	return fmt.Errorf("%s has duplicated values in %s: %s", collection.Name(), field, strings.Join(values, ", "))
+}
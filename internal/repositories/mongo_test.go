@@ -0,0 +1,58 @@
+package repositories_test
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/repositories/repotest"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The suite runs against a throwaway database of the server given by
+// TEST_MONGODB_URI, which must be a replica set.
+func TestMongoRepositories(t *testing.T) {
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	db, err := database.Connect(ctx, uri, "repotest_"+bson.NewObjectID().Hex())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		db.Client.Drop(ctx)
+		db.Disconnect(ctx)
+	})
+
+	if err := db.CheckTransactions(ctx); err != nil {
+		t.Fatalf("CheckTransactions: %v", err)
+	}
+
+	if err := repositories.Migrate(ctx, db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	repotest.RunAll(t, repotest.Repositories{
+		Users:             repositories.NewUserRepository(db),
+		RefreshTokens:     repositories.NewRefreshTokenRepository(db),
+		EventOutbox:       repositories.NewEventOutboxRepository(db),
+		Groups:            repositories.NewGroupRepository(db),
+		OneTimeTokens:     repositories.NewOneTimeTokenRepository(db),
+		MailOutbox:        repositories.NewMailOutboxRepository(db),
+		AuditLog:          repositories.NewAuditLogRepository(db),
+		Webhooks:          repositories.NewWebhookRepository(db),
+		WebhookDeliveries: repositories.NewWebhookDeliveryRepository(db),
+		SAMLRequests:      repositories.NewSAMLRequestRepository(db),
+	})
+}
@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type OneTimeTokenRepositoryInterface interface {
+	Create(ctx context.Context, token *models.OneTimeToken) error
+	Consume(ctx context.Context, purpose, tokenHash, bindingHash string) (*models.OneTimeToken, error)
+	FindLatest(ctx context.Context, userID bson.ObjectID, purpose string) (*models.OneTimeToken, error)
+	ClaimAttempt(ctx context.Context, userID bson.ObjectID, purpose string, maxAttempts int) (*models.OneTimeToken, error)
+	Delete(ctx context.Context, id bson.ObjectID) error
+	DeleteByUser(ctx context.Context, userID bson.ObjectID, purpose string) error
+}
+
+type OneTimeTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewOneTimeTokenRepository(db *database.Database) *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{
+		collection: db.Client.Collection("one_time_tokens"),
+	}
+}
+
+func (r *OneTimeTokenRepository) Create(ctx context.Context, token *models.OneTimeToken) error {
+	_, err := r.collection.InsertOne(ctx, token)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Consume atomically deletes and returns the token matching the hash and the
+// browser binding, so a token can be used only once.
+func (r *OneTimeTokenRepository) Consume(ctx context.Context, purpose, tokenHash, bindingHash string) (*models.OneTimeToken, error) {
+	filter := bson.M{"purpose": purpose, "token_hash": tokenHash}
+	if bindingHash != "" {
+		filter["binding_hash"] = bindingHash
+	}
+
+	var token models.OneTimeToken
+	err := r.collection.FindOneAndDelete(ctx, filter).Decode(&token)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &token, nil
+}
+
+func (r *OneTimeTokenRepository) FindLatest(ctx context.Context, userID bson.ObjectID, purpose string) (*models.OneTimeToken, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	var token models.OneTimeToken
+	err := r.collection.FindOne(ctx, bson.M{"user_id": userID, "purpose": purpose}, opts).Decode(&token)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &token, nil
+}
+
+// ClaimAttempt atomically counts an attempt on the latest token of the
+// purpose that has fewer than maxAttempts, and returns it with the attempt
+// counted. Concurrent guesses can't exceed maxAttempts between them.
+func (r *OneTimeTokenRepository) ClaimAttempt(ctx context.Context, userID bson.ObjectID, purpose string, maxAttempts int) (*models.OneTimeToken, error) {
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetReturnDocument(options.After)
+
+	var token models.OneTimeToken
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID, "purpose": purpose, "attempts": bson.M{"$lt": maxAttempts}},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		opts,
+	).Decode(&token)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &token, nil
+}
+
+// Delete returns ErrNotFound when the token was already deleted, so that
+// only one caller can use it.
+func (r *OneTimeTokenRepository) Delete(ctx context.Context, id bson.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *OneTimeTokenRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID, purpose string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose})
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"authentication-jwt/internal/database"
 	"authentication-jwt/internal/models"
 	"context"
-	"fmt"
+	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -14,7 +14,10 @@ import (
 type RefreshTokenRepositoryInterface interface {
 	Create(ctx context.Context, token *models.RefreshToken) error
 	FindByToken(ctx context.Context, token string) (*models.RefreshToken, error)
+	Revoke(ctx context.Context, id bson.ObjectID) (bool, error)
 	Delete(ctx context.Context, token string) error
+	DeleteByUser(ctx context.Context, userID bson.ObjectID) error
+	ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.RefreshToken, error)
 }
 
 type RefreshTokenRepository struct {
@@ -22,41 +25,17 @@ type RefreshTokenRepository struct {
 }
 
 func NewRefreshTokenRepository(db *database.Database) *RefreshTokenRepository {
-	collection := db.Client.Collection("refresh_tokens")
-
-	indexModel := mongo.IndexModel{
-		Keys: bson.D{
-			{Key: "user_id", Value: 1},
-			{Key: "token", Value: 1},
-		},
-		Options: options.Index().SetUnique(true),
-	}
-
-	_, err := collection.Indexes().CreateOne(context.Background(), indexModel)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to create index on refresh_tokens collection: %v", err))
-	}
-
 	return &RefreshTokenRepository{
-		collection: collection,
+		collection: db.Client.Collection("refresh_tokens"),
 	}
 }
 
+// Create stores the refresh token of a new session. A user has one refresh
+// token per logged in device.
 func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
-	filter := bson.M{"user_id": token.UserID}
-	update := bson.M{
-		"$set": bson.M{
-			"token":      token.Token,
-			"created_at": token.CreatedAt,
-			"expires_at": token.ExpiresAt,
-			"updated_at": token.UpdatedAt,
-		},
-	}
-	opts := options.UpdateOne().SetUpsert(true)
-
-	_, err := r.collection.UpdateOne(ctx, filter, update, opts)
+	_, err := r.collection.InsertOne(ctx, token)
 	if err != nil {
-		return err
+		return mongoError(err)
 	}
 	return nil
 }
@@ -65,19 +44,59 @@ func (r *RefreshTokenRepository) FindByToken(ctx context.Context, token string)
 	var refreshToken models.RefreshToken
 	err := r.collection.FindOne(ctx, bson.M{"token": token}).Decode(&refreshToken)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil // No refresh token found for the user
-		}
-		return nil, err
+		return nil, mongoError(err)
 	}
 	return &refreshToken, nil
 }
 
+// Revoke marks the token as exchanged. It reports false if the token was
+// already revoked, so two concurrent refreshes can't both succeed.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id bson.ObjectID) (bool, error) {
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now(), "updated_at": time.Now()}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
 func (r *RefreshTokenRepository) Delete(ctx context.Context, token string) error {
-	_, err := r.collection.DeleteOne(ctx, bson.M{"token": token})
+	result, err := r.collection.DeleteOne(ctx, bson.M{"token": token})
 	if err != nil {
 		return err
 	}
 
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
 	return nil
 }
+
+func (r *RefreshTokenRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ListByUser returns the refresh tokens of the user, oldest first.
+func (r *RefreshTokenRepository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.RefreshToken, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []*models.RefreshToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
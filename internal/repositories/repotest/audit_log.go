@@ -0,0 +1,199 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestAuditLogRepository(t *testing.T, repository repositories.AuditLogRepositoryInterface) {
+	ctx := testContext(t)
+	actorID := uniqueName("actor")
+
+	var appended []*models.AuditEvent
+	for i, outcome := range []string{models.AuditOutcomeSuccess, models.AuditOutcomeFailure, models.AuditOutcomeSuccess} {
+		event := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionLogin,
+			Outcome:   outcome,
+			ActorID:   actorID,
+			TargetID:  actorID,
+			IPAddress: "192.0.2.1",
+			UserAgent: "repotest",
+			RequestID: uniqueName("request"),
+			Details:   map[string]string{"attempt": string(rune('1' + i))},
+			CreatedAt: time.Now(),
+		}
+		mustNotFail(t, "Append", repository.Append(ctx, event))
+		appended = append(appended, event)
+	}
+
+	t.Run("NewestFirst", func(t *testing.T) {
+		events, err := repository.List(ctx, repositories.AuditFilter{ActorID: actorID}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 3 {
+			t.Fatalf("List returned %d events, want 3", len(events))
+		}
+		for i, event := range events {
+			want := appended[len(appended)-1-i]
+			assertEqual(t, "ID", event.ID, want.ID)
+			assertEqual(t, "RequestID", event.RequestID, want.RequestID)
+			assertEqual(t, "Details[attempt]", event.Details["attempt"], want.Details["attempt"])
+			assertTime(t, "CreatedAt", event.CreatedAt, want.CreatedAt)
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		page, err := repository.List(ctx, repositories.AuditFilter{ActorID: actorID}, bson.NilObjectID, 2)
+		mustNotFail(t, "List", err)
+		if len(page) != 2 {
+			t.Fatalf("first page has %d events, want 2", len(page))
+		}
+
+		page, err = repository.List(ctx, repositories.AuditFilter{ActorID: actorID}, page[1].ID, 2)
+		mustNotFail(t, "List", err)
+		if len(page) != 1 || page[0].ID != appended[0].ID {
+			t.Errorf("second page = %v, want the oldest event", page)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		events, err := repository.List(ctx, repositories.AuditFilter{ActorID: actorID, Outcome: models.AuditOutcomeFailure}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 1 || events[0].ID != appended[1].ID {
+			t.Errorf("List by outcome = %v, want the failure", events)
+		}
+
+		events, err = repository.List(ctx, repositories.AuditFilter{TargetID: actorID, Since: time.Now().Add(time.Minute)}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 0 {
+			t.Errorf("List since the future = %v, want none", events)
+		}
+
+		events, err = repository.List(ctx, repositories.AuditFilter{Action: models.AuditActionLogin, ActorID: actorID, Until: time.Now().Add(time.Minute)}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 3 {
+			t.Errorf("List until the future returned %d events, want 3", len(events))
+		}
+	})
+
+	t.Run("Identifiers", func(t *testing.T) {
+		identifier := uniqueName("Named") + "@Example.com"
+		naming := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionLogin,
+			Outcome:   models.AuditOutcomeFailure,
+			Details:   map[string]string{"identifier": identifier},
+			CreatedAt: time.Now(),
+		}
+		mustNotFail(t, "Append", repository.Append(ctx, naming))
+
+		events, err := repository.List(ctx, repositories.AuditFilter{Identifiers: []string{uniqueName("other"), strings.ToLower(identifier)}}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 1 || events[0].ID != naming.ID {
+			t.Errorf("List by identifier = %v, want %s", events, naming.ID.Hex())
+		}
+
+		events, err = repository.List(ctx, repositories.AuditFilter{Identifiers: []string{identifier}, Outcome: models.AuditOutcomeSuccess}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 0 {
+			t.Errorf("List by identifier and outcome = %v, want none", events)
+		}
+	})
+
+	t.Run("AnonymizeUser", func(t *testing.T) {
+		userID := uniqueName("deleted")
+		byAdmin := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionRevokeAllSessions,
+			Outcome:   models.AuditOutcomeSuccess,
+			ActorID:   "admin",
+			TargetID:  userID,
+			IPAddress: "192.0.2.2",
+			UserAgent: "admin-agent",
+			Details:   map[string]string{"email": "user@example.com", "reason": "test"},
+			CreatedAt: time.Now(),
+		}
+		byUser := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionLogin,
+			Outcome:   models.AuditOutcomeFailure,
+			ActorID:   userID,
+			TargetID:  userID,
+			IPAddress: "192.0.2.3",
+			UserAgent: "user-agent",
+			Details:   map[string]string{"identifier": "user@example.com"},
+			CreatedAt: time.Now(),
+		}
+		failedLogin := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionLogin,
+			Outcome:   models.AuditOutcomeFailure,
+			IPAddress: "192.0.2.4",
+			Details:   map[string]string{"reason": "invalid credentials", "identifier": strings.ToUpper(userID)},
+			CreatedAt: time.Now(),
+		}
+		failedRegistration := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionRegister,
+			Outcome:   models.AuditOutcomeFailure,
+			Details:   map[string]string{"reason": "duplicate", "email": userID + "@example.com"},
+			CreatedAt: time.Now(),
+		}
+		otherLogin := &models.AuditEvent{
+			ID:        bson.NewObjectID(),
+			Action:    models.AuditActionLogin,
+			Outcome:   models.AuditOutcomeFailure,
+			Details:   map[string]string{"identifier": "other-" + userID},
+			CreatedAt: time.Now(),
+		}
+		for _, event := range []*models.AuditEvent{byAdmin, byUser, failedLogin, failedRegistration, otherLogin} {
+			mustNotFail(t, "Append", repository.Append(ctx, event))
+		}
+
+		mustNotFail(t, "AnonymizeUser", repository.AnonymizeUser(ctx, userID, []string{userID, userID + "@example.com"}))
+
+		events, err := repository.List(ctx, repositories.AuditFilter{TargetID: userID}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) != 0 {
+			t.Errorf("List by the deleted user = %v, want none", events)
+		}
+
+		events, err = repository.List(ctx, repositories.AuditFilter{ActorID: "admin", TargetID: models.AuditDeletedUser}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) == 0 || events[0].ID != byAdmin.ID {
+			t.Fatalf("List of the anonymized event = %v, want %s", events, byAdmin.ID.Hex())
+		}
+		assertEqual(t, "IPAddress of the admin", events[0].IPAddress, byAdmin.IPAddress)
+		assertEqual(t, "Details[reason]", events[0].Details["reason"], "test")
+		assertEqual(t, "Details[email]", events[0].Details["email"], "")
+
+		events, err = repository.List(ctx, repositories.AuditFilter{ActorID: models.AuditDeletedUser}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		if len(events) == 0 || events[0].ID != byUser.ID {
+			t.Fatalf("List of the anonymized event = %v, want %s", events, byUser.ID.Hex())
+		}
+		assertEqual(t, "TargetID", events[0].TargetID, models.AuditDeletedUser)
+		assertEqual(t, "IPAddress", events[0].IPAddress, "")
+		assertEqual(t, "UserAgent", events[0].UserAgent, "")
+		assertEqual(t, "Details[identifier]", events[0].Details["identifier"], "")
+
+		events, err = repository.List(ctx, repositories.AuditFilter{Outcome: models.AuditOutcomeFailure}, bson.NilObjectID, 10)
+		mustNotFail(t, "List", err)
+		found := map[bson.ObjectID]*models.AuditEvent{}
+		for _, event := range events {
+			found[event.ID] = event
+		}
+		if found[failedLogin.ID] == nil || found[failedRegistration.ID] == nil || found[otherLogin.ID] == nil {
+			t.Fatalf("List of the failures = %v", events)
+		}
+		assertEqual(t, "Details[identifier] of the failed login", found[failedLogin.ID].Details["identifier"], "")
+		assertEqual(t, "Details[reason] of the failed login", found[failedLogin.ID].Details["reason"], "invalid credentials")
+		assertEqual(t, "Details[email] of the failed registration", found[failedRegistration.ID].Details["email"], "")
+		assertEqual(t, "Details[identifier] of another user", found[otherLogin.ID].Details["identifier"], otherLogin.Details["identifier"])
+	})
+}
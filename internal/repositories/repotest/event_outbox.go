@@ -0,0 +1,121 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestEventOutboxRepository expects an empty outbox, as ClaimDue picks any
+// due event.
+func TestEventOutboxRepository(t *testing.T, repository repositories.EventOutboxRepositoryInterface) {
+	ctx := testContext(t)
+	user := newUser(t, uniqueName("events"))
+
+	mustNotFail(t, "Append", repository.Append(ctx))
+
+	claimed, err := repository.ClaimDue(ctx, time.Minute)
+	mustNotFail(t, "ClaimDue", err)
+	if claimed != nil {
+		t.Fatalf("ClaimDue on an empty outbox = %v, want nil", claimed)
+	}
+
+	older := models.NewDomainEvent(models.EventUserRegistered, user, map[string]string{"source": "test"})
+	older.NextAttemptAt = time.Now().Add(-time.Minute)
+	newer := models.NewDomainEvent(models.EventUserLoggedIn, user, nil)
+	later := models.NewDomainEvent(models.EventPasswordChanged, user, nil)
+	later.NextAttemptAt = time.Now().Add(time.Hour)
+	mustNotFail(t, "Append", repository.Append(ctx, later, newer, older))
+
+	t.Run("ClaimDueInOrder", func(t *testing.T) {
+		first, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if first == nil || first.ID != older.ID {
+			t.Fatalf("first ClaimDue = %v, want the oldest due event %s", first, older.ID.Hex())
+		}
+		assertEqual(t, "Type", first.Type, older.Type)
+		assertEqual(t, "UserID", first.UserID, user.ID)
+		assertEqual(t, "Data[source]", first.Data["source"], "test")
+		assertEqual(t, "Data[email]", first.Data["email"], user.Email)
+		assertEqual(t, "Attempts", first.Attempts, 1)
+		assertTime(t, "OccurredAt", first.OccurredAt, older.OccurredAt)
+
+		second, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if second == nil || second.ID != newer.ID {
+			t.Fatalf("second ClaimDue = %v, want %s", second, newer.ID.Hex())
+		}
+
+		// Both are leased and the last one isn't due.
+		none, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if none != nil {
+			t.Errorf("ClaimDue returned the leased or future event %s", none.ID.Hex())
+		}
+	})
+
+	t.Run("MarkDeliveredAndRetry", func(t *testing.T) {
+		mustNotFail(t, "MarkDelivered", repository.MarkDelivered(ctx, older.ID, "webhooks"))
+		mustNotFail(t, "MarkDelivered", repository.MarkDelivered(ctx, older.ID, "webhooks"))
+		mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, older.ID, time.Now().Add(-time.Second), "mail: boom"))
+
+		retried, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if retried == nil || retried.ID != older.ID {
+			t.Fatalf("ClaimDue after MarkRetry = %v, want %s", retried, older.ID.Hex())
+		}
+		assertEqual(t, "Attempts", retried.Attempts, 2)
+		assertEqual(t, "LastError", retried.LastError, "mail: boom")
+		if !slices.Equal(retried.Delivered, []string{"webhooks"}) {
+			t.Errorf("Delivered = %v, want [webhooks]", retried.Delivered)
+		}
+	})
+
+	t.Run("MarkDispatchedAndFailed", func(t *testing.T) {
+		mustNotFail(t, "MarkDispatched", repository.MarkDispatched(ctx, older.ID))
+		mustNotFail(t, "MarkFailed", repository.MarkFailed(ctx, newer.ID, "gave up"))
+		mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, older.ID, time.Now().Add(-time.Second), ""))
+		mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, newer.ID, time.Now().Add(-time.Second), "gave up"))
+
+		// Neither dispatched nor failed events are claimed again.
+		none, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if none != nil {
+			t.Errorf("ClaimDue returned the finished event %s", none.ID.Hex())
+		}
+	})
+
+	t.Run("DeleteByUser", func(t *testing.T) {
+		other := newUser(t, uniqueName("events"))
+		ofUser := models.NewDomainEvent(models.EventUserLoggedIn, user, nil)
+		ofOther := models.NewDomainEvent(models.EventUserLoggedIn, other, nil)
+		ofUser.NextAttemptAt = time.Now().Add(-time.Minute)
+		mustNotFail(t, "Append", repository.Append(ctx, ofUser, ofOther))
+
+		events, err := repository.ListByUser(ctx, user.ID)
+		mustNotFail(t, "ListByUser", err)
+		if len(events) == 0 || events[0].ID != ofUser.ID {
+			t.Errorf("ListByUser = %v, want the newest event %s first", events, ofUser.ID.Hex())
+		}
+		for _, event := range events {
+			assertEqual(t, "UserID", event.UserID, user.ID)
+		}
+
+		mustNotFail(t, "DeleteByUser", repository.DeleteByUser(ctx, user.ID))
+
+		events, err = repository.ListByUser(ctx, user.ID)
+		mustNotFail(t, "ListByUser", err)
+		if len(events) != 0 {
+			t.Errorf("ListByUser after DeleteByUser = %v, want none", events)
+		}
+
+		claimed, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if claimed == nil || claimed.ID != ofOther.ID {
+			t.Fatalf("ClaimDue after DeleteByUser = %v, want the event of another user %s", claimed, ofOther.ID.Hex())
+		}
+		mustNotFail(t, "MarkDispatched", repository.MarkDispatched(ctx, ofOther.ID))
+	})
+}
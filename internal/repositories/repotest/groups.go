@@ -0,0 +1,98 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestGroupRepository(t *testing.T, repository repositories.GroupRepositoryInterface) {
+	ctx := testContext(t)
+	member := bson.NewObjectID()
+	other := bson.NewObjectID()
+
+	group, err := models.NewGroup(uniqueName("group"))
+	mustNotFail(t, "NewGroup", err)
+	group.ExternalID = uniqueName("external")
+	group.AddMember(member)
+	group.AddMember(other)
+	mustNotFail(t, "Create", repository.Create(ctx, group))
+
+	t.Run("CreateAndFind", func(t *testing.T) {
+		found, err := repository.FindById(ctx, group.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		if found == nil {
+			t.Fatal("FindById didn't find the group")
+		}
+		assertEqual(t, "DisplayName", found.DisplayName, group.DisplayName)
+		assertEqual(t, "ExternalID", found.ExternalID, group.ExternalID)
+		if !slices.Equal(found.Members, group.Members) {
+			t.Errorf("Members = %v, want %v", found.Members, group.Members)
+		}
+
+		missing, err := repository.FindById(ctx, bson.NewObjectID().Hex())
+		assertNotFound(t, "FindById of a missing group", missing, err)
+
+		if _, err := repository.FindById(ctx, "not-an-id"); err == nil {
+			t.Error("FindById accepted an invalid ID")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		groups, total, err := repository.List(ctx, repositories.GroupFilter{ExternalID: group.ExternalID}, 0, 10)
+		mustNotFail(t, "List", err)
+		assertEqual(t, "total", total, int64(1))
+		if len(groups) != 1 || groups[0].ID != group.ID {
+			t.Errorf("List by external ID = %v, want the group", groups)
+		}
+
+		groups, total, err = repository.List(ctx, repositories.GroupFilter{DisplayName: uniqueName("missing")}, 0, 10)
+		mustNotFail(t, "List", err)
+		if total != 0 || len(groups) != 0 {
+			t.Errorf("List of a missing name = %v (%d), want none", groups, total)
+		}
+	})
+
+	t.Run("ListByMember", func(t *testing.T) {
+		groups, err := repository.ListByMember(ctx, member)
+		mustNotFail(t, "ListByMember", err)
+		if len(groups) != 1 || groups[0].ID != group.ID {
+			t.Errorf("ListByMember = %v, want the group", groups)
+		}
+
+		groups, err = repository.ListByMember(ctx, bson.NewObjectID())
+		mustNotFail(t, "ListByMember", err)
+		if len(groups) != 0 {
+			t.Errorf("ListByMember of a user in no group = %v, want none", groups)
+		}
+	})
+
+	t.Run("UpdateAndRemoveMember", func(t *testing.T) {
+		group.DisplayName = uniqueName("renamed")
+		mustNotFail(t, "Update", repository.Update(ctx, group))
+		mustNotFail(t, "RemoveMember", repository.RemoveMember(ctx, member))
+
+		found, err := repository.FindById(ctx, group.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertEqual(t, "DisplayName", found.DisplayName, group.DisplayName)
+		if !slices.Equal(found.Members, []bson.ObjectID{other}) {
+			t.Errorf("Members = %v, want [%s]", found.Members, other.Hex())
+		}
+
+		groups, err := repository.ListByMember(ctx, member)
+		mustNotFail(t, "ListByMember", err)
+		if len(groups) != 0 {
+			t.Errorf("ListByMember of a removed member = %v, want none", groups)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		mustNotFail(t, "Delete", repository.Delete(ctx, group.ID.Hex()))
+
+		found, err := repository.FindById(ctx, group.ID.Hex())
+		assertNotFound(t, "FindById of a deleted group", found, err)
+	})
+}
@@ -0,0 +1,80 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"testing"
+	"time"
+)
+
+// TestMailOutboxRepository expects an empty outbox, as ClaimDue picks any due
+// message.
+func TestMailOutboxRepository(t *testing.T, repository repositories.MailOutboxRepositoryInterface) {
+	ctx := testContext(t)
+
+	message := models.NewMailMessage("user@example.com", "Subject", "Text", "<p>HTML</p>")
+	later := models.NewMailMessage("user@example.com", "Later", "Text", "")
+	later.NextAttemptAt = time.Now().Add(time.Hour)
+	mustNotFail(t, "Enqueue", repository.Enqueue(ctx, message))
+	mustNotFail(t, "Enqueue", repository.Enqueue(ctx, later))
+
+	claimed, err := repository.ClaimDue(ctx, time.Minute)
+	mustNotFail(t, "ClaimDue", err)
+	if claimed == nil || claimed.ID != message.ID {
+		t.Fatalf("ClaimDue = %v, want %s", claimed, message.ID.Hex())
+	}
+	assertEqual(t, "To", claimed.To, message.To)
+	assertEqual(t, "Subject", claimed.Subject, message.Subject)
+	assertEqual(t, "Text", claimed.Text, message.Text)
+	assertEqual(t, "HTML", claimed.HTML, message.HTML)
+	assertEqual(t, "Attempts", claimed.Attempts, 1)
+
+	none, err := repository.ClaimDue(ctx, time.Minute)
+	mustNotFail(t, "ClaimDue", err)
+	if none != nil {
+		t.Errorf("ClaimDue returned the leased or future message %s", none.ID.Hex())
+	}
+
+	mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, message.ID, time.Now().Add(-time.Second), "smtp: boom"))
+	retried, err := repository.ClaimDue(ctx, time.Minute)
+	mustNotFail(t, "ClaimDue", err)
+	if retried == nil || retried.ID != message.ID {
+		t.Fatalf("ClaimDue after MarkRetry = %v, want %s", retried, message.ID.Hex())
+	}
+	assertEqual(t, "Attempts", retried.Attempts, 2)
+	assertEqual(t, "LastError", retried.LastError, "smtp: boom")
+
+	mustNotFail(t, "MarkSent", repository.MarkSent(ctx, message.ID))
+	mustNotFail(t, "MarkFailed", repository.MarkFailed(ctx, later.ID, "gave up"))
+	mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, message.ID, time.Now().Add(-time.Second), ""))
+	mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, later.ID, time.Now().Add(-time.Second), "gave up"))
+
+	none, err = repository.ClaimDue(ctx, time.Minute)
+	mustNotFail(t, "ClaimDue", err)
+	if none != nil {
+		t.Errorf("ClaimDue returned the finished message %s", none.ID.Hex())
+	}
+
+	purged := models.NewMailMessage(uniqueName("purged")+"@example.com", "Subject", "Text", "")
+	mustNotFail(t, "Enqueue", repository.Enqueue(ctx, purged))
+
+	messages, err := repository.ListByRecipient(ctx, purged.To)
+	mustNotFail(t, "ListByRecipient", err)
+	if len(messages) != 1 || messages[0].ID != purged.ID {
+		t.Errorf("ListByRecipient = %v, want %s", messages, purged.ID.Hex())
+	}
+
+	mustNotFail(t, "DeleteByRecipient", repository.DeleteByRecipient(ctx, purged.To))
+
+	messages, err = repository.ListByRecipient(ctx, purged.To)
+	mustNotFail(t, "ListByRecipient", err)
+	if len(messages) != 0 {
+		t.Errorf("ListByRecipient after DeleteByRecipient = %v, want none", messages)
+	}
+
+	none, err = repository.ClaimDue(ctx, time.Minute)
+	mustNotFail(t, "ClaimDue", err)
+	if none != nil {
+		t.Errorf("ClaimDue returned the message %s deleted by recipient", none.ID.Hex())
+	}
+}
@@ -0,0 +1,105 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestOneTimeTokenRepository(t *testing.T, repository repositories.OneTimeTokenRepositoryInterface) {
+	t.Run("ConsumeOnce", func(t *testing.T) {
+		ctx := testContext(t)
+		token := models.NewOneTimeToken(bson.NewObjectID(), models.PurposeMagicLink, uniqueName("hash"), "binding", time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, token))
+
+		wrongBinding, err := repository.Consume(ctx, token.Purpose, token.TokenHash, "other")
+		assertNotFound(t, "Consume with another binding", wrongBinding, err)
+
+		consumed, err := repository.Consume(ctx, token.Purpose, token.TokenHash, token.BindingHash)
+		mustNotFail(t, "Consume", err)
+		if consumed == nil {
+			t.Fatal("Consume didn't find the token")
+		}
+		assertEqual(t, "ID", consumed.ID, token.ID)
+		assertEqual(t, "UserID", consumed.UserID, token.UserID)
+		assertTime(t, "ExpiresAt", consumed.ExpiresAt, token.ExpiresAt)
+
+		again, err := repository.Consume(ctx, token.Purpose, token.TokenHash, token.BindingHash)
+		assertNotFound(t, "second Consume", again, err)
+	})
+
+	t.Run("FindLatestAndAttempts", func(t *testing.T) {
+		ctx := testContext(t)
+		userID := bson.NewObjectID()
+		older := models.NewOneTimeToken(userID, models.PurposeLoginCode, uniqueName("hash"), "", time.Now().Add(time.Hour))
+		older.CreatedAt = time.Now().Add(-time.Minute)
+		latest := models.NewOneTimeToken(userID, models.PurposeLoginCode, uniqueName("hash"), "", time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, older))
+		mustNotFail(t, "Create", repository.Create(ctx, latest))
+
+		for attempt := 1; attempt <= 2; attempt++ {
+			claimed, err := repository.ClaimAttempt(ctx, userID, models.PurposeLoginCode, 2)
+			mustNotFail(t, "ClaimAttempt", err)
+			if claimed == nil || claimed.ID != latest.ID {
+				t.Fatalf("ClaimAttempt = %v, want %s", claimed, latest.ID.Hex())
+			}
+			assertEqual(t, "Attempts", claimed.Attempts, attempt)
+		}
+
+		found, err := repository.FindLatest(ctx, userID, models.PurposeLoginCode)
+		mustNotFail(t, "FindLatest", err)
+		if found == nil || found.ID != latest.ID {
+			t.Fatalf("FindLatest = %v, want %s", found, latest.ID.Hex())
+		}
+		assertEqual(t, "Attempts", found.Attempts, 2)
+
+		none, err := repository.FindLatest(ctx, userID, models.PurposeMFACode)
+		assertNotFound(t, "FindLatest of another purpose", none, err)
+
+		mustNotFail(t, "Delete", repository.Delete(ctx, latest.ID))
+		if err := repository.Delete(ctx, latest.ID); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("second Delete = %v, want ErrNotFound", err)
+		}
+		found, err = repository.FindLatest(ctx, userID, models.PurposeLoginCode)
+		mustNotFail(t, "FindLatest", err)
+		if found == nil || found.ID != older.ID {
+			t.Fatalf("FindLatest after Delete = %v, want %s", found, older.ID.Hex())
+		}
+
+		mustNotFail(t, "DeleteByUser", repository.DeleteByUser(ctx, userID, models.PurposeLoginCode))
+		found, err = repository.FindLatest(ctx, userID, models.PurposeLoginCode)
+		assertNotFound(t, "FindLatest after DeleteByUser", found, err)
+	})
+
+	t.Run("ClaimAttemptConcurrently", func(t *testing.T) {
+		ctx := testContext(t)
+		userID := bson.NewObjectID()
+		token := models.NewOneTimeToken(userID, models.PurposeLoginCode, uniqueName("hash"), "", time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, token))
+
+		const maxAttempts, guesses = 3, 10
+		var wg sync.WaitGroup
+		var claimed atomic.Int32
+		for range guesses {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repository.ClaimAttempt(ctx, userID, models.PurposeLoginCode, maxAttempts)
+				if err == nil {
+					claimed.Add(1)
+				} else if !errors.Is(err, repositories.ErrNotFound) {
+					t.Errorf("ClaimAttempt: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assertEqual(t, "claimed attempts", int(claimed.Load()), maxAttempts)
+	})
+}
@@ -0,0 +1,114 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRefreshTokenRepository(t *testing.T, repository repositories.RefreshTokenRepositoryInterface) {
+	t.Run("CreateAndFind", func(t *testing.T) {
+		ctx := testContext(t)
+		token := models.NewRefreshToken(uniqueName("token"), bson.NewObjectID(), time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, token))
+
+		found, err := repository.FindByToken(ctx, token.Token)
+		mustNotFail(t, "FindByToken", err)
+		if found == nil {
+			t.Fatal("FindByToken didn't find the token")
+		}
+		assertEqual(t, "ID", found.ID, token.ID)
+		assertEqual(t, "UserID", found.UserID, token.UserID)
+		assertTime(t, "ExpiresAt", found.ExpiresAt, token.ExpiresAt)
+		if found.RevokedAt != nil {
+			t.Errorf("RevokedAt = %v, want nil", found.RevokedAt)
+		}
+
+		missing, err := repository.FindByToken(ctx, uniqueName("missing"))
+		assertNotFound(t, "FindByToken of a missing token", missing, err)
+	})
+
+	t.Run("SessionsPerUser", func(t *testing.T) {
+		ctx := testContext(t)
+		userID := bson.NewObjectID()
+		first := models.NewRefreshToken(uniqueName("first"), userID, time.Now().Add(time.Hour))
+		second := models.NewRefreshToken(uniqueName("second"), userID, time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, first))
+		mustNotFail(t, "Create", repository.Create(ctx, second))
+
+		for _, token := range []*models.RefreshToken{first, second} {
+			found, err := repository.FindByToken(ctx, token.Token)
+			mustNotFail(t, "FindByToken", err)
+			if found == nil {
+				t.Errorf("token %s of a second session was replaced", token.Token)
+			}
+		}
+
+		tokens, err := repository.ListByUser(ctx, userID)
+		mustNotFail(t, "ListByUser", err)
+		if len(tokens) != 2 || tokens[0].ID != first.ID || tokens[1].ID != second.ID {
+			t.Errorf("ListByUser = %v, want both sessions, oldest first", tokens)
+		}
+	})
+
+	t.Run("DuplicateToken", func(t *testing.T) {
+		ctx := testContext(t)
+		token := models.NewRefreshToken(uniqueName("duplicate"), bson.NewObjectID(), time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, token))
+
+		again := models.NewRefreshToken(token.Token, bson.NewObjectID(), time.Now().Add(time.Hour))
+		if err := repository.Create(ctx, again); err == nil {
+			t.Error("Create stored the same token twice")
+		}
+	})
+
+	t.Run("RevokeOnce", func(t *testing.T) {
+		ctx := testContext(t)
+		token := models.NewRefreshToken(uniqueName("revoke"), bson.NewObjectID(), time.Now().Add(time.Hour))
+		mustNotFail(t, "Create", repository.Create(ctx, token))
+
+		revoked, err := repository.Revoke(ctx, token.ID)
+		mustNotFail(t, "Revoke", err)
+		assertEqual(t, "first Revoke", revoked, true)
+
+		revoked, err = repository.Revoke(ctx, token.ID)
+		mustNotFail(t, "Revoke", err)
+		assertEqual(t, "second Revoke", revoked, false)
+
+		found, err := repository.FindByToken(ctx, token.Token)
+		mustNotFail(t, "FindByToken", err)
+		if found == nil || found.RevokedAt == nil {
+			t.Errorf("revoked token = %v, want RevokedAt set", found)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := testContext(t)
+		userID := bson.NewObjectID()
+		token := models.NewRefreshToken(uniqueName("delete"), bson.NewObjectID(), time.Now().Add(time.Hour))
+		first := models.NewRefreshToken(uniqueName("user"), userID, time.Now().Add(time.Hour))
+		second := models.NewRefreshToken(uniqueName("user"), userID, time.Now().Add(time.Hour))
+		for _, created := range []*models.RefreshToken{token, first, second} {
+			mustNotFail(t, "Create", repository.Create(ctx, created))
+		}
+
+		mustNotFail(t, "Delete", repository.Delete(ctx, token.Token))
+		mustNotFail(t, "DeleteByUser", repository.DeleteByUser(ctx, userID))
+
+		for _, deleted := range []*models.RefreshToken{token, first, second} {
+			found, err := repository.FindByToken(ctx, deleted.Token)
+			assertNotFound(t, "FindByToken of a deleted token", found, err)
+		}
+
+		if err := repository.Delete(ctx, token.Token); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Delete of a deleted token = %v, want ErrNotFound", err)
+		}
+		if err := repository.Delete(ctx, uniqueName("unknown")); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Delete of an unknown token = %v, want ErrNotFound", err)
+		}
+	})
+}
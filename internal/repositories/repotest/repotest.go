@@ -0,0 +1,113 @@
+// Package repotest is the conformance suite of the repository interfaces.
+// Every storage backend runs it from its own tests, against a fresh,
+// empty database, so all of them behave the same.
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Backends store times with different precisions; MongoDB keeps
+// milliseconds.
+const timePrecision = time.Millisecond
+
+func newUser(t *testing.T, name string) *models.User {
+	t.Helper()
+
+	user, err := models.NewUser(name, name+"@example.com", "hash-of-"+name)
+	if err != nil {
+		t.Fatalf("NewUser: %v", err)
+	}
+	return user
+}
+
+func assertTime(t *testing.T, field string, got, want time.Time) {
+	t.Helper()
+
+	if got.Sub(want).Abs() >= timePrecision {
+		t.Errorf("%s = %v, want %v", field, got, want)
+	}
+}
+
+func assertEqual[T comparable](t *testing.T, field string, got, want T) {
+	t.Helper()
+
+	if got != want {
+		t.Errorf("%s = %v, want %v", field, got, want)
+	}
+}
+
+func mustNotFail(t *testing.T, operation string, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s: %v", operation, err)
+	}
+}
+
+// assertNotFound checks that a lookup found nothing and said so with
+// ErrNotFound.
+func assertNotFound[T any](t *testing.T, call string, got *T, err error) {
+	t.Helper()
+
+	if got != nil || !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("%s = %v, %v, want nil, ErrNotFound", call, got, err)
+	}
+}
+
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s%s", prefix, bson.NewObjectID().Hex()[16:])
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// Repositories are the repositories of a backend. The suite of each
+// repository left nil is skipped.
+type Repositories struct {
+	Users             repositories.UserRepositoryInterface
+	RefreshTokens     repositories.RefreshTokenRepositoryInterface
+	EventOutbox       repositories.EventOutboxRepositoryInterface
+	Groups            repositories.GroupRepositoryInterface
+	OneTimeTokens     repositories.OneTimeTokenRepositoryInterface
+	MailOutbox        repositories.MailOutboxRepositoryInterface
+	AuditLog          repositories.AuditLogRepositoryInterface
+	Webhooks          repositories.WebhookRepositoryInterface
+	WebhookDeliveries repositories.WebhookDeliveryRepositoryInterface
+	SAMLRequests      repositories.SAMLRequestRepositoryInterface
+}
+
+// RunAll runs the suite of every repository given.
+func RunAll(t *testing.T, repos Repositories) {
+	run(t, "Users", repos.Users, TestUserRepository)
+	run(t, "RefreshTokens", repos.RefreshTokens, TestRefreshTokenRepository)
+	run(t, "EventOutbox", repos.EventOutbox, TestEventOutboxRepository)
+	run(t, "Groups", repos.Groups, TestGroupRepository)
+	run(t, "OneTimeTokens", repos.OneTimeTokens, TestOneTimeTokenRepository)
+	run(t, "MailOutbox", repos.MailOutbox, TestMailOutboxRepository)
+	run(t, "AuditLog", repos.AuditLog, TestAuditLogRepository)
+	run(t, "Webhooks", repos.Webhooks, TestWebhookRepository)
+	run(t, "WebhookDeliveries", repos.WebhookDeliveries, TestWebhookDeliveryRepository)
+	run(t, "SAMLRequests", repos.SAMLRequests, TestSAMLRequestRepository)
+}
+
+func run[R comparable](t *testing.T, name string, repository R, suite func(*testing.T, R)) {
+	t.Run(name, func(t *testing.T) {
+		var none R
+		if repository == none {
+			t.Skip("not implemented by this backend")
+		}
+		suite(t, repository)
+	})
+}
@@ -0,0 +1,24 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"testing"
+	"time"
+)
+
+func TestSAMLRequestRepository(t *testing.T, repository repositories.SAMLRequestRepositoryInterface) {
+	ctx := testContext(t)
+	request := models.NewSAMLRequest(uniqueName("id-"), time.Now().Add(5*time.Minute))
+	mustNotFail(t, "Create", repository.Create(ctx, request))
+
+	consumed, err := repository.Consume(ctx, request.ID)
+	mustNotFail(t, "Consume", err)
+	if consumed == nil {
+		t.Fatal("Consume didn't find the request")
+	}
+	assertTime(t, "ExpiresAt", consumed.ExpiresAt, request.ExpiresAt)
+
+	again, err := repository.Consume(ctx, request.ID)
+	assertNotFound(t, "second Consume", again, err)
+}
@@ -0,0 +1,456 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestUserRepository(t *testing.T, repository repositories.UserRepositoryInterface) {
+	t.Run("CreateAndFind", func(t *testing.T) {
+		ctx := testContext(t)
+		user := newUser(t, uniqueName("create"))
+		user.GivenName = "Ada"
+		user.Roles = []string{"admin"}
+		user.LinkIdentity("google", user.ID.Hex())
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		byID, err := repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertUser(t, byID, user)
+
+		byEmail, err := repository.FindByEmail(ctx, user.Email)
+		mustNotFail(t, "FindByEmail", err)
+		assertUser(t, byEmail, user)
+
+		byUsername, err := repository.FindByUsername(ctx, user.Username)
+		mustNotFail(t, "FindByUsername", err)
+		assertUser(t, byUsername, user)
+
+		byIdentity, err := repository.FindByIdentity(ctx, "google", user.ID.Hex())
+		mustNotFail(t, "FindByIdentity", err)
+		assertUser(t, byIdentity, user)
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		ctx := testContext(t)
+		user := newUser(t, uniqueName("duplicate"))
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		sameEmail := newUser(t, uniqueName("duplicate"))
+		sameEmail.Email = user.Email
+		if err := repository.Create(ctx, sameEmail); !errors.Is(err, repositories.ErrDuplicateEmail) {
+			t.Errorf("Create of a user with a taken email = %v, want ErrDuplicateEmail", err)
+		}
+
+		sameUsername := newUser(t, uniqueName("duplicate"))
+		sameUsername.Username = user.Username
+		if err := repository.Create(ctx, sameUsername); !errors.Is(err, repositories.ErrDuplicateUsername) {
+			t.Errorf("Create of a user with a taken username = %v, want ErrDuplicateUsername", err)
+		}
+
+		otherCase := newUser(t, uniqueName("duplicate"))
+		otherCase.Email = strings.ToUpper(user.Email)
+		if err := repository.Create(ctx, otherCase); !errors.Is(err, repositories.ErrDuplicateEmail) {
+			t.Errorf("Create of a user with a taken email in another case = %v, want ErrDuplicateEmail", err)
+		}
+
+		otherCase.Email = uniqueName("duplicate") + "@example.com"
+		otherCase.Username = strings.ToUpper(user.Username)
+		if err := repository.Create(ctx, otherCase); !errors.Is(err, repositories.ErrDuplicateUsername) {
+			t.Errorf("Create of a user with a taken username in another case = %v, want ErrDuplicateUsername", err)
+		}
+
+		other := newUser(t, uniqueName("duplicate"))
+		mustNotFail(t, "Create", repository.Create(ctx, other))
+		other.Username = user.Username
+		if err := repository.Update(ctx, other); !errors.Is(err, repositories.ErrDuplicateUsername) {
+			t.Errorf("Update to a taken username = %v, want ErrDuplicateUsername", err)
+		}
+	})
+
+	t.Run("CaseInsensitive", func(t *testing.T) {
+		ctx := testContext(t)
+		name := uniqueName("Mixed")
+		user, err := models.NewUser(name, name+"@Example.COM", "hash-of-"+name)
+		mustNotFail(t, "NewUser", err)
+		assertEqual(t, "Email", user.Email, name+"@example.com")
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		byEmail, err := repository.FindByEmail(ctx, strings.ToLower(name)+"@EXAMPLE.com")
+		mustNotFail(t, "FindByEmail", err)
+		assertUser(t, byEmail, user)
+
+		byUsername, err := repository.FindByUsername(ctx, strings.ToUpper(name))
+		mustNotFail(t, "FindByUsername", err)
+		assertUser(t, byUsername, user)
+
+		users, total, err := repository.List(ctx, repositories.UserFilter{Username: strings.ToLower(name)}, 0, 10)
+		mustNotFail(t, "List", err)
+		if total != 1 || len(users) != 1 || users[0].ID != user.ID {
+			t.Errorf("List(userName %s) = %v, %d, want %s", strings.ToLower(name), users, total, user.ID.Hex())
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		ctx := testContext(t)
+
+		user, err := repository.FindById(ctx, bson.NewObjectID().Hex())
+		assertNotFound(t, "FindById of a missing user", user, err)
+
+		user, err = repository.FindByEmail(ctx, uniqueName("missing")+"@example.com")
+		assertNotFound(t, "FindByEmail of a missing user", user, err)
+
+		user, err = repository.FindByUsername(ctx, uniqueName("missing"))
+		assertNotFound(t, "FindByUsername of a missing user", user, err)
+
+		user, err = repository.FindByIdentity(ctx, "google", uniqueName("missing"))
+		assertNotFound(t, "FindByIdentity of a missing user", user, err)
+
+		if err := repository.Update(ctx, newUser(t, uniqueName("missing"))); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Update of a missing user = %v, want ErrNotFound", err)
+		}
+
+		if err := repository.Delete(ctx, bson.NewObjectID().Hex()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Delete of a missing user = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("InvalidID", func(t *testing.T) {
+		ctx := testContext(t)
+
+		if _, err := repository.FindById(ctx, "not-an-id"); err == nil {
+			t.Error("FindById with an invalid ID succeeded")
+		}
+
+		if err := repository.Delete(ctx, "not-an-id"); err == nil {
+			t.Error("Delete with an invalid ID succeeded")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		ctx := testContext(t)
+		user := newUser(t, uniqueName("update"))
+		user.LinkIdentity("google", "first")
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		loggedOutAt := time.Now()
+		user.EmailVerified = true
+		user.EmailMFAEnabled = true
+		user.FamilyName = "Lovelace"
+		user.PendingEmail = uniqueName("pending") + "@example.com"
+		user.Locale = "pt-BR"
+		user.Disabled = true
+		user.Roles = []string{"admin", "auditor"}
+		user.LoggedOutAt = &loggedOutAt
+		user.DeletedAt = &loggedOutAt
+		user.Notifications = map[string]bool{models.SecurityEventNewDeviceLogin: false}
+		user.SeeDevice(models.NewDevice("device", "agent", "127.0.0.1"))
+		user.LinkIdentity("saml", "second")
+		mustNotFail(t, "Update", repository.Update(ctx, user))
+
+		found, err := repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertUser(t, found, user)
+
+		byIdentity, err := repository.FindByIdentity(ctx, "saml", "second")
+		mustNotFail(t, "FindByIdentity", err)
+		if byIdentity == nil || byIdentity.ID != user.ID {
+			t.Errorf("FindByIdentity of a linked identity = %v, want %s", byIdentity, user.ID.Hex())
+		}
+
+		// Fields set back to their zero value are cleared.
+		user.PendingEmail = ""
+		user.FamilyName = ""
+		user.DeletedAt = nil
+		mustNotFail(t, "Update", repository.Update(ctx, user))
+
+		found, err = repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertUser(t, found, user)
+
+		if err := repository.Update(ctx, &models.User{}); err == nil {
+			t.Error("Update of a user without ID succeeded")
+		}
+	})
+
+	t.Run("UpdateStale", func(t *testing.T) {
+		ctx := testContext(t)
+		user := newUser(t, uniqueName("stale"))
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		first, err := repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		second, err := repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+
+		// The user as created can be updated without being read again.
+		user.GivenName = "Ada"
+		mustNotFail(t, "Update", repository.Update(ctx, user))
+
+		loggedOutAt := time.Now()
+		first.LoggedOutAt = &loggedOutAt
+		if err := repository.Update(ctx, first); !errors.Is(err, repositories.ErrStale) {
+			t.Fatalf("Update of a stale copy = %v, want ErrStale", err)
+		}
+
+		err = repositories.UpdateUser(ctx, repository, second, func(user *models.User) error {
+			user.LoggedOutAt = &loggedOutAt
+			return nil
+		})
+		mustNotFail(t, "UpdateUser", err)
+
+		found, err := repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertEqual(t, "GivenName", found.GivenName, "Ada")
+		assertOptionalTime(t, "LoggedOutAt", found.LoggedOutAt, &loggedOutAt)
+		assertUser(t, found, second)
+
+		missing := newUser(t, uniqueName("missing"))
+		if err := repository.Update(ctx, missing); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("Update of a missing user = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("SeeDevice", func(t *testing.T) {
+		ctx := testContext(t)
+		user := newUser(t, uniqueName("device"))
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		isNew, err := repository.SeeDevice(ctx, user.ID, models.NewDevice("first", "agent", "127.0.0.1"))
+		mustNotFail(t, "SeeDevice", err)
+		assertEqual(t, "first device is new", isNew, false)
+
+		isNew, err = repository.SeeDevice(ctx, user.ID, models.NewDevice("second", "$password", "127.0.0.2"))
+		mustNotFail(t, "SeeDevice", err)
+		assertEqual(t, "second device is new", isNew, true)
+
+		isNew, err = repository.SeeDevice(ctx, user.ID, models.NewDevice("first", "agent", "127.0.0.3"))
+		mustNotFail(t, "SeeDevice", err)
+		assertEqual(t, "known device is new", isNew, false)
+
+		found, err := repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertEqual(t, "known devices", len(found.KnownDevices), 2)
+		for _, device := range found.KnownDevices {
+			switch device.ID {
+			case "first":
+				assertEqual(t, "first device IP address", device.IPAddress, "127.0.0.3")
+			case "second":
+				assertEqual(t, "second device user agent", device.UserAgent, "$password")
+			}
+		}
+
+		// Only the devices are written.
+		user.KnownDevices = found.KnownDevices
+		assertUser(t, found, user)
+
+		for i := range models.MaxKnownDevices {
+			_, err := repository.SeeDevice(ctx, user.ID, models.NewDevice(fmt.Sprintf("device-%d", i), "agent", "127.0.0.1"))
+			mustNotFail(t, "SeeDevice", err)
+		}
+		found, err = repository.FindById(ctx, user.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertEqual(t, "known devices", len(found.KnownDevices), models.MaxKnownDevices)
+
+		if _, err := repository.SeeDevice(ctx, bson.NewObjectID(), models.NewDevice("first", "agent", "127.0.0.1")); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("SeeDevice of a missing user = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		ctx := testContext(t)
+		externalID := uniqueName("external")
+
+		var created []*models.User
+		for range 3 {
+			user := newUser(t, uniqueName("list"))
+			user.ExternalID = externalID
+			mustNotFail(t, "Create", repository.Create(ctx, user))
+			created = append(created, user)
+		}
+
+		users, total, err := repository.List(ctx, repositories.UserFilter{ExternalID: externalID}, 1, 1)
+		mustNotFail(t, "List", err)
+		assertEqual(t, "total", total, int64(3))
+		if len(users) != 1 || users[0].ID != created[1].ID {
+			t.Errorf("List second page = %v, want %s", users, created[1].ID.Hex())
+		}
+
+		users, total, err = repository.List(ctx, repositories.UserFilter{Email: created[2].Email}, 0, 10)
+		mustNotFail(t, "List", err)
+		assertEqual(t, "total", total, int64(1))
+		if len(users) != 1 || users[0].ID != created[2].ID {
+			t.Errorf("List by email = %v, want %s", users, created[2].ID.Hex())
+		}
+
+		users, total, err = repository.List(ctx, repositories.UserFilter{Username: uniqueName("nobody")}, 0, 10)
+		mustNotFail(t, "List", err)
+		if total != 0 || users == nil || len(users) != 0 {
+			t.Errorf("List without matches = %v, %d, want an empty list", users, total)
+		}
+	})
+
+	t.Run("ListDeleted", func(t *testing.T) {
+		ctx := testContext(t)
+		var created []*models.User
+		for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Hour} {
+			user := newUser(t, uniqueName("deleted"))
+			deletedAt := time.Now().Add(-age)
+			user.DeletedAt = &deletedAt
+			mustNotFail(t, "Create", repository.Create(ctx, user))
+			created = append(created, user)
+		}
+		kept := newUser(t, uniqueName("kept"))
+		mustNotFail(t, "Create", repository.Create(ctx, kept))
+		t.Cleanup(func() {
+			for _, user := range append(created, kept) {
+				_ = repository.Delete(context.WithoutCancel(ctx), user.ID.Hex())
+			}
+		})
+
+		users, err := repository.ListDeleted(ctx, time.Now().Add(-90*time.Minute), 1000)
+		mustNotFail(t, "ListDeleted", err)
+
+		var listed []bson.ObjectID
+		for _, user := range users {
+			if user.DeletedAt == nil {
+				t.Errorf("ListDeleted returned %s, which isn't deleted", user.ID.Hex())
+			}
+			if slices.ContainsFunc(append(created, kept), func(created *models.User) bool { return created.ID == user.ID }) {
+				listed = append(listed, user.ID)
+			}
+		}
+		if !slices.Equal(listed, []bson.ObjectID{created[0].ID, created[1].ID}) {
+			t.Errorf("ListDeleted = %v, want the two users deleted before the cutoff, oldest first", listed)
+		}
+
+		users, err = repository.ListDeleted(ctx, time.Now(), 1)
+		mustNotFail(t, "ListDeleted", err)
+		if len(users) != 1 {
+			t.Errorf("ListDeleted returned %d users, want the limit of 1", len(users))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := testContext(t)
+		user := newUser(t, uniqueName("delete"))
+		user.LinkIdentity("google", user.ID.Hex())
+		mustNotFail(t, "Create", repository.Create(ctx, user))
+
+		mustNotFail(t, "Delete", repository.Delete(ctx, user.ID.Hex()))
+
+		found, err := repository.FindById(ctx, user.ID.Hex())
+		assertNotFound(t, "FindById of a deleted user", found, err)
+
+		found, err = repository.FindByIdentity(ctx, "google", user.ID.Hex())
+		assertNotFound(t, "FindByIdentity of a deleted user", found, err)
+	})
+
+	t.Run("DeleteDeleted", func(t *testing.T) {
+		ctx := testContext(t)
+		active := newUser(t, uniqueName("active"))
+		deleted := newUser(t, uniqueName("deleted"))
+		deletedAt := time.Now().Add(-time.Hour)
+		deleted.DeletedAt = &deletedAt
+		deleted.LinkIdentity("google", deleted.ID.Hex())
+		for _, user := range []*models.User{active, deleted} {
+			mustNotFail(t, "Create", repository.Create(ctx, user))
+		}
+		t.Cleanup(func() { _ = repository.Delete(context.WithoutCancel(ctx), active.ID.Hex()) })
+
+		if err := repository.DeleteDeleted(ctx, active.ID.Hex(), time.Now()); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("DeleteDeleted of a user who didn't delete their account = %v, want ErrNotFound", err)
+		}
+		if err := repository.DeleteDeleted(ctx, deleted.ID.Hex(), deletedAt.Add(-time.Minute)); !errors.Is(err, repositories.ErrNotFound) {
+			t.Errorf("DeleteDeleted of a user deleted after the cutoff = %v, want ErrNotFound", err)
+		}
+
+		mustNotFail(t, "DeleteDeleted", repository.DeleteDeleted(ctx, deleted.ID.Hex(), time.Now()))
+
+		found, err := repository.FindById(ctx, deleted.ID.Hex())
+		assertNotFound(t, "FindById of a deleted user", found, err)
+
+		found, err = repository.FindByIdentity(ctx, "google", deleted.ID.Hex())
+		assertNotFound(t, "FindByIdentity of a deleted user", found, err)
+
+		found, err = repository.FindById(ctx, active.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertUser(t, found, active)
+	})
+}
+
+func assertUser(t *testing.T, got, want *models.User) {
+	t.Helper()
+
+	if got == nil {
+		t.Fatalf("user %s not found", want.ID.Hex())
+	}
+
+	assertEqual(t, "ID", got.ID, want.ID)
+	assertEqual(t, "Username", got.Username, want.Username)
+	assertEqual(t, "Password", got.Password, want.Password)
+	assertEqual(t, "Email", got.Email, want.Email)
+	assertEqual(t, "PendingEmail", got.PendingEmail, want.PendingEmail)
+	assertEqual(t, "EmailVerified", got.EmailVerified, want.EmailVerified)
+	assertEqual(t, "EmailMFAEnabled", got.EmailMFAEnabled, want.EmailMFAEnabled)
+	assertEqual(t, "GivenName", got.GivenName, want.GivenName)
+	assertEqual(t, "FamilyName", got.FamilyName, want.FamilyName)
+	assertEqual(t, "ExternalID", got.ExternalID, want.ExternalID)
+	assertEqual(t, "Locale", got.Locale, want.Locale)
+	assertEqual(t, "Disabled", got.Disabled, want.Disabled)
+	assertTime(t, "CreatedAt", got.CreatedAt, want.CreatedAt)
+	assertTime(t, "UpdatedAt", got.UpdatedAt, want.UpdatedAt)
+
+	if !slices.Equal(got.Roles, want.Roles) && len(got.Roles)+len(want.Roles) > 0 {
+		t.Errorf("Roles = %v, want %v", got.Roles, want.Roles)
+	}
+
+	if len(got.Identities) != len(want.Identities) {
+		t.Errorf("Identities = %v, want %v", got.Identities, want.Identities)
+	}
+	for _, identity := range want.Identities {
+		if !got.HasIdentity(identity.Provider, identity.Subject) {
+			t.Errorf("identity %s/%s missing", identity.Provider, identity.Subject)
+		}
+	}
+
+	if len(got.KnownDevices) != len(want.KnownDevices) {
+		t.Errorf("KnownDevices = %v, want %v", got.KnownDevices, want.KnownDevices)
+	}
+	for i := range min(len(got.KnownDevices), len(want.KnownDevices)) {
+		assertEqual(t, "KnownDevices.ID", got.KnownDevices[i].ID, want.KnownDevices[i].ID)
+		assertEqual(t, "KnownDevices.UserAgent", got.KnownDevices[i].UserAgent, want.KnownDevices[i].UserAgent)
+	}
+
+	if len(got.Notifications) != len(want.Notifications) {
+		t.Errorf("Notifications = %v, want %v", got.Notifications, want.Notifications)
+	}
+	for event, enabled := range want.Notifications {
+		assertEqual(t, "Notifications["+event+"]", got.Notifications[event], enabled)
+	}
+
+	assertOptionalTime(t, "LoggedOutAt", got.LoggedOutAt, want.LoggedOutAt)
+	assertOptionalTime(t, "DeletedAt", got.DeletedAt, want.DeletedAt)
+}
+
+func assertOptionalTime(t *testing.T, field string, got, want *time.Time) {
+	t.Helper()
+
+	switch {
+	case want == nil && got != nil:
+		t.Errorf("%s = %v, want nil", field, got)
+	case want != nil && got == nil:
+		t.Errorf("%s = nil, want %v", field, want)
+	case want != nil:
+		assertTime(t, field, *got, *want)
+	}
+}
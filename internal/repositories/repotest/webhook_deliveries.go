@@ -0,0 +1,118 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// TestWebhookDeliveryRepository expects no deliveries to be stored, as
+// ClaimDue picks any due delivery.
+func TestWebhookDeliveryRepository(t *testing.T, repository repositories.WebhookDeliveryRepositoryInterface) {
+	ctx := testContext(t)
+	webhookID, userID := bson.NewObjectID(), bson.NewObjectID()
+
+	delivery := models.NewWebhookDelivery(webhookID, userID, uniqueName("event"), models.WebhookEventUserLogin, `{"event":"user.login"}`)
+	mustNotFail(t, "Create", repository.Create(ctx, delivery))
+
+	t.Run("CreateOncePerEvent", func(t *testing.T) {
+		duplicate := models.NewWebhookDelivery(webhookID, userID, delivery.EventID, delivery.Event, delivery.Payload)
+		mustNotFail(t, "Create", repository.Create(ctx, duplicate))
+
+		deliveries, total, err := repository.List(ctx, repositories.WebhookDeliveryFilter{WebhookID: webhookID}, 0, 10)
+		mustNotFail(t, "List", err)
+		assertEqual(t, "total", total, int64(1))
+		if len(deliveries) != 1 || deliveries[0].ID != delivery.ID {
+			t.Fatalf("List = %v, want only the first delivery", deliveries)
+		}
+		assertEqual(t, "Payload", deliveries[0].Payload, delivery.Payload)
+		assertEqual(t, "UserID", deliveries[0].UserID, userID)
+	})
+
+	t.Run("ClaimRetryAndDeliver", func(t *testing.T) {
+		claimed, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if claimed == nil || claimed.ID != delivery.ID {
+			t.Fatalf("ClaimDue = %v, want %s", claimed, delivery.ID.Hex())
+		}
+		assertEqual(t, "Attempts", claimed.Attempts, 1)
+
+		none, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if none != nil {
+			t.Fatalf("ClaimDue returned the leased delivery %s", none.ID.Hex())
+		}
+
+		mustNotFail(t, "MarkRetry", repository.MarkRetry(ctx, delivery.ID, time.Now().Add(-time.Second), 503, "unavailable"))
+		retried, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if retried == nil || retried.ID != delivery.ID {
+			t.Fatalf("ClaimDue after MarkRetry = %v, want %s", retried, delivery.ID.Hex())
+		}
+		assertEqual(t, "LastStatusCode", retried.LastStatusCode, 503)
+		assertEqual(t, "LastError", retried.LastError, "unavailable")
+
+		mustNotFail(t, "MarkDelivered", repository.MarkDelivered(ctx, delivery.ID, 204))
+		found, err := repository.FindById(ctx, delivery.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertEqual(t, "Status", found.Status, models.WebhookDeliveryDelivered)
+		assertEqual(t, "LastStatusCode", found.LastStatusCode, 204)
+		assertEqual(t, "LastError", found.LastError, "")
+		if found.DeliveredAt == nil {
+			t.Error("DeliveredAt isn't set")
+		}
+	})
+
+	t.Run("DeadAndRedeliver", func(t *testing.T) {
+		mustNotFail(t, "MarkDead", repository.MarkDead(ctx, delivery.ID, 500, "failed"))
+
+		dead, total, err := repository.List(ctx, repositories.WebhookDeliveryFilter{Status: models.WebhookDeliveryDead}, 0, 10)
+		mustNotFail(t, "List", err)
+		if total != 1 || len(dead) != 1 || dead[0].ID != delivery.ID {
+			t.Fatalf("List of dead letters = %v (%d), want the delivery", dead, total)
+		}
+
+		mustNotFail(t, "Redeliver", repository.Redeliver(ctx, delivery.ID))
+		claimed, err := repository.ClaimDue(ctx, time.Minute)
+		mustNotFail(t, "ClaimDue", err)
+		if claimed == nil || claimed.ID != delivery.ID {
+			t.Fatalf("ClaimDue after Redeliver = %v, want %s", claimed, delivery.ID.Hex())
+		}
+		assertEqual(t, "Attempts", claimed.Attempts, 1)
+	})
+
+	t.Run("DeleteByWebhook", func(t *testing.T) {
+		mustNotFail(t, "DeleteByWebhook", repository.DeleteByWebhook(ctx, webhookID))
+
+		found, err := repository.FindById(ctx, delivery.ID.Hex())
+		assertNotFound(t, "FindById of a deleted delivery", found, err)
+	})
+
+	t.Run("DeleteByUser", func(t *testing.T) {
+		otherWebhookID := bson.NewObjectID()
+		ofUser := models.NewWebhookDelivery(otherWebhookID, userID, uniqueName("event"), models.WebhookEventUserDeleted, `{}`)
+		ofOther := models.NewWebhookDelivery(otherWebhookID, bson.NewObjectID(), uniqueName("event"), models.WebhookEventUserLogin, `{}`)
+		mustNotFail(t, "Create", repository.Create(ctx, ofUser))
+		mustNotFail(t, "Create", repository.Create(ctx, ofOther))
+		mustNotFail(t, "MarkDead", repository.MarkDead(ctx, ofUser.ID, 500, "failed"))
+
+		deliveries, total, err := repository.List(ctx, repositories.WebhookDeliveryFilter{UserID: userID}, 0, 10)
+		mustNotFail(t, "List", err)
+		if total != 1 || len(deliveries) != 1 || deliveries[0].ID != ofUser.ID {
+			t.Errorf("List by user = %v, %d, want %s", deliveries, total, ofUser.ID.Hex())
+		}
+
+		mustNotFail(t, "DeleteByUser", repository.DeleteByUser(ctx, userID))
+
+		found, err := repository.FindById(ctx, ofUser.ID.Hex())
+		assertNotFound(t, "FindById of a delivery of the user", found, err)
+		found, err = repository.FindById(ctx, ofOther.ID.Hex())
+		mustNotFail(t, "FindById of a delivery of another user", err)
+		assertEqual(t, "UserID", found.UserID, ofOther.UserID)
+
+		mustNotFail(t, "DeleteByWebhook", repository.DeleteByWebhook(ctx, otherWebhookID))
+	})
+}
@@ -0,0 +1,93 @@
+package repotest
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"bytes"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestWebhookRepository(t *testing.T, repository repositories.WebhookRepositoryInterface) {
+	ctx := testContext(t)
+
+	login, err := models.NewWebhook("https://example.com/login", "logins", "secret", []string{models.WebhookEventUserLogin})
+	mustNotFail(t, "NewWebhook", err)
+	all, err := models.NewWebhook("https://example.com/all", "", "secret", []string{models.WebhookEventAll})
+	mustNotFail(t, "NewWebhook", err)
+	inactive, err := models.NewWebhook("https://example.com/inactive", "", "secret", []string{models.WebhookEventUserLogin})
+	mustNotFail(t, "NewWebhook", err)
+	inactive.Active = false
+	for _, webhook := range []*models.Webhook{login, all, inactive} {
+		mustNotFail(t, "Create", repository.Create(ctx, webhook))
+	}
+
+	t.Run("CreateAndFind", func(t *testing.T) {
+		found, err := repository.FindById(ctx, login.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		if found == nil {
+			t.Fatal("FindById didn't find the webhook")
+		}
+		assertEqual(t, "URL", found.URL, login.URL)
+		assertEqual(t, "Description", found.Description, login.Description)
+		assertEqual(t, "Secret", found.Secret, login.Secret)
+		assertEqual(t, "Active", found.Active, true)
+		if !slices.Equal(found.Events, login.Events) {
+			t.Errorf("Events = %v, want %v", found.Events, login.Events)
+		}
+
+		missing, err := repository.FindById(ctx, bson.NewObjectID().Hex())
+		assertNotFound(t, "FindById of a missing webhook", missing, err)
+	})
+
+	t.Run("FindSubscribed", func(t *testing.T) {
+		assertWebhooks(t, "FindSubscribed(login)", func() ([]*models.Webhook, error) {
+			return repository.FindSubscribed(ctx, models.WebhookEventUserLogin)
+		}, login, all)
+		assertWebhooks(t, "FindSubscribed(deleted)", func() ([]*models.Webhook, error) {
+			return repository.FindSubscribed(ctx, models.WebhookEventUserDeleted)
+		}, all)
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		login.Active = false
+		mustNotFail(t, "Update", repository.Update(ctx, login))
+		found, err := repository.FindById(ctx, login.ID.Hex())
+		mustNotFail(t, "FindById", err)
+		assertEqual(t, "Active", found.Active, false)
+		if found.UpdatedAt.Before(found.CreatedAt) {
+			t.Errorf("UpdatedAt %v is before CreatedAt %v", found.UpdatedAt, found.CreatedAt)
+		}
+
+		mustNotFail(t, "Delete", repository.Delete(ctx, inactive.ID.Hex()))
+		assertWebhooks(t, "List", func() ([]*models.Webhook, error) {
+			return repository.List(ctx)
+		}, login, all)
+	})
+}
+
+func assertWebhooks(t *testing.T, operation string, list func() ([]*models.Webhook, error), want ...*models.Webhook) {
+	t.Helper()
+
+	webhooks, err := list()
+	mustNotFail(t, operation, err)
+
+	var got, wanted []bson.ObjectID
+	for _, webhook := range webhooks {
+		got = append(got, webhook.ID)
+	}
+	for _, webhook := range want {
+		wanted = append(wanted, webhook.ID)
+	}
+	slices.SortFunc(got, compareIDs)
+	slices.SortFunc(wanted, compareIDs)
+	if !slices.Equal(got, wanted) {
+		t.Errorf("%s = %v, want %v", operation, got, wanted)
+	}
+}
+
+func compareIDs(a, b bson.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
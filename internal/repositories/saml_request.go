@@ -0,0 +1,44 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type SAMLRequestRepositoryInterface interface {
+	Create(ctx context.Context, request *models.SAMLRequest) error
+	Consume(ctx context.Context, id string) (*models.SAMLRequest, error)
+}
+
+type SAMLRequestRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSAMLRequestRepository(db *database.Database) *SAMLRequestRepository {
+	return &SAMLRequestRepository{
+		collection: db.Client.Collection("saml_requests"),
+	}
+}
+
+func (r *SAMLRequestRepository) Create(ctx context.Context, request *models.SAMLRequest) error {
+	_, err := r.collection.InsertOne(ctx, request)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+// Consume atomically removes the pending request and returns it, so only the
+// first response referencing it is accepted.
+func (r *SAMLRequestRepository) Consume(ctx context.Context, id string) (*models.SAMLRequest, error) {
+	var request models.SAMLRequest
+	err := r.collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&request)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &request, nil
+}
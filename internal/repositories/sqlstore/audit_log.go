@@ -0,0 +1,160 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const auditColumns = `id, action, outcome, actor_id, target_id, ip_address, user_agent, request_id, details, created_at`
+
+type AuditLogRepository struct {
+	db *DB
+}
+
+func NewAuditLogRepository(db *DB) *AuditLogRepository {
+	return &AuditLogRepository{db: db}
+}
+
+func (r *AuditLogRepository) Append(ctx context.Context, event *models.AuditEvent) error {
+	details := "{}"
+	if event.Details != nil {
+		var err error
+		if details, err = jsonArg(event.Details); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`INSERT INTO audit_log (`+auditColumns+`) VALUES (`+placeholders(1, 10)+`)`,
+		event.ID.Hex(), event.Action, event.Outcome, event.ActorID, event.TargetID, event.IPAddress, event.UserAgent,
+		event.RequestID, details, timeArg(event.CreatedAt))
+	return dbError(err)
+}
+
+// List returns the newest events matching the filter. Pages are walked with
+// the ID of the last event of the previous page as before; a zero ID starts
+// from the newest event.
+func (r *AuditLogRepository) List(ctx context.Context, filter repositories.AuditFilter, before bson.ObjectID, limit int) ([]*models.AuditEvent, error) {
+	var conditions []string
+	var args []any
+	where := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.Outcome != "" {
+		where("outcome = $%d", filter.Outcome)
+	}
+	if filter.ActorID != "" {
+		where("actor_id = $%d", filter.ActorID)
+	}
+	if filter.TargetID != "" {
+		where("target_id = $%d", filter.TargetID)
+	}
+	if len(filter.Identifiers) > 0 {
+		in := placeholders(len(args)+1, len(filter.Identifiers))
+		for _, identifier := range filter.Identifiers {
+			args = append(args, strings.ToLower(identifier))
+		}
+		conditions = append(conditions, r.naming(in))
+	}
+	if !filter.Since.IsZero() {
+		where("created_at >= $%d", timeArg(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where("created_at < $%d", timeArg(filter.Until))
+	}
+	if !before.IsZero() {
+		where("id < $%d", before.Hex())
+	}
+
+	suffix := ""
+	if len(conditions) > 0 {
+		suffix = `WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+	suffix += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	return r.find(ctx, suffix, args...)
+}
+
+func (r *AuditLogRepository) find(ctx context.Context, suffix string, args ...any) ([]*models.AuditEvent, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx, `SELECT `+auditColumns+` FROM audit_log `+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		event := &models.AuditEvent{}
+		err := rows.Scan(scanID(&event.ID), &event.Action, &event.Outcome, &event.ActorID, &event.TargetID, &event.IPAddress,
+			&event.UserAgent, &event.RequestID, scanJSON(&event.Details), &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(event.Details) == 0 {
+			event.Details = nil
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// AnonymizeUser applies models.AuditEvent.Anonymize to the events of the
+// user and to the events naming them.
+func (r *AuditLogRepository) AnonymizeUser(ctx context.Context, userID string, identifiers []string) error {
+	conditions := []string{`actor_id = $1`, `target_id = $1`}
+	args := []any{userID}
+	if len(identifiers) > 0 {
+		for _, identifier := range identifiers {
+			args = append(args, strings.ToLower(identifier))
+		}
+		conditions = append(conditions, r.naming(placeholders(2, len(identifiers))))
+	}
+
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		events, err := r.find(ctx, `WHERE `+strings.Join(conditions, " OR "), args...)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			event.Anonymize(userID)
+
+			details := "{}"
+			if event.Details != nil {
+				if details, err = jsonArg(event.Details); err != nil {
+					return err
+				}
+			}
+
+			_, err = r.db.conn(ctx).ExecContext(ctx,
+				`UPDATE audit_log SET actor_id = $1, target_id = $2, ip_address = $3, user_agent = $4, details = $5 WHERE id = $6`,
+				event.ActorID, event.TargetID, event.IPAddress, event.UserAgent, details, event.ID.Hex())
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// naming is the condition matching the events naming one of the lower case
+// identifiers bound to the placeholders in.
+func (r *AuditLogRepository) naming(in string) string {
+	var naming []string
+	for _, key := range models.AuditIdentifyingDetails {
+		naming = append(naming, `lower(`+r.db.jsonText("details", key)+`) IN (`+in+`)`)
+	}
+	return `(` + strings.Join(naming, " OR ") + `)`
+}
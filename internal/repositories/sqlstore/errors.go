@@ -0,0 +1,72 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/repositories"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// pgUniqueViolation is the SQLSTATE of unique constraint violations.
+const pgUniqueViolation = "23505"
+
+// dbError maps the errors of the SQL drivers to the repository errors.
+func dbError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ErrNotFound
+	}
+
+	constraint, ok := uniqueViolation(err)
+	if !ok {
+		return err
+	}
+
+	switch constraint {
+	case "users_email", "index 'users_email'":
+		return repositories.ErrDuplicateEmail
+	case "users_username", "index 'users_username'":
+		return repositories.ErrDuplicateUsername
+	default:
+		return fmt.Errorf("%w: %v", repositories.ErrConflict, err)
+	}
+}
+
+// uniqueViolation tells whether err is the violation of a unique constraint,
+// and which one: its name on PostgreSQL, its columns or, for the indexes on
+// expressions, "index '<name>'" on SQLite.
+func uniqueViolation(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.ConstraintName, pgErr.Code == pgUniqueViolation
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		if sqliteErr.Code() != sqlite3.SQLITE_CONSTRAINT_UNIQUE && sqliteErr.Code() != sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			return "", false
+		}
+		// The message ends with "UNIQUE constraint failed: users.email (2067)".
+		_, columns, _ := strings.Cut(sqliteErr.Error(), "UNIQUE constraint failed: ")
+		columns, _, _ = strings.Cut(columns, " (")
+		return columns, true
+	}
+
+	return "", false
+}
+
+// notFoundIfNone returns ErrNotFound when the statement changed no row.
+func notFoundIfNone(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
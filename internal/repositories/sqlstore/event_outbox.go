@@ -0,0 +1,171 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Dispatched events are kept for a week for troubleshooting.
+const dispatchedEventRetention = 7 * 24 * time.Hour
+
+const eventColumns = `id, type, user_id, data, occurred_at, status, delivered, attempts, next_attempt_at, last_error, dispatched_at`
+
+type EventOutboxRepository struct {
+	db *DB
+}
+
+func NewEventOutboxRepository(db *DB) *EventOutboxRepository {
+	return &EventOutboxRepository{db: db}
+}
+
+// Append writes the events to the outbox. Called inside a transaction, they
+// are only stored if the state change they describe is.
+func (r *EventOutboxRepository) Append(ctx context.Context, events ...*models.DomainEvent) error {
+	for _, event := range events {
+		data, err := jsonArg(event.Data)
+		if err != nil {
+			return err
+		}
+		delivered, err := jsonArg(nonNil(event.Delivered))
+		if err != nil {
+			return err
+		}
+
+		_, err = r.db.conn(ctx).ExecContext(ctx,
+			`INSERT INTO event_outbox (`+eventColumns+`) VALUES (`+placeholders(1, 11)+`)`,
+			event.ID.Hex(), event.Type, event.UserID.Hex(), data, timeArg(event.OccurredAt), event.Status, delivered,
+			event.Attempts, timeArg(event.NextAttemptAt), event.LastError, nullTimeArg(event.DispatchedAt))
+		if err != nil {
+			return dbError(err)
+		}
+	}
+	return nil
+}
+
+// ClaimDue atomically picks the oldest pending event that is due and pushes
+// its next attempt forward by the lease, so another dispatcher won't pick it
+// up while it is being handled.
+func (r *EventOutboxRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.DomainEvent, error) {
+	now := time.Now()
+	row := r.db.conn(ctx).QueryRowContext(ctx,
+		`UPDATE event_outbox SET next_attempt_at = $1, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM event_outbox WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at LIMIT 1`+r.db.skipLocked()+`
+		)
+		RETURNING `+eventColumns,
+		timeArg(now.Add(lease)), models.EventStatusPending, timeArg(now))
+
+	event, err := scanEvent(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Nothing due
+		}
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func (r *EventOutboxRepository) MarkDelivered(ctx context.Context, id bson.ObjectID, subscriber string) error {
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		var delivered []string
+		err := r.db.conn(ctx).QueryRowContext(ctx, `SELECT delivered FROM event_outbox WHERE id = $1`, id.Hex()).Scan(scanJSON(&delivered))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		if slices.Contains(delivered, subscriber) {
+			return nil
+		}
+
+		value, err := jsonArg(append(delivered, subscriber))
+		if err != nil {
+			return err
+		}
+
+		_, err = r.db.conn(ctx).ExecContext(ctx, `UPDATE event_outbox SET delivered = $1 WHERE id = $2`, value, id.Hex())
+		return err
+	})
+}
+
+// MarkDispatched also purges the events dispatched before the retention
+// period, standing in for the TTL index of MongoDB.
+func (r *EventOutboxRepository) MarkDispatched(ctx context.Context, id bson.ObjectID) error {
+	now := time.Now()
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE event_outbox SET status = $1, dispatched_at = $2, last_error = '' WHERE id = $3`,
+		models.EventStatusDispatched, timeArg(now), id.Hex())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.conn(ctx).ExecContext(ctx,
+		`DELETE FROM event_outbox WHERE dispatched_at < $1`,
+		timeArg(now.Add(-dispatchedEventRetention)))
+	return err
+}
+
+func (r *EventOutboxRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE event_outbox SET next_attempt_at = $1, last_error = $2 WHERE id = $3`,
+		timeArg(nextAttemptAt), lastError, id.Hex())
+	return err
+}
+
+func (r *EventOutboxRepository) MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE event_outbox SET status = $1, last_error = $2 WHERE id = $3`,
+		models.EventStatusFailed, lastError, id.Hex())
+	return err
+}
+
+func scanEvent(row rowScanner) (*models.DomainEvent, error) {
+	var event models.DomainEvent
+	var dispatchedAt sql.NullTime
+	err := row.Scan(scanID(&event.ID), &event.Type, scanID(&event.UserID), scanJSON(&event.Data), &event.OccurredAt,
+		&event.Status, scanJSON(&event.Delivered), &event.Attempts, &event.NextAttemptAt, &event.LastError, &dispatchedAt)
+	if err != nil {
+		return nil, err
+	}
+	event.DispatchedAt = timePointer(dispatchedAt)
+
+	return &event, nil
+}
+
+// ListByUser returns the events about the user still in the outbox, newest
+// first.
+func (r *EventOutboxRepository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.DomainEvent, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx,
+		`SELECT `+eventColumns+` FROM event_outbox WHERE user_id = $1 ORDER BY id DESC`, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.DomainEvent{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// DeleteByUser removes the events about the user, whether dispatched or not.
+func (r *EventOutboxRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM event_outbox WHERE user_id = $1`, userID.Hex())
+	return err
+}
@@ -0,0 +1,169 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const groupColumns = `id, display_name, external_id, members, created_at, updated_at`
+
+type GroupRepository struct {
+	db *DB
+}
+
+func NewGroupRepository(db *DB) *GroupRepository {
+	return &GroupRepository{db: db}
+}
+
+func (r *GroupRepository) Create(ctx context.Context, group *models.Group) error {
+	members, err := jsonArg(nonNil(group.Members))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.conn(ctx).ExecContext(ctx,
+		`INSERT INTO groups (`+groupColumns+`) VALUES (`+placeholders(1, 6)+`)`,
+		group.ID.Hex(), group.DisplayName, group.ExternalID, members, timeArg(group.CreatedAt), timeArg(group.UpdatedAt))
+	return dbError(err)
+}
+
+func (r *GroupRepository) FindById(ctx context.Context, id string) (*models.Group, error) {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	groups, err := r.find(ctx, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		return nil, repositories.ErrNotFound
+	}
+
+	return groups[0], nil
+}
+
+func (r *GroupRepository) List(ctx context.Context, filter repositories.GroupFilter, offset, limit int) ([]*models.Group, int64, error) {
+	var conditions []string
+	var args []any
+	if filter.DisplayName != "" {
+		args = append(args, filter.DisplayName)
+		conditions = append(conditions, fmt.Sprintf("display_name = $%d", len(args)))
+	}
+	if filter.ExternalID != "" {
+		args = append(args, filter.ExternalID)
+		conditions = append(conditions, fmt.Sprintf("external_id = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM groups `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	groups, err := r.find(ctx, fmt.Sprintf(`%s ORDER BY id LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return groups, total, nil
+}
+
+func (r *GroupRepository) Update(ctx context.Context, group *models.Group) error {
+	if group.ID.IsZero() {
+		return errors.New("group ID is required for update")
+	}
+
+	group.UpdatedAt = time.Now()
+
+	members, err := jsonArg(nonNil(group.Members))
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE groups SET display_name = $1, external_id = $2, members = $3, updated_at = $4 WHERE id = $5`,
+		group.DisplayName, group.ExternalID, members, timeArg(group.UpdatedAt), group.ID.Hex())
+	if err != nil {
+		return dbError(err)
+	}
+	return notFoundIfNone(result)
+}
+
+func (r *GroupRepository) Delete(ctx context.Context, id string) error {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	result, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM groups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(result)
+}
+
+// RemoveMember removes the user from every group. The members are a JSON
+// list, so the groups are matched on its text and updated one by one.
+func (r *GroupRepository) RemoveMember(ctx context.Context, userID bson.ObjectID) error {
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		groups, err := r.ListByMember(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			group.RemoveMember(userID)
+			if err := r.Update(ctx, group); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *GroupRepository) ListByMember(ctx context.Context, userID bson.ObjectID) ([]*models.Group, error) {
+	// The text match narrows down the groups, which are then checked on the
+	// decoded members.
+	groups, err := r.find(ctx, `WHERE CAST(members AS TEXT) LIKE $1 ORDER BY id`, "%"+userID.Hex()+"%")
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(groups, func(group *models.Group) bool {
+		return !slices.Contains(group.Members, userID)
+	}), nil
+}
+
+func (r *GroupRepository) find(ctx context.Context, suffix string, args ...any) ([]*models.Group, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx, `SELECT `+groupColumns+` FROM groups `+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []*models.Group{}
+	for rows.Next() {
+		group := &models.Group{}
+		err := rows.Scan(scanID(&group.ID), &group.DisplayName, &group.ExternalID, scanJSON(&group.Members), &group.CreatedAt, &group.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
@@ -0,0 +1,132 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Sent messages are kept for a week for troubleshooting, without their
+// bodies, which may hold links and codes.
+const sentMailRetention = 7 * 24 * time.Hour
+
+const mailColumns = `id, recipient, subject, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+type MailOutboxRepository struct {
+	db *DB
+}
+
+func NewMailOutboxRepository(db *DB) *MailOutboxRepository {
+	return &MailOutboxRepository{db: db}
+}
+
+func (r *MailOutboxRepository) Enqueue(ctx context.Context, message *models.MailMessage) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`INSERT INTO mail_outbox (`+mailColumns+`) VALUES (`+placeholders(1, 11)+`)`,
+		message.ID.Hex(), message.To, message.Subject, message.Text, message.HTML, message.Status, message.Attempts,
+		timeArg(message.NextAttemptAt), message.LastError, timeArg(message.CreatedAt), nullTimeArg(message.SentAt))
+	return dbError(err)
+}
+
+// ClaimDue atomically picks the oldest pending message that is due and pushes
+// its next attempt forward by the lease, so another worker won't pick it up
+// while it is being sent. If the worker dies, the message is retried once the
+// lease expires.
+func (r *MailOutboxRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.MailMessage, error) {
+	now := time.Now()
+	row := r.db.conn(ctx).QueryRowContext(ctx,
+		`UPDATE mail_outbox SET next_attempt_at = $1, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM mail_outbox WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at LIMIT 1`+r.db.skipLocked()+`
+		)
+		RETURNING `+mailColumns,
+		timeArg(now.Add(lease)), models.MailStatusPending, timeArg(now))
+
+	message, err := scanMessage(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Nothing due
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// MarkSent also purges the messages sent before the retention period,
+// standing in for the TTL index of MongoDB.
+func (r *MailOutboxRepository) MarkSent(ctx context.Context, id bson.ObjectID) error {
+	now := time.Now()
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE mail_outbox SET status = $1, sent_at = $2, last_error = '', text_body = '', html_body = '' WHERE id = $3`,
+		models.MailStatusSent, timeArg(now), id.Hex())
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.conn(ctx).ExecContext(ctx,
+		`DELETE FROM mail_outbox WHERE sent_at < $1`,
+		timeArg(now.Add(-sentMailRetention)))
+	return err
+}
+
+func (r *MailOutboxRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, lastError string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE mail_outbox SET next_attempt_at = $1, last_error = $2 WHERE id = $3`,
+		timeArg(nextAttemptAt), lastError, id.Hex())
+	return err
+}
+
+func (r *MailOutboxRepository) MarkFailed(ctx context.Context, id bson.ObjectID, lastError string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE mail_outbox SET status = $1, last_error = $2 WHERE id = $3`,
+		models.MailStatusFailed, lastError, id.Hex())
+	return err
+}
+
+// ListByRecipient returns the messages sent or still to send to the
+// recipient, newest first.
+func (r *MailOutboxRepository) ListByRecipient(ctx context.Context, recipient string) ([]*models.MailMessage, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx,
+		`SELECT `+mailColumns+` FROM mail_outbox WHERE recipient = $1 ORDER BY id DESC`, recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*models.MailMessage{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// DeleteByRecipient deletes the messages sent or still to send to the
+// recipient.
+func (r *MailOutboxRepository) DeleteByRecipient(ctx context.Context, recipient string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM mail_outbox WHERE recipient = $1`, recipient)
+	return err
+}
+
+func scanMessage(row rowScanner) (*models.MailMessage, error) {
+	var message models.MailMessage
+	var sentAt sql.NullTime
+	err := row.Scan(scanID(&message.ID), &message.To, &message.Subject, &message.Text, &message.HTML, &message.Status,
+		&message.Attempts, &message.NextAttemptAt, &message.LastError, &message.CreatedAt, &sentAt)
+	if err != nil {
+		return nil, err
+	}
+	message.SentAt = timePointer(sentAt)
+
+	return &message, nil
+}
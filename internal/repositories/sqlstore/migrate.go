@@ -0,0 +1,134 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/repositories"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Migrations are SQL files named <version>_<description>.sql, applied in
+// order of their names, each in its own transaction. Applied files must
+// never be edited; changes go in a new file.
+//
+//go:embed migrations
+var migrations embed.FS
+
+// Migrate applies the migrations not recorded in schema_migrations yet.
+func (d *DB) Migrate(ctx context.Context) error {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	files, err := d.migrationFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		version := strings.TrimSuffix(file, ".sql")
+		if _, ok := applied[version]; ok {
+			continue
+		}
+
+		script, err := fs.ReadFile(migrations, path.Join("migrations", string(d.dialect), file))
+		if err != nil {
+			return err
+		}
+
+		err = d.WithTransaction(ctx, func(ctx context.Context) error {
+			if _, err := d.conn(ctx).ExecContext(ctx, string(script)); err != nil {
+				return err
+			}
+			_, err := d.conn(ctx).ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", version, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatus lists every migration of the dialect with the time it was
+// applied.
+func (d *DB) MigrationStatus(ctx context.Context) ([]repositories.MigrationStatus, error) {
+	applied, err := d.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := d.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]repositories.MigrationStatus, len(files))
+	for i, file := range files {
+		name := strings.TrimSuffix(file, ".sql")
+		version, description, _ := strings.Cut(name, "_")
+		statuses[i] = repositories.MigrationStatus{
+			Version:     version,
+			Description: strings.ReplaceAll(description, "_", " "),
+		}
+		if appliedAt, ok := applied[name]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// migrationFiles returns the names of the migration files of the dialect, in
+// the order they are applied.
+func (d *DB) migrationFiles() ([]string, error) {
+	entries, err := fs.ReadDir(migrations, path.Join("migrations", string(d.dialect)))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".sql") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// appliedMigrations returns the time each applied migration was applied at,
+// creating schema_migrations on a new database.
+func (d *DB) appliedMigrations(ctx context.Context) (map[string]time.Time, error) {
+	_, err := d.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]time.Time{}
+	for rows.Next() {
+		var version string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
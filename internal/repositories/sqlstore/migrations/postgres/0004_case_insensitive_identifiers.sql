@@ -0,0 +1,11 @@
+-- Emails get the lower case domain of models.NormalizeEmail, then emails and
+-- usernames are unique regardless of case.
+UPDATE users
+SET email = substring(email from '^(.*)@') || '@' || lower(substring(email from '@([^@]*)$'))
+WHERE email LIKE '%@%';
+
+DROP INDEX users_email;
+CREATE UNIQUE INDEX users_email ON users (lower(email));
+
+DROP INDEX users_username;
+CREATE UNIQUE INDEX users_username ON users (lower(username));
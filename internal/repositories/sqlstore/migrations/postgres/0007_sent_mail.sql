@@ -0,0 +1,6 @@
+-- Sent mail is deleted after the retention period, and the mail of purged
+-- accounts by recipient. The bodies of the mail already sent are dropped.
+UPDATE mail_outbox SET text_body = '', html_body = '' WHERE status = 'sent';
+
+CREATE INDEX mail_outbox_sent_at ON mail_outbox (sent_at);
+CREATE INDEX mail_outbox_recipient ON mail_outbox (recipient);
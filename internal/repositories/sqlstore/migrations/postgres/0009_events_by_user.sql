@@ -0,0 +1,7 @@
+-- The purge of deleted accounts removes their events and webhook deliveries.
+-- Deliveries didn't record the user, which is read back from their payload.
+ALTER TABLE webhook_deliveries ADD COLUMN user_id TEXT;
+UPDATE webhook_deliveries SET user_id = payload::jsonb->'data'->>'user_id';
+
+CREATE INDEX webhook_deliveries_user_id ON webhook_deliveries (user_id);
+CREATE INDEX event_outbox_user_id ON event_outbox (user_id);
@@ -0,0 +1,63 @@
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    username TEXT NOT NULL,
+    password TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL,
+    email_verified BOOLEAN NOT NULL DEFAULT 0,
+    email_mfa_enabled BOOLEAN NOT NULL DEFAULT 0,
+    given_name TEXT NOT NULL DEFAULT '',
+    family_name TEXT NOT NULL DEFAULT '',
+    external_id TEXT NOT NULL DEFAULT '',
+    locale TEXT NOT NULL DEFAULT '',
+    disabled BOOLEAN NOT NULL DEFAULT 0,
+    roles TEXT NOT NULL DEFAULT '[]',
+    known_devices TEXT NOT NULL DEFAULT '[]',
+    notifications TEXT NOT NULL DEFAULT '{}',
+    logged_out_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX users_email ON users (email);
+CREATE INDEX users_username ON users (username);
+CREATE INDEX users_external_id ON users (external_id);
+
+CREATE TABLE user_identities (
+    user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    linked_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE refresh_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX refresh_tokens_expires_at ON refresh_tokens (expires_at);
+
+CREATE TABLE event_outbox (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    data TEXT NOT NULL DEFAULT '{}',
+    occurred_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL,
+    delivered TEXT NOT NULL DEFAULT '[]',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    dispatched_at TIMESTAMP
+);
+
+CREATE INDEX event_outbox_due ON event_outbox (status, next_attempt_at);
+CREATE INDEX event_outbox_dispatched_at ON event_outbox (dispatched_at);
@@ -0,0 +1,2 @@
+DROP INDEX users_username;
+CREATE UNIQUE INDEX users_username ON users (username);
@@ -0,0 +1,12 @@
+-- Emails get the lower case domain of models.NormalizeEmail, then emails and
+-- usernames are unique regardless of case. rtrim(email, <every character but
+-- @>) keeps the email up to its last @.
+UPDATE users
+SET email = rtrim(email, replace(email, '@', '')) || lower(substr(email, length(rtrim(email, replace(email, '@', ''))) + 1))
+WHERE email LIKE '%@%';
+
+DROP INDEX users_email;
+CREATE UNIQUE INDEX users_email ON users (lower(email));
+
+DROP INDEX users_username;
+CREATE UNIQUE INDEX users_username ON users (lower(username));
@@ -0,0 +1,2 @@
+-- Email changes wait in pending_email until the new address is confirmed.
+ALTER TABLE users ADD COLUMN pending_email TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Accounts deleted by their user are kept until the grace period is over.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX users_deleted_at ON users (deleted_at);
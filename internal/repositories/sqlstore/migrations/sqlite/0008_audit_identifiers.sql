@@ -0,0 +1,4 @@
+-- The purge of deleted accounts finds the audit events naming them, as
+-- failed logins, by the email or username they hold.
+CREATE INDEX audit_log_email ON audit_log (lower(json_extract(details, '$.email')));
+CREATE INDEX audit_log_identifier ON audit_log (lower(json_extract(details, '$.identifier')));
@@ -0,0 +1,114 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const oneTimeTokenColumns = `id, user_id, purpose, token_hash, binding_hash, attempts, created_at, expires_at`
+
+type OneTimeTokenRepository struct {
+	db *DB
+}
+
+func NewOneTimeTokenRepository(db *DB) *OneTimeTokenRepository {
+	return &OneTimeTokenRepository{db: db}
+}
+
+// Create stores the token. Expired tokens are deleted on the way, standing
+// in for the TTL index of MongoDB.
+func (r *OneTimeTokenRepository) Create(ctx context.Context, token *models.OneTimeToken) error {
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM one_time_tokens WHERE expires_at < $1`, timeArg(time.Now()))
+		if err != nil {
+			return err
+		}
+
+		_, err = r.db.conn(ctx).ExecContext(ctx,
+			`INSERT INTO one_time_tokens (`+oneTimeTokenColumns+`) VALUES (`+placeholders(1, 8)+`)`,
+			token.ID.Hex(), token.UserID.Hex(), token.Purpose, token.TokenHash, token.BindingHash, token.Attempts,
+			timeArg(token.CreatedAt), timeArg(token.ExpiresAt))
+		return dbError(err)
+	})
+}
+
+// Consume atomically deletes and returns the token matching the hash and the
+// browser binding, so a token can be used only once.
+func (r *OneTimeTokenRepository) Consume(ctx context.Context, purpose, tokenHash, bindingHash string) (*models.OneTimeToken, error) {
+	query := `DELETE FROM one_time_tokens WHERE purpose = $1 AND token_hash = $2`
+	args := []any{purpose, tokenHash}
+	if bindingHash != "" {
+		query += ` AND binding_hash = $3`
+		args = append(args, bindingHash)
+	}
+
+	token, err := scanOneTimeToken(r.db.conn(ctx).QueryRowContext(ctx, query+` RETURNING `+oneTimeTokenColumns, args...))
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return token, nil
+}
+
+func (r *OneTimeTokenRepository) FindLatest(ctx context.Context, userID bson.ObjectID, purpose string) (*models.OneTimeToken, error) {
+	row := r.db.conn(ctx).QueryRowContext(ctx,
+		`SELECT `+oneTimeTokenColumns+` FROM one_time_tokens WHERE user_id = $1 AND purpose = $2 ORDER BY created_at DESC LIMIT 1`,
+		userID.Hex(), purpose)
+
+	token, err := scanOneTimeToken(row)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return token, nil
+}
+
+// ClaimAttempt atomically counts an attempt on the latest token of the
+// purpose that has fewer than maxAttempts, and returns it with the attempt
+// counted. The limit is checked again on the row being updated, so
+// concurrent guesses can't exceed it between them.
+func (r *OneTimeTokenRepository) ClaimAttempt(ctx context.Context, userID bson.ObjectID, purpose string, maxAttempts int) (*models.OneTimeToken, error) {
+	row := r.db.conn(ctx).QueryRowContext(ctx,
+		`UPDATE one_time_tokens SET attempts = attempts + 1
+		WHERE attempts < $3 AND id = (
+			SELECT id FROM one_time_tokens WHERE user_id = $1 AND purpose = $2 AND attempts < $3 ORDER BY created_at DESC LIMIT 1
+		)
+		RETURNING `+oneTimeTokenColumns,
+		userID.Hex(), purpose, maxAttempts)
+
+	token, err := scanOneTimeToken(row)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return token, nil
+}
+
+// Delete returns ErrNotFound when the token was already deleted, so that
+// only one caller can use it.
+func (r *OneTimeTokenRepository) Delete(ctx context.Context, id bson.ObjectID) error {
+	result, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM one_time_tokens WHERE id = $1`, id.Hex())
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(result)
+}
+
+func (r *OneTimeTokenRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID, purpose string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM one_time_tokens WHERE user_id = $1 AND purpose = $2`, userID.Hex(), purpose)
+	return err
+}
+
+func scanOneTimeToken(row *sql.Row) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	err := row.Scan(scanID(&token.ID), scanID(&token.UserID), &token.Purpose, &token.TokenHash, &token.BindingHash,
+		&token.Attempts, &token.CreatedAt, &token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
@@ -0,0 +1,59 @@
+package sqlstore_test
+
+import (
+	"authentication-jwt/internal/repositories/repotest"
+	"authentication-jwt/internal/repositories/sqlstore"
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The suite runs in a throwaway schema of the database given by
+// TEST_POSTGRES_URL.
+func TestPostgresRepositories(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "repotest_" + bson.NewObjectID().Hex()
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("CREATE SCHEMA: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	schemaURL, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("TEST_POSTGRES_URL must be a URL: %v", err)
+	}
+	query := schemaURL.Query()
+	query.Set("search_path", schema)
+	schemaURL.RawQuery = query.Encode()
+
+	store, err := sqlstore.Open(ctx, sqlstore.Postgres, schemaURL.String())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+
+	repotest.RunAll(t, newRepositories(store))
+}
@@ -0,0 +1,112 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type RefreshTokenRepository struct {
+	db *DB
+}
+
+func NewRefreshTokenRepository(db *DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// Create stores the refresh token of a new session. The expired tokens of
+// the user are deleted on the way, standing in for the TTL index of MongoDB.
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.db.conn(ctx).ExecContext(ctx,
+			`DELETE FROM refresh_tokens WHERE user_id = $1 AND expires_at < $2`,
+			token.UserID.Hex(), timeArg(time.Now()))
+		if err != nil {
+			return err
+		}
+
+		_, err = r.db.conn(ctx).ExecContext(ctx,
+			`INSERT INTO refresh_tokens (id, user_id, token, created_at, expires_at, updated_at, revoked_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			token.ID.Hex(), token.UserID.Hex(), token.Token, timeArg(token.CreatedAt), timeArg(token.ExpiresAt),
+			timeArg(token.UpdatedAt), nullTimeArg(token.RevokedAt))
+		return dbError(err)
+	})
+}
+
+func (r *RefreshTokenRepository) FindByToken(ctx context.Context, token string) (*models.RefreshToken, error) {
+	var refreshToken models.RefreshToken
+	var revokedAt sql.NullTime
+	err := r.db.conn(ctx).QueryRowContext(ctx,
+		`SELECT id, user_id, token, created_at, expires_at, updated_at, revoked_at FROM refresh_tokens WHERE token = $1`,
+		token,
+	).Scan(scanID(&refreshToken.ID), scanID(&refreshToken.UserID), &refreshToken.Token, &refreshToken.CreatedAt,
+		&refreshToken.ExpiresAt, &refreshToken.UpdatedAt, &revokedAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	refreshToken.RevokedAt = timePointer(revokedAt)
+
+	return &refreshToken, nil
+}
+
+// Revoke marks the token as exchanged. It reports false if the token was
+// already revoked, so two concurrent refreshes can't both succeed.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id bson.ObjectID) (bool, error) {
+	now := timeArg(time.Now())
+	result, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = $1, updated_at = $1 WHERE id = $2 AND revoked_at IS NULL`,
+		now, id.Hex())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (r *RefreshTokenRepository) Delete(ctx context.Context, token string) error {
+	result, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM refresh_tokens WHERE token = $1`, token)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(result)
+}
+
+func (r *RefreshTokenRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id = $1`, userID.Hex())
+	return err
+}
+
+// ListByUser returns the refresh tokens of the user, oldest first.
+func (r *RefreshTokenRepository) ListByUser(ctx context.Context, userID bson.ObjectID) ([]*models.RefreshToken, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx,
+		`SELECT id, user_id, token, created_at, expires_at, updated_at, revoked_at FROM refresh_tokens WHERE user_id = $1 ORDER BY id`,
+		userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.RefreshToken{}
+	for rows.Next() {
+		token := &models.RefreshToken{}
+		var revokedAt sql.NullTime
+		err := rows.Scan(scanID(&token.ID), scanID(&token.UserID), &token.Token, &token.CreatedAt, &token.ExpiresAt,
+			&token.UpdatedAt, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		token.RevokedAt = timePointer(revokedAt)
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
@@ -0,0 +1,44 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"context"
+	"time"
+)
+
+type SAMLRequestRepository struct {
+	db *DB
+}
+
+func NewSAMLRequestRepository(db *DB) *SAMLRequestRepository {
+	return &SAMLRequestRepository{db: db}
+}
+
+// Create stores the pending request. Expired requests are deleted on the
+// way, standing in for the TTL index of MongoDB.
+func (r *SAMLRequestRepository) Create(ctx context.Context, request *models.SAMLRequest) error {
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM saml_requests WHERE expires_at < $1`, timeArg(time.Now()))
+		if err != nil {
+			return err
+		}
+
+		_, err = r.db.conn(ctx).ExecContext(ctx,
+			`INSERT INTO saml_requests (id, created_at, expires_at) VALUES ($1, $2, $3)`,
+			request.ID, timeArg(request.CreatedAt), timeArg(request.ExpiresAt))
+		return dbError(err)
+	})
+}
+
+// Consume atomically removes the pending request and returns it, so only the
+// first response referencing it is accepted.
+func (r *SAMLRequestRepository) Consume(ctx context.Context, id string) (*models.SAMLRequest, error) {
+	var request models.SAMLRequest
+	err := r.db.conn(ctx).QueryRowContext(ctx,
+		`DELETE FROM saml_requests WHERE id = $1 RETURNING id, created_at, expires_at`, id,
+	).Scan(&request.ID, &request.CreatedAt, &request.ExpiresAt)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return &request, nil
+}
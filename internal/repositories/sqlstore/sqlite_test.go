@@ -0,0 +1,68 @@
+package sqlstore_test
+
+import (
+	"authentication-jwt/internal/repositories/repotest"
+	"authentication-jwt/internal/repositories/sqlstore"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteRepositories(t *testing.T) {
+	store, err := sqlstore.Open(context.Background(), sqlstore.SQLite, filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+
+	repotest.RunAll(t, newRepositories(store))
+}
+
+func TestSQLiteMigrateTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.db")
+
+	store, err := sqlstore.Connect(context.Background(), sqlstore.SQLite, path)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	statuses, err := store.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if len(statuses) == 0 || statuses[0].AppliedAt != nil {
+		t.Fatalf("MigrationStatus of a new database = %v, want pending migrations", statuses)
+	}
+	store.Close(context.Background())
+
+	for range 2 {
+		store, err := sqlstore.Open(context.Background(), sqlstore.SQLite, path)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		statuses, err := store.MigrationStatus(context.Background())
+		if err != nil {
+			t.Fatalf("MigrationStatus: %v", err)
+		}
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				t.Errorf("migration %s not applied", status.Version)
+			}
+		}
+		store.Close(context.Background())
+	}
+}
+
+func newRepositories(store *sqlstore.DB) repotest.Repositories {
+	return repotest.Repositories{
+		Users:             sqlstore.NewUserRepository(store),
+		RefreshTokens:     sqlstore.NewRefreshTokenRepository(store),
+		EventOutbox:       sqlstore.NewEventOutboxRepository(store),
+		Groups:            sqlstore.NewGroupRepository(store),
+		OneTimeTokens:     sqlstore.NewOneTimeTokenRepository(store),
+		MailOutbox:        sqlstore.NewMailOutboxRepository(store),
+		AuditLog:          sqlstore.NewAuditLogRepository(store),
+		Webhooks:          sqlstore.NewWebhookRepository(store),
+		WebhookDeliveries: sqlstore.NewWebhookDeliveryRepository(store),
+		SAMLRequests:      sqlstore.NewSAMLRequestRepository(store),
+	}
+}
@@ -0,0 +1,144 @@
+// Package sqlstore implements the repositories on top of database/sql, for
+// deployments standardized on a relational database instead of MongoDB, or
+// running from a single binary with an embedded SQLite database.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // Registers the "pgx" driver
+	_ "modernc.org/sqlite"             // Registers the "sqlite" driver
+)
+
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// driverNames are the database/sql drivers of each dialect.
+var driverNames = map[Dialect]string{
+	Postgres: "pgx",
+	SQLite:   "sqlite",
+}
+
+// sqliteOptions are added to SQLite file names given without options. WAL
+// lets readers run along the writer, and immediate transactions wait for the
+// write lock up to the busy timeout instead of failing when upgrading to it.
+const sqliteOptions = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(10000)&_txlock=immediate"
+
+// DB is a connection pool to the SQL database. The repositories built on it
+// take part in the transaction started by WithTransaction when they are
+// called with its context.
+type DB struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+type txKey struct{}
+
+// querier is what the repositories need from either the pool or a
+// transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Open connects to the database and applies the pending migrations.
+func Open(ctx context.Context, dialect Dialect, dsn string) (*DB, error) {
+	store, err := Connect(ctx, dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.Migrate(ctx); err != nil {
+		store.db.Close()
+		return nil, fmt.Errorf("failed to migrate the database: %w", err)
+	}
+
+	return store, nil
+}
+
+// Connect connects to the database without migrating it.
+func Connect(ctx context.Context, dialect Dialect, dsn string) (*DB, error) {
+	driverName, ok := driverNames[dialect]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQL dialect %q", dialect)
+	}
+
+	if dialect == SQLite && !strings.Contains(dsn, "?") {
+		dsn += "?" + sqliteOptions
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{db: db, dialect: dialect}, nil
+}
+
+// WithTransaction runs fn in a transaction, committed if fn returns nil. When
+// ctx already carries a transaction, fn simply runs in it.
+func (d *DB) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *DB) Ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// Close closes the connection pool. It has the signature of the lifecycle
+// stop hooks.
+func (d *DB) Close(context.Context) error {
+	return d.db.Close()
+}
+
+// conn returns the transaction carried by ctx, or the pool.
+func (d *DB) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return d.db
+}
+
+// jsonText is the expression reading a key of a JSON object column as text.
+func (d *DB) jsonText(column, key string) string {
+	if d.dialect == Postgres {
+		return column + "->>'" + key + "'"
+	}
+	return "json_extract(" + column + ", '$." + key + "')"
+}
+
+// skipLocked is the row locking clause letting concurrent workers claim
+// different rows, where the dialect supports it.
+func (d *DB) skipLocked() string {
+	if d.dialect == Postgres {
+		return " FOR UPDATE SKIP LOCKED"
+	}
+	return ""
+}
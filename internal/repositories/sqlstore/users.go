@@ -0,0 +1,337 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const userColumns = `id, username, password, email, pending_email, email_verified, email_mfa_enabled, given_name, family_name,
+	external_id, locale, disabled, roles, known_devices, notifications, logged_out_at, deleted_at, created_at, updated_at`
+
+// UserRepository stores the users in the users table and their linked
+// identities in user_identities.
+type UserRepository struct {
+	db *DB
+}
+
+func NewUserRepository(db *DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	// Update matches on UpdatedAt, kept at the precision of MongoDB as the
+	// other times.
+	user.UpdatedAt = user.UpdatedAt.Truncate(time.Millisecond)
+
+	args, err := userArgs(user)
+	if err != nil {
+		return err
+	}
+
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		query := `INSERT INTO users (` + userColumns + `) VALUES (` + placeholders(1, len(args)) + `)`
+		if _, err := r.db.conn(ctx).ExecContext(ctx, query, args...); err != nil {
+			return dbError(err)
+		}
+		return r.insertIdentities(ctx, user)
+	})
+}
+
+func (r *UserRepository) FindById(ctx context.Context, id string) (*models.User, error) {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.findOne(ctx, `WHERE id = $1`, id)
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, `WHERE lower(email) = lower($1)`, models.NormalizeEmail(email))
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.findOne(ctx, `WHERE lower(username) = lower($1)`, username)
+}
+
+func (r *UserRepository) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.findOne(ctx, `WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)`, provider, subject)
+}
+
+func (r *UserRepository) List(ctx context.Context, filter repositories.UserFilter, offset, limit int) ([]*models.User, int64, error) {
+	var conditions []string
+	var args []any
+	for condition, value := range map[string]string{
+		"lower(username) = lower($%d)": filter.Username,
+		"lower(email) = lower($%d)":    models.NormalizeEmail(filter.Email),
+		"external_id = $%d":            filter.ExternalID,
+	} {
+		if value != "" {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+		}
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM users `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	query := fmt.Sprintf(`%s ORDER BY id LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+	users, err := r.find(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
+	if user.ID.IsZero() {
+		return errors.New("user ID is required for update")
+	}
+
+	previous := user.UpdatedAt
+	user.UpdatedAt = repositories.NextUpdatedAt(previous)
+
+	args, err := userArgs(user)
+	if err != nil {
+		user.UpdatedAt = previous
+		return err
+	}
+
+	columns := strings.Split(userColumns, ",")
+	assignments := make([]string, 0, len(columns)-1)
+	for i, column := range columns[1:] {
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", strings.TrimSpace(column), i+2))
+	}
+	args = append(args, timeArg(previous))
+
+	// Matching on the previous UpdatedAt keeps the update from undoing a
+	// change made since the user was read.
+	err = r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		query := fmt.Sprintf(`UPDATE users SET %s WHERE id = $1 AND updated_at = $%d`, strings.Join(assignments, ", "), len(args))
+		result, err := r.db.conn(ctx).ExecContext(ctx, query, args...)
+		if err != nil {
+			return dbError(err)
+		}
+		if err := notFoundIfNone(result); err != nil {
+			if _, findErr := r.findOne(ctx, `WHERE id = $1`, user.ID.Hex()); findErr == nil {
+				return repositories.ErrStale
+			}
+			return err
+		}
+
+		if _, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = $1`, user.ID.Hex()); err != nil {
+			return err
+		}
+		return r.insertIdentities(ctx, user)
+	})
+	if err != nil {
+		user.UpdatedAt = previous
+	}
+	return err
+}
+
+// SeeDevice applies models.User.SeeDevice to the stored user, locking its row
+// meanwhile, and only writes its known devices. It isn't a change of the
+// account, so UpdatedAt is kept.
+func (r *UserRepository) SeeDevice(ctx context.Context, userID bson.ObjectID, device models.Device) (bool, error) {
+	isNew := false
+	err := r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		query := `SELECT known_devices FROM users WHERE id = $1`
+		if r.db.dialect == Postgres {
+			query += ` FOR UPDATE`
+		}
+
+		var user models.User
+		if err := r.db.conn(ctx).QueryRowContext(ctx, query, userID.Hex()).Scan(scanJSON(&user.KnownDevices)); err != nil {
+			return dbError(err)
+		}
+		isNew = user.SeeDevice(device)
+
+		knownDevices, err := jsonArg(user.KnownDevices)
+		if err != nil {
+			return err
+		}
+		_, err = r.db.conn(ctx).ExecContext(ctx, `UPDATE users SET known_devices = $1 WHERE id = $2`, knownDevices, userID.Hex())
+		return err
+	})
+	return isNew, err
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = $1`, id); err != nil {
+			return err
+		}
+		result, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+		return notFoundIfNone(result)
+	})
+}
+
+// DeleteDeleted deletes the user only if they deleted their account before the
+// given time, and returns ErrNotFound otherwise, as when it was restored since.
+func (r *UserRepository) DeleteDeleted(ctx context.Context, id string, before time.Time) error {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	return r.db.WithTransaction(ctx, func(ctx context.Context) error {
+		result, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM users WHERE id = $1 AND deleted_at < $2`, id, timeArg(before))
+		if err != nil {
+			return err
+		}
+		if err := notFoundIfNone(result); err != nil {
+			return err
+		}
+		_, err = r.db.conn(ctx).ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = $1`, id)
+		return err
+	})
+}
+
+// ListDeleted returns the users who deleted their account before the given
+// time, oldest deletion first.
+func (r *UserRepository) ListDeleted(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	return r.find(ctx, `WHERE deleted_at < $1 ORDER BY deleted_at LIMIT $2`, timeArg(before), limit)
+}
+
+func (r *UserRepository) insertIdentities(ctx context.Context, user *models.User) error {
+	for _, identity := range user.Identities {
+		_, err := r.db.conn(ctx).ExecContext(ctx,
+			`INSERT INTO user_identities (user_id, provider, subject, linked_at) VALUES ($1, $2, $3, $4)`,
+			user.ID.Hex(), identity.Provider, identity.Subject, timeArg(identity.LinkedAt))
+		if err != nil {
+			return dbError(err)
+		}
+	}
+	return nil
+}
+
+func (r *UserRepository) findOne(ctx context.Context, where string, args ...any) (*models.User, error) {
+	users, err := r.find(ctx, where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(users) == 0 {
+		return nil, repositories.ErrNotFound
+	}
+
+	return users[0], nil
+}
+
+// find loads the users matching the query suffix, with their identities.
+func (r *UserRepository) find(ctx context.Context, suffix string, args ...any) ([]*models.User, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx, `SELECT `+userColumns+` FROM users `+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	byID := map[string]*models.User{}
+	for rows.Next() {
+		user := &models.User{}
+		var loggedOutAt, deletedAt sql.NullTime
+		err := rows.Scan(
+			scanID(&user.ID), &user.Username, &user.Password, &user.Email, &user.PendingEmail, &user.EmailVerified, &user.EmailMFAEnabled,
+			&user.GivenName, &user.FamilyName, &user.ExternalID, &user.Locale, &user.Disabled,
+			scanJSON(&user.Roles), scanJSON(&user.KnownDevices), scanJSON(&user.Notifications),
+			&loggedOutAt, &deletedAt, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		user.LoggedOutAt = timePointer(loggedOutAt)
+		user.DeletedAt = timePointer(deletedAt)
+
+		users = append(users, user)
+		byID[user.ID.Hex()] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(users) == 0 {
+		return users, nil
+	}
+
+	ids := make([]any, 0, len(users))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+
+	identityRows, err := r.db.conn(ctx).QueryContext(ctx,
+		`SELECT user_id, provider, subject, linked_at FROM user_identities WHERE user_id IN (`+placeholders(1, len(ids))+`) ORDER BY linked_at`,
+		ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer identityRows.Close()
+
+	for identityRows.Next() {
+		var userID string
+		var identity models.Identity
+		if err := identityRows.Scan(&userID, &identity.Provider, &identity.Subject, &identity.LinkedAt); err != nil {
+			return nil, err
+		}
+		byID[userID].Identities = append(byID[userID].Identities, identity)
+	}
+
+	return users, identityRows.Err()
+}
+
+// userArgs lists the values of userColumns.
+func userArgs(user *models.User) ([]any, error) {
+	roles, err := jsonArg(nonNil(user.Roles))
+	if err != nil {
+		return nil, err
+	}
+	knownDevices, err := jsonArg(nonNil(user.KnownDevices))
+	if err != nil {
+		return nil, err
+	}
+	notifications := "{}"
+	if user.Notifications != nil {
+		if notifications, err = jsonArg(user.Notifications); err != nil {
+			return nil, err
+		}
+	}
+
+	return []any{
+		user.ID.Hex(), user.Username, user.Password, user.Email, user.PendingEmail, user.EmailVerified, user.EmailMFAEnabled,
+		user.GivenName, user.FamilyName, user.ExternalID, user.Locale, user.Disabled,
+		roles, knownDevices, notifications,
+		nullTimeArg(user.LoggedOutAt), nullTimeArg(user.DeletedAt), timeArg(user.CreatedAt), timeArg(user.UpdatedAt),
+	}, nil
+}
+
+// nonNil keeps nil slices from being stored as JSON null.
+func nonNil[T any](values []T) []T {
+	if values == nil {
+		return []T{}
+	}
+	return values
+}
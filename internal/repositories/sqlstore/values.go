@@ -0,0 +1,113 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// The models keep MongoDB object IDs; they are stored as their hex form.
+
+// idScanner scans a hex ID into an object ID.
+type idScanner struct {
+	id *bson.ObjectID
+}
+
+func scanID(id *bson.ObjectID) sql.Scanner {
+	return idScanner{id: id}
+}
+
+func (s idScanner) Scan(value any) error {
+	var hex string
+	switch v := value.(type) {
+	case string:
+		hex = v
+	case []byte:
+		hex = string(v)
+	case nil:
+		*s.id = bson.NilObjectID
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into an object ID", value)
+	}
+
+	id, err := bson.ObjectIDFromHex(hex)
+	if err != nil {
+		return err
+	}
+	*s.id = id
+	return nil
+}
+
+// nullIDArg stores a zero object ID as NULL, which scanID reads back as zero.
+func nullIDArg(id bson.ObjectID) sql.NullString {
+	if id.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: id.Hex(), Valid: true}
+}
+
+// jsonScanner scans a JSON column into the value it points to.
+type jsonScanner struct {
+	dest any
+}
+
+func scanJSON(dest any) sql.Scanner {
+	return jsonScanner{dest: dest}
+}
+
+func (s jsonScanner) Scan(value any) error {
+	switch v := value.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), s.dest)
+	case []byte:
+		return json.Unmarshal(v, s.dest)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T as JSON", value)
+	}
+}
+
+// jsonArg encodes a value for a JSON column. It is passed as a string, which
+// both drivers write as is.
+func jsonArg(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// timeArg normalizes times to UTC, so they compare correctly in databases
+// storing them as text.
+func timeArg(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func nullTimeArg(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func timePointer(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// placeholders returns "$from, $from+1, ..." for n arguments.
+func placeholders(from, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,186 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const deliveryColumns = `id, webhook_id, user_id, event_id, event, payload, status, attempts, next_attempt_at,
+	last_status_code, last_error, created_at, delivered_at`
+
+type WebhookDeliveryRepository struct {
+	db *DB
+}
+
+func NewWebhookDeliveryRepository(db *DB) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{db: db}
+}
+
+// Create queues the delivery unless the event was already queued for the
+// webhook.
+func (r *WebhookDeliveryRepository) Create(ctx context.Context, delivery *models.WebhookDelivery) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`INSERT INTO webhook_deliveries (`+deliveryColumns+`) VALUES (`+placeholders(1, 13)+`)
+		ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+		delivery.ID.Hex(), delivery.WebhookID.Hex(), nullIDArg(delivery.UserID), delivery.EventID, delivery.Event, delivery.Payload, delivery.Status,
+		delivery.Attempts, timeArg(delivery.NextAttemptAt), delivery.LastStatusCode, delivery.LastError,
+		timeArg(delivery.CreatedAt), nullTimeArg(delivery.DeliveredAt))
+	return dbError(err)
+}
+
+func (r *WebhookDeliveryRepository) FindById(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	delivery, err := scanDelivery(r.db.conn(ctx).QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1`, id))
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return delivery, nil
+}
+
+// List returns the deliveries matching the filter, newest first.
+func (r *WebhookDeliveryRepository) List(ctx context.Context, filter repositories.WebhookDeliveryFilter, offset, limit int) ([]*models.WebhookDelivery, int64, error) {
+	var conditions []string
+	var args []any
+	if !filter.WebhookID.IsZero() {
+		args = append(args, filter.WebhookID.Hex())
+		conditions = append(conditions, fmt.Sprintf("webhook_id = $%d", len(args)))
+	}
+	if !filter.UserID.IsZero() {
+		args = append(args, filter.UserID.Hex())
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.conn(ctx).QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_deliveries `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	rows, err := r.db.conn(ctx).QueryContext(ctx,
+		fmt.Sprintf(`SELECT %s FROM webhook_deliveries %s ORDER BY id DESC LIMIT $%d OFFSET $%d`, deliveryColumns, where, len(args)-1, len(args)),
+		args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return deliveries, total, nil
+}
+
+// ClaimDue atomically picks the oldest pending delivery that is due and
+// pushes its next attempt forward by the lease, so another worker won't pick
+// it up while it is being delivered.
+func (r *WebhookDeliveryRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	row := r.db.conn(ctx).QueryRowContext(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = $1, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM webhook_deliveries WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at LIMIT 1`+r.db.skipLocked()+`
+		)
+		RETURNING `+deliveryColumns,
+		timeArg(now.Add(lease)), models.WebhookDeliveryPending, timeArg(now))
+
+	delivery, err := scanDelivery(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Nothing due
+		}
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+func (r *WebhookDeliveryRepository) MarkDelivered(ctx context.Context, id bson.ObjectID, statusCode int) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = $1, last_status_code = $2, delivered_at = $3, last_error = '' WHERE id = $4`,
+		models.WebhookDeliveryDelivered, statusCode, timeArg(time.Now()), id.Hex())
+	return err
+}
+
+func (r *WebhookDeliveryRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, statusCode int, lastError string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = $1, last_status_code = $2, last_error = $3 WHERE id = $4`,
+		timeArg(nextAttemptAt), statusCode, lastError, id.Hex())
+	return err
+}
+
+func (r *WebhookDeliveryRepository) MarkDead(ctx context.Context, id bson.ObjectID, statusCode int, lastError string) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = $1, last_status_code = $2, last_error = $3 WHERE id = $4`,
+		models.WebhookDeliveryDead, statusCode, lastError, id.Hex())
+	return err
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts.
+func (r *WebhookDeliveryRepository) Redeliver(ctx context.Context, id bson.ObjectID) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = $1, attempts = 0, next_attempt_at = $2 WHERE id = $3`,
+		models.WebhookDeliveryPending, timeArg(time.Now()), id.Hex())
+	return err
+}
+
+func (r *WebhookDeliveryRepository) DeleteByWebhook(ctx context.Context, webhookID bson.ObjectID) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = $1`, webhookID.Hex())
+	return err
+}
+
+// DeleteByUser removes the deliveries of the events about the user, whatever
+// their status.
+func (r *WebhookDeliveryRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE user_id = $1`, userID.Hex())
+	return err
+}
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(scanID(&delivery.ID), scanID(&delivery.WebhookID), scanID(&delivery.UserID), &delivery.EventID, &delivery.Event, &delivery.Payload,
+		&delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError,
+		&delivery.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	delivery.DeliveredAt = timePointer(deliveredAt)
+
+	return &delivery, nil
+}
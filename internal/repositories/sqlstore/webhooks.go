@@ -0,0 +1,129 @@
+package sqlstore
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const webhookColumns = `id, url, description, events, secret, active, created_at, updated_at`
+
+type WebhookRepository struct {
+	db *DB
+}
+
+func NewWebhookRepository(db *DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	events, err := jsonArg(nonNil(webhook.Events))
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.conn(ctx).ExecContext(ctx,
+		`INSERT INTO webhooks (`+webhookColumns+`) VALUES (`+placeholders(1, 8)+`)`,
+		webhook.ID.Hex(), webhook.URL, webhook.Description, events, webhook.Secret, webhook.Active,
+		timeArg(webhook.CreatedAt), timeArg(webhook.UpdatedAt))
+	return dbError(err)
+}
+
+func (r *WebhookRepository) FindById(ctx context.Context, id string) (*models.Webhook, error) {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	webhooks, err := r.find(ctx, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(webhooks) == 0 {
+		return nil, repositories.ErrNotFound
+	}
+
+	return webhooks[0], nil
+}
+
+// FindSubscribed returns the active webhooks subscribed to the event. There
+// are few webhooks, so the event lists are matched here rather than in SQL,
+// where JSON functions differ between dialects.
+func (r *WebhookRepository) FindSubscribed(ctx context.Context, event string) ([]*models.Webhook, error) {
+	webhooks, err := r.find(ctx, `WHERE active = $1 ORDER BY id`, true)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribed := []*models.Webhook{}
+	for _, webhook := range webhooks {
+		if webhook.Subscribes(event) {
+			subscribed = append(subscribed, webhook)
+		}
+	}
+
+	return subscribed, nil
+}
+
+func (r *WebhookRepository) List(ctx context.Context) ([]*models.Webhook, error) {
+	return r.find(ctx, `ORDER BY id`)
+}
+
+func (r *WebhookRepository) Update(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID.IsZero() {
+		return errors.New("webhook ID is required for update")
+	}
+
+	webhook.UpdatedAt = time.Now()
+
+	events, err := jsonArg(nonNil(webhook.Events))
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.conn(ctx).ExecContext(ctx,
+		`UPDATE webhooks SET url = $1, description = $2, events = $3, secret = $4, active = $5, updated_at = $6 WHERE id = $7`,
+		webhook.URL, webhook.Description, events, webhook.Secret, webhook.Active, timeArg(webhook.UpdatedAt), webhook.ID.Hex())
+	if err != nil {
+		return dbError(err)
+	}
+	return notFoundIfNone(result)
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, id string) error {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", repositories.ErrNotFound, err)
+	}
+
+	result, err := r.db.conn(ctx).ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return notFoundIfNone(result)
+}
+
+func (r *WebhookRepository) find(ctx context.Context, suffix string, args ...any) ([]*models.Webhook, error) {
+	rows, err := r.db.conn(ctx).QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks `+suffix, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		webhook := &models.Webhook{}
+		err := rows.Scan(scanID(&webhook.ID), &webhook.URL, &webhook.Description, scanJSON(&webhook.Events), &webhook.Secret,
+			&webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
@@ -6,20 +6,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
+type UserFilter struct {
+	Username   string
+	Email      string
+	ExternalID string
+}
+
 type UserRepositoryInterface interface {
 	Create(ctx context.Context, user *models.User) error
 	FindById(ctx context.Context, id string) (*models.User, error)
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	List(ctx context.Context, filter UserFilter, offset, limit int) ([]*models.User, int64, error)
 	Update(ctx context.Context, user *models.User) error
+	Delete(ctx context.Context, id string) error
+	DeleteDeleted(ctx context.Context, id string, before time.Time) error
+	ListDeleted(ctx context.Context, before time.Time, limit int) ([]*models.User, error)
+	SeeDevice(ctx context.Context, userID bson.ObjectID, device models.Device) (bool, error)
 }
 
+// caseInsensitive is the collation of the unique indexes on email and
+// username. Queries on these fields must use it to be served by the indexes.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
 type UserRepository struct {
 	collection *mongo.Collection
 }
@@ -31,9 +48,12 @@ func NewUserRepository(db *database.Database) *UserRepository {
 }
 
 func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	// Update matches on UpdatedAt, kept at the precision MongoDB stores.
+	user.UpdatedAt = user.UpdatedAt.Truncate(time.Millisecond)
+
 	_, err := r.collection.InsertOne(ctx, user)
 	if err != nil {
-		return err
+		return mongoError(err)
 	}
 	return nil
 }
@@ -42,48 +62,261 @@ func (r *UserRepository) FindById(ctx context.Context, id string) (*models.User,
 	var user models.User
 	objID, err := bson.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, fmt.Errorf("invalid ID format: %v", err)
+		return nil, fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
 	}
 
 	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
-	log.Default().Printf("Finding user with ID: %s", objID)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil // No user found
-		}
-		return nil, err
+		return nil, mongoError(err)
 	}
 
-	log.Default().Printf("User found: %+v", user)
-
 	return &user, nil
 }
 
 func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	opts := options.FindOne().SetCollation(caseInsensitive)
+	err := r.collection.FindOne(ctx, bson.M{"email": models.NormalizeEmail(email)}, opts).Decode(&user)
 
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil // No user found
-		}
-		return nil, err // Other errors
+		return nil, mongoError(err)
+	}
+
+	return &user, nil
+}
+
+func (r *UserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	opts := options.FindOne().SetCollation(caseInsensitive)
+	err := r.collection.FindOne(ctx, bson.M{"username": username}, opts).Decode(&user)
+
+	if err != nil {
+		return nil, mongoError(err)
 	}
 
 	return &user, nil
 }
 
+func (r *UserRepository) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	var user models.User
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &user, nil
+}
+
+func (r *UserRepository) List(ctx context.Context, filter UserFilter, offset, limit int) ([]*models.User, int64, error) {
+	query := bson.M{}
+	if filter.Username != "" {
+		query["username"] = filter.Username
+	}
+	if filter.Email != "" {
+		query["email"] = models.NormalizeEmail(filter.Email)
+	}
+	if filter.ExternalID != "" {
+		query["external_id"] = filter.ExternalID
+	}
+
+	// Usernames and emails match case-insensitively. The collation applies to
+	// the whole query, so it is left out when filtering on the external ID only.
+	countOpts := options.Count()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+	if filter.Username != "" || filter.Email != "" {
+		countOpts.SetCollation(caseInsensitive)
+		opts.SetCollation(caseInsensitive)
+	}
+
+	total, err := r.collection.CountDocuments(ctx, query, countOpts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []*models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
 func (r *UserRepository) Update(ctx context.Context, user *models.User) error {
 	if user.ID.IsZero() {
 		return errors.New("user ID is required for update")
 	}
 
-	user.UpdatedAt = time.Now()
+	previous := user.UpdatedAt
+	user.UpdatedAt = NextUpdatedAt(previous)
+
+	// The document is replaced rather than $set, which would leave the
+	// omitempty fields cleared by the caller unchanged. Matching on the
+	// previous UpdatedAt keeps it from undoing a change made since the user
+	// was read.
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": user.ID, "updated_at": previous}, user)
+	if err == nil && result.MatchedCount == 0 {
+		err = r.staleOrNotFound(ctx, user.ID)
+	}
+	if err != nil {
+		user.UpdatedAt = previous
+		return mongoError(err)
+	}
+
+	return nil
+}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": user})
+func (r *UserRepository) staleOrNotFound(ctx context.Context, id bson.ObjectID) error {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": id})
 	if err != nil {
 		return err
 	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrStale
+}
+
+// SeeDevice applies models.User.SeeDevice to the stored user in one update
+// of its known devices, leaving the rest of the user alone. It isn't a change
+// of the account, so UpdatedAt is kept.
+func (r *UserRepository) SeeDevice(ctx context.Context, userID bson.ObjectID, device models.Device) (bool, error) {
+	// The values are passed as $literal, so that a user agent starting with
+	// "$" isn't read as a field path.
+	deviceID := bson.M{"$literal": device.ID}
+	devices := bson.M{"$ifNull": bson.A{"$known_devices", bson.A{}}}
+	seen := bson.M{"$map": bson.M{
+		"input": devices,
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$$this.id", deviceID}},
+			bson.M{"$mergeObjects": bson.A{"$$this", bson.M{"$literal": bson.M{"ip_address": device.IPAddress, "last_seen_at": device.LastSeenAt}}}},
+			"$$this",
+		}},
+	}}
+	added := bson.M{"$slice": bson.A{
+		bson.M{"$sortArray": bson.M{
+			"input":  bson.M{"$concatArrays": bson.A{devices, bson.M{"$literal": bson.A{device}}}},
+			"sortBy": bson.M{"last_seen_at": -1},
+		}},
+		models.MaxKnownDevices,
+	}}
+	pipeline := bson.A{bson.M{"$set": bson.M{"known_devices": bson.M{"$cond": bson.A{
+		bson.M{"$in": bson.A{deviceID, bson.M{"$ifNull": bson.A{"$known_devices.id", bson.A{}}}}},
+		seen,
+		added,
+	}}}}}
+
+	opts := options.FindOneAndUpdate().
+		SetProjection(bson.M{"known_devices": 1}).
+		SetReturnDocument(options.Before)
+
+	var before models.User
+	if err := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": userID}, pipeline, opts).Decode(&before); err != nil {
+		return false, mongoError(err)
+	}
+
+	return before.SeeDevice(device), nil
+}
+
+// NextUpdatedAt is the UpdatedAt of a document updated now, at the precision
+// MongoDB stores. It is always after previous, even within the same
+// millisecond, so that copies read before the update can be told stale.
+func NextUpdatedAt(previous time.Time) time.Time {
+	now := time.Now().Truncate(time.Millisecond)
+	if !now.After(previous) {
+		now = previous.Add(time.Millisecond)
+	}
+	return now
+}
+
+// maxUpdateAttempts bounds how many times UpdateUser starts over.
+const maxUpdateAttempts = 5
+
+// UpdateUser applies change to the user and saves it. When the user was
+// changed since it was read, it is read again and change applied to the
+// fresh copy, so that neither change is lost. user is left as saved.
+func UpdateUser(ctx context.Context, repository UserRepositoryInterface, user *models.User, change func(user *models.User) error) error {
+	for attempt := 1; ; attempt++ {
+		if err := change(user); err != nil {
+			return err
+		}
+
+		err := repository.Update(ctx, user)
+		if !errors.Is(err, ErrStale) || attempt == maxUpdateAttempts {
+			return err
+		}
+
+		fresh, err := repository.FindById(ctx, user.ID.Hex())
+		if err != nil {
+			return err
+		}
+		*user = *fresh
+	}
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
 
 	return nil
 }
+
+// DeleteDeleted deletes the user only if they deleted their account before the
+// given time, and returns ErrNotFound otherwise, as when it was restored since.
+func (r *UserRepository) DeleteDeleted(ctx context.Context, id string, before time.Time) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID, "deleted_at": bson.M{"$lt": before}})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListDeleted returns the users who deleted their account before the given
+// time, oldest deletion first.
+func (r *UserRepository) ListDeleted(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "deleted_at", Value: 1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, bson.M{"deleted_at": bson.M{"$lt": before}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
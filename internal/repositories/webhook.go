@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type WebhookRepositoryInterface interface {
+	Create(ctx context.Context, webhook *models.Webhook) error
+	FindById(ctx context.Context, id string) (*models.Webhook, error)
+	FindSubscribed(ctx context.Context, event string) ([]*models.Webhook, error)
+	List(ctx context.Context) ([]*models.Webhook, error)
+	Update(ctx context.Context, webhook *models.Webhook) error
+	Delete(ctx context.Context, id string) error
+}
+
+type WebhookRepository struct {
+	collection *mongo.Collection
+}
+
+func NewWebhookRepository(db *database.Database) *WebhookRepository {
+	return &WebhookRepository{
+		collection: db.Client.Collection("webhooks"),
+	}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	_, err := r.collection.InsertOne(ctx, webhook)
+	if err != nil {
+		return mongoError(err)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) FindById(ctx context.Context, id string) (*models.Webhook, error) {
+	var webhook models.Webhook
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&webhook)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &webhook, nil
+}
+
+// FindSubscribed returns the active webhooks subscribed to the event.
+func (r *WebhookRepository) FindSubscribed(ctx context.Context, event string) ([]*models.Webhook, error) {
+	filter := bson.M{
+		"active": true,
+		"events": bson.M{"$in": bson.A{event, models.WebhookEventAll}},
+	}
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []*models.Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) List(ctx context.Context) ([]*models.Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := []*models.Webhook{}
+	if err := cursor.All(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *WebhookRepository) Update(ctx context.Context, webhook *models.Webhook) error {
+	if webhook.ID.IsZero() {
+		return errors.New("webhook ID is required for update")
+	}
+
+	webhook.UpdatedAt = time.Now()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": webhook.ID}, bson.M{"$set": webhook})
+	if err != nil {
+		return mongoError(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *WebhookRepository) Delete(ctx context.Context, id string) error {
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
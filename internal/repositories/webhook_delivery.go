@@ -0,0 +1,214 @@
+package repositories
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type WebhookDeliveryFilter struct {
+	WebhookID bson.ObjectID
+	UserID    bson.ObjectID
+	Status    string
+}
+
+type WebhookDeliveryRepositoryInterface interface {
+	Create(ctx context.Context, delivery *models.WebhookDelivery) error
+	FindById(ctx context.Context, id string) (*models.WebhookDelivery, error)
+	List(ctx context.Context, filter WebhookDeliveryFilter, offset, limit int) ([]*models.WebhookDelivery, int64, error)
+	ClaimDue(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error)
+	MarkDelivered(ctx context.Context, id bson.ObjectID, statusCode int) error
+	MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, statusCode int, lastError string) error
+	MarkDead(ctx context.Context, id bson.ObjectID, statusCode int, lastError string) error
+	Redeliver(ctx context.Context, id bson.ObjectID) error
+	DeleteByWebhook(ctx context.Context, webhookID bson.ObjectID) error
+	DeleteByUser(ctx context.Context, userID bson.ObjectID) error
+}
+
+type WebhookDeliveryRepository struct {
+	collection *mongo.Collection
+}
+
+func NewWebhookDeliveryRepository(db *database.Database) *WebhookDeliveryRepository {
+	return &WebhookDeliveryRepository{
+		collection: db.Client.Collection("webhook_deliveries"),
+	}
+}
+
+// Create queues the delivery unless the event was already queued for the
+// webhook.
+func (r *WebhookDeliveryRepository) Create(ctx context.Context, delivery *models.WebhookDelivery) error {
+	filter := bson.M{"webhook_id": delivery.WebhookID, "event_id": delivery.EventID}
+	opts := options.UpdateOne().SetUpsert(true)
+
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$setOnInsert": delivery}, opts)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) FindById(ctx context.Context, id string) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	objID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ID format: %v", ErrNotFound, err)
+	}
+
+	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&delivery)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+
+	return &delivery, nil
+}
+
+// List returns the deliveries matching the filter, newest first.
+func (r *WebhookDeliveryRepository) List(ctx context.Context, filter WebhookDeliveryFilter, offset, limit int) ([]*models.WebhookDelivery, int64, error) {
+	query := bson.M{}
+	if !filter.WebhookID.IsZero() {
+		query["webhook_id"] = filter.WebhookID
+	}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	deliveries := []*models.WebhookDelivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, 0, err
+	}
+
+	return deliveries, total, nil
+}
+
+// ClaimDue atomically picks the oldest pending delivery that is due and
+// pushes its next attempt forward by the lease, so another worker won't pick
+// it up while it is being delivered.
+func (r *WebhookDeliveryRepository) ClaimDue(ctx context.Context, lease time.Duration) (*models.WebhookDelivery, error) {
+	now := time.Now()
+	filter := bson.M{
+		"status":          models.WebhookDeliveryPending,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"next_attempt_at": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var delivery models.WebhookDelivery
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&delivery)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil // Nothing due
+		}
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (r *WebhookDeliveryRepository) MarkDelivered(ctx context.Context, id bson.ObjectID, statusCode int) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":           models.WebhookDeliveryDelivered,
+			"last_status_code": statusCode,
+			"delivered_at":     time.Now(),
+		},
+		"$unset": bson.M{"last_error": ""},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) MarkRetry(ctx context.Context, id bson.ObjectID, nextAttemptAt time.Time, statusCode int, lastError string) error {
+	update := bson.M{"$set": bson.M{
+		"next_attempt_at":  nextAttemptAt,
+		"last_status_code": statusCode,
+		"last_error":       lastError,
+	}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) MarkDead(ctx context.Context, id bson.ObjectID, statusCode int, lastError string) error {
+	update := bson.M{"$set": bson.M{
+		"status":           models.WebhookDeliveryDead,
+		"last_status_code": statusCode,
+		"last_error":       lastError,
+	}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Redeliver queues a delivery again with a fresh set of attempts.
+func (r *WebhookDeliveryRepository) Redeliver(ctx context.Context, id bson.ObjectID) error {
+	update := bson.M{"$set": bson.M{
+		"status":          models.WebhookDeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *WebhookDeliveryRepository) DeleteByWebhook(ctx context.Context, webhookID bson.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"webhook_id": webhookID})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteByUser removes the deliveries of the events about the user, whatever
+// their status.
+func (r *WebhookDeliveryRepository) DeleteByUser(ctx context.Context, userID bson.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	return nil
+}
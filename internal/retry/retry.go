@@ -0,0 +1,77 @@
+// Package retry runs the workers draining the outboxes: they claim the due
+// items one at a time, and failed items are retried with exponential backoff
+// until they run out of attempts.
+package retry
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Policy sets how often a worker polls and how failed items are retried.
+type Policy struct {
+	PollInterval time.Duration
+	// Lease is how long a claimed item is hidden from the other workers.
+	Lease       time.Duration
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Backoff doubles the wait after each failed attempt, up to MaxBackoff.
+func (p Policy) Backoff(attempts int) time.Duration {
+	delay := p.BaseBackoff
+	for i := 1; i < attempts && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxBackoff)
+}
+
+// NextAttempt returns when an item that failed its attempts-th attempt is
+// retried.
+func (p Policy) NextAttempt(attempts int) time.Time {
+	return time.Now().Add(p.Backoff(attempts))
+}
+
+// Exhausted reports whether an item that failed its attempts-th attempt is
+// given up on.
+func (p Policy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// Run processes the due items until the context is cancelled. claim returns
+// the next due item, leased for the given duration, or nil when none is due;
+// then Run waits PollInterval before claiming again. name describes the items
+// in the logs.
+func Run[T any](ctx context.Context, policy Policy, name string, claim func(ctx context.Context, lease time.Duration) (*T, error), process func(ctx context.Context, item *T)) {
+	ticker := time.NewTicker(policy.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		Drain(ctx, policy, name, claim, process)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain processes the items claimed until none is due.
+func Drain[T any](ctx context.Context, policy Policy, name string, claim func(ctx context.Context, lease time.Duration) (*T, error), process func(ctx context.Context, item *T)) {
+	for ctx.Err() == nil {
+		item, err := claim(ctx, policy.Lease)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to claim "+name, "error", err)
+			return
+		}
+
+		if item == nil {
+			return
+		}
+
+		process(ctx, item)
+	}
+}
@@ -0,0 +1,130 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{BaseBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}
+	for attempts, want := range map[int]time.Duration{
+		0:   30 * time.Second,
+		1:   30 * time.Second,
+		2:   time.Minute,
+		3:   2 * time.Minute,
+		4:   4 * time.Minute,
+		5:   5 * time.Minute,
+		100: 5 * time.Minute,
+	} {
+		if got := policy.Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	policy := Policy{MaxAttempts: 3}
+	for attempts, want := range map[int]bool{1: false, 2: false, 3: true, 4: true} {
+		if got := policy.Exhausted(attempts); got != want {
+			t.Errorf("Exhausted(%d) = %t, want %t", attempts, got, want)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	policy := Policy{Lease: time.Minute}
+
+	t.Run("UntilNoneDue", func(t *testing.T) {
+		due := []int{1, 2, 3}
+		var processed []int
+		Drain(context.Background(), policy, "item",
+			func(ctx context.Context, lease time.Duration) (*int, error) {
+				if lease != time.Minute {
+					t.Errorf("claimed with lease %s", lease)
+				}
+				if len(due) == 0 {
+					return nil, nil
+				}
+				item := due[0]
+				due = due[1:]
+				return &item, nil
+			},
+			func(ctx context.Context, item *int) { processed = append(processed, *item) },
+		)
+
+		if len(processed) != 3 {
+			t.Errorf("processed %v, want the 3 items", processed)
+		}
+	})
+
+	t.Run("ClaimFailed", func(t *testing.T) {
+		claims := 0
+		Drain(context.Background(), policy, "item",
+			func(ctx context.Context, lease time.Duration) (*int, error) {
+				claims++
+				return nil, errors.New("database down")
+			},
+			func(ctx context.Context, item *int) { t.Error("processed an item") },
+		)
+
+		if claims != 1 {
+			t.Errorf("claimed %d times after a failure, want 1", claims)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		processed := 0
+		Drain(ctx, policy, "item",
+			func(ctx context.Context, lease time.Duration) (*int, error) {
+				item := 0
+				return &item, nil
+			},
+			func(ctx context.Context, item *int) {
+				processed++
+				cancel()
+			},
+		)
+
+		if processed != 1 {
+			t.Errorf("processed %d items after cancellation, want 1", processed)
+		}
+	})
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	claims := make(chan struct{}, 10)
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, Policy{PollInterval: time.Millisecond}, "item",
+			func(ctx context.Context, lease time.Duration) (*int, error) {
+				select {
+				case claims <- struct{}{}:
+				default:
+				}
+				return nil, nil
+			},
+			func(ctx context.Context, item *int) {},
+		)
+		close(done)
+	}()
+
+	// Nothing is due, so each claim comes from a poll.
+	for range 3 {
+		select {
+		case <-claims:
+		case <-time.After(time.Second):
+			t.Fatal("Run didn't poll")
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return once cancelled")
+	}
+}
@@ -0,0 +1,211 @@
+package scim
+
+import (
+	"authentication-jwt/internal/models"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var memberPathExpression = regexp.MustCompile(`(?i)^members\[value eq "([^"]*)"\]$`)
+
+// ApplyUserPatch applies PatchOp operations to a user. Only the attributes
+// stored on models.User can be modified.
+func ApplyUserPatch(user *models.User, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return NewError(400, "invalidSyntax", fmt.Sprintf("Unsupported operation: %s", operation.Op))
+		}
+
+		if operation.Path == "" {
+			values, ok := operation.Value.(map[string]any)
+			if !ok || op == "remove" {
+				return NewError(400, "noTarget", "Operation without path requires an object value")
+			}
+			for path, value := range values {
+				if err := setUserAttribute(user, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if op == "remove" {
+			if err := setUserAttribute(user, operation.Path, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := setUserAttribute(user, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setUserAttribute(user *models.User, path string, value any) error {
+	switch strings.ToLower(path) {
+	case "active":
+		active, err := boolValue(value)
+		if err != nil {
+			return err
+		}
+		user.Disabled = !active
+	case "username":
+		if value == nil {
+			return NewError(400, "mutability", "userName is required")
+		}
+		user.Username = stringValue(value)
+	case "externalid":
+		user.ExternalID = stringValue(value)
+	case "name":
+		name, _ := value.(map[string]any)
+		user.GivenName = stringValue(name["givenName"])
+		user.FamilyName = stringValue(name["familyName"])
+	case "name.givenname":
+		user.GivenName = stringValue(value)
+	case "name.familyname":
+		user.FamilyName = stringValue(value)
+	case "emails", `emails[type eq "work"].value`, `emails[primary eq true].value`:
+		email := emailValue(value)
+		if email == "" {
+			return NewError(400, "mutability", "email is required")
+		}
+		user.Email = models.NormalizeEmail(email)
+	default:
+		return NewError(400, "invalidPath", fmt.Sprintf("Unsupported path: %s", path))
+	}
+
+	return nil
+}
+
+// ApplyGroupPatch applies PatchOp operations to a group.
+func ApplyGroupPatch(group *models.Group, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		path := strings.ToLower(operation.Path)
+
+		switch {
+		case path == "displayname" && op != "remove":
+			group.DisplayName = stringValue(operation.Value)
+		case path == "externalid":
+			group.ExternalID = stringValue(operation.Value)
+		case path == "members" && op == "add":
+			members, err := memberValues(operation.Value)
+			if err != nil {
+				return err
+			}
+			for _, member := range members {
+				group.AddMember(member)
+			}
+		case path == "members" && op == "replace":
+			members, err := memberValues(operation.Value)
+			if err != nil {
+				return err
+			}
+			group.Members = []bson.ObjectID{}
+			for _, member := range members {
+				group.AddMember(member)
+			}
+		case path == "members" && op == "remove":
+			if operation.Value == nil {
+				group.Members = []bson.ObjectID{}
+				continue
+			}
+			members, err := memberValues(operation.Value)
+			if err != nil {
+				return err
+			}
+			for _, member := range members {
+				group.RemoveMember(member)
+			}
+		case memberPathExpression.MatchString(operation.Path) && op == "remove":
+			member, err := bson.ObjectIDFromHex(memberPathExpression.FindStringSubmatch(operation.Path)[1])
+			if err != nil {
+				return NewError(400, "invalidValue", "Invalid member ID")
+			}
+			group.RemoveMember(member)
+		case path == "" && (op == "add" || op == "replace"):
+			values, ok := operation.Value.(map[string]any)
+			if !ok {
+				return NewError(400, "noTarget", "Operation without path requires an object value")
+			}
+			for key, value := range values {
+				if err := ApplyGroupPatch(group, []PatchOperation{{Op: op, Path: key, Value: value}}); err != nil {
+					return err
+				}
+			}
+		default:
+			return NewError(400, "invalidPath", fmt.Sprintf("Unsupported operation %s on path %s", operation.Op, operation.Path))
+		}
+	}
+
+	return group.Validate()
+}
+
+func memberValues(value any) ([]bson.ObjectID, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, NewError(400, "invalidValue", "members must be a list")
+	}
+
+	members := []bson.ObjectID{}
+	for _, item := range items {
+		member, _ := item.(map[string]any)
+		id, err := bson.ObjectIDFromHex(stringValue(member["value"]))
+		if err != nil {
+			return nil, NewError(400, "invalidValue", "Invalid member ID")
+		}
+		members = append(members, id)
+	}
+
+	return members, nil
+}
+
+func stringValue(value any) string {
+	s, _ := value.(string)
+	return s
+}
+
+// boolValue accepts booleans and the "True"/"False" strings some identity
+// providers send.
+func boolValue(value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, NewError(400, "invalidValue", "active must be a boolean")
+		}
+		return b, nil
+	default:
+		return false, NewError(400, "invalidValue", "active must be a boolean")
+	}
+}
+
+func emailValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []any:
+		var first string
+		for _, item := range v {
+			email, _ := item.(map[string]any)
+			if primary, _ := email["primary"].(bool); primary {
+				return stringValue(email["value"])
+			}
+			if first == "" {
+				first = stringValue(email["value"])
+			}
+		}
+		return first
+	}
+	return ""
+}
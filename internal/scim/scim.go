@@ -0,0 +1,246 @@
+// Package scim maps users and groups onto SCIM 2.0 resources (RFC 7643) and
+// implements the parts of the protocol (RFC 7644) used by the provisioning API.
+package scim
+
+import (
+	"authentication-jwt/internal/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	ContentType = "application/scim+json"
+)
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+type Name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Name       *Name    `json:"name,omitempty"`
+	Emails     []Email  `json:"emails,omitempty"`
+	Active     *bool    `json:"active,omitempty"`
+	Password   string   `json:"password,omitempty"`
+	Meta       *Meta    `json:"meta,omitempty"`
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int64    `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources,omitempty"`
+}
+
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// Error is a SCIM error response. It is also returned as a Go error by the
+// helpers of this package.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// ETag returns the weak entity tag of a resource. Mongo stores timestamps
+// with millisecond precision, so the tag is built from milliseconds to stay
+// stable across reads.
+func ETag(updatedAt time.Time) string {
+	return `W/"` + strconv.FormatInt(updatedAt.UnixMilli(), 10) + `"`
+}
+
+func NewUser(user *models.User, baseURL string) User {
+	active := !user.Disabled
+	resource := User{
+		Schemas:    []string{UserSchema},
+		ID:         user.ID.Hex(),
+		ExternalID: user.ExternalID,
+		UserName:   user.Username,
+		Emails:     []Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:     &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt.UTC().Format(time.RFC3339),
+			LastModified: user.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     baseURL + "/Users/" + user.ID.Hex(),
+			Version:      ETag(user.UpdatedAt),
+		},
+	}
+
+	if user.GivenName != "" || user.FamilyName != "" {
+		resource.Name = &Name{GivenName: user.GivenName, FamilyName: user.FamilyName}
+	}
+
+	return resource
+}
+
+// PrimaryEmail returns the primary email of the resource, or the first one.
+func (u *User) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// ApplyTo copies the attributes of the resource onto the user, as a PUT
+// replaces the whole resource.
+func (u *User) ApplyTo(user *models.User) {
+	user.Username = u.UserName
+	user.ExternalID = u.ExternalID
+	if email := u.PrimaryEmail(); email != "" {
+		user.Email = models.NormalizeEmail(email)
+	}
+	user.GivenName, user.FamilyName = "", ""
+	if u.Name != nil {
+		user.GivenName = u.Name.GivenName
+		user.FamilyName = u.Name.FamilyName
+	}
+	user.Disabled = u.Active != nil && !*u.Active
+}
+
+func NewGroup(group *models.Group, baseURL string) Group {
+	members := []Member{}
+	for _, member := range group.Members {
+		members = append(members, Member{
+			Value: member.Hex(),
+			Ref:   baseURL + "/Users/" + member.Hex(),
+		})
+	}
+
+	return Group{
+		Schemas:     []string{GroupSchema},
+		ID:          group.ID.Hex(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     members,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt.UTC().Format(time.RFC3339),
+			LastModified: group.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     baseURL + "/Groups/" + group.ID.Hex(),
+			Version:      ETag(group.UpdatedAt),
+		},
+	}
+}
+
+var filterExpression = regexp.MustCompile(`^\s*([A-Za-z.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// ParseFilter parses the single `attribute eq "value"` expressions that
+// provisioning clients use to look up resources. The attribute name is
+// returned in lower case since SCIM attribute names are case insensitive.
+func ParseFilter(filter string) (string, string, error) {
+	matches := filterExpression.FindStringSubmatch(filter)
+	if matches == nil {
+		return "", "", NewError(400, "invalidFilter", fmt.Sprintf("Unsupported filter: %s", filter))
+	}
+
+	value, err := strconv.Unquote(`"` + matches[2] + `"`)
+	if err != nil {
+		return "", "", NewError(400, "invalidFilter", "Invalid filter value")
+	}
+
+	return strings.ToLower(matches[1]), value, nil
+}
+
+// ParsePagination reads startIndex (1-based) and count, returning the offset
+// and limit to query with. A count of 0, or less, asks for totalResults only
+// (RFC 7644 section 3.4.2.4) and gives a limit of 0.
+func ParsePagination(startIndex, count string) (int, int) {
+	start, err := strconv.Atoi(startIndex)
+	if err != nil || start < 1 {
+		start = 1
+	}
+
+	limit, err := strconv.Atoi(count)
+	if err != nil {
+		limit = 100
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > 200 {
+		limit = 200
+	}
+
+	return start - 1, limit
+}
+
+// WriteJSON writes a SCIM response with the SCIM media type.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,39 @@
+package scim
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	for filter, want := range map[string]struct {
+		attribute, value string
+	}{
+		`userName eq "alice01"`:               {"username", "alice01"},
+		`  externalId   eq   "ext-1"  `:       {"externalid", "ext-1"},
+		`emails.value eq "alice@example.com"`: {"emails.value", "alice@example.com"},
+		`displayName eq "R\"&D\\"`:            {"displayname", `R"&D\`},
+		`userName eq ""`:                      {"username", ""},
+	} {
+		attribute, value, err := ParseFilter(filter)
+		if err != nil || attribute != want.attribute || value != want.value {
+			t.Errorf("ParseFilter(%s) = %q, %q, %v, want %q, %q", filter, attribute, value, err, want.attribute, want.value)
+		}
+	}
+
+	for _, filter := range []string{
+		`userName ne "alice01"`,
+		`userName co "alice"`,
+		`userName sw "a"`,
+		`userName gt "a"`,
+		`userName pr`,
+		`userName eq alice01`,
+		`userName eq "alice01" and active eq "true"`,
+		`not (userName eq "alice01")`,
+		`emails[type eq "work"].value eq "alice@example.com"`,
+		``,
+	} {
+		_, _, err := ParseFilter(filter)
+		scimErr, ok := err.(*Error)
+		if !ok || scimErr.ScimType != "invalidFilter" {
+			t.Errorf("ParseFilter(%s) error = %v, want invalidFilter", filter, err)
+		}
+	}
+}
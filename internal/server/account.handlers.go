@@ -0,0 +1,459 @@
+package server
+
+import (
+	"authentication-jwt/internal/accounts"
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type AccountHandler struct {
+	userRepository            repositories.UserRepositoryInterface
+	refreshTokenRepository    repositories.RefreshTokenRepositoryInterface
+	oneTimeTokenRepository    repositories.OneTimeTokenRepositoryInterface
+	groupRepository           repositories.GroupRepositoryInterface
+	mailOutboxRepository      repositories.MailOutboxRepositoryInterface
+	eventOutboxRepository     repositories.EventOutboxRepositoryInterface
+	webhookDeliveryRepository repositories.WebhookDeliveryRepositoryInterface
+	mailService               *mail.Service
+	emailCodes                *emailCodes
+	sessions                  *sessions
+	purger                    *accounts.Purger
+	audit                     *auditLog
+}
+
+func newAccountHandler(userRepository repositories.UserRepositoryInterface, refreshTokenRepository repositories.RefreshTokenRepositoryInterface, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface, groupRepository repositories.GroupRepositoryInterface, mailOutboxRepository repositories.MailOutboxRepositoryInterface, eventOutboxRepository repositories.EventOutboxRepositoryInterface, webhookDeliveryRepository repositories.WebhookDeliveryRepositoryInterface, mailService *mail.Service, emailCodes *emailCodes, sessions *sessions, purger *accounts.Purger, audit *auditLog) *AccountHandler {
+	return &AccountHandler{
+		userRepository:            userRepository,
+		refreshTokenRepository:    refreshTokenRepository,
+		oneTimeTokenRepository:    oneTimeTokenRepository,
+		groupRepository:           groupRepository,
+		mailOutboxRepository:      mailOutboxRepository,
+		eventOutboxRepository:     eventOutboxRepository,
+		webhookDeliveryRepository: webhookDeliveryRepository,
+		mailService:               mailService,
+		emailCodes:                emailCodes,
+		sessions:                  sessions,
+		purger:                    purger,
+		audit:                     audit,
+	}
+}
+
+// DeleteAccount deletes the account of the logged in user, who confirms it
+// with their password, or with a code sent by email when they have none. The
+// user is logged out everywhere and the account can be restored from the
+// emailed link until the grace period is over, when it is purged.
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code" binding:"omitempty,len=6,numeric"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Password == "" && req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password or code is required"})
+		return
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	confirmed := false
+	if req.Password != "" {
+		confirmed = user.Password != "" && auth.CheckPasswordHash(c.Request.Context(), req.Password, user.Password)
+	} else {
+		err := h.emailCodes.verify(c.Request.Context(), user.ID, models.PurposeAccountDeletionCode, req.Code)
+		if err != nil && !errors.Is(err, errEmailCodeInvalid) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		confirmed = err == nil
+	}
+
+	if !confirmed {
+		h.audit.record(c, &models.AuditEvent{
+			Action:   models.AuditActionAccountDelete,
+			Outcome:  models.AuditOutcomeFailure,
+			ActorID:  user.ID.Hex(),
+			TargetID: user.ID.Hex(),
+			Details:  map[string]string{"reason": "invalid password or code"},
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password or code"})
+		return
+	}
+
+	now := time.Now()
+	err = h.sessions.revokeAll(c.Request.Context(), user, func(user *models.User) {
+		user.DeletedAt = &now
+	})
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to delete account")
+		return
+	}
+
+	// Recorded before a purge, which anonymizes it along with the others.
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionAccountDelete,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+	})
+
+	clearSessionCookies(c)
+
+	gracePeriod := h.purger.GracePeriod()
+	if gracePeriod == 0 {
+		// The purger tries again later if this fails.
+		if err := h.purger.Purge(c.Request.Context(), user, "self_service"); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to purge account", "user_id", user.ID.Hex(), "error", err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Account deleted",
+		})
+		return
+	}
+
+	purgeAt := now.Add(gracePeriod)
+	if err := h.sendRestoreLink(c.Request.Context(), user, purgeAt); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to send account restore link", "user_id", user.ID.Hex(), "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Account scheduled for deletion. It can be restored from the link sent by email until then",
+		"purge_at": purgeAt,
+	})
+}
+
+// sendRestoreLink emails the link restoring the account, valid until it is
+// purged.
+func (h *AccountHandler) sendRestoreLink(ctx context.Context, user *models.User, purgeAt time.Time) error {
+	if err := h.oneTimeTokenRepository.DeleteByUser(ctx, user.ID, models.PurposeAccountRestore); err != nil {
+		return err
+	}
+
+	token, err := auth.RandomString(32)
+	if err != nil {
+		return err
+	}
+
+	oneTimeToken := models.NewOneTimeToken(user.ID, models.PurposeAccountRestore, auth.HashToken(token), "", purgeAt)
+	if err := h.oneTimeTokenRepository.Create(ctx, oneTimeToken); err != nil {
+		return err
+	}
+
+	return h.mailService.Send(ctx, user.Email, user.Locale, "account_deletion", map[string]any{
+		"Username":  user.Username,
+		"Link":      os.Getenv("APP_BASE_URL") + "/api/auth/account/restore?token=" + url.QueryEscape(token),
+		"PurgeDate": purgeAt.UTC().Format(time.DateOnly),
+	})
+}
+
+// RestoreAccount handles the link sent on deletion, cancelling it. The user
+// logs in again afterwards. Opening the link only shows a confirmation.
+func (h *AccountHandler) RestoreAccount(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		showConfirmation(c, "Restore your account", "Your account is scheduled for deletion. Confirm to cancel it.", "Restore account")
+		return
+	}
+
+	// The confirmation page posts a form, API clients post JSON.
+	var req struct {
+		Token string `json:"token" form:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oneTimeToken, err := h.oneTimeTokenRepository.Consume(c.Request.Context(), models.PurposeAccountRestore, auth.HashToken(req.Token), "")
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && oneTimeToken.IsExpired()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		return
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), oneTimeToken.UserID.Hex())
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if user.DeletedAt != nil {
+		err := repositories.UpdateUser(c.Request.Context(), h.userRepository, user, func(user *models.User) error {
+			user.DeletedAt = nil
+			return nil
+		})
+		if err != nil {
+			abortWithRepositoryError(c, err, "User", "Failed to restore account")
+			return
+		}
+
+		h.audit.record(c, &models.AuditEvent{
+			Action:   models.AuditActionAccountRestore,
+			Outcome:  models.AuditOutcomeSuccess,
+			ActorID:  user.ID.Hex(),
+			TargetID: user.ID.Hex(),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Account restored. You can log in again",
+	})
+}
+
+type exportedSession struct {
+	ID        bson.ObjectID `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	RevokedAt *time.Time    `json:"revoked_at,omitempty"`
+}
+
+type exportedGroup struct {
+	ID          bson.ObjectID `json:"id"`
+	DisplayName string        `json:"display_name"`
+}
+
+type exportedMail struct {
+	ID        bson.ObjectID `json:"id"`
+	To        string        `json:"to"`
+	Subject   string        `json:"subject"`
+	Status    string        `json:"status"`
+	CreatedAt time.Time     `json:"created_at"`
+	SentAt    *time.Time    `json:"sent_at,omitempty"`
+}
+
+type exportedEvent struct {
+	ID         bson.ObjectID     `json:"id"`
+	Type       string            `json:"type"`
+	Data       map[string]string `json:"data"`
+	OccurredAt time.Time         `json:"occurred_at"`
+}
+
+type exportedWebhookDelivery struct {
+	ID          bson.ObjectID `json:"id"`
+	Event       string        `json:"event"`
+	Status      string        `json:"status"`
+	CreatedAt   time.Time     `json:"created_at"`
+	DeliveredAt *time.Time    `json:"delivered_at,omitempty"`
+}
+
+// Export returns the personal data held on the logged in user as a JSON
+// archive: the profile, devices, settings, sessions, groups, the audit events
+// about them or naming them, the emails sent to their addresses, and their
+// domain events and webhook deliveries. Secrets such as password hashes,
+// tokens and the bodies of the emails, which hold codes and links, are left
+// out, as are the addresses and user agents of the requests made by others.
+func (h *AccountHandler) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	user, err := h.userRepository.FindById(ctx, c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	refreshTokens, err := h.refreshTokenRepository.ListByUser(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sessions"})
+		return
+	}
+
+	userSessions := []exportedSession{}
+	for _, refreshToken := range refreshTokens {
+		userSessions = append(userSessions, exportedSession{
+			ID:        refreshToken.ID,
+			CreatedAt: refreshToken.CreatedAt,
+			ExpiresAt: refreshToken.ExpiresAt,
+			RevokedAt: refreshToken.RevokedAt,
+		})
+	}
+
+	memberships, err := h.groupRepository.ListByMember(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve groups"})
+		return
+	}
+
+	userGroups := []exportedGroup{}
+	for _, group := range memberships {
+		userGroups = append(userGroups, exportedGroup{ID: group.ID, DisplayName: group.DisplayName})
+	}
+
+	auditEvents, err := h.auditEvents(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+
+	userMail := []exportedMail{}
+	for _, address := range []string{user.Email, user.PendingEmail} {
+		if address == "" {
+			continue
+		}
+		messages, err := h.mailOutboxRepository.ListByRecipient(ctx, address)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve emails"})
+			return
+		}
+		for _, message := range messages {
+			userMail = append(userMail, exportedMail{
+				ID:        message.ID,
+				To:        message.To,
+				Subject:   message.Subject,
+				Status:    message.Status,
+				CreatedAt: message.CreatedAt,
+				SentAt:    message.SentAt,
+			})
+		}
+	}
+
+	events, err := h.eventOutboxRepository.ListByUser(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	userEvents := []exportedEvent{}
+	for _, event := range events {
+		userEvents = append(userEvents, exportedEvent{ID: event.ID, Type: event.Type, Data: event.Data, OccurredAt: event.OccurredAt})
+	}
+
+	deliveries, err := h.webhookDeliveries(ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook deliveries"})
+		return
+	}
+
+	userDeliveries := []exportedWebhookDelivery{}
+	for _, delivery := range deliveries {
+		userDeliveries = append(userDeliveries, exportedWebhookDelivery{
+			ID:          delivery.ID,
+			Event:       delivery.Event,
+			Status:      delivery.Status,
+			CreatedAt:   delivery.CreatedAt,
+			DeliveredAt: delivery.DeliveredAt,
+		})
+	}
+
+	knownDevices := user.KnownDevices
+	if knownDevices == nil {
+		knownDevices = []models.Device{}
+	}
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionDataExport,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+	})
+
+	c.Header("Content-Disposition", `attachment; filename="account-export.json"`)
+	c.JSON(http.StatusOK, gin.H{
+		"exported_at":        time.Now(),
+		"user":               user.ToResponse(),
+		"known_devices":      knownDevices,
+		"notifications":      user.NotificationSettings(),
+		"sessions":           userSessions,
+		"groups":             userGroups,
+		"audit_events":       auditEvents,
+		"emails":             userMail,
+		"events":             userEvents,
+		"webhook_deliveries": userDeliveries,
+	})
+}
+
+// auditEvents returns every event done by or to the user, or naming them as
+// failed logins and registrations do, newest first.
+func (h *AccountHandler) auditEvents(ctx context.Context, user *models.User) ([]*models.AuditEvent, error) {
+	userID := user.ID.Hex()
+	var identifiers []string
+	for _, identifier := range []string{user.Email, user.PendingEmail, user.Username} {
+		if identifier != "" {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+
+	events := []*models.AuditEvent{}
+	seen := map[bson.ObjectID]bool{}
+
+	for _, filter := range []repositories.AuditFilter{{ActorID: userID}, {TargetID: userID}, {Identifiers: identifiers}} {
+		var before bson.ObjectID
+		for {
+			page, err := h.audit.repository.List(ctx, filter, before, maxAuditPageSize)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, event := range page {
+				if seen[event.ID] {
+					continue
+				}
+				seen[event.ID] = true
+
+				if event.ActorID != userID {
+					event.IPAddress = ""
+					event.UserAgent = ""
+				}
+				events = append(events, event)
+			}
+
+			if len(page) < maxAuditPageSize {
+				break
+			}
+			before = page[len(page)-1].ID
+		}
+	}
+
+	slices.SortFunc(events, func(a, b *models.AuditEvent) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return events, nil
+}
+
+// webhookDeliveries returns every webhook delivery of the events about the
+// user, newest first.
+func (h *AccountHandler) webhookDeliveries(ctx context.Context, userID bson.ObjectID) ([]*models.WebhookDelivery, error) {
+	deliveries := []*models.WebhookDelivery{}
+	for {
+		page, _, err := h.webhookDeliveryRepository.List(ctx, repositories.WebhookDeliveryFilter{UserID: userID}, len(deliveries), maxAuditPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, page...)
+		if len(page) < maxAuditPageSize {
+			return deliveries, nil
+		}
+	}
+}
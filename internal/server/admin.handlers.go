@@ -0,0 +1,91 @@
+package server
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+type AdminHandler struct {
+	audit *auditLog
+}
+
+func newAdminHandler(audit *auditLog) *AdminHandler {
+	return &AdminHandler{
+		audit: audit,
+	}
+}
+
+// ListAuditEvents returns the audit log, newest first. The next page is
+// requested with the next_cursor of the response as cursor.
+func (h *AdminHandler) ListAuditEvents(c *gin.Context) {
+	filter := repositories.AuditFilter{
+		Action:   c.Query("action"),
+		Outcome:  c.Query("outcome"),
+		ActorID:  c.Query("actor_id"),
+		TargetID: c.Query("target_id"),
+	}
+
+	for param, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if c.Query(param) == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, c.Query(param))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 timestamp"})
+			return
+		}
+		*value = t
+	}
+
+	var before bson.ObjectID
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := bson.ObjectIDFromHex(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		before = id
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditPageSize)))
+	if err != nil || limit < 1 || limit > maxAuditPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxAuditPageSize)})
+		return
+	}
+
+	// One extra event tells whether there is a next page.
+	events, err := h.audit.repository.List(c.Request.Context(), filter, before, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+
+	var nextCursor string
+	if len(events) > limit {
+		events = events[:limit]
+		nextCursor = events[limit-1].ID.Hex()
+	}
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:  models.AuditActionAuditQuery,
+		Outcome: models.AuditOutcomeSuccess,
+		ActorID: c.GetString("userID"),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":      events,
+		"next_cursor": nextCursor,
+	})
+}
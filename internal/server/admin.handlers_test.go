@@ -0,0 +1,254 @@
+package server
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// registerAdmin registers a user with the admin role and logs them in.
+func (s *testServer) registerAdmin(email, username string) *http.Cookie {
+	s.t.Helper()
+	s.register(email, username, "correct-horse")
+
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), email)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	err = repositories.UpdateUser(context.Background(), s.deps.UserRepository, user, func(user *models.User) error {
+		user.Roles = append(user.Roles, "admin")
+		return nil
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	accessToken, _ := s.logon(email, "correct-horse")
+	return accessToken
+}
+
+// auditPage lists the audit log with the query and decodes the page.
+func (s *testServer) auditPage(query url.Values, accessToken *http.Cookie) ([]*models.AuditEvent, string) {
+	s.t.Helper()
+	res := s.do(http.MethodGet, "/api/admin/audit?"+query.Encode(), nil, accessToken)
+	expectStatus(s.t, res, http.StatusOK)
+
+	var page struct {
+		Events     []*models.AuditEvent `json:"events"`
+		NextCursor string               `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &page); err != nil {
+		s.t.Fatal(err)
+	}
+	return page.Events, page.NextCursor
+}
+
+func TestListAuditEvents(t *testing.T) {
+	s := newTestServer(t)
+	accessToken := s.registerAdmin("admin@example.com", "admin01")
+
+	// The events are an hour apart from midnight, the newest last.
+	midnight := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, event := range []models.AuditEvent{
+		{Action: "test.login", Outcome: models.AuditOutcomeSuccess, ActorID: "alice", TargetID: "alice"},
+		{Action: "test.login", Outcome: models.AuditOutcomeFailure, ActorID: "bob", TargetID: "bob"},
+		{Action: "test.update", Outcome: models.AuditOutcomeSuccess, ActorID: "alice", TargetID: "bob"},
+		{Action: "test.login", Outcome: models.AuditOutcomeSuccess, ActorID: "bob", TargetID: "bob"},
+		{Action: "test.delete", Outcome: models.AuditOutcomeFailure, ActorID: "alice", TargetID: "carol"},
+	} {
+		event.ID = bson.NewObjectID()
+		event.CreatedAt = midnight.Add(time.Duration(i) * time.Hour)
+		if err := s.deps.AuditLogRepository.Append(context.Background(), &event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("RequiresAdmin", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodGet, "/api/admin/audit", nil), http.StatusUnauthorized)
+
+		s.register("alice@example.com", "alice01", "correct-horse")
+		userToken, _ := s.logon("alice@example.com", "correct-horse")
+		expectStatus(t, s.do(http.MethodGet, "/api/admin/audit", nil, userToken), http.StatusForbidden)
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		until := midnight.Add(5 * time.Hour).Format(time.RFC3339)
+		for name, test := range map[string]struct {
+			query url.Values
+			want  []string
+		}{
+			"Action":   {url.Values{"action": {"test.login"}}, []string{"test.login@3", "test.login@1", "test.login@0"}},
+			"Outcome":  {url.Values{"outcome": {"failure"}, "until": {until}}, []string{"test.delete@4", "test.login@1"}},
+			"ActorID":  {url.Values{"actor_id": {"alice"}}, []string{"test.delete@4", "test.update@2", "test.login@0"}},
+			"TargetID": {url.Values{"target_id": {"bob"}}, []string{"test.login@3", "test.update@2", "test.login@1"}},
+			"Since":    {url.Values{"since": {midnight.Add(3 * time.Hour).Format(time.RFC3339)}, "actor_id": {"bob"}}, []string{"test.login@3"}},
+			"Until":    {url.Values{"until": {midnight.Add(time.Hour).Format(time.RFC3339)}}, []string{"test.login@0"}},
+			"Combined": {url.Values{"action": {"test.login"}, "outcome": {"success"}, "actor_id": {"bob"}}, []string{"test.login@3"}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				events, nextCursor := s.auditPage(test.query, accessToken)
+				var got []string
+				for _, event := range events {
+					got = append(got, event.Action+"@"+strconv.Itoa(int(event.CreatedAt.Sub(midnight).Hours())))
+				}
+				if !slices.Equal(got, test.want) || nextCursor != "" {
+					t.Errorf("events = %v, next cursor %q, want %v", got, nextCursor, test.want)
+				}
+			})
+		}
+	})
+
+	t.Run("InvalidParameters", func(t *testing.T) {
+		for _, query := range []string{
+			"since=yesterday",
+			"since=2020-01-01",
+			"until=1577836800",
+			"until=2020-01-01T00:00:00",
+			"limit=0",
+			"limit=-1",
+			"limit=" + strconv.Itoa(maxAuditPageSize+1),
+			"limit=ten",
+			"cursor=not-an-id",
+		} {
+			expectStatus(t, s.do(http.MethodGet, "/api/admin/audit?"+query, nil, accessToken), http.StatusBadRequest)
+		}
+
+		s.auditPage(url.Values{"limit": {"1"}}, accessToken)
+		s.auditPage(url.Values{"limit": {strconv.Itoa(maxAuditPageSize)}}, accessToken)
+	})
+
+	t.Run("Paging", func(t *testing.T) {
+		for limit, pages := range map[int][]int{1: {1, 1, 1, 1, 1}, 2: {2, 2, 1}, 5: {5}, 6: {5}} {
+			query := url.Values{"until": {midnight.Add(5 * time.Hour).Format(time.RFC3339)}, "limit": {strconv.Itoa(limit)}}
+			var got []int
+			var seen []bson.ObjectID
+			for {
+				events, nextCursor := s.auditPage(query, accessToken)
+				got = append(got, len(events))
+				for _, event := range events {
+					seen = append(seen, event.ID)
+				}
+				if nextCursor == "" {
+					break
+				}
+				if nextCursor != events[len(events)-1].ID.Hex() {
+					t.Fatalf("limit %d: next cursor %s isn't the last event of the page", limit, nextCursor)
+				}
+				query.Set("cursor", nextCursor)
+			}
+
+			if !slices.Equal(got, pages) {
+				t.Errorf("limit %d: pages of %v events, want %v", limit, got, pages)
+			}
+			for i := 1; i < len(seen); i++ {
+				if seen[i].Hex() >= seen[i-1].Hex() {
+					t.Errorf("limit %d: events not listed newest first without repeats", limit)
+				}
+			}
+		}
+	})
+
+	t.Run("Recorded", func(t *testing.T) {
+		events, _ := s.auditPage(url.Values{"action": {models.AuditActionAuditQuery}, "limit": {"1"}}, accessToken)
+		if len(events) != 1 || events[0].ActorID != findUserID(t, s, "admin@example.com").Hex() {
+			t.Errorf("audit queries not recorded with the admin as actor: %+v", events)
+		}
+	})
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	aliceToken := s.registerAdmin("alice@example.com", "alice01")
+	bobToken := s.registerAdmin("bob@example.com", "bob0001")
+	alice := findUserID(t, s, "alice@example.com").Hex()
+	bob := findUserID(t, s, "bob@example.com").Hex()
+
+	lastEvent := func(t *testing.T, action string) *models.AuditEvent {
+		t.Helper()
+		events, err := s.deps.AuditLogRepository.List(context.Background(), repositories.AuditFilter{Action: action}, bson.ObjectID{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			t.Fatalf("no %s event", action)
+		}
+		return events[0]
+	}
+
+	res := s.do(http.MethodPost, "/api/admin/webhooks", gin.H{"url": "https://example.com/hook", "events": []string{models.WebhookEventAll}}, aliceToken)
+	expectStatus(t, res, http.StatusCreated)
+	var created struct {
+		Webhook models.Webhook `json:"webhook"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	webhookID := created.Webhook.ID.Hex()
+
+	t.Run("Created", func(t *testing.T) {
+		event := lastEvent(t, "admin.webhooks.create")
+		if event.Outcome != models.AuditOutcomeSuccess || event.ActorID != alice || event.TargetID != webhookID || event.Details["status"] != "201" {
+			t.Errorf("event = %+v", event)
+		}
+	})
+
+	t.Run("ActorOfEachRequest", func(t *testing.T) {
+		res := s.do(http.MethodPut, "/api/admin/webhooks/"+webhookID, gin.H{"url": "https://example.com/other", "events": []string{models.WebhookEventAll}}, bobToken)
+		expectStatus(t, res, http.StatusOK)
+
+		event := lastEvent(t, "admin.webhooks.replace")
+		if event.ActorID != bob || event.TargetID != webhookID {
+			t.Errorf("event = %+v, want done by %s to %s", event, bob, webhookID)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		res := s.do(http.MethodPut, "/api/admin/webhooks/"+webhookID, gin.H{"url": "ftp://example.com", "events": []string{models.WebhookEventAll}}, aliceToken)
+		expectStatus(t, res, http.StatusBadRequest)
+
+		event := lastEvent(t, "admin.webhooks.replace")
+		if event.Outcome != models.AuditOutcomeFailure || event.ActorID != alice || event.Details["status"] != "400" {
+			t.Errorf("event = %+v", event)
+		}
+	})
+
+	t.Run("ReadsNotRecorded", func(t *testing.T) {
+		before := lastEvent(t, "")
+		expectStatus(t, s.do(http.MethodGet, "/api/admin/webhooks/"+webhookID, nil, aliceToken), http.StatusOK)
+		expectStatus(t, s.do(http.MethodGet, "/api/admin/webhooks", nil, aliceToken), http.StatusOK)
+
+		if event := lastEvent(t, ""); event.ID != before.ID {
+			t.Errorf("read recorded: %+v", event)
+		}
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodDelete, "/api/admin/webhooks/"+webhookID, nil, bobToken), http.StatusNoContent)
+
+		event := lastEvent(t, "admin.webhooks.delete")
+		if event.Outcome != models.AuditOutcomeSuccess || event.ActorID != bob || event.TargetID != webhookID {
+			t.Errorf("event = %+v", event)
+		}
+	})
+
+	t.Run("SCIM", func(t *testing.T) {
+		s.withSCIM()
+		res := s.scimDo(http.MethodDelete, "/scim/v2/Users/"+alice, nil)
+		expectStatus(t, res, http.StatusNoContent)
+
+		event := lastEvent(t, "scim.users.delete")
+		if event.ActorID != "scim" || event.TargetID != alice {
+			t.Errorf("event = %+v", event)
+		}
+	})
+}
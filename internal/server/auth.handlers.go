@@ -2,23 +2,40 @@ package server
 
 import (
 	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/metrics"
 	"authentication-jwt/internal/models"
 	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
 	"net/http"
-	"time"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
+	authenticator          auth.Authenticator
+	emailCodes             *emailCodes
+	notifications          *securityNotifications
 	userRepository         repositories.UserRepositoryInterface
-	refreshTokenRepository repositories.RefreshTokenRepositoryInterface
+	oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface
+	sessions               *sessions
+	audit                  *auditLog
+	eventBus               *events.Bus
 }
 
-func newAuthHandler(userRepository repositories.UserRepositoryInterface, refreshTokenRepository repositories.RefreshTokenRepositoryInterface) *AuthHandler {
+func newAuthHandler(authenticator auth.Authenticator, emailCodes *emailCodes, notifications *securityNotifications, userRepository repositories.UserRepositoryInterface, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface, sessions *sessions, audit *auditLog, eventBus *events.Bus) *AuthHandler {
 	return &AuthHandler{
+		authenticator:          authenticator,
+		emailCodes:             emailCodes,
+		notifications:          notifications,
 		userRepository:         userRepository,
-		refreshTokenRepository: refreshTokenRepository,
+		oneTimeTokenRepository: oneTimeTokenRepository,
+		sessions:               sessions,
+		audit:                  audit,
+		eventBus:               eventBus,
 	}
 }
 
@@ -34,42 +51,55 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userRepository.FindByEmail(c, req.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing user"})
-		return
-	}
-
-	if user != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
-		return
-	}
-
-	hashPassword, err := auth.HashPassword(req.Password)
+	hashPassword, err := auth.HashPassword(c.Request.Context(), req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	user, err = models.NewUser(req.Username, req.Email, hashPassword)
+	user, err := models.NewUser(req.Username, req.Email, hashPassword)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	user.Locale = mail.MatchLocale(c.GetHeader("Accept-Language"))
 
-	err = h.userRepository.Create(c.Request.Context(), user)
+	// The unique indexes on email and username reject a concurrent
+	// registration of the same account.
+	err = h.eventBus.Commit(c.Request.Context(), func(ctx context.Context) error {
+		return h.userRepository.Create(ctx, user)
+	}, models.NewDomainEvent(models.EventUserRegistered, user, nil))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		metrics.Registrations.WithLabelValues(metrics.OutcomeFailure).Inc()
+		if errors.Is(err, repositories.ErrDuplicateEmail) || errors.Is(err, repositories.ErrDuplicateUsername) {
+			h.audit.record(c, &models.AuditEvent{
+				Action:  models.AuditActionRegister,
+				Outcome: models.AuditOutcomeFailure,
+				Details: map[string]string{"reason": err.Error(), "email": req.Email},
+			})
+		}
+		abortWithRepositoryError(c, err, "User", "Failed to create user")
 		return
 	}
+	metrics.Registrations.WithLabelValues(metrics.OutcomeSuccess).Inc()
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionRegister,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+	})
 
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
 }
 
+// Logon checks a password against the account with the given email or
+// username. Clients sending "email" instead of "identifier" keep working.
 func (h *AuthHandler) Logon(c *gin.Context) {
 	var req struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
+		Identifier string `json:"identifier" binding:"required_without=Email"`
+		Email      string `json:"email"`
+		Password   string `json:"password" binding:"required,min=8"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -77,59 +107,64 @@ func (h *AuthHandler) Logon(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userRepository.FindByEmail(c, req.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
-		return
+	identifier := req.Identifier
+	if identifier == "" {
+		identifier = req.Email
 	}
 
-	if user == nil || !auth.CheckPasswordHash(req.Password, user.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+	user, err := h.authenticator.Authenticate(c.Request.Context(), identifier, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		metrics.Logins.WithLabelValues("password", metrics.OutcomeFailure).Inc()
+		h.audit.record(c, &models.AuditEvent{
+			Action:  models.AuditActionLogin,
+			Outcome: models.AuditOutcomeFailure,
+			Details: map[string]string{"reason": "invalid credentials", "identifier": identifier},
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username, email or password"})
 		return
 	}
 
-	accessToken, err := auth.GenerateAccessToken(user.ID.Hex())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate user"})
 		return
 	}
 
-	refreshToken, err := auth.GenerateRefreshToken(user.ID.Hex())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate refresh token"})
+	if user.EmailMFAEnabled && !user.Disabled {
+		metrics.Logins.WithLabelValues("password", "mfa_required").Inc()
+		requireMFA(c, h.emailCodes, user)
 		return
 	}
 
-	c.SetCookie(
-		"access_token",
-		accessToken,
-		15*60, // 15 minutes
-		"/",
-		"localhost", // domain
-		false,       // secure
-		true,        // httpOnly
-	)
+	if err := h.sessions.issue(c, user); err != nil {
+		abortWithSessionError(c, err)
+		return
+	}
 
-	c.SetCookie(
-		"refresh_token",
-		refreshToken,
-		7*24*60*60, // 7 days
-		"/api/auth/refresh",
-		"localhost", // domain
-		false,       // secure
-		true,        // httpOnly
-	)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+	})
+}
 
-	refreshTokenModel := models.NewRefreshToken(refreshToken, user.ID, time.Now().Add(7*24*time.Hour))
+// requireMFA sends the second factor code and answers with the MFA token the
+// client exchanges, together with the code, for the session cookies.
+func requireMFA(c *gin.Context, emailCodes *emailCodes, user *models.User) {
+	err := emailCodes.send(c.Request.Context(), user, models.PurposeMFACode)
+	if err != nil && !errors.Is(err, errEmailCodeCooldown) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send MFA code"})
+		return
+	}
 
-	err = h.refreshTokenRepository.Create(c.Request.Context(), refreshTokenModel)
+	mfaToken, err := auth.GenerateMFAToken(user.ID.Hex())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate MFA token"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Login successful",
+		"message":      "MFA required",
+		"mfa_required": true,
+		"mfa_methods":  []string{"email"},
+		"mfa_token":    mfaToken,
 	})
 }
 
@@ -140,84 +175,201 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	refreshTokenModel, err := h.refreshTokenRepository.FindByToken(c.Request.Context(), refreshToken)
+	user, err := h.sessions.refresh(c, refreshToken)
+	metrics.Refreshes.WithLabelValues(sessionOutcome(err)).Inc()
+
+	event := &models.AuditEvent{
+		Action:  models.AuditActionRefresh,
+		Outcome: models.AuditOutcomeSuccess,
+	}
+	if user != nil {
+		event.ActorID = user.ID.Hex()
+		event.TargetID = user.ID.Hex()
+	}
+	if err != nil {
+		event.Outcome = models.AuditOutcomeFailure
+		event.Details = map[string]string{"reason": err.Error()}
+	}
+	h.audit.record(c, event)
+
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve refresh token"})
+		if errors.Is(err, errRefreshTokenReused) {
+			clearSessionCookies(c)
+		}
+		abortWithSessionError(c, err)
 		return
 	}
 
-	if refreshTokenModel == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tokens refreshed successfully",
+	})
+}
+
+// ChangePassword sets a new password for the logged in user. Users without a
+// password yet confirm it with a code sent by email. Every other session is
+// logged out.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code" binding:"omitempty,len=6,numeric"`
+		NewPassword     string `json:"new_password" binding:"required,min=8"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	newAccessToken, err := auth.GenerateAccessToken(refreshTokenModel.UserID.Hex())
+	user, err := h.userRepository.FindById(c.Request.Context(), c.GetString("userID"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate new access token"})
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	err = h.emailCodes.reauthenticate(c.Request.Context(), user, req.CurrentPassword, req.Code, models.PurposeReauthenticationCode)
+	if errors.Is(err, errReauthenticationFailed) {
+		h.audit.record(c, &models.AuditEvent{
+			Action:   models.AuditActionPasswordChange,
+			Outcome:  models.AuditOutcomeFailure,
+			ActorID:  user.ID.Hex(),
+			TargetID: user.ID.Hex(),
+			Details:  map[string]string{"reason": "incorrect current password or code"},
+		})
+		abortWithReauthenticationError(c, user)
 		return
 	}
 
-	newRefreshToken, err := auth.GenerateRefreshToken(refreshTokenModel.UserID.Hex())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate new refresh token"})
-		return
-	}
-
-	c.SetCookie(
-		"access_token",
-		newAccessToken,
-		15*60, // 15 minutes
-		"/",
-		"localhost", // domain
-		false,       // secure
-		true,        // httpOnly
-	)
-
-	c.SetCookie(
-		"refresh_token",
-		newRefreshToken,
-		7*24*60*60, // 7 days
-		"/api/auth/refresh",
-		"localhost", // domain
-		false,       // secure
-		true,        // httpOnly
-	)
-
-	newRefreshTokenModel := models.NewRefreshToken(newRefreshToken, refreshTokenModel.UserID, time.Now().Add(7*24*time.Hour))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+
+	hashPassword, err := auth.HashPassword(c.Request.Context(), req.NewPassword)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	err = h.refreshTokenRepository.Create(c.Request.Context(), newRefreshTokenModel)
+	passwordChanged := models.NewDomainEvent(models.EventPasswordChanged, user, nil)
+	err = h.sessions.revokeAll(c.Request.Context(), user, func(user *models.User) {
+		user.Password = hashPassword
+	}, passwordChanged)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
 		return
 	}
 
+	if err := h.sessions.create(c, user); err != nil {
+		abortWithSessionError(c, err)
+		return
+	}
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionPasswordChange,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+	})
+
+	h.notifications.notify(c, user, models.SecurityEventPasswordChanged)
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Tokens refreshed successfully",
+		"message": "Password changed successfully",
+	})
+}
+
+// RevokeSessions handles the "this wasn't me" link of the security
+// notifications and logs the user out everywhere. Opening the link only shows
+// a confirmation.
+func (h *AuthHandler) RevokeSessions(c *gin.Context) {
+	if c.Request.Method == http.MethodGet {
+		showConfirmation(c, "Sign out everywhere", "Someone may have accessed your account. Confirm to end all of its sessions.", "Sign out everywhere")
+		return
+	}
+
+	// The confirmation page posts a form, API clients post JSON.
+	var req struct {
+		Token string `json:"token" form:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oneTimeToken, err := h.oneTimeTokenRepository.Consume(c.Request.Context(), models.PurposeRevokeSessions, auth.HashToken(req.Token), "")
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && oneTimeToken.IsExpired()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		return
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), oneTimeToken.UserID.Hex())
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if err := h.sessions.revokeAll(c.Request.Context(), user, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionRevokeAllSessions,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+		Details:  map[string]string{"reason": "security notification link"},
+	})
+
+	clearSessionCookies(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "All sessions have been revoked. Change your password to secure your account",
 	})
 }
 
+// Logout ends the session of the refresh token cookie. The access token stays
+// valid until it expires.
 func (h *AuthHandler) Logout(c *gin.Context) {
-	c.SetCookie(
-		"access_token",
-		"",
-		-1,
-		"/",
-		"localhost", // domain
-		false,       // secure
-		true,        // httpOnly
-	)
-
-	c.SetCookie(
-		"refresh_token",
-		"",
-		-1,
-		"/api/auth/refresh",
-		"localhost", // domain
-		false,       // secure
-		true,        // httpOnly
-	)
+	// The route is public, so the actor is only known if the access token is
+	// still valid, or from the refresh token.
+	var userID string
+	if accessToken, err := c.Cookie("access_token"); err == nil {
+		if _, claims, err := auth.ValidateAccessToken(accessToken, os.Getenv("JWT_SECRET")); err == nil {
+			userID, _ = claims["sub"].(string)
+		}
+	}
+
+	if refreshToken, err := c.Cookie("refresh_token"); err == nil && refreshToken != "" {
+		user, err := h.sessions.revoke(c.Request.Context(), refreshToken)
+		if err != nil {
+			clearSessionCookies(c)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+			return
+		}
+
+		if user != nil && userID == "" {
+			userID = user.ID.Hex()
+		}
+	}
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionLogout,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  userID,
+		TargetID: userID,
+	})
+
+	clearSessionCookies(c)
 }
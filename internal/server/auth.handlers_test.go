@@ -0,0 +1,1225 @@
+package server
+
+import (
+	"authentication-jwt/internal/accounts"
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/repositories/memory"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// mailbox keeps the emails sent during a test.
+type mailbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, message mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *mailbox) sentTo(to string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, message := range m.messages {
+		if message.To == to {
+			count++
+		}
+	}
+	return count
+}
+
+// last returns the last email sent to the address.
+func (m *mailbox) last(t *testing.T, to string) mail.Message {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i]
+		}
+	}
+	t.Fatalf("no email sent to %s", to)
+	return mail.Message{}
+}
+
+// linkToken returns the token of the link in the last email sent to the
+// address.
+func (m *mailbox) linkToken(t *testing.T, to string) string {
+	t.Helper()
+	message := m.last(t, to)
+	match := linkTokenPattern.FindStringSubmatch(message.Text)
+	if match == nil {
+		t.Fatalf("no link in the email to %s: %s", to, message.Text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// code returns the one-time code in the last email sent to the address.
+func (m *mailbox) code(t *testing.T, to string) string {
+	t.Helper()
+	message := m.last(t, to)
+	code := codePattern.FindString(message.Text)
+	if code == "" {
+		t.Fatalf("no code in the email to %s: %s", to, message.Text)
+	}
+	return code
+}
+
+var (
+	linkTokenPattern = regexp.MustCompile(`token=(\S+)`)
+	codePattern      = regexp.MustCompile(`\b\d{6}\b`)
+)
+
+type testServer struct {
+	t       *testing.T
+	handler http.Handler
+	deps    Dependencies
+	mailbox *mailbox
+	// eventOutbox is the outbox of deps.EventBus, which the purger empties.
+	eventOutbox *memory.EventOutboxRepository
+	userAgent   string
+}
+
+// newTestServer builds the router on in-memory repositories.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("JWT_SECRET_REFRESH", "test-secret-refresh")
+	t.Setenv("AUTH_BACKENDS", "")
+
+	userRepository := memory.NewUserRepository()
+	authenticator, err := auth.LoadAuthenticator(userRepository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailbox := &mailbox{}
+	eventOutbox := memory.NewEventOutboxRepository()
+	deps := Dependencies{
+		Authenticator:             authenticator,
+		UserRepository:            userRepository,
+		RefreshTokenRepository:    memory.NewRefreshTokenRepository(),
+		SAMLRequestRepository:     memory.NewSAMLRequestRepository(),
+		GroupRepository:           memory.NewGroupRepository(),
+		OneTimeTokenRepository:    memory.NewOneTimeTokenRepository(),
+		AuditLogRepository:        memory.NewAuditLogRepository(),
+		WebhookRepository:         memory.NewWebhookRepository(),
+		WebhookDeliveryRepository: memory.NewWebhookDeliveryRepository(),
+		MailOutboxRepository:      memory.NewMailOutboxRepository(),
+		EventOutboxRepository:     eventOutbox,
+		EventBus:                  events.NewBus(memory.Transactor{}, eventOutbox),
+		MailService:               mail.NewService(mailbox, mail.NewTemplates()),
+		Draining:                  func() bool { return false },
+	}
+	deps.AccountPurger = accounts.NewPurger(deps.UserRepository, deps.RefreshTokenRepository, deps.OneTimeTokenRepository, deps.GroupRepository, deps.AuditLogRepository, deps.MailOutboxRepository, eventOutbox, deps.WebhookDeliveryRepository, deps.EventBus, accounts.DefaultGracePeriod)
+
+	return &testServer{t: t, handler: NewRouter(deps), deps: deps, mailbox: mailbox, eventOutbox: eventOutbox, userAgent: "handler-tests"}
+}
+
+// do sends the request with the given cookies and body, encoded as JSON
+// unless nil.
+func (s *testServer) do(method, path string, body any, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader.Reset(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.userAgent)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// postForm posts the values as the confirmation pages of the emailed links do.
+func (s *testServer) postForm(path string, values url.Values) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", s.userAgent)
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// withGracePeriod rebuilds the router with deleted accounts purged after the
+// given grace period.
+func (s *testServer) withGracePeriod(gracePeriod time.Duration) {
+	s.deps.AccountPurger = accounts.NewPurger(s.deps.UserRepository, s.deps.RefreshTokenRepository, s.deps.OneTimeTokenRepository, s.deps.GroupRepository, s.deps.AuditLogRepository, s.deps.MailOutboxRepository, s.eventOutbox, s.deps.WebhookDeliveryRepository, s.deps.EventBus, gracePeriod)
+	s.handler = NewRouter(s.deps)
+}
+
+func (s *testServer) register(email, username, password string) {
+	s.t.Helper()
+	res := s.do(http.MethodPost, "/api/auth/register", gin.H{"email": email, "username": username, "password": password})
+	expectStatus(s.t, res, http.StatusCreated)
+}
+
+// logon returns the access and refresh token cookies.
+func (s *testServer) logon(email, password string) (*http.Cookie, *http.Cookie) {
+	s.t.Helper()
+	res := s.do(http.MethodPost, "/api/auth/logon", gin.H{"email": email, "password": password})
+	expectStatus(s.t, res, http.StatusOK)
+	return sessionCookies(s.t, res)
+}
+
+func sessionCookies(t *testing.T, res *httptest.ResponseRecorder) (*http.Cookie, *http.Cookie) {
+	t.Helper()
+	accessToken, refreshToken := cookie(res, "access_token"), cookie(res, "refresh_token")
+	if accessToken == nil || accessToken.Value == "" || refreshToken == nil || refreshToken.Value == "" {
+		t.Fatalf("session cookies not set: %v", res.Result().Cookies())
+	}
+	return accessToken, refreshToken
+}
+
+func cookie(res *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func expectStatus(t *testing.T, res *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if res.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", res.Code, status, res.Body)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := newTestServer(t)
+
+	s.register("alice@example.com", "alice01", "correct-horse")
+
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user == nil || user.Username != "alice01" || user.Password == "correct-horse" {
+		t.Fatalf("stored user = %+v", user)
+	}
+
+	t.Run("DuplicateEmail", func(t *testing.T) {
+		res := s.do(http.MethodPost, "/api/auth/register", gin.H{"email": "alice@example.com", "username": "alice02", "password": "correct-horse"})
+		expectStatus(t, res, http.StatusConflict)
+	})
+
+	t.Run("DuplicateUsername", func(t *testing.T) {
+		res := s.do(http.MethodPost, "/api/auth/register", gin.H{"email": "alice2@example.com", "username": "alice01", "password": "correct-horse"})
+		expectStatus(t, res, http.StatusConflict)
+	})
+
+	t.Run("DuplicateInAnotherCase", func(t *testing.T) {
+		res := s.do(http.MethodPost, "/api/auth/register", gin.H{"email": "alice@EXAMPLE.COM", "username": "alice02", "password": "correct-horse"})
+		expectStatus(t, res, http.StatusConflict)
+
+		res = s.do(http.MethodPost, "/api/auth/register", gin.H{"email": "alice2@example.com", "username": "Alice01", "password": "correct-horse"})
+		expectStatus(t, res, http.StatusConflict)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		statuses := make(chan int, 8)
+		var wg sync.WaitGroup
+		for i := range cap(statuses) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := s.do(http.MethodPost, "/api/auth/register", gin.H{"email": "carol@example.com", "username": fmt.Sprintf("carol%03d", i), "password": "correct-horse"})
+				statuses <- res.Code
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		created := 0
+		for status := range statuses {
+			switch status {
+			case http.StatusCreated:
+				created++
+			case http.StatusConflict:
+			default:
+				t.Errorf("status = %d, want %d or %d", status, http.StatusCreated, http.StatusConflict)
+			}
+		}
+		if created != 1 {
+			t.Errorf("%d registrations of the same email succeeded, want 1", created)
+		}
+	})
+
+	for name, body := range map[string]gin.H{
+		"MissingEmail":  {"username": "bob0001", "password": "correct-horse"},
+		"InvalidEmail":  {"email": "bob", "username": "bob0001", "password": "correct-horse"},
+		"ShortUsername": {"email": "bob@example.com", "username": "bob", "password": "correct-horse"},
+		"ShortPassword": {"email": "bob@example.com", "username": "bob0001", "password": "short"},
+		"Reserved":      {"email": "bob@example.com", "username": "Administrator", "password": "correct-horse"},
+		"AtInUsername":  {"email": "bob@example.com", "username": "bob@example", "password": "correct-horse"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := s.do(http.MethodPost, "/api/auth/register", body)
+			expectStatus(t, res, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("MalformedBody", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader("{"))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		s.handler.ServeHTTP(res, req)
+		expectStatus(t, res, http.StatusBadRequest)
+	})
+}
+
+func TestLogon(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+
+	s.logon("alice@example.com", "correct-horse")
+	if s.mailbox.sentTo("alice@example.com") != 0 {
+		t.Error("new device notification sent for the first device")
+	}
+
+	t.Run("NewDevice", func(t *testing.T) {
+		s.userAgent = "another-browser"
+		defer func() { s.userAgent = "handler-tests" }()
+
+		s.logon("alice@example.com", "correct-horse")
+		if s.mailbox.sentTo("alice@example.com") != 1 {
+			t.Error("no new device notification sent")
+		}
+
+		s.logon("alice@example.com", "correct-horse")
+		if s.mailbox.sentTo("alice@example.com") != 1 {
+			t.Error("new device notification sent for a known device")
+		}
+	})
+
+	for name, identifier := range map[string]string{
+		"Username":        "alice01",
+		"UsernameCase":    "ALICE01",
+		"Email":           "alice@example.com",
+		"EmailDomainCase": "alice@EXAMPLE.com",
+		"EmailLocalCase":  "Alice@example.com",
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := s.do(http.MethodPost, "/api/auth/logon", gin.H{"identifier": identifier, "password": "correct-horse"})
+			expectStatus(t, res, http.StatusOK)
+		})
+	}
+
+	for name, body := range map[string]gin.H{
+		"WrongPassword":   {"email": "alice@example.com", "password": "wrong-password"},
+		"UnknownEmail":    {"email": "bob@example.com", "password": "correct-horse"},
+		"UnknownUsername": {"identifier": "bob0001", "password": "correct-horse"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := s.do(http.MethodPost, "/api/auth/logon", body)
+			expectStatus(t, res, http.StatusUnauthorized)
+			if cookie(res, "access_token") != nil {
+				t.Error("session cookie set on failed logon")
+			}
+		})
+	}
+
+	t.Run("MissingPassword", func(t *testing.T) {
+		res := s.do(http.MethodPost, "/api/auth/logon", gin.H{"email": "alice@example.com"})
+		expectStatus(t, res, http.StatusBadRequest)
+	})
+
+	t.Run("MissingIdentifier", func(t *testing.T) {
+		res := s.do(http.MethodPost, "/api/auth/logon", gin.H{"password": "correct-horse"})
+		expectStatus(t, res, http.StatusBadRequest)
+	})
+
+	t.Run("DisabledAccount", func(t *testing.T) {
+		user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		user.Disabled = true
+		if err := s.deps.UserRepository.Update(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+
+		res := s.do(http.MethodPost, "/api/auth/logon", gin.H{"email": "alice@example.com", "password": "correct-horse"})
+		expectStatus(t, res, http.StatusForbidden)
+	})
+}
+
+func TestRefresh(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	_, refreshToken := s.logon("alice@example.com", "correct-horse")
+
+	res := s.do(http.MethodPost, "/api/auth/refresh", nil, refreshToken)
+	expectStatus(t, res, http.StatusOK)
+	accessToken, rotated := sessionCookies(t, res)
+	if rotated.Value == refreshToken.Value {
+		t.Fatal("refresh token not rotated")
+	}
+	expectStatus(t, s.do(http.MethodGet, "/api/user", nil, accessToken), http.StatusOK)
+
+	t.Run("MissingCookie", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		invalid := &http.Cookie{Name: "refresh_token", Value: "not-a-token"}
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, invalid), http.StatusUnauthorized)
+	})
+
+	t.Run("AccessTokenAsRefreshToken", func(t *testing.T) {
+		forged := &http.Cookie{Name: "refresh_token", Value: accessToken.Value}
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, forged), http.StatusUnauthorized)
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		res := s.do(http.MethodPost, "/api/auth/refresh", nil, refreshToken)
+		expectStatus(t, res, http.StatusUnauthorized)
+		if cleared := cookie(res, "refresh_token"); cleared == nil || cleared.MaxAge >= 0 {
+			t.Error("session cookies not cleared on reuse")
+		}
+
+		// Every session of the user is revoked, the rotated token included.
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, rotated), http.StatusUnauthorized)
+	})
+}
+
+func TestLogout(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	aliceID := findUserID(t, s, "alice@example.com")
+	accessToken, refreshToken := s.logon("alice@example.com", "correct-horse")
+	_, otherRefreshToken := s.logon("alice@example.com", "correct-horse")
+
+	// The browser sends the refresh token to logout too.
+	if !strings.HasPrefix("/api/auth/logout", refreshToken.Path) {
+		t.Errorf("refresh token cookie path %s doesn't cover logout", refreshToken.Path)
+	}
+
+	res := s.do(http.MethodPost, "/api/auth/logout", nil, accessToken, refreshToken)
+	expectStatus(t, res, http.StatusOK)
+	for _, name := range []string{"access_token", "refresh_token"} {
+		if cleared := cookie(res, name); cleared == nil || cleared.Value != "" || cleared.MaxAge >= 0 {
+			t.Errorf("%s cookie not cleared: %v", name, cleared)
+		}
+	}
+
+	t.Run("SessionRevoked", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, refreshToken), http.StatusUnauthorized)
+
+		// The other sessions go on.
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, otherRefreshToken), http.StatusOK)
+
+		events, err := s.eventOutbox.ListByUser(context.Background(), aliceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revoked := 0
+		for _, event := range events {
+			if event.Type == models.EventSessionRevoked {
+				revoked++
+			}
+		}
+		if revoked != 1 {
+			t.Errorf("%d SessionRevoked events, want 1", revoked)
+		}
+	})
+
+	t.Run("WithoutAccessToken", func(t *testing.T) {
+		_, refreshToken := s.logon("alice@example.com", "correct-horse")
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/logout", nil, refreshToken), http.StatusOK)
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, refreshToken), http.StatusUnauthorized)
+
+		events, err := s.deps.AuditLogRepository.List(context.Background(), repositories.AuditFilter{Action: models.AuditActionLogout}, bson.ObjectID{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].ActorID != aliceID.Hex() {
+			t.Errorf("logout not recorded as done by the owner of the refresh token: %+v", events)
+		}
+	})
+
+	t.Run("WithoutSession", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/logout", nil), http.StatusOK)
+	})
+}
+
+func TestEmailCodeGuesses(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+
+	requestCode := func(t *testing.T) string {
+		t.Helper()
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/email-code", gin.H{"email": "alice@example.com"}), http.StatusAccepted)
+		return s.mailbox.code(t, "alice@example.com")
+	}
+
+	// verifyConcurrently sends the code n times at once and returns how many
+	// logins succeeded.
+	verifyConcurrently := func(t *testing.T, code string, n int) int {
+		t.Helper()
+		statuses := make(chan int, n)
+		var wg sync.WaitGroup
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := s.do(http.MethodPost, "/api/auth/email-code/verify", gin.H{"email": "alice@example.com", "code": code})
+				statuses <- res.Code
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		succeeded := 0
+		for status := range statuses {
+			switch status {
+			case http.StatusOK:
+				succeeded++
+			case http.StatusUnauthorized:
+			default:
+				t.Errorf("status = %d, want %d or %d", status, http.StatusOK, http.StatusUnauthorized)
+			}
+		}
+		return succeeded
+	}
+
+	t.Run("ConcurrentWrongGuesses", func(t *testing.T) {
+		code := requestCode(t)
+		wrong := fmt.Sprintf("%06d", (mustAtoi(t, code)+1)%1_000_000)
+
+		if succeeded := verifyConcurrently(t, wrong, 2*emailCodeMaxAttempts); succeeded != 0 {
+			t.Fatalf("%d wrong guesses succeeded", succeeded)
+		}
+
+		// The guesses used up the attempts, so the code no longer works.
+		if succeeded := verifyConcurrently(t, code, 1); succeeded != 0 {
+			t.Error("code accepted after the attempts were used up")
+		}
+	})
+
+	t.Run("ConcurrentReuse", func(t *testing.T) {
+		// Codes can only be resent after the cooldown.
+		if err := s.deps.OneTimeTokenRepository.DeleteByUser(context.Background(), findUserID(t, s, "alice@example.com"), models.PurposeLoginCode); err != nil {
+			t.Fatal(err)
+		}
+		code := requestCode(t)
+
+		if succeeded := verifyConcurrently(t, code, emailCodeMaxAttempts); succeeded != 1 {
+			t.Errorf("the code was used %d times, want 1", succeeded)
+		}
+	})
+}
+
+func TestEmailMFA(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+	expectStatus(t, s.do(http.MethodPost, "/api/user/email/verification", nil, accessToken), http.StatusAccepted)
+	res := s.do(http.MethodPost, "/api/user/email/verification/confirm", gin.H{"code": s.mailbox.code(t, "alice@example.com")}, accessToken)
+	expectStatus(t, res, http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/user/mfa/email", nil, accessToken), http.StatusOK)
+
+	t.Run("LoginCode", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/email-code", gin.H{"email": "alice@example.com"}), http.StatusAccepted)
+		res := s.do(http.MethodPost, "/api/auth/email-code/verify", gin.H{"email": "alice@example.com", "code": s.mailbox.code(t, "alice@example.com")})
+		expectStatus(t, res, http.StatusOK)
+		if cookie(res, "access_token") != nil {
+			t.Fatal("login code skipped the second factor")
+		}
+
+		var body struct {
+			MFAToken string `json:"mfa_token"`
+		}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body.MFAToken == "" {
+			t.Fatalf("login code response = %s", res.Body)
+		}
+
+		res = s.do(http.MethodPost, "/api/auth/mfa/email/verify", gin.H{"mfa_token": body.MFAToken, "code": s.mailbox.code(t, "alice@example.com")})
+		expectStatus(t, res, http.StatusOK)
+		sessionCookies(t, res)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodDelete, "/api/user/mfa/email", gin.H{}, accessToken), http.StatusUnauthorized)
+		expectStatus(t, s.do(http.MethodDelete, "/api/user/mfa/email", gin.H{"current_password": "wrong-password"}, accessToken), http.StatusUnauthorized)
+
+		user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.EmailMFAEnabled {
+			t.Fatal("MFA disabled without the password")
+		}
+
+		expectStatus(t, s.do(http.MethodDelete, "/api/user/mfa/email", gin.H{"current_password": "correct-horse"}, accessToken), http.StatusOK)
+		s.logon("alice@example.com", "correct-horse")
+	})
+}
+
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func findUserID(t *testing.T, s *testServer, email string) bson.ObjectID {
+	t.Helper()
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// createExternalUser stores a user without a password, as SSO and SCIM
+// create them, and logs them in with an email code.
+func (s *testServer) createExternalUser(email, username string) *http.Cookie {
+	s.t.Helper()
+	user, err := models.NewExternalUser(username, email)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if err := s.deps.UserRepository.Create(context.Background(), user); err != nil {
+		s.t.Fatal(err)
+	}
+
+	expectStatus(s.t, s.do(http.MethodPost, "/api/auth/email-code", gin.H{"email": email}), http.StatusAccepted)
+	res := s.do(http.MethodPost, "/api/auth/email-code/verify", gin.H{"email": email, "code": s.mailbox.code(s.t, email)})
+	expectStatus(s.t, res, http.StatusOK)
+	accessToken, _ := sessionCookies(s.t, res)
+	return accessToken
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t)
+
+	t.Run("WithPassword", func(t *testing.T) {
+		s.register("alice@example.com", "alice01", "correct-horse")
+		accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+		res := s.do(http.MethodPut, "/api/user/password", gin.H{"current_password": "wrong-password", "new_password": "battery-staple"}, accessToken)
+		expectStatus(t, res, http.StatusUnauthorized)
+
+		res = s.do(http.MethodPut, "/api/user/password", gin.H{"current_password": "correct-horse", "new_password": "battery-staple"}, accessToken)
+		expectStatus(t, res, http.StatusOK)
+		newAccessToken, _ := sessionCookies(t, res)
+		s.logon("alice@example.com", "battery-staple")
+
+		// The access token from before the change is rejected, even within
+		// the same second, and the one issued with it is not.
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, accessToken), http.StatusUnauthorized)
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, newAccessToken), http.StatusOK)
+	})
+
+	t.Run("WithoutPassword", func(t *testing.T) {
+		accessToken := s.createExternalUser("bob@example.com", "bob0001")
+
+		res := s.do(http.MethodPut, "/api/user/password", gin.H{"new_password": "battery-staple"}, accessToken)
+		expectStatus(t, res, http.StatusUnauthorized)
+
+		res = s.do(http.MethodPatch, "/api/user", gin.H{"email": "bob@example.org"}, accessToken)
+		expectStatus(t, res, http.StatusUnauthorized)
+
+		expectStatus(t, s.do(http.MethodPost, "/api/user/reauthentication/code", nil, accessToken), http.StatusAccepted)
+		code := s.mailbox.code(t, "bob@example.com")
+
+		res = s.do(http.MethodPut, "/api/user/password", gin.H{"code": code, "new_password": "battery-staple"}, accessToken)
+		expectStatus(t, res, http.StatusOK)
+		s.logon("bob@example.com", "battery-staple")
+	})
+}
+
+func TestRevokeSessionsLink(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+	res := s.do(http.MethodPut, "/api/user/password", gin.H{"current_password": "correct-horse", "new_password": "battery-staple"}, accessToken)
+	expectStatus(t, res, http.StatusOK)
+	newAccessToken, _ := sessionCookies(t, res)
+	token := s.mailbox.linkToken(t, "alice@example.com")
+
+	t.Run("Opened", func(t *testing.T) {
+		res := s.do(http.MethodGet, "/api/auth/sessions/revoke?token="+url.QueryEscape(token), nil)
+		expectStatus(t, res, http.StatusOK)
+		if !strings.Contains(res.Body.String(), `method="post"`) {
+			t.Errorf("no confirmation form: %s", res.Body.String())
+		}
+
+		// Opening the link, e.g. by a mail scanner, revokes nothing.
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, newAccessToken), http.StatusOK)
+	})
+
+	t.Run("Confirmed", func(t *testing.T) {
+		expectStatus(t, s.postForm("/api/auth/sessions/revoke", url.Values{"token": {token}}), http.StatusOK)
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, newAccessToken), http.StatusUnauthorized)
+
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/sessions/revoke", gin.H{"token": token}), http.StatusUnauthorized)
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	accessToken, refreshToken := s.logon("alice@example.com", "correct-horse")
+
+	res := s.do(http.MethodGet, "/api/user", nil, accessToken)
+	expectStatus(t, res, http.StatusOK)
+
+	var body struct {
+		User struct {
+			Email    string `json:"email"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.User.Email != "alice@example.com" || body.User.Username != "alice01" || body.User.Password != "" {
+		t.Errorf("user = %+v", body.User)
+	}
+
+	t.Run("MissingCookie", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		invalid := &http.Cookie{Name: "access_token", Value: "not-a-token"}
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, invalid), http.StatusUnauthorized)
+	})
+
+	t.Run("RefreshTokenAsAccessToken", func(t *testing.T) {
+		forged := &http.Cookie{Name: "access_token", Value: refreshToken.Value}
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, forged), http.StatusUnauthorized)
+	})
+
+	t.Run("DeletedUser", func(t *testing.T) {
+		user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.deps.UserRepository.Delete(context.Background(), user.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, accessToken), http.StatusNotFound)
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+	accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+	res := s.do(http.MethodPatch, "/api/user", gin.H{"username": "alice02", "given_name": " Alice ", "locale": "pt-BR,pt;q=0.9"}, accessToken)
+	expectStatus(t, res, http.StatusOK)
+
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice02" || user.GivenName != "Alice" || user.Locale != "pt-BR" || user.Email != "alice@example.com" {
+		t.Errorf("stored user = %+v", user)
+	}
+
+	t.Run("MissingFieldsKept", func(t *testing.T) {
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"family_name": "Liddell"}, accessToken)
+		expectStatus(t, res, http.StatusOK)
+
+		user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "alice02" || user.GivenName != "Alice" || user.FamilyName != "Liddell" {
+			t.Errorf("stored user = %+v", user)
+		}
+	})
+
+	t.Run("UsernameTaken", func(t *testing.T) {
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"username": "BOB0001"}, accessToken)
+		expectStatus(t, res, http.StatusConflict)
+	})
+
+	for name, body := range map[string]gin.H{
+		"ShortUsername":    {"username": "al"},
+		"ReservedUsername": {"username": "postmaster"},
+		"AtInUsername":     {"username": "alice@example"},
+		"InvalidEmail":     {"email": "alice"},
+		"EmptyEmail":       {"email": ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := s.do(http.MethodPatch, "/api/user", body, accessToken)
+			expectStatus(t, res, http.StatusBadRequest)
+		})
+	}
+
+	t.Run("WithoutSession", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodPatch, "/api/user", gin.H{"given_name": "Eve"}), http.StatusUnauthorized)
+	})
+}
+
+func TestChangeEmail(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+	accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+	findAlice := func(t *testing.T) *models.User {
+		t.Helper()
+		user, err := s.deps.UserRepository.FindByUsername(context.Background(), "alice01")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"email": "alice@example.org", "current_password": "wrong-password"}, accessToken)
+		expectStatus(t, res, http.StatusUnauthorized)
+		if s.mailbox.sentTo("alice@example.org") != 0 {
+			t.Error("confirmation sent without the password")
+		}
+	})
+
+	t.Run("EmailTaken", func(t *testing.T) {
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"email": "BOB@example.com", "current_password": "correct-horse"}, accessToken)
+		expectStatus(t, res, http.StatusConflict)
+	})
+
+	res := s.do(http.MethodPatch, "/api/user", gin.H{"email": "alice@example.org", "current_password": "correct-horse"}, accessToken)
+	expectStatus(t, res, http.StatusOK)
+
+	if user := findAlice(t); user.Email != "alice@example.com" || user.PendingEmail != "alice@example.org" {
+		t.Fatalf("email changed before confirmation: %+v", user)
+	}
+	if s.mailbox.sentTo("alice@example.org") != 1 {
+		t.Error("no confirmation sent to the new address")
+	}
+	if s.mailbox.sentTo("alice@example.com") != 1 {
+		t.Error("no notice sent to the old address")
+	}
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodGet, "/api/auth/email-change/confirm?token=not-a-token", nil), http.StatusUnauthorized)
+	})
+
+	t.Run("EarlierLinkRevoked", func(t *testing.T) {
+		earlier := s.mailbox.linkToken(t, "alice@example.org")
+
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"email": "alice@example.org", "current_password": "correct-horse"}, accessToken)
+		expectStatus(t, res, http.StatusOK)
+
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/email-change/confirm", gin.H{"token": earlier}), http.StatusUnauthorized)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		token := s.mailbox.linkToken(t, "alice@example.org")
+
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"email": "Alice@EXAMPLE.com"}, accessToken)
+		expectStatus(t, res, http.StatusOK)
+
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/email-change/confirm", gin.H{"token": token}), http.StatusUnauthorized)
+		if user := findAlice(t); user.Email != "alice@example.com" || user.PendingEmail != "" {
+			t.Errorf("stored user = %+v", user)
+		}
+	})
+
+	t.Run("Confirmed", func(t *testing.T) {
+		res := s.do(http.MethodPatch, "/api/user", gin.H{"email": "alice@example.org", "current_password": "correct-horse"}, accessToken)
+		expectStatus(t, res, http.StatusOK)
+		token := s.mailbox.linkToken(t, "alice@example.org")
+		notices := s.mailbox.sentTo("alice@example.com")
+
+		expectStatus(t, s.do(http.MethodGet, "/api/auth/email-change/confirm?token="+url.QueryEscape(token), nil), http.StatusOK)
+
+		user := findAlice(t)
+		if user.Email != "alice@example.org" || user.PendingEmail != "" || !user.EmailVerified {
+			t.Errorf("stored user = %+v", user)
+		}
+		if s.mailbox.sentTo("alice@example.com") != notices+1 {
+			t.Error("no notice of the change sent to the old address")
+		}
+
+		s.logon("alice@example.org", "correct-horse")
+
+		// The link can be used once.
+		expectStatus(t, s.do(http.MethodGet, "/api/auth/email-change/confirm?token="+url.QueryEscape(token), nil), http.StatusUnauthorized)
+	})
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	accessToken, refreshToken := s.logon("alice@example.com", "correct-horse")
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodDelete, "/api/user", gin.H{"password": "wrong-password"}, accessToken), http.StatusUnauthorized)
+	})
+
+	t.Run("WithoutCredential", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodDelete, "/api/user", gin.H{}, accessToken), http.StatusBadRequest)
+	})
+
+	res := s.do(http.MethodDelete, "/api/user", gin.H{"password": "correct-horse"}, accessToken)
+	expectStatus(t, res, http.StatusOK)
+
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DeletedAt == nil {
+		t.Fatal("account not marked as deleted")
+	}
+
+	t.Run("LoggedOut", func(t *testing.T) {
+		expectStatus(t, s.do(http.MethodGet, "/api/user", nil, accessToken), http.StatusForbidden)
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/refresh", nil, refreshToken), http.StatusUnauthorized)
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/logon", gin.H{"email": "alice@example.com", "password": "correct-horse"}), http.StatusForbidden)
+	})
+
+	t.Run("NotPurgedDuringGracePeriod", func(t *testing.T) {
+		s.deps.AccountPurger.PurgeDue(context.Background())
+		if _, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Restored", func(t *testing.T) {
+		token := s.mailbox.linkToken(t, "alice@example.com")
+
+		// Opening the link only shows a confirmation.
+		expectStatus(t, s.do(http.MethodGet, "/api/auth/account/restore?token="+url.QueryEscape(token), nil), http.StatusOK)
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/logon", gin.H{"email": "alice@example.com", "password": "correct-horse"}), http.StatusForbidden)
+
+		expectStatus(t, s.postForm("/api/auth/account/restore", url.Values{"token": {token}}), http.StatusOK)
+		s.logon("alice@example.com", "correct-horse")
+
+		// The link can be used once.
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/account/restore", gin.H{"token": token}), http.StatusUnauthorized)
+	})
+}
+
+func TestPurgeAccount(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+
+	ctx := context.Background()
+	findUser := func(t *testing.T, email string) *models.User {
+		t.Helper()
+		user, err := s.deps.UserRepository.FindByEmail(ctx, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	group, err := models.NewGroup("Engineering")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.Members = append(group.Members, findUser(t, "alice@example.com").ID, findUser(t, "bob@example.com").ID)
+	if err := s.deps.GroupRepository.Create(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+
+	var delivery *models.WebhookDelivery
+	expectPurged := func(t *testing.T, user *models.User) {
+		t.Helper()
+		if _, err := s.deps.UserRepository.FindById(ctx, user.ID.Hex()); err == nil {
+			t.Error("user not deleted")
+		}
+
+		refreshTokens, err := s.deps.RefreshTokenRepository.ListByUser(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refreshTokens) != 0 {
+			t.Errorf("%d sessions left", len(refreshTokens))
+		}
+
+		groups, err := s.deps.GroupRepository.ListByMember(ctx, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 0 {
+			t.Error("user left in groups")
+		}
+
+		events, err := s.deps.AuditLogRepository.List(ctx, repositories.AuditFilter{TargetID: models.AuditDeletedUser}, bson.ObjectID{}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			t.Error("audit events not anonymized")
+		}
+		for _, event := range events {
+			if event.ActorID == user.ID.Hex() || event.TargetID == user.ID.Hex() {
+				t.Errorf("audit event still refers to the user: %+v", event)
+			}
+		}
+
+		events, err = s.deps.AuditLogRepository.List(ctx, repositories.AuditFilter{Outcome: models.AuditOutcomeFailure}, bson.ObjectID{}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if event.Names([]string{user.Email, user.Username}) {
+				t.Errorf("failed attempt still names the user: %+v", event)
+			}
+		}
+
+		if _, err := s.deps.WebhookDeliveryRepository.FindById(ctx, delivery.ID.Hex()); err == nil {
+			t.Error("webhook delivery not deleted")
+		}
+
+		// Only the UserDeleted event is left about the user, to be delivered.
+		for {
+			event, err := s.eventOutbox.ClaimDue(ctx, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event == nil {
+				break
+			}
+			if event.UserID == user.ID && event.Type != models.EventUserDeleted {
+				t.Errorf("%s event of the user left in the outbox", event.Type)
+			}
+		}
+	}
+
+	// Before the purge, the user has failed logins and webhook deliveries.
+	prepare := func(t *testing.T, user *models.User) {
+		t.Helper()
+		for _, identifier := range []string{user.Email, strings.ToUpper(user.Username)} {
+			res := s.do(http.MethodPost, "/api/auth/logon", gin.H{"identifier": identifier, "password": "wrong-password"})
+			expectStatus(t, res, http.StatusUnauthorized)
+		}
+
+		delivery = models.NewWebhookDelivery(bson.NewObjectID(), user.ID, bson.NewObjectID().Hex(), models.WebhookEventUserLogin, `{}`)
+		if err := s.deps.WebhookDeliveryRepository.Create(ctx, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Immediate", func(t *testing.T) {
+		s.withGracePeriod(0)
+		alice := findUser(t, "alice@example.com")
+		prepare(t, alice)
+		accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+		expectStatus(t, s.do(http.MethodDelete, "/api/user", gin.H{"password": "correct-horse"}, accessToken), http.StatusOK)
+		expectPurged(t, alice)
+	})
+
+	t.Run("GracePeriodOver", func(t *testing.T) {
+		s.withGracePeriod(time.Hour)
+		bob := findUser(t, "bob@example.com")
+		prepare(t, bob)
+		accessToken, _ := s.logon("bob@example.com", "correct-horse")
+
+		expectStatus(t, s.do(http.MethodDelete, "/api/user", gin.H{"password": "correct-horse"}, accessToken), http.StatusOK)
+
+		deletedAt := time.Now().Add(-2 * time.Hour)
+		bob = findUser(t, "bob@example.com")
+		bob.DeletedAt = &deletedAt
+		if err := s.deps.UserRepository.Update(ctx, bob); err != nil {
+			t.Fatal(err)
+		}
+
+		s.deps.AccountPurger.PurgeDue(ctx)
+		expectPurged(t, bob)
+	})
+
+	t.Run("RestoredMeanwhile", func(t *testing.T) {
+		s.register("carol@example.com", "carol01", "correct-horse")
+		s.logon("carol@example.com", "correct-horse")
+		carol := findUser(t, "carol@example.com")
+		deletedAt := time.Now().Add(-2 * time.Hour)
+		carol.DeletedAt = &deletedAt
+		if err := s.deps.UserRepository.Update(ctx, carol); err != nil {
+			t.Fatal(err)
+		}
+
+		purger := accounts.NewPurger(restoringUserRepository{s.deps.UserRepository}, s.deps.RefreshTokenRepository, s.deps.OneTimeTokenRepository, s.deps.GroupRepository, s.deps.AuditLogRepository, s.deps.MailOutboxRepository, s.eventOutbox, s.deps.WebhookDeliveryRepository, s.deps.EventBus, time.Hour)
+		purger.PurgeDue(ctx)
+
+		if findUser(t, "carol@example.com").DeletedAt != nil {
+			t.Fatal("account not restored")
+		}
+		refreshTokens, err := s.deps.RefreshTokenRepository.ListByUser(ctx, carol.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refreshTokens) == 0 {
+			t.Error("sessions of the restored account deleted")
+		}
+		for {
+			event, err := s.eventOutbox.ClaimDue(ctx, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event == nil {
+				break
+			}
+			if event.UserID == carol.ID && event.Type == models.EventUserDeleted {
+				t.Error("UserDeleted event sent for the restored account")
+			}
+		}
+	})
+}
+
+// restoringUserRepository restores the accounts it lists as deleted, as if
+// their users restored them right after the purger listed them.
+type restoringUserRepository struct {
+	repositories.UserRepositoryInterface
+}
+
+func (r restoringUserRepository) ListDeleted(ctx context.Context, before time.Time, limit int) ([]*models.User, error) {
+	users, err := r.UserRepositoryInterface.ListDeleted(ctx, before, limit)
+	for _, user := range users {
+		restored := *user
+		restored.DeletedAt = nil
+		if err := r.Update(ctx, &restored); err != nil {
+			return nil, err
+		}
+	}
+	return users, err
+}
+
+func TestDeleteAccountWithCode(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	accessToken, _ := s.logon("alice@example.com", "correct-horse")
+
+	expectStatus(t, s.do(http.MethodPost, "/api/user/deletion/code", nil, accessToken), http.StatusAccepted)
+	code := s.mailbox.code(t, "alice@example.com")
+
+	expectStatus(t, s.do(http.MethodDelete, "/api/user", gin.H{"code": code}, accessToken), http.StatusOK)
+}
+
+func TestExport(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	accessToken, _ := s.logon("alice@example.com", "correct-horse")
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/logon", gin.H{"identifier": "ALICE01", "password": "wrong-password"}), http.StatusUnauthorized)
+
+	ctx := context.Background()
+	if err := s.deps.MailOutboxRepository.Enqueue(ctx, models.NewMailMessage("alice@example.com", "Your login code", "Your code is 482913", "")); err != nil {
+		t.Fatal(err)
+	}
+	delivery := models.NewWebhookDelivery(bson.NewObjectID(), findUserID(t, s, "alice@example.com"), bson.NewObjectID().Hex(), models.WebhookEventUserLogin, `{}`)
+	if err := s.deps.WebhookDeliveryRepository.Create(ctx, delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	res := s.do(http.MethodGet, "/api/user/export", nil, accessToken)
+	expectStatus(t, res, http.StatusOK)
+
+	if disposition := res.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	var archive struct {
+		User              models.UserResponse  `json:"user"`
+		KnownDevices      []models.Device      `json:"known_devices"`
+		Sessions          []map[string]any     `json:"sessions"`
+		AuditEvents       []*models.AuditEvent `json:"audit_events"`
+		Emails            []map[string]any     `json:"emails"`
+		Events            []map[string]any     `json:"events"`
+		WebhookDeliveries []map[string]any     `json:"webhook_deliveries"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	if archive.User.Email != "alice@example.com" || len(archive.KnownDevices) != 1 || len(archive.Sessions) != 1 {
+		t.Errorf("archive = %s", res.Body)
+	}
+	if _, ok := archive.Sessions[0]["token"]; ok {
+		t.Error("session token exported")
+	}
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(res.Body.String(), user.Password) {
+		t.Error("password hash exported")
+	}
+
+	actions := []string{}
+	failedLogins := 0
+	for _, event := range archive.AuditEvents {
+		actions = append(actions, event.Action)
+		if event.Action == models.AuditActionLogin && event.Outcome == models.AuditOutcomeFailure {
+			failedLogins++
+		}
+	}
+	if !slices.Contains(actions, models.AuditActionRegister) || !slices.Contains(actions, models.AuditActionLogin) || failedLogins != 1 {
+		t.Errorf("audit events = %v, with %d failed logins", actions, failedLogins)
+	}
+
+	if len(archive.Emails) != 1 || archive.Emails[0]["subject"] != "Your login code" {
+		t.Errorf("emails = %v", archive.Emails)
+	}
+	if strings.Contains(res.Body.String(), "482913") {
+		t.Error("email body exported")
+	}
+	if len(archive.Events) == 0 {
+		t.Error("domain events not exported")
+	}
+	if len(archive.WebhookDeliveries) != 1 || archive.WebhookDeliveries[0]["id"] != delivery.ID.Hex() {
+		t.Errorf("webhook deliveries = %v", archive.WebhookDeliveries)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// confirmationPage is shown when an emailed link is opened. Mail scanners and
+// link previews follow GET requests, so only the form's POST acts on the link.
+var confirmationPage = template.Must(template.New("confirmation").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Text}}</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{.Button}}</button>
+</form>
+</body>
+</html>
+`))
+
+// showConfirmation renders the confirmation page of the link in the request,
+// posting its token back to the same path.
+func showConfirmation(c *gin.Context, title, text, button string) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Status(http.StatusOK)
+	confirmationPage.Execute(c.Writer, gin.H{
+		"Title":  title,
+		"Text":   text,
+		"Button": button,
+		"Action": c.Request.URL.Path,
+		"Token":  token,
+	})
+}
@@ -0,0 +1,386 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailCodeHandler struct {
+	emailCodes     *emailCodes
+	notifications  *securityNotifications
+	userRepository repositories.UserRepositoryInterface
+	sessions       *sessions
+	audit          *auditLog
+}
+
+func newEmailCodeHandler(emailCodes *emailCodes, notifications *securityNotifications, userRepository repositories.UserRepositoryInterface, sessions *sessions, audit *auditLog) *EmailCodeHandler {
+	return &EmailCodeHandler{
+		emailCodes:     emailCodes,
+		notifications:  notifications,
+		userRepository: userRepository,
+		sessions:       sessions,
+		audit:          audit,
+	}
+}
+
+func (h *EmailCodeHandler) RequestLoginCode(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userRepository.FindByEmail(c.Request.Context(), req.Email)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	// The response doesn't reveal whether the email is registered or whether
+	// the cooldown skipped sending.
+	if err == nil && !user.Disabled {
+		err := h.emailCodes.send(c.Request.Context(), user, models.PurposeLoginCode)
+		if err != nil && !errors.Is(err, errEmailCodeCooldown) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "If the email is registered, a code has been sent",
+		"resend_after": int(emailCodeCooldown.Seconds()),
+	})
+}
+
+func (h *EmailCodeHandler) VerifyLoginCode(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+		Code  string `json:"code" binding:"required,len=6,numeric"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userRepository.FindByEmail(c.Request.Context(), req.Email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	h.completeWithCode(c, user, models.PurposeLoginCode, req.Code)
+}
+
+func (h *EmailCodeHandler) VerifyMFACode(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required,len=6,numeric"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.userFromMFAToken(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	h.completeWithCode(c, user, models.PurposeMFACode, req.Code)
+}
+
+func (h *EmailCodeHandler) ResendMFACode(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.userFromMFAToken(c, req.MFAToken)
+	if !ok {
+		return
+	}
+
+	h.sendCode(c, user, models.PurposeMFACode)
+}
+
+func (h *EmailCodeHandler) completeWithCode(c *gin.Context, user *models.User, purpose, code string) {
+	err := h.emailCodes.verify(c.Request.Context(), user.ID, purpose, code)
+	if errors.Is(err, errEmailCodeInvalid) {
+		h.audit.record(c, &models.AuditEvent{
+			Action:   models.AuditActionLogin,
+			Outcome:  models.AuditOutcomeFailure,
+			TargetID: user.ID.Hex(),
+			Details:  map[string]string{"route": c.FullPath(), "reason": "invalid code"},
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+
+	// Receiving the code proves ownership of the address.
+	if !user.EmailVerified {
+		if err := h.markEmailVerified(c, user); err != nil {
+			abortWithRepositoryError(c, err, "User", "Failed to update user")
+			return
+		}
+	}
+
+	// A login code replaces the password, not the second factor.
+	if purpose == models.PurposeLoginCode && user.EmailMFAEnabled && !user.Disabled {
+		requireMFA(c, h.emailCodes, user)
+		return
+	}
+
+	if err := h.sessions.issue(c, user); err != nil {
+		abortWithSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+	})
+}
+
+func (h *EmailCodeHandler) userFromMFAToken(c *gin.Context, mfaToken string) (*models.User, bool) {
+	userID, err := auth.ValidateMFAToken(mfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil, false
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), userID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return nil, false
+	}
+
+	if err != nil || !user.EmailMFAEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (h *EmailCodeHandler) sendCode(c *gin.Context, user *models.User, purpose string) {
+	err := h.emailCodes.send(c.Request.Context(), user, purpose)
+	if errors.Is(err, errEmailCodeCooldown) {
+		c.Header("Retry-After", strconv.Itoa(int(emailCodeCooldown.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "A code was sent recently, try again later"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "Code sent",
+		"resend_after": int(emailCodeCooldown.Seconds()),
+	})
+}
+
+func (h *EmailCodeHandler) SendVerificationCode(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already verified"})
+		return
+	}
+
+	h.sendCode(c, user, models.PurposeEmailVerificationCode)
+}
+
+// SendReauthenticationCode sends the code confirming a password or email
+// change, or disabling MFA, for users without a password.
+func (h *EmailCodeHandler) SendReauthenticationCode(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	h.sendCode(c, user, models.PurposeReauthenticationCode)
+}
+
+// SendDeletionCode sends the code confirming the deletion of the account, for
+// users without a password.
+func (h *EmailCodeHandler) SendDeletionCode(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	h.sendCode(c, user, models.PurposeAccountDeletionCode)
+}
+
+func (h *EmailCodeHandler) ConfirmVerificationCode(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required,len=6,numeric"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	err := h.emailCodes.verify(c.Request.Context(), user.ID, models.PurposeEmailVerificationCode, req.Code)
+	if errors.Is(err, errEmailCodeInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+
+	if err := h.markEmailVerified(c, user); err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to update user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+		"user":    user.ToResponse(),
+	})
+}
+
+func (h *EmailCodeHandler) EnableMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	// Codes would go to an address nobody proved to own.
+	if !user.EmailVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Verify your email before enabling email MFA"})
+		return
+	}
+
+	h.setMFA(c, user, true)
+}
+
+// DisableMFA turns email MFA off, confirmed with the password, or with a code
+// sent by email for users without a password.
+func (h *EmailCodeHandler) DisableMFA(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code" binding:"omitempty,len=6,numeric"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	err := h.emailCodes.reauthenticate(c.Request.Context(), user, req.CurrentPassword, req.Code, models.PurposeReauthenticationCode)
+	if errors.Is(err, errReauthenticationFailed) {
+		h.audit.record(c, &models.AuditEvent{
+			Action:   models.AuditActionMFADisable,
+			Outcome:  models.AuditOutcomeFailure,
+			ActorID:  user.ID.Hex(),
+			TargetID: user.ID.Hex(),
+			Details:  map[string]string{"reason": "incorrect current password or code"},
+		})
+		abortWithReauthenticationError(c, user)
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+
+	h.setMFA(c, user, false)
+}
+
+func (h *EmailCodeHandler) setMFA(c *gin.Context, user *models.User, enabled bool) {
+	changed := user.EmailMFAEnabled != enabled
+
+	err := repositories.UpdateUser(c.Request.Context(), h.userRepository, user, func(user *models.User) error {
+		user.EmailMFAEnabled = enabled
+		return nil
+	})
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to update user")
+		return
+	}
+
+	action := models.AuditActionMFADisable
+	if enabled {
+		action = models.AuditActionMFAEnable
+	}
+	h.audit.record(c, &models.AuditEvent{
+		Action:   action,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+	})
+
+	if changed && enabled {
+		h.notifications.notify(c, user, models.SecurityEventMFAEnabled)
+	} else if changed {
+		h.notifications.notify(c, user, models.SecurityEventMFADisabled)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email MFA updated successfully",
+		"user":    user.ToResponse(),
+	})
+}
+
+// markEmailVerified records that the user received a code at their address.
+func (h *EmailCodeHandler) markEmailVerified(c *gin.Context, user *models.User) error {
+	return repositories.UpdateUser(c.Request.Context(), h.userRepository, user, func(user *models.User) error {
+		user.EmailVerified = true
+		return nil
+	})
+}
+
+func (h *EmailCodeHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	user, err := h.userRepository.FindById(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return nil, false
+	}
+
+	return user, true
+}
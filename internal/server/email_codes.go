@@ -0,0 +1,157 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/metrics"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	emailCodeTTL         = 10 * time.Minute
+	emailCodeCooldown    = 60 * time.Second
+	emailCodeMaxAttempts = 5
+)
+
+var (
+	errEmailCodeCooldown = errors.New("a code was sent recently")
+	errEmailCodeInvalid  = errors.New("invalid or expired code")
+
+	errReauthenticationFailed = errors.New("invalid password or code")
+)
+
+// emailCodes sends six-digit one-time codes by email and checks them. Codes
+// are stored hashed, expire after emailCodeTTL, are discarded after
+// emailCodeMaxAttempts wrong guesses and can only be resent after
+// emailCodeCooldown.
+type emailCodes struct {
+	mailService            *mail.Service
+	oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface
+}
+
+func newEmailCodes(mailService *mail.Service, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface) *emailCodes {
+	return &emailCodes{
+		mailService:            mailService,
+		oneTimeTokenRepository: oneTimeTokenRepository,
+	}
+}
+
+func (e *emailCodes) send(ctx context.Context, user *models.User, purpose string) error {
+	if err := e.cooldown(ctx, user.ID, purpose); err != nil {
+		return err
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	// Codes are short, so they are hashed with bcrypt rather than the plain
+	// hash used for long tokens.
+	codeHash, err := auth.HashPassword(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	// Only the most recent code is valid.
+	if err := e.oneTimeTokenRepository.DeleteByUser(ctx, user.ID, purpose); err != nil {
+		return err
+	}
+
+	token := models.NewOneTimeToken(user.ID, purpose, codeHash, "", time.Now().Add(emailCodeTTL))
+	if err := e.oneTimeTokenRepository.Create(ctx, token); err != nil {
+		return err
+	}
+
+	return e.mailService.Send(ctx, user.Email, user.Locale, "email_code", map[string]any{
+		"Username":         user.Username,
+		"Code":             code,
+		"ExpiresInMinutes": int(emailCodeTTL.Minutes()),
+	})
+}
+
+// cooldown returns errEmailCodeCooldown while the latest token sent for the
+// purpose is younger than emailCodeCooldown.
+func (e *emailCodes) cooldown(ctx context.Context, userID bson.ObjectID, purpose string) error {
+	latest, err := e.oneTimeTokenRepository.FindLatest(ctx, userID, purpose)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if time.Since(latest.CreatedAt) < emailCodeCooldown {
+		return errEmailCodeCooldown
+	}
+	return nil
+}
+
+// reauthenticate confirms a sensitive change with the password of the user,
+// or for users without one, such as those created by SSO or SCIM, with a code
+// sent for purpose. Holding a session isn't enough, as it may be stolen.
+func (e *emailCodes) reauthenticate(ctx context.Context, user *models.User, password, code, purpose string) error {
+	if user.Password != "" {
+		if !auth.CheckPasswordHash(ctx, password, user.Password) {
+			return errReauthenticationFailed
+		}
+		return nil
+	}
+
+	if code == "" {
+		return errReauthenticationFailed
+	}
+
+	err := e.verify(ctx, user.ID, purpose, code)
+	if errors.Is(err, errEmailCodeInvalid) {
+		return errReauthenticationFailed
+	}
+	return err
+}
+
+// verify checks the code against the latest one sent. An attempt is claimed
+// before comparing, so concurrent guesses share the emailCodeMaxAttempts, and
+// a matching code is deleted before being accepted, so it works only once.
+func (e *emailCodes) verify(ctx context.Context, userID bson.ObjectID, purpose, code string) error {
+	token, err := e.oneTimeTokenRepository.ClaimAttempt(ctx, userID, purpose, emailCodeMaxAttempts)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return errEmailCodeInvalid
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if token.IsExpired() {
+		return errEmailCodeInvalid
+	}
+
+	if !auth.CheckPasswordHash(ctx, code, token.TokenHash) {
+		if token.Attempts >= emailCodeMaxAttempts {
+			metrics.Lockouts.WithLabelValues("email_code").Inc()
+			err := e.oneTimeTokenRepository.Delete(ctx, token.ID)
+			if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+		}
+		return errEmailCodeInvalid
+	}
+
+	// Another request with the same code may have used it meanwhile.
+	err = e.oneTimeTokenRepository.Delete(ctx, token.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return errEmailCodeInvalid
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// abortWithRepositoryError responds to a failed repository call with the
+// status its typed error maps to. resource names what was looked up or saved,
+// and message is returned when the repository failed for another reason.
+func abortWithRepositoryError(c *gin.Context, err error, resource, message string) {
+	switch {
+	case errors.Is(err, repositories.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": resource + " not found"})
+	case errors.Is(err, repositories.ErrDuplicateEmail):
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+	case errors.Is(err, repositories.ErrDuplicateUsername):
+		c.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
+	case errors.Is(err, repositories.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": resource + " already exists"})
+	case errors.Is(err, repositories.ErrStale):
+		c.JSON(http.StatusConflict, gin.H{"error": resource + " was modified concurrently, try again"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// abortWithReauthenticationError responds to a failed reauthenticate, telling
+// users without a password that they need a code.
+func abortWithReauthenticationError(c *gin.Context, user *models.User) {
+	if user.Password != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "A valid code sent by email is required"})
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is a readiness check of a dependency.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// dependencyStatus is what /readyz shows of a check. The endpoint is
+// unauthenticated, so errors and latencies are only logged.
+type dependencyStatus struct {
+	Status string `json:"status"`
+}
+
+type HealthHandler struct {
+	checks   []HealthCheck
+	draining func() bool
+}
+
+func newHealthHandler(draining func() bool, checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{
+		checks:   checks,
+		draining: draining,
+	}
+}
+
+// Liveness only tells the process is serving requests. Dependencies are
+// left to Readiness, so an outage of the database doesn't get every instance
+// restarted.
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness runs every dependency check concurrently and fails if any of them
+// does, or if the server is shutting down.
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	statuses := make(map[string]dependencyStatus, len(h.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check.Check(ctx)
+			status := dependencyStatus{Status: "ok"}
+			if err != nil {
+				status.Status = "unavailable"
+				slog.WarnContext(ctx, "Readiness check failed", "check", check.Name, "latency", time.Since(start), "error", err)
+			}
+
+			mu.Lock()
+			statuses[check.Name] = status
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	code, overall := http.StatusOK, "ok"
+	for _, status := range statuses {
+		if status.Status != "ok" {
+			code, overall = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+	if h.draining() {
+		code, overall = http.StatusServiceUnavailable, "draining"
+	}
+
+	c.JSON(code, gin.H{
+		"status": overall,
+		"checks": statuses,
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := newHealthHandler(func() bool { return false },
+		HealthCheck{Name: "database", Check: func(ctx context.Context) error {
+			return errors.New("dial tcp 10.0.3.7:27017: connection refused")
+		}},
+		HealthCheck{Name: "jwt_secrets", Check: func(ctx context.Context) error { return nil }},
+	)
+	router := gin.New()
+	router.GET("/readyz", handler.Readiness)
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	expectStatus(t, res, http.StatusServiceUnavailable)
+
+	if strings.Contains(res.Body.String(), "10.0.3.7") {
+		t.Errorf("readiness leaks the check error: %s", res.Body)
+	}
+
+	var body struct {
+		Status string                     `json:"status"`
+		Checks map[string]json.RawMessage `json:"checks"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"database": `{"status":"unavailable"}`, "jwt_secrets": `{"status":"ok"}`}
+	if body.Status != "unavailable" || len(body.Checks) != len(want) {
+		t.Fatalf("readiness = %s", res.Body)
+	}
+	for name, check := range want {
+		if string(body.Checks[name]) != check {
+			t.Errorf("check %s = %s, want %s", name, body.Checks[name], check)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	magicLinkNonceCookie = "magic_link_nonce"
+	magicLinkTTL         = 15 * time.Minute
+)
+
+type MagicLinkHandler struct {
+	mailService            *mail.Service
+	emailCodes             *emailCodes
+	userRepository         repositories.UserRepositoryInterface
+	sessions               *sessions
+	oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface
+}
+
+func newMagicLinkHandler(mailService *mail.Service, emailCodes *emailCodes, userRepository repositories.UserRepositoryInterface, sessions *sessions, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		mailService:            mailService,
+		emailCodes:             emailCodes,
+		userRepository:         userRepository,
+		sessions:               sessions,
+		oneTimeTokenRepository: oneTimeTokenRepository,
+	}
+}
+
+func (h *MagicLinkHandler) Request(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The link only works in the browser holding this nonce, so a forwarded
+	// or intercepted email can't be used elsewhere.
+	nonce, err := c.Cookie(magicLinkNonceCookie)
+	if err != nil || nonce == "" {
+		nonce, err = auth.RandomString(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create login link"})
+			return
+		}
+	}
+
+	c.SetCookie(
+		magicLinkNonceCookie,
+		nonce,
+		int(magicLinkTTL.Seconds()),
+		"/api/auth/magic-link",
+		"localhost", // domain
+		false,       // secure
+		true,        // httpOnly
+	)
+
+	user, err := h.userRepository.FindByEmail(c.Request.Context(), req.Email)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	// The response is the same whether or not the email is registered, and
+	// whether or not the cooldown skipped sending.
+	if err == nil && !user.Disabled {
+		err := h.emailCodes.cooldown(c.Request.Context(), user.ID, models.PurposeMagicLink)
+		if errors.Is(err, errEmailCodeCooldown) {
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": "If the email is registered, a login link has been sent",
+			})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create login link"})
+			return
+		}
+
+		token, err := auth.RandomString(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create login link"})
+			return
+		}
+
+		oneTimeToken := models.NewOneTimeToken(user.ID, models.PurposeMagicLink, auth.HashToken(token), auth.HashToken(nonce), time.Now().Add(magicLinkTTL))
+		if err := h.oneTimeTokenRepository.Create(c.Request.Context(), oneTimeToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create login link"})
+			return
+		}
+
+		link := os.Getenv("APP_BASE_URL") + "/api/auth/magic-link/consume?token=" + url.QueryEscape(token)
+		err = h.mailService.Send(c.Request.Context(), user.Email, user.Locale, "magic_link", map[string]any{
+			"Username":         user.Username,
+			"Link":             link,
+			"ExpiresInMinutes": int(magicLinkTTL.Minutes()),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send login link"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If the email is registered, a login link has been sent",
+	})
+}
+
+func (h *MagicLinkHandler) Consume(c *gin.Context) {
+	token := c.Query("token")
+	if c.Request.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	nonce, err := c.Cookie(magicLinkNonceCookie)
+	if err != nil || nonce == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login link must be opened in the browser where it was requested"})
+		return
+	}
+
+	oneTimeToken, err := h.oneTimeTokenRepository.Consume(c.Request.Context(), models.PurposeMagicLink, auth.HashToken(token), auth.HashToken(nonce))
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && oneTimeToken.IsExpired()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve login link"})
+		return
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), oneTimeToken.UserID.Hex())
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired login link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	c.SetCookie(magicLinkNonceCookie, "", -1, "/api/auth/magic-link", "localhost", false, true)
+
+	// A login link replaces the password, not the second factor.
+	if user.EmailMFAEnabled && !user.Disabled {
+		requireMFA(c, h.emailCodes, user)
+		return
+	}
+
+	if err := h.sessions.issue(c, user); err != nil {
+		abortWithSessionError(c, err)
+		return
+	}
+
+	if redirectURL := os.Getenv("LOGIN_REDIRECT_URL"); redirectURL != "" && c.Request.Method == http.MethodGet {
+		c.Redirect(http.StatusFound, redirectURL)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+	})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/models"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestMagicLink requests a login link and returns its token and the nonce
+// cookie of the browser that requested it.
+func (s *testServer) requestMagicLink(email string) (string, *http.Cookie) {
+	s.t.Helper()
+	res := s.do(http.MethodPost, "/api/auth/magic-link", gin.H{"email": email})
+	expectStatus(s.t, res, http.StatusAccepted)
+	nonce := cookie(res, magicLinkNonceCookie)
+	if nonce == nil {
+		s.t.Fatal("no nonce cookie set")
+	}
+	return s.mailbox.linkToken(s.t, email), nonce
+}
+
+func TestMagicLinkConsume(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	consume := func(token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/magic-link/consume", gin.H{"token": token}, cookies...)
+	}
+
+	token, nonce := s.requestMagicLink("alice@example.com")
+
+	t.Run("WithoutCookie", func(t *testing.T) {
+		expectStatus(t, consume(token), http.StatusUnauthorized)
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		expectStatus(t, consume(token, &http.Cookie{Name: magicLinkNonceCookie, Value: "another-browser"}), http.StatusUnauthorized)
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		res := consume(token, nonce)
+		expectStatus(t, res, http.StatusOK)
+		sessionCookies(t, res)
+
+		expectStatus(t, consume(token, nonce), http.StatusUnauthorized)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := models.NewOneTimeToken(findUserID(t, s, "alice@example.com"), models.PurposeMagicLink, auth.HashToken("expired-token"), auth.HashToken(nonce.Value), time.Now().Add(-time.Minute))
+		if err := s.deps.OneTimeTokenRepository.Create(context.Background(), expired); err != nil {
+			t.Fatal(err)
+		}
+
+		expectStatus(t, consume("expired-token", nonce), http.StatusUnauthorized)
+	})
+}
+
+func TestMagicLinkCooldown(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+
+	s.requestMagicLink("alice@example.com")
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/magic-link", gin.H{"email": "alice@example.com"}), http.StatusAccepted)
+	if sent := s.mailbox.sentTo("alice@example.com"); sent != 1 {
+		t.Errorf("%d links sent within the cooldown, want 1", sent)
+	}
+}
+
+func TestMagicLinkMFA(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice@example.com", "alice01", "correct-horse")
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.EmailVerified = true
+	user.EmailMFAEnabled = true
+	if err := s.deps.UserRepository.Update(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	token, nonce := s.requestMagicLink("alice@example.com")
+	res := s.do(http.MethodGet, "/api/auth/magic-link/consume?token="+url.QueryEscape(token), nil, nonce)
+	expectStatus(t, res, http.StatusOK)
+	if cookie(res, "access_token") != nil {
+		t.Fatal("login link skipped the second factor")
+	}
+
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body.MFAToken == "" {
+		t.Fatalf("consume response = %s", res.Body)
+	}
+
+	res = s.do(http.MethodPost, "/api/auth/mfa/email/verify", gin.H{"mfa_token": body.MFAToken, "code": s.mailbox.code(t, "alice@example.com")})
+	expectStatus(t, res, http.StatusOK)
+	sessionCookies(t, res)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/middlewares"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowLogin  = "login"
+	oidcFlowLink   = "link"
+)
+
+type OIDCHandler struct {
+	providers      map[string]*auth.OIDCProvider
+	userRepository repositories.UserRepositoryInterface
+	sessions       *sessions
+}
+
+func newOIDCHandler(providers map[string]*auth.OIDCProvider, userRepository repositories.UserRepositoryInterface, sessions *sessions) *OIDCHandler {
+	return &OIDCHandler{
+		providers:      providers,
+		userRepository: userRepository,
+		sessions:       sessions,
+	}
+}
+
+func (h *OIDCHandler) Login(c *gin.Context) {
+	h.startFlow(c, oidcFlowLogin)
+}
+
+// Link starts a flow that attaches the upstream identity to the logged-in
+// user instead of logging in.
+func (h *OIDCHandler) Link(c *gin.Context) {
+	h.startFlow(c, oidcFlowLink+":"+c.GetString("userID"))
+}
+
+func (h *OIDCHandler) startFlow(c *gin.Context, mode string) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, err1 := auth.RandomString(32)
+	nonce, err2 := auth.RandomString(32)
+	verifier, err3 := auth.RandomString(32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	// The state, nonce and PKCE verifier are kept in a short-lived cookie so the
+	// callback can only be completed by the browser that started the flow.
+	c.SetCookie(
+		oidcFlowCookie,
+		strings.Join([]string{state, nonce, verifier, mode}, "."),
+		10*60, // 10 minutes
+		"/api/auth/oidc",
+		"localhost", // domain
+		false,       // secure
+		true,        // httpOnly
+	)
+
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, verifier))
+}
+
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, ok := h.providers[providerName]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	flow, err := c.Cookie(oidcFlowCookie)
+	c.SetCookie(oidcFlowCookie, "", -1, "/api/auth/oidc", "localhost", false, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login flow not found or expired"})
+		return
+	}
+
+	parts := strings.Split(flow, ".")
+	if len(parts) != 4 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login flow"})
+		return
+	}
+	state, nonce, verifier, mode := parts[0], parts[1], parts[2], parts[3]
+
+	if subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state"})
+		return
+	}
+
+	if errParam := c.Query("error"); errParam != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider returned an error: " + errParam})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), nonce, verifier)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to authenticate with identity provider"})
+		return
+	}
+
+	linkedUser, err := h.userRepository.FindByIdentity(c.Request.Context(), providerName, identity.Subject)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if linkUserID, ok := strings.CutPrefix(mode, oidcFlowLink+":"); ok {
+		h.completeLink(c, linkUserID, linkedUser, providerName, identity)
+		return
+	}
+
+	user := linkedUser
+	if user == nil {
+		// Accounts are only created or linked by email when the provider vouches
+		// for the address, otherwise anyone could claim an existing account.
+		if identity.Email == "" || !identity.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Identity provider did not return a verified email"})
+			return
+		}
+
+		// Other accounts with the email have to link the identity from a
+		// session, unless the provider is trusted for the domain.
+		linkByEmail := provider.TrustsEmail(identity.Email)
+		user, err = provisionExternalUser(c.Request.Context(), h.userRepository, providerName, identity.Subject, identity.Email, identity.PreferredUsername, linkByEmail)
+		if errors.Is(err, errExternalEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists, log in to link the identity to it"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+			return
+		}
+	}
+
+	if err := h.sessions.issue(c, user); err != nil {
+		abortWithSessionError(c, err)
+		return
+	}
+
+	if redirectURL := os.Getenv("LOGIN_REDIRECT_URL"); redirectURL != "" {
+		c.Redirect(http.StatusFound, redirectURL)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+	})
+}
+
+func (h *OIDCHandler) completeLink(c *gin.Context, linkUserID string, linkedUser *models.User, providerName string, identity *auth.OIDCIdentity) {
+	// The flow cookie only says who started the link, the session proves it.
+	// The callback isn't behind the auth middleware, so its checks are run
+	// here.
+	accessToken, err := c.Cookie("access_token")
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := middlewares.Authenticate(c.Request.Context(), h.userRepository, accessToken)
+	var authErr *middlewares.AuthError
+	if errors.As(err, &authErr) {
+		c.JSON(authErr.Status, gin.H{"error": authErr.Message})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if user.ID.Hex() != linkUserID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+
+	if linkedUser != nil {
+		if linkedUser.ID.Hex() == linkUserID {
+			c.JSON(http.StatusOK, gin.H{"message": "Identity already linked"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Identity is linked to another account"})
+		return
+	}
+
+	err = repositories.UpdateUser(c.Request.Context(), h.userRepository, user, func(user *models.User) error {
+		user.LinkIdentity(providerName, identity.Subject)
+		return nil
+	})
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to link identity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Identity linked successfully",
+		"user":    user.ToResponse(),
+	})
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const oidcTestProvider = "mock"
+
+// oidcIdentity is what the mock provider asserts in the ID token.
+type oidcIdentity struct {
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+// authorization is a code issued by the mock provider, waiting to be
+// exchanged.
+type authorization struct {
+	identity  oidcIdentity
+	nonce     string
+	challenge string
+}
+
+// mockOIDCProvider is an OpenID Connect provider issuing ID tokens signed with
+// its own key, and checking the PKCE verifier on the code exchange.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &mockOIDCProvider{key: key, codes: map[string]authorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("/jwks", provider.jwks)
+	mux.HandleFunc("/token", provider.token)
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func (p *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := p.server.URL
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"jwks_uri":                              issuer + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(value *big.Int) string { return base64.RawURLEncoding.EncodeToString(value.Bytes()) }
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(p.key.N),
+			"e":   encode(big.NewInt(int64(p.key.E))),
+		}},
+	})
+}
+
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	code := r.PostFormValue("code")
+	authorization, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            "client",
+		"sub":            authorization.identity.subject,
+		"email":          authorization.identity.email,
+		"email_verified": authorization.identity.emailVerified,
+		"nonce":          authorization.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+// authorize issues a code for the identity, as the provider would once the
+// user logged in, with the nonce and PKCE challenge of the authorization
+// request.
+func (p *mockOIDCProvider) authorize(t *testing.T, authorizationURL *url.URL, identity oidcIdentity) string {
+	t.Helper()
+	code, err := auth.RandomString(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := authorizationURL.Query()
+	p.mu.Lock()
+	p.codes[code] = authorization{identity: identity, nonce: query.Get("nonce"), challenge: query.Get("code_challenge")}
+	p.mu.Unlock()
+	return code
+}
+
+// withOIDC rebuilds the router with the mock provider, trusted for the given
+// email domains.
+func (s *testServer) withOIDC(provider *mockOIDCProvider, trustedDomains ...string) {
+	s.t.Helper()
+	oidcProvider, err := auth.NewOIDCProvider(context.Background(), auth.OIDCProviderConfig{
+		Name:           oidcTestProvider,
+		Issuer:         provider.server.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		RedirectURL:    "http://localhost:8080/api/auth/oidc/" + oidcTestProvider + "/callback",
+		TrustedDomains: trustedDomains,
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.deps.OIDCProviders = map[string]*auth.OIDCProvider{oidcTestProvider: oidcProvider}
+	s.handler = NewRouter(s.deps)
+}
+
+// oidcFlow is a flow started by the browser, with the cookie that binds it.
+type oidcFlow struct {
+	authorizationURL *url.URL
+	cookie           *http.Cookie
+}
+
+// startOIDC starts a login, or a link with the given session.
+func (s *testServer) startOIDC(path string, cookies ...*http.Cookie) oidcFlow {
+	s.t.Helper()
+	res := s.do(http.MethodGet, path, nil, cookies...)
+	expectStatus(s.t, res, http.StatusFound)
+
+	authorizationURL, err := url.Parse(res.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	flowCookie := cookie(res, "oidc_flow")
+	if flowCookie == nil {
+		s.t.Fatal("flow cookie not set")
+	}
+	return oidcFlow{authorizationURL: authorizationURL, cookie: flowCookie}
+}
+
+// callback returns to the service with the code and state, as the provider
+// redirects the browser.
+func (s *testServer) callback(code, state string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	s.t.Helper()
+	query := url.Values{"code": {code}, "state": {state}}
+	return s.do(http.MethodGet, "/api/auth/oidc/"+oidcTestProvider+"/callback?"+query.Encode(), nil, cookies...)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s := newTestServer(t)
+	provider := newMockOIDCProvider(t)
+	s.withOIDC(provider)
+	loginPath := "/api/auth/oidc/" + oidcTestProvider + "/login"
+	alice := oidcIdentity{subject: "alice-oidc", email: "alice@example.com", emailVerified: true}
+
+	t.Run("Valid", func(t *testing.T) {
+		flow := s.startOIDC(loginPath)
+		code := provider.authorize(t, flow.authorizationURL, alice)
+
+		res := s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie)
+		expectStatus(t, res, http.StatusOK)
+		sessionCookies(t, res)
+
+		user, err := s.deps.UserRepository.FindByIdentity(context.Background(), oidcTestProvider, alice.subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != alice.email || !user.EmailVerified {
+			t.Errorf("provisioned user = %+v", user)
+		}
+	})
+
+	t.Run("WrongState", func(t *testing.T) {
+		flow := s.startOIDC(loginPath)
+		code := provider.authorize(t, flow.authorizationURL, alice)
+		expectStatus(t, s.callback(code, "forged-state", flow.cookie), http.StatusBadRequest)
+	})
+
+	t.Run("WithoutFlowCookie", func(t *testing.T) {
+		flow := s.startOIDC(loginPath)
+		code := provider.authorize(t, flow.authorizationURL, alice)
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state")), http.StatusBadRequest)
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		flow, other := s.startOIDC(loginPath), s.startOIDC(loginPath)
+		query := flow.authorizationURL.Query()
+		query.Set("nonce", other.authorizationURL.Query().Get("nonce"))
+		forged := *flow.authorizationURL
+		forged.RawQuery = query.Encode()
+
+		code := provider.authorize(t, &forged, alice)
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie), http.StatusUnauthorized)
+	})
+
+	t.Run("WrongVerifier", func(t *testing.T) {
+		// The code was issued for another flow, whose PKCE verifier this
+		// browser doesn't have.
+		flow, other := s.startOIDC(loginPath), s.startOIDC(loginPath)
+		query := flow.authorizationURL.Query()
+		query.Set("code_challenge", other.authorizationURL.Query().Get("code_challenge"))
+		forged := *flow.authorizationURL
+		forged.RawQuery = query.Encode()
+
+		code := provider.authorize(t, &forged, alice)
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie), http.StatusUnauthorized)
+	})
+
+	t.Run("UnverifiedEmail", func(t *testing.T) {
+		flow := s.startOIDC(loginPath)
+		code := provider.authorize(t, flow.authorizationURL, oidcIdentity{subject: "carol-oidc", email: "carol@example.com"})
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie), http.StatusForbidden)
+	})
+
+	t.Run("ExistingEmail", func(t *testing.T) {
+		s.register("bob@example.com", "bob0001", "correct-horse")
+
+		flow := s.startOIDC(loginPath)
+		code := provider.authorize(t, flow.authorizationURL, oidcIdentity{subject: "bob-oidc", email: "bob@example.com", emailVerified: true})
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie), http.StatusConflict)
+	})
+
+	t.Run("TrustedDomain", func(t *testing.T) {
+		trusted := newTestServer(t)
+		trusted.withOIDC(provider, "example.com")
+		trusted.register("bob@example.com", "bob0001", "correct-horse")
+
+		flow := trusted.startOIDC(loginPath)
+		code := provider.authorize(t, flow.authorizationURL, oidcIdentity{subject: "bob-oidc", email: "bob@example.com", emailVerified: true})
+		expectStatus(t, trusted.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie), http.StatusOK)
+
+		user, err := trusted.deps.UserRepository.FindByIdentity(context.Background(), oidcTestProvider, "bob-oidc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "bob0001" {
+			t.Errorf("identity linked to %s, want bob0001", user.Username)
+		}
+	})
+}
+
+func TestOIDCLink(t *testing.T) {
+	s := newTestServer(t)
+	provider := newMockOIDCProvider(t)
+	s.withOIDC(provider)
+	linkPath := "/api/user/identities/" + oidcTestProvider + "/link"
+
+	s.register("alice@example.com", "alice01", "correct-horse")
+	aliceToken, _ := s.logon("alice@example.com", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+	bobToken, _ := s.logon("bob@example.com", "correct-horse")
+
+	// The identity may have any email, the session proves who links it.
+	identity := oidcIdentity{subject: "alice-oidc", email: "alice@elsewhere.example.com"}
+
+	link := func(t *testing.T, flowToken, callbackToken *http.Cookie, identity oidcIdentity) *httptest.ResponseRecorder {
+		t.Helper()
+		flow := s.startOIDC(linkPath, flowToken)
+		code := provider.authorize(t, flow.authorizationURL, identity)
+		return s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie, callbackToken)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		expectStatus(t, link(t, aliceToken, aliceToken, identity), http.StatusOK)
+
+		user, err := s.deps.UserRepository.FindByIdentity(context.Background(), oidcTestProvider, identity.subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "alice01" {
+			t.Errorf("identity linked to %s, want alice01", user.Username)
+		}
+	})
+
+	t.Run("AlreadyLinked", func(t *testing.T) {
+		res := link(t, aliceToken, aliceToken, identity)
+		expectStatus(t, res, http.StatusOK)
+
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil || body.Message != "Identity already linked" {
+			t.Errorf("body = %s", res.Body)
+		}
+	})
+
+	t.Run("LinkedToAnotherAccount", func(t *testing.T) {
+		expectStatus(t, link(t, bobToken, bobToken, identity), http.StatusConflict)
+	})
+
+	t.Run("OtherSession", func(t *testing.T) {
+		// Bob's session can't complete a link started by Alice.
+		other := oidcIdentity{subject: "other-oidc", email: "other@example.com"}
+		expectStatus(t, link(t, aliceToken, bobToken, other), http.StatusUnauthorized)
+	})
+
+	t.Run("WithoutSession", func(t *testing.T) {
+		flow := s.startOIDC(linkPath, aliceToken)
+		code := provider.authorize(t, flow.authorizationURL, oidcIdentity{subject: "other-oidc"})
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie), http.StatusUnauthorized)
+	})
+
+	t.Run("RevokedSession", func(t *testing.T) {
+		flow := s.startOIDC(linkPath, bobToken)
+		code := provider.authorize(t, flow.authorizationURL, oidcIdentity{subject: "bob-oidc"})
+
+		// Changing the password logs out everywhere, which must also reject
+		// the session the link was started with.
+		res := s.do(http.MethodPut, "/api/user/password", gin.H{"current_password": "correct-horse", "new_password": "battery-staple"}, bobToken)
+		expectStatus(t, res, http.StatusOK)
+
+		expectStatus(t, s.callback(code, flow.authorizationURL.Query().Get("state"), flow.cookie, bobToken), http.StatusUnauthorized)
+	})
+}
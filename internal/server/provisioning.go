@@ -0,0 +1,58 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+)
+
+// errExternalEmailTaken is returned when an identity not linked yet has the
+// email of an existing account, which the provider isn't trusted to claim.
+var errExternalEmailTaken = errors.New("email of the external identity is already registered")
+
+// provisionExternalUser resolves the local user for an identity asserted by an
+// upstream provider. A new account is created just in time, unless there is
+// already one with the same email: the identity is linked to it if
+// linkByEmail, which callers only set when the provider is trusted for the
+// domain of the email, otherwise errExternalEmailTaken is returned. Callers
+// must only pass emails the provider has verified.
+func provisionExternalUser(ctx context.Context, userRepository repositories.UserRepositoryInterface, provider, subject, email, preferredUsername string, linkByEmail bool) (*models.User, error) {
+	user, err := userRepository.FindByEmail(ctx, email)
+	if err == nil {
+		if !linkByEmail {
+			return nil, errExternalEmailTaken
+		}
+
+		err := repositories.UpdateUser(ctx, userRepository, user, func(user *models.User) error {
+			user.LinkIdentity(provider, subject)
+			user.EmailVerified = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return nil, err
+	}
+
+	user, err = models.NewExternalUser(auth.ExternalUsername(preferredUsername, email), email)
+	if err != nil {
+		return nil, err
+	}
+	user.LinkIdentity(provider, subject)
+	user.EmailVerified = true
+
+	if err := auth.CreateExternalUser(ctx, userRepository, user); err != nil {
+		if errors.Is(err, repositories.ErrDuplicateEmail) {
+			return nil, errExternalEmailTaken
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	samlProvider      = "saml"
+	samlRequestCookie = "saml_request"
+	samlRequestTTL    = 10 * time.Minute
+)
+
+type SAMLHandler struct {
+	serviceProvider       *auth.SAMLServiceProvider
+	samlRequestRepository repositories.SAMLRequestRepositoryInterface
+	userRepository        repositories.UserRepositoryInterface
+	sessions              *sessions
+}
+
+func newSAMLHandler(serviceProvider *auth.SAMLServiceProvider, samlRequestRepository repositories.SAMLRequestRepositoryInterface, userRepository repositories.UserRepositoryInterface, sessions *sessions) *SAMLHandler {
+	return &SAMLHandler{
+		serviceProvider:       serviceProvider,
+		samlRequestRepository: samlRequestRepository,
+		userRepository:        userRepository,
+		sessions:              sessions,
+	}
+}
+
+func (h *SAMLHandler) Metadata(c *gin.Context) {
+	metadata, err := h.serviceProvider.Metadata()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate metadata"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+func (h *SAMLHandler) Login(c *gin.Context) {
+	redirectURL, requestID, err := h.serviceProvider.AuthnRequestURL()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create authentication request"})
+		return
+	}
+
+	request := models.NewSAMLRequest(requestID, time.Now().Add(samlRequestTTL))
+	if err := h.samlRequestRepository.Create(c.Request.Context(), request); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store authentication request"})
+		return
+	}
+
+	// The IdP posts the response from its own site, so the cookie must be
+	// sent on cross-site requests, which browsers only allow for secure
+	// cookies. They treat http://localhost as secure.
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(
+		samlRequestCookie,
+		auth.HashToken(requestID),
+		int(samlRequestTTL.Seconds()),
+		"/api/auth/saml",
+		"localhost", // domain
+		true,        // secure
+		true,        // httpOnly
+	)
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// AssertionConsumerService handles the HTTP-POST binding response. The request
+// ID travels in RelayState and is consumed before the assertion is accepted,
+// so each AuthnRequest can be answered only once. It must match the cookie set
+// by Login, so a response obtained by someone else can't log the browser into
+// their account.
+func (h *SAMLHandler) AssertionConsumerService(c *gin.Context) {
+	requestID := c.PostForm("RelayState")
+	if requestID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing RelayState"})
+		return
+	}
+
+	requestHash, err := c.Cookie(samlRequestCookie)
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(samlRequestCookie, "", -1, "/api/auth/saml", "localhost", true, true)
+	if err != nil || subtle.ConstantTimeCompare([]byte(requestHash), []byte(auth.HashToken(requestID))) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication request was started in another browser"})
+		return
+	}
+
+	request, err := h.samlRequestRepository.Consume(c.Request.Context(), requestID)
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && request.IsExpired()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unknown, expired or already used authentication request"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve authentication request"})
+		return
+	}
+
+	assertion, err := h.serviceProvider.ParseResponse(c.Request, request.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid SAML response"})
+		return
+	}
+
+	user, err := h.userRepository.FindByIdentity(c.Request.Context(), samlProvider, assertion.NameID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+
+	if errors.Is(err, repositories.ErrNotFound) {
+		if assertion.Email == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "SAML assertion has no email attribute"})
+			return
+		}
+
+		linkByEmail := h.serviceProvider.TrustsEmail(assertion.Email)
+		user, err = provisionExternalUser(c.Request.Context(), h.userRepository, samlProvider, assertion.NameID, assertion.Email, assertion.Username, linkByEmail)
+		if errors.Is(err, errExternalEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+			return
+		}
+	}
+
+	if err := h.sessions.issue(c, user); err != nil {
+		abortWithSessionError(c, err)
+		return
+	}
+
+	if redirectURL := os.Getenv("LOGIN_REDIRECT_URL"); redirectURL != "" {
+		c.Redirect(http.StatusFound, redirectURL)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
+	})
+}
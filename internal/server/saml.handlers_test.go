@@ -0,0 +1,316 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+const samlRootURL = "http://localhost:8080"
+
+// testIdP signs SAML responses for the service provider of the test server.
+type testIdP struct {
+	idp *saml.IdentityProvider
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, certificate := newTestKeyPair(t, "idp")
+	return &testIdP{idp: &saml.IdentityProvider{
+		Key:         key,
+		Certificate: certificate,
+		MetadataURL: url.URL{Scheme: "https", Host: "idp.example.com", Path: "/metadata"},
+		SSOURL:      url.URL{Scheme: "https", Host: "idp.example.com", Path: "/sso"},
+	}}
+}
+
+// newTestKeyPair makes an RSA key with a self-signed certificate.
+func newTestKeyPair(t *testing.T, name string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certificate
+}
+
+// withSAML rebuilds the router with a service provider trusting the IdP for
+// the given email domains.
+func (s *testServer) withSAML(idp *testIdP, trustedDomains ...string) {
+	s.t.Helper()
+	dir := s.t.TempDir()
+	key, certificate := newTestKeyPair(s.t, "sp")
+
+	metadata, err := xml.Marshal(idp.idp.Metadata())
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"sp.key":       pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		"sp.crt":       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}),
+		"idp-metadata": metadata,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+
+	serviceProvider, err := auth.NewSAMLServiceProvider(auth.SAMLConfig{
+		RootURL:         samlRootURL,
+		EntityID:        samlRootURL + "/api/auth/saml/metadata",
+		KeyFile:         filepath.Join(dir, "sp.key"),
+		CertFile:        filepath.Join(dir, "sp.crt"),
+		IDPMetadataFile: filepath.Join(dir, "idp-metadata"),
+		TrustedDomains:  trustedDomains,
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.deps.SAMLServiceProvider = serviceProvider
+	s.handler = NewRouter(s.deps)
+}
+
+// samlLogin starts a login and returns the ID of the AuthnRequest, which
+// comes back in RelayState, and the cookie binding it to the browser.
+func (s *testServer) samlLogin() (string, *http.Cookie) {
+	s.t.Helper()
+	res := s.do(http.MethodGet, "/api/auth/saml/login", nil)
+	expectStatus(s.t, res, http.StatusFound)
+	requestCookie := cookie(res, samlRequestCookie)
+	if requestCookie == nil {
+		s.t.Fatal("no request cookie set")
+	}
+
+	location, err := url.Parse(res.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	requestID := location.Query().Get("RelayState")
+	if requestID == "" {
+		s.t.Fatalf("no RelayState in %s", location)
+	}
+	return requestID, requestCookie
+}
+
+// samlAssertion is what the IdP asserts, with the fields that tests break.
+type samlAssertion struct {
+	requestID string
+	nameID    string
+	email     string
+	audience  string
+	issuedAt  time.Time
+}
+
+func newSAMLAssertion(requestID, nameID, email string) samlAssertion {
+	return samlAssertion{
+		requestID: requestID,
+		nameID:    nameID,
+		email:     email,
+		audience:  samlRootURL + "/api/auth/saml/metadata",
+		issuedAt:  time.Now(),
+	}
+}
+
+// samlResponse returns the base64 SAMLResponse of the IdP for the assertion.
+func (i *testIdP) samlResponse(t *testing.T, assertion samlAssertion) string {
+	t.Helper()
+	acsURL := samlRootURL + "/api/auth/saml/acs"
+	req := &saml.IdpAuthnRequest{
+		IDP:                     i.idp,
+		Request:                 saml.AuthnRequest{ID: assertion.requestID},
+		ServiceProviderMetadata: &saml.EntityDescriptor{EntityID: assertion.audience},
+		SPSSODescriptor:         &saml.SPSSODescriptor{},
+		ACSEndpoint:             &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: acsURL},
+		Now:                     assertion.issuedAt,
+	}
+	req.Assertion = &saml.Assertion{
+		ID:           fmt.Sprintf("id-%d", assertion.issuedAt.UnixNano()),
+		IssueInstant: assertion.issuedAt,
+		Version:      "2.0",
+		Issuer:       saml.Issuer{Value: i.idp.MetadataURL.String()},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Format: string(saml.PersistentNameIDFormat), Value: assertion.nameID},
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: assertion.requestID,
+					NotOnOrAfter: assertion.issuedAt.Add(saml.MaxIssueDelay),
+					Recipient:    acsURL,
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore:            assertion.issuedAt.Add(-saml.MaxClockSkew),
+			NotOnOrAfter:         assertion.issuedAt.Add(saml.MaxIssueDelay),
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: assertion.audience}}},
+		},
+		AttributeStatements: []saml.AttributeStatement{{
+			Attributes: []saml.Attribute{
+				{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: assertion.email}}},
+				{Name: "uid", Values: []saml.AttributeValue{{Type: "xs:string", Value: assertion.nameID}}},
+			},
+		}},
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.SAMLResponse
+}
+
+// postSAMLResponse posts the response to the ACS as the browser would.
+func (s *testServer) postSAMLResponse(samlResponse, relayState string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	s.t.Helper()
+	form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {relayState}}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/saml/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", s.userAgent)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestSAMLAssertionConsumerService(t *testing.T) {
+	s := newTestServer(t)
+	idp := newTestIdP(t)
+	s.withSAML(idp)
+
+	t.Run("Valid", func(t *testing.T) {
+		requestID, requestCookie := s.samlLogin()
+		samlResponse := idp.samlResponse(t, newSAMLAssertion(requestID, "alice-saml", "alice@corp.example.com"))
+
+		res := s.postSAMLResponse(samlResponse, requestID, requestCookie)
+		expectStatus(t, res, http.StatusOK)
+		sessionCookies(t, res)
+
+		user, err := s.deps.UserRepository.FindByIdentity(context.Background(), samlProvider, "alice-saml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "alice@corp.example.com" || !user.EmailVerified {
+			t.Errorf("provisioned user = %+v", user)
+		}
+
+		// The request ID was consumed by the first response.
+		expectStatus(t, s.postSAMLResponse(samlResponse, requestID, requestCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("UnknownRequest", func(t *testing.T) {
+		samlResponse := idp.samlResponse(t, newSAMLAssertion("id-unknown", "alice-saml", "alice@corp.example.com"))
+		requestCookie := &http.Cookie{Name: samlRequestCookie, Value: auth.HashToken("id-unknown")}
+		expectStatus(t, s.postSAMLResponse(samlResponse, "id-unknown", requestCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("OtherRequest", func(t *testing.T) {
+		requestID, requestCookie := s.samlLogin()
+		otherID, _ := s.samlLogin()
+		samlResponse := idp.samlResponse(t, newSAMLAssertion(otherID, "alice-saml", "alice@corp.example.com"))
+		expectStatus(t, s.postSAMLResponse(samlResponse, requestID, requestCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("WithoutCookie", func(t *testing.T) {
+		requestID, _ := s.samlLogin()
+		samlResponse := idp.samlResponse(t, newSAMLAssertion(requestID, "alice-saml", "alice@corp.example.com"))
+		expectStatus(t, s.postSAMLResponse(samlResponse, requestID), http.StatusUnauthorized)
+	})
+
+	t.Run("OtherBrowser", func(t *testing.T) {
+		// The response to a login started by an attacker, posted from the
+		// browser of a victim who started their own.
+		requestID, _ := s.samlLogin()
+		_, victimCookie := s.samlLogin()
+		samlResponse := idp.samlResponse(t, newSAMLAssertion(requestID, "mallory-saml", "mallory@corp.example.com"))
+		expectStatus(t, s.postSAMLResponse(samlResponse, requestID, victimCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("WrongSignature", func(t *testing.T) {
+		requestID, requestCookie := s.samlLogin()
+		samlResponse := newTestIdP(t).samlResponse(t, newSAMLAssertion(requestID, "alice-saml", "alice@corp.example.com"))
+		expectStatus(t, s.postSAMLResponse(samlResponse, requestID, requestCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("WrongAudience", func(t *testing.T) {
+		requestID, requestCookie := s.samlLogin()
+		assertion := newSAMLAssertion(requestID, "alice-saml", "alice@corp.example.com")
+		assertion.audience = "https://other.example.com/saml/metadata"
+		expectStatus(t, s.postSAMLResponse(idp.samlResponse(t, assertion), requestID, requestCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		requestID, requestCookie := s.samlLogin()
+		assertion := newSAMLAssertion(requestID, "alice-saml", "alice@corp.example.com")
+		assertion.issuedAt = time.Now().Add(-time.Hour)
+		expectStatus(t, s.postSAMLResponse(idp.samlResponse(t, assertion), requestID, requestCookie), http.StatusUnauthorized)
+	})
+
+	t.Run("ExistingEmail", func(t *testing.T) {
+		s.register("bob@corp.example.com", "bob0001", "correct-horse")
+
+		requestID, requestCookie := s.samlLogin()
+		samlResponse := idp.samlResponse(t, newSAMLAssertion(requestID, "bob-saml", "bob@corp.example.com"))
+		expectStatus(t, s.postSAMLResponse(samlResponse, requestID, requestCookie), http.StatusConflict)
+
+		if _, err := s.deps.UserRepository.FindByIdentity(context.Background(), samlProvider, "bob-saml"); err == nil {
+			t.Error("identity linked to the account with the same email")
+		}
+	})
+
+	t.Run("TrustedDomain", func(t *testing.T) {
+		trusted := newTestServer(t)
+		trusted.withSAML(idp, "corp.example.com")
+		trusted.register("carol@corp.example.com", "carol01", "correct-horse")
+
+		requestID, requestCookie := trusted.samlLogin()
+		samlResponse := idp.samlResponse(t, newSAMLAssertion(requestID, "carol-saml", "carol@corp.example.com"))
+		expectStatus(t, trusted.postSAMLResponse(samlResponse, requestID, requestCookie), http.StatusOK)
+
+		user, err := trusted.deps.UserRepository.FindByIdentity(context.Background(), samlProvider, "carol-saml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "carol01" {
+			t.Errorf("identity linked to %s, want carol01", user.Username)
+		}
+	})
+}
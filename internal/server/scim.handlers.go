@@ -0,0 +1,492 @@
+package server
+
+import (
+	"authentication-jwt/internal/accounts"
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/scim"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type SCIMHandler struct {
+	baseURL         string
+	userRepository  repositories.UserRepositoryInterface
+	groupRepository repositories.GroupRepositoryInterface
+	purger          *accounts.Purger
+	eventBus        *events.Bus
+}
+
+func newSCIMHandler(baseURL string, userRepository repositories.UserRepositoryInterface, groupRepository repositories.GroupRepositoryInterface, purger *accounts.Purger, eventBus *events.Bus) *SCIMHandler {
+	return &SCIMHandler{
+		baseURL:         baseURL,
+		userRepository:  userRepository,
+		groupRepository: groupRepository,
+		purger:          purger,
+		eventBus:        eventBus,
+	}
+}
+
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	filter := repositories.UserFilter{}
+	if expression := c.Query("filter"); expression != "" {
+		attribute, value, err := scim.ParseFilter(expression)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+
+		switch attribute {
+		case "username":
+			filter.Username = value
+		case "externalid":
+			filter.ExternalID = value
+		case "emails", "emails.value":
+			filter.Email = value
+		default:
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidFilter", "Unsupported filter attribute"))
+			return
+		}
+	}
+
+	offset, limit := scim.ParsePagination(c.Query("startIndex"), c.Query("count"))
+
+	// MongoDB reads a limit of 0 as no limit, so with count=0 one user is
+	// listed for the total and left out.
+	users, total, err := h.userRepository.List(c.Request.Context(), filter, offset, max(limit, 1))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	resources := []any{}
+	for _, user := range users[:min(limit, len(users))] {
+		resources = append(resources, scim.NewUser(user, h.baseURL))
+	}
+
+	scim.WriteJSON(c.Writer, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	etag := scim.ETag(user.UpdatedAt)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Header("ETag", etag)
+	scim.WriteJSON(c.Writer, http.StatusOK, scim.NewUser(user, h.baseURL))
+}
+
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidSyntax", err.Error()))
+		return
+	}
+
+	email := resource.PrimaryEmail()
+
+	var user *models.User
+	var err error
+	if resource.Password != "" {
+		hashPassword, err := auth.HashPassword(c.Request.Context(), resource.Password)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+		user, err = models.NewUser(resource.UserName, email, hashPassword)
+		if err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", err.Error()))
+			return
+		}
+	} else {
+		user, err = models.NewExternalUser(resource.UserName, email)
+		if err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", err.Error()))
+			return
+		}
+	}
+	resource.ApplyTo(user)
+
+	err = h.eventBus.Commit(c.Request.Context(), func(ctx context.Context) error {
+		return h.userRepository.Create(ctx, user)
+	}, models.NewDomainEvent(models.EventUserRegistered, user, map[string]string{"source": "scim"}))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Header("ETag", scim.ETag(user.UpdatedAt))
+	c.Header("Location", h.baseURL+"/Users/"+user.ID.Hex())
+	c.Set("auditTargetID", user.ID.Hex())
+	scim.WriteJSON(c.Writer, http.StatusCreated, scim.NewUser(user, h.baseURL))
+}
+
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok || !checkIfMatch(c, user.UpdatedAt) {
+		return
+	}
+
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidSyntax", err.Error()))
+		return
+	}
+
+	username := user.Username
+	resource.ApplyTo(user)
+	h.saveUser(c, user, username)
+}
+
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok || !checkIfMatch(c, user.UpdatedAt) {
+		return
+	}
+
+	var patch scim.PatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidSyntax", err.Error()))
+		return
+	}
+
+	username := user.Username
+	if err := scim.ApplyUserPatch(user, patch.Operations); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	h.saveUser(c, user, username)
+}
+
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok || !checkIfMatch(c, user.UpdatedAt) {
+		return
+	}
+
+	if err := h.purger.Purge(c.Request.Context(), user, "scim"); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) findUser(c *gin.Context) (*models.User, bool) {
+	user, err := h.userRepository.FindById(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repositories.ErrNotFound) {
+		scimError(c, scim.NewError(http.StatusNotFound, "", "User not found"))
+		return nil, false
+	}
+
+	if err != nil {
+		scimError(c, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// saveUser stores the changes to the user, whose username was previously
+// username. A new username must pass the checks of a new account.
+func (h *SCIMHandler) saveUser(c *gin.Context, user *models.User, username string) {
+	if err := user.ValidateProfile(); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", err.Error()))
+		return
+	}
+
+	if user.Username != username {
+		if err := models.CheckUsername(user.Username); err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", err.Error()))
+			return
+		}
+	}
+
+	if err := h.userRepository.Update(c.Request.Context(), user); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Header("ETag", scim.ETag(user.UpdatedAt))
+	scim.WriteJSON(c.Writer, http.StatusOK, scim.NewUser(user, h.baseURL))
+}
+
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	filter := repositories.GroupFilter{}
+	if expression := c.Query("filter"); expression != "" {
+		attribute, value, err := scim.ParseFilter(expression)
+		if err != nil {
+			scimError(c, err)
+			return
+		}
+
+		switch attribute {
+		case "displayname":
+			filter.DisplayName = value
+		case "externalid":
+			filter.ExternalID = value
+		default:
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidFilter", "Unsupported filter attribute"))
+			return
+		}
+	}
+
+	offset, limit := scim.ParsePagination(c.Query("startIndex"), c.Query("count"))
+
+	// As for users, a limit of 0 would list every group.
+	groups, total, err := h.groupRepository.List(c.Request.Context(), filter, offset, max(limit, 1))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	resources := []any{}
+	for _, group := range groups[:min(limit, len(groups))] {
+		resources = append(resources, scim.NewGroup(group, h.baseURL))
+	}
+
+	scim.WriteJSON(c.Writer, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, ok := h.findGroup(c)
+	if !ok {
+		return
+	}
+
+	etag := scim.ETag(group.UpdatedAt)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Header("ETag", etag)
+	scim.WriteJSON(c.Writer, http.StatusOK, scim.NewGroup(group, h.baseURL))
+}
+
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var resource scim.Group
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidSyntax", err.Error()))
+		return
+	}
+
+	group, err := models.NewGroup(resource.DisplayName)
+	if err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", err.Error()))
+		return
+	}
+	group.ExternalID = resource.ExternalID
+
+	if !h.applyMembers(c, group, resource.Members) || !h.checkMembers(c, group, nil) {
+		return
+	}
+
+	if err := h.groupRepository.Create(c.Request.Context(), group); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Header("ETag", scim.ETag(group.UpdatedAt))
+	c.Header("Location", h.baseURL+"/Groups/"+group.ID.Hex())
+	c.Set("auditTargetID", group.ID.Hex())
+	scim.WriteJSON(c.Writer, http.StatusCreated, scim.NewGroup(group, h.baseURL))
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	group, ok := h.findGroup(c)
+	if !ok || !checkIfMatch(c, group.UpdatedAt) {
+		return
+	}
+
+	var resource scim.Group
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidSyntax", err.Error()))
+		return
+	}
+
+	previous := group.Members
+	group.DisplayName = resource.DisplayName
+	group.ExternalID = resource.ExternalID
+	group.Members = []bson.ObjectID{}
+	if !h.applyMembers(c, group, resource.Members) || !h.checkMembers(c, group, previous) {
+		return
+	}
+
+	h.saveGroup(c, group)
+}
+
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	group, ok := h.findGroup(c)
+	if !ok || !checkIfMatch(c, group.UpdatedAt) {
+		return
+	}
+
+	var patch scim.PatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidSyntax", err.Error()))
+		return
+	}
+
+	previous := slices.Clone(group.Members)
+	if err := scim.ApplyGroupPatch(group, patch.Operations); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	if !h.checkMembers(c, group, previous) {
+		return
+	}
+
+	h.saveGroup(c, group)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	group, ok := h.findGroup(c)
+	if !ok || !checkIfMatch(c, group.UpdatedAt) {
+		return
+	}
+
+	if err := h.groupRepository.Delete(c.Request.Context(), group.ID.Hex()); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) findGroup(c *gin.Context) (*models.Group, bool) {
+	group, err := h.groupRepository.FindById(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, repositories.ErrNotFound) {
+		scimError(c, scim.NewError(http.StatusNotFound, "", "Group not found"))
+		return nil, false
+	}
+
+	if err != nil {
+		scimError(c, err)
+		return nil, false
+	}
+
+	return group, true
+}
+
+func (h *SCIMHandler) applyMembers(c *gin.Context, group *models.Group, members []scim.Member) bool {
+	for _, member := range members {
+		memberID, err := bson.ObjectIDFromHex(member.Value)
+		if err != nil {
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", "Invalid member ID"))
+			return false
+		}
+		group.AddMember(memberID)
+	}
+	return true
+}
+
+// checkMembers rejects members of the group that are not users. Only those
+// not in previous, the members before the change, are looked up.
+func (h *SCIMHandler) checkMembers(c *gin.Context, group *models.Group, previous []bson.ObjectID) bool {
+	for _, member := range group.Members {
+		if slices.Contains(previous, member) {
+			continue
+		}
+
+		_, err := h.userRepository.FindById(c.Request.Context(), member.Hex())
+		if errors.Is(err, repositories.ErrNotFound) {
+			scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", "Member not found: "+member.Hex()))
+			return false
+		}
+
+		if err != nil {
+			scimError(c, err)
+			return false
+		}
+	}
+	return true
+}
+
+func (h *SCIMHandler) saveGroup(c *gin.Context, group *models.Group) {
+	if err := group.Validate(); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, "invalidValue", err.Error()))
+		return
+	}
+
+	if err := h.groupRepository.Update(c.Request.Context(), group); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Header("ETag", scim.ETag(group.UpdatedAt))
+	scim.WriteJSON(c.Writer, http.StatusOK, scim.NewGroup(group, h.baseURL))
+}
+
+// checkIfMatch enforces optimistic concurrency when the client sends
+// If-Match with the version it last read.
+func checkIfMatch(c *gin.Context, updatedAt time.Time) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+
+	if ifMatch != scim.ETag(updatedAt) {
+		scimError(c, scim.NewError(http.StatusPreconditionFailed, "", "Resource was modified"))
+		return false
+	}
+
+	return true
+}
+
+func scimError(c *gin.Context, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		switch {
+		case errors.Is(err, repositories.ErrNotFound):
+			scimErr = scim.NewError(http.StatusNotFound, "", "Resource not found")
+		case errors.Is(err, repositories.ErrDuplicateEmail):
+			scimErr = scim.NewError(http.StatusConflict, "uniqueness", "email already exists")
+		case errors.Is(err, repositories.ErrDuplicateUsername):
+			scimErr = scim.NewError(http.StatusConflict, "uniqueness", "userName already exists")
+		case errors.Is(err, repositories.ErrConflict):
+			scimErr = scim.NewError(http.StatusConflict, "uniqueness", "Resource already exists")
+		case errors.Is(err, repositories.ErrStale):
+			scimErr = scim.NewError(http.StatusPreconditionFailed, "", "Resource was modified concurrently")
+		default:
+			scimErr = scim.NewError(http.StatusInternalServerError, "", "Internal server error")
+		}
+	}
+
+	status, err := strconv.Atoi(scimErr.Status)
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+
+	scim.WriteJSON(c.Writer, status, scimErr)
+}
@@ -0,0 +1,430 @@
+package server
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/scim"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// withSCIM rebuilds the router with the SCIM API enabled.
+func (s *testServer) withSCIM() {
+	s.t.Helper()
+	s.t.Setenv("SCIM_BEARER_TOKEN", "scim-token")
+	s.t.Setenv("SCIM_BASE_URL", "http://localhost:8080/scim/v2")
+	s.handler = NewRouter(s.deps)
+}
+
+// scimDo sends the request to the SCIM API with the bearer token and the
+// body, encoded as JSON unless nil.
+func (s *testServer) scimDo(method, path string, body any) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.serve(s.scimRequest(method, path, body))
+}
+
+// scimRequest builds the request scimDo sends, for tests to change its headers.
+func (s *testServer) scimRequest(method, path string, body any) *http.Request {
+	s.t.Helper()
+	var reader bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader.Reset(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/scim+json")
+	req.Header.Set("Authorization", "Bearer scim-token")
+	return req
+}
+
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	s.handler.ServeHTTP(res, req)
+	return res
+}
+
+// scimList lists the resources at the path and decodes the list response.
+func (s *testServer) scimList(path string) map[string]json.RawMessage {
+	s.t.Helper()
+	res := s.scimDo(http.MethodGet, path, nil)
+	expectStatus(s.t, res, http.StatusOK)
+
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(res.Body.Bytes(), &list); err != nil {
+		s.t.Fatal(err)
+	}
+	return list
+}
+
+func TestSCIMListPagination(t *testing.T) {
+	s := newTestServer(t)
+	s.withSCIM()
+	s.register("alice@example.com", "alice01", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+	for _, name := range []string{"Engineering", "Sales"} {
+		group, err := models.NewGroup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.deps.GroupRepository.Create(context.Background(), group); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, resource := range []string{"Users", "Groups"} {
+		t.Run(resource, func(t *testing.T) {
+			for query, want := range map[string]struct {
+				total, itemsPerPage string
+				resources           bool
+			}{
+				"":                      {"2", "2", true},
+				"?count=1":              {"2", "1", true},
+				"?count=0":              {"2", "0", false},
+				"?count=-1":             {"2", "0", false},
+				"?count=0&startIndex=2": {"2", "0", false},
+			} {
+				list := s.scimList("/scim/v2/" + resource + query)
+				_, resources := list["Resources"]
+				if string(list["totalResults"]) != want.total || string(list["itemsPerPage"]) != want.itemsPerPage || resources != want.resources {
+					t.Errorf("list%s = totalResults %s, itemsPerPage %s, Resources %t", query, list["totalResults"], list["itemsPerPage"], resources)
+				}
+			}
+		})
+	}
+}
+
+func TestSCIMUsernameChecks(t *testing.T) {
+	s := newTestServer(t)
+	s.withSCIM()
+	s.register("alice@example.com", "alice01", "correct-horse")
+	path := "/scim/v2/Users/" + findUserID(t, s, "alice@example.com").Hex()
+
+	for _, username := range []string{"admin", "alice@example.org"} {
+		t.Run(username, func(t *testing.T) {
+			res := s.scimDo(http.MethodPut, path, gin.H{
+				"schemas":  []string{scim.UserSchema},
+				"userName": username,
+				"emails":   []gin.H{{"value": "alice@example.com", "primary": true}},
+			})
+			expectStatus(t, res, http.StatusBadRequest)
+			expectSCIMType(t, res, "invalidValue")
+
+			res = s.scimDo(http.MethodPatch, path, gin.H{
+				"schemas":    []string{scim.PatchOpSchema},
+				"Operations": []gin.H{{"op": "replace", "path": "userName", "value": username}},
+			})
+			expectStatus(t, res, http.StatusBadRequest)
+			expectSCIMType(t, res, "invalidValue")
+		})
+	}
+
+	user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "alice01" {
+		t.Errorf("username = %s, want alice01", user.Username)
+	}
+}
+
+func expectSCIMType(t *testing.T, res *httptest.ResponseRecorder, scimType string) {
+	t.Helper()
+	var body scim.Error
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.ScimType != scimType {
+		t.Errorf("scimType = %q, want %q", body.ScimType, scimType)
+	}
+}
+
+func TestSCIMAuth(t *testing.T) {
+	s := newTestServer(t)
+	s.withSCIM()
+
+	for name, authorization := range map[string]string{
+		"Missing":    "",
+		"WrongToken": "Bearer other-token",
+		"NotBearer":  "Basic c2NpbS10b2tlbg==",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := s.scimRequest(http.MethodGet, "/scim/v2/Users", nil)
+			req.Header.Set("Authorization", authorization)
+			res := s.serve(req)
+			expectStatus(t, res, http.StatusUnauthorized)
+			if res.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+
+	expectStatus(t, s.scimDo(http.MethodGet, "/scim/v2/Users", nil), http.StatusOK)
+}
+
+func TestSCIMUsers(t *testing.T) {
+	s := newTestServer(t)
+	s.withSCIM()
+
+	newUser := gin.H{
+		"schemas":    []string{scim.UserSchema},
+		"userName":   "alice01",
+		"externalId": "ext-alice",
+		"name":       gin.H{"givenName": "Alice", "familyName": "Smith"},
+		"emails":     []gin.H{{"value": "alice@example.com", "primary": true}},
+	}
+	res := s.scimDo(http.MethodPost, "/scim/v2/Users", newUser)
+	expectStatus(t, res, http.StatusCreated)
+	var created scim.User
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	path := "/scim/v2/Users/" + created.ID
+	if location := res.Header().Get("Location"); location != "http://localhost:8080"+path {
+		t.Errorf("Location = %s", location)
+	}
+	etag := res.Header().Get("ETag")
+
+	t.Run("DuplicateUserName", func(t *testing.T) {
+		duplicate := gin.H{
+			"schemas":  []string{scim.UserSchema},
+			"userName": "ALICE01",
+			"emails":   []gin.H{{"value": "alice@example.org", "primary": true}},
+		}
+		res := s.scimDo(http.MethodPost, "/scim/v2/Users", duplicate)
+		expectStatus(t, res, http.StatusConflict)
+		expectSCIMType(t, res, "uniqueness")
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		res := s.scimDo(http.MethodGet, path, nil)
+		expectStatus(t, res, http.StatusOK)
+		if res.Header().Get("ETag") != etag {
+			t.Errorf("ETag = %s, want %s", res.Header().Get("ETag"), etag)
+		}
+
+		req := s.scimRequest(http.MethodGet, path, nil)
+		req.Header.Set("If-None-Match", etag)
+		expectStatus(t, s.serve(req), http.StatusNotModified)
+
+		expectStatus(t, s.scimDo(http.MethodGet, "/scim/v2/Users/"+bson.NewObjectID().Hex(), nil), http.StatusNotFound)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		replaced := gin.H{
+			"schemas":  []string{scim.UserSchema},
+			"userName": "alice02",
+			"name":     gin.H{"givenName": "Alice", "familyName": "Jones"},
+			"emails":   []gin.H{{"value": "alice@example.com", "primary": true}},
+		}
+		req := s.scimRequest(http.MethodPut, path, replaced)
+		req.Header.Set("If-Match", etag)
+		res := s.serve(req)
+		expectStatus(t, res, http.StatusOK)
+		etag = res.Header().Get("ETag")
+
+		user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username != "alice02" || user.FamilyName != "Jones" || user.ExternalID != "" {
+			t.Errorf("user = %s %s %q after replace", user.Username, user.FamilyName, user.ExternalID)
+		}
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		res := s.scimDo(http.MethodPatch, path, gin.H{
+			"schemas": []string{scim.PatchOpSchema},
+			"Operations": []gin.H{
+				{"op": "replace", "path": "active", "value": false},
+				{"op": "add", "path": "name.givenName", "value": "Ally"},
+			},
+		})
+		expectStatus(t, res, http.StatusOK)
+		etag = res.Header().Get("ETag")
+
+		user, err := s.deps.UserRepository.FindByEmail(context.Background(), "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.Disabled || user.GivenName != "Ally" {
+			t.Errorf("user disabled %t, given name %s after patch", user.Disabled, user.GivenName)
+		}
+
+		res = s.scimDo(http.MethodPatch, path, gin.H{
+			"schemas":    []string{scim.PatchOpSchema},
+			"Operations": []gin.H{{"op": "replace", "path": "title", "value": "Engineer"}},
+		})
+		expectStatus(t, res, http.StatusBadRequest)
+		expectSCIMType(t, res, "invalidPath")
+	})
+
+	t.Run("StaleIfMatch", func(t *testing.T) {
+		stale := scim.ETag(time.Unix(0, 0))
+		for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			req := s.scimRequest(method, path, gin.H{
+				"schemas":    []string{scim.UserSchema, scim.PatchOpSchema},
+				"userName":   "alice03",
+				"emails":     []gin.H{{"value": "alice@example.com", "primary": true}},
+				"Operations": []gin.H{{"op": "replace", "path": "userName", "value": "alice03"}},
+			})
+			req.Header.Set("If-Match", stale)
+			expectStatus(t, s.serve(req), http.StatusPreconditionFailed)
+		}
+
+		if _, err := s.deps.UserRepository.FindByUsername(context.Background(), "alice02"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := s.scimRequest(http.MethodDelete, path, nil)
+		req.Header.Set("If-Match", etag)
+		expectStatus(t, s.serve(req), http.StatusNoContent)
+
+		expectStatus(t, s.scimDo(http.MethodGet, path, nil), http.StatusNotFound)
+		expectStatus(t, s.scimDo(http.MethodDelete, path, nil), http.StatusNotFound)
+	})
+}
+
+func TestSCIMFilter(t *testing.T) {
+	s := newTestServer(t)
+	s.withSCIM()
+	s.register("alice@example.com", "alice01", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+
+	for filter, total := range map[string]string{
+		`userName eq "alice01"`:             "1",
+		`USERNAME eq "Alice01"`:             "1",
+		`emails.value eq "bob@example.com"`: "1",
+		`emails eq "carol@example.com"`:     "0",
+	} {
+		list := s.scimList("/scim/v2/Users?filter=" + url.QueryEscape(filter))
+		if string(list["totalResults"]) != total {
+			t.Errorf("filter %s: totalResults = %s, want %s", filter, list["totalResults"], total)
+		}
+	}
+
+	for _, filter := range []string{
+		`userName co "alice"`,
+		`userName sw "a"`,
+		`userName pr`,
+		`userName eq "alice01" or userName eq "bob0001"`,
+		`name.givenName eq "Alice"`,
+	} {
+		res := s.scimDo(http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(filter), nil)
+		expectStatus(t, res, http.StatusBadRequest)
+		expectSCIMType(t, res, "invalidFilter")
+	}
+}
+
+func TestSCIMGroups(t *testing.T) {
+	s := newTestServer(t)
+	s.withSCIM()
+	s.register("alice@example.com", "alice01", "correct-horse")
+	s.register("bob@example.com", "bob0001", "correct-horse")
+	alice := findUserID(t, s, "alice@example.com").Hex()
+	bob := findUserID(t, s, "bob@example.com").Hex()
+	unknown := bson.NewObjectID().Hex()
+
+	t.Run("UnknownMember", func(t *testing.T) {
+		res := s.scimDo(http.MethodPost, "/scim/v2/Groups", gin.H{
+			"schemas":     []string{scim.GroupSchema},
+			"displayName": "Sales",
+			"members":     []gin.H{{"value": alice}, {"value": unknown}},
+		})
+		expectStatus(t, res, http.StatusBadRequest)
+		expectSCIMType(t, res, "invalidValue")
+	})
+
+	res := s.scimDo(http.MethodPost, "/scim/v2/Groups", gin.H{
+		"schemas":     []string{scim.GroupSchema},
+		"displayName": "Engineering",
+		"members":     []gin.H{{"value": alice}},
+	})
+	expectStatus(t, res, http.StatusCreated)
+	var created scim.Group
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	path := "/scim/v2/Groups/" + created.ID
+
+	members := func(t *testing.T) []string {
+		t.Helper()
+		group, err := s.deps.GroupRepository.FindById(context.Background(), created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var members []string
+		for _, member := range group.Members {
+			members = append(members, member.Hex())
+		}
+		return members
+	}
+
+	t.Run("Patch", func(t *testing.T) {
+		res := s.scimDo(http.MethodPatch, path, gin.H{
+			"schemas":    []string{scim.PatchOpSchema},
+			"Operations": []gin.H{{"op": "add", "path": "members", "value": []gin.H{{"value": unknown}}}},
+		})
+		expectStatus(t, res, http.StatusBadRequest)
+		expectSCIMType(t, res, "invalidValue")
+
+		res = s.scimDo(http.MethodPatch, path, gin.H{
+			"schemas": []string{scim.PatchOpSchema},
+			"Operations": []gin.H{
+				{"op": "add", "path": "members", "value": []gin.H{{"value": bob}}},
+				{"op": "remove", "path": `members[value eq "` + alice + `"]`},
+			},
+		})
+		expectStatus(t, res, http.StatusOK)
+		if got := members(t); !slices.Equal(got, []string{bob}) {
+			t.Errorf("members = %v, want [%s]", got, bob)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		res := s.scimDo(http.MethodPut, path, gin.H{
+			"schemas":     []string{scim.GroupSchema},
+			"displayName": "Engineering",
+			"members":     []gin.H{{"value": bob}, {"value": unknown}},
+		})
+		expectStatus(t, res, http.StatusBadRequest)
+		expectSCIMType(t, res, "invalidValue")
+
+		req := s.scimRequest(http.MethodPut, path, gin.H{
+			"schemas":     []string{scim.GroupSchema},
+			"displayName": "Engineering",
+			"members":     []gin.H{{"value": alice}},
+		})
+		req.Header.Set("If-Match", scim.ETag(time.Unix(0, 0)))
+		expectStatus(t, s.serve(req), http.StatusPreconditionFailed)
+
+		res = s.scimDo(http.MethodPut, path, gin.H{
+			"schemas":     []string{scim.GroupSchema},
+			"displayName": "Platform",
+			"members":     []gin.H{{"value": alice}, {"value": bob}},
+		})
+		expectStatus(t, res, http.StatusOK)
+		if got := members(t); !slices.Equal(got, []string{alice, bob}) {
+			t.Errorf("members = %v, want [%s %s]", got, alice, bob)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		expectStatus(t, s.scimDo(http.MethodDelete, path, nil), http.StatusNoContent)
+		expectStatus(t, s.scimDo(http.MethodGet, path, nil), http.StatusNotFound)
+	})
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"log/slog"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const revokeSessionsLinkTTL = 7 * 24 * time.Hour
+
+// securityNotifications emails users about sensitive events on their account.
+// Every email carries a "this wasn't me" link that revokes all sessions.
+type securityNotifications struct {
+	mailService            *mail.Service
+	oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface
+}
+
+func newSecurityNotifications(mailService *mail.Service, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface) *securityNotifications {
+	return &securityNotifications{
+		mailService:            mailService,
+		oneTimeTokenRepository: oneTimeTokenRepository,
+	}
+}
+
+// notify sends the notification of the event to the user's email, unless the
+// user turned it off. Failures are logged and don't fail the request.
+func (n *securityNotifications) notify(c *gin.Context, user *models.User, event string) {
+	n.notifyAddress(c, user, user.Email, event)
+}
+
+func (n *securityNotifications) notifyAddress(c *gin.Context, user *models.User, email, event string) {
+	if !user.WantsNotification(event) {
+		return
+	}
+
+	if err := n.send(c, user, email, event); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to send security notification", "event", event, "user_id", user.ID.Hex(), "error", err)
+	}
+}
+
+func (n *securityNotifications) send(c *gin.Context, user *models.User, email, event string) error {
+	token, err := auth.RandomString(32)
+	if err != nil {
+		return err
+	}
+
+	oneTimeToken := models.NewOneTimeToken(user.ID, models.PurposeRevokeSessions, auth.HashToken(token), "", time.Now().Add(revokeSessionsLinkTTL))
+	if err := n.oneTimeTokenRepository.Create(c.Request.Context(), oneTimeToken); err != nil {
+		return err
+	}
+
+	return n.mailService.Send(c.Request.Context(), email, user.Locale, "security_notification", map[string]any{
+		"Username":   user.Username,
+		"Event":      event,
+		"Time":       time.Now().UTC().Format("2006-01-02 15:04 MST"),
+		"IPAddress":  c.ClientIP(),
+		"UserAgent":  c.Request.UserAgent(),
+		"RevokeLink": os.Getenv("APP_BASE_URL") + "/api/auth/sessions/revoke?token=" + url.QueryEscape(token),
+	})
+}
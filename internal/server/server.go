@@ -1,54 +1,168 @@
 package server
 
 import (
-	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/accounts"
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/lifecycle"
+	"authentication-jwt/internal/logging"
+	"authentication-jwt/internal/mail"
 	"authentication-jwt/internal/middlewares"
 	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/webhooks"
+	"context"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type Server struct {
-	userRepository         repositories.UserRepositoryInterface
-	refreshTokenRepository repositories.RefreshTokenRepositoryInterface
+// Dependencies are what the routes are built from. NewServer loads them from
+// the environment, tests inject in-memory ones.
+type Dependencies struct {
+	Authenticator             auth.Authenticator
+	UserRepository            repositories.UserRepositoryInterface
+	RefreshTokenRepository    repositories.RefreshTokenRepositoryInterface
+	SAMLRequestRepository     repositories.SAMLRequestRepositoryInterface
+	GroupRepository           repositories.GroupRepositoryInterface
+	OneTimeTokenRepository    repositories.OneTimeTokenRepositoryInterface
+	AuditLogRepository        repositories.AuditLogRepositoryInterface
+	WebhookRepository         repositories.WebhookRepositoryInterface
+	WebhookDeliveryRepository repositories.WebhookDeliveryRepositoryInterface
+	MailOutboxRepository      repositories.MailOutboxRepositoryInterface
+	EventOutboxRepository     repositories.EventOutboxRepositoryInterface
+	EventBus                  *events.Bus
+	MailService               *mail.Service
+	AccountPurger             *accounts.Purger
+	OIDCProviders             map[string]*auth.OIDCProvider
+	// SAMLServiceProvider is nil when SAML isn't configured.
+	SAMLServiceProvider *auth.SAMLServiceProvider
+	// Draining tells whether the server is shutting down, which fails the
+	// readiness probe.
+	Draining func() bool
+	// HealthChecks are the readiness checks of the dependencies, the signing
+	// keys being always checked.
+	HealthChecks []HealthCheck
 }
 
-func NewServer() http.Server {
+// NewServer builds the HTTP server. The background workers and the database
+// connection are registered with lc, which stops them on shutdown.
+func NewServer(lc *lifecycle.Lifecycle) *http.Server {
 	port := os.Getenv("PORT")
 
-	db := database.NewDatabase()
-	userRepository := repositories.NewUserRepository(db)
-	refreshTokenRepository := repositories.NewRefreshTokenRepository(db)
+	storage := openStorage(lc)
 
-	server := &Server{
-		userRepository:         userRepository,
-		refreshTokenRepository: refreshTokenRepository,
+	authenticator, err := auth.LoadAuthenticator(storage.userRepository)
+	if err != nil {
+		logging.Fatal("Failed to load authentication backends", "error", err)
 	}
 
-	return http.Server{
+	oidcProviders, err := auth.LoadOIDCProviders(context.Background())
+	if err != nil {
+		logging.Fatal("Failed to load OIDC providers", "error", err)
+	}
+
+	samlServiceProvider, err := auth.LoadSAMLServiceProvider()
+	if err != nil {
+		logging.Fatal("Failed to load SAML service provider", "error", err)
+	}
+
+	mailer, err := mail.LoadMailer()
+	if err != nil {
+		logging.Fatal("Failed to load mailer", "error", err)
+	}
+
+	// Emails are queued and delivered in the background, so a failing mail
+	// server doesn't fail the request.
+	outbox := mail.NewOutbox(mailer, storage.mailOutboxRepository)
+	lc.Go("mail outbox", outbox.Run)
+
+	dispatcher := webhooks.NewDispatcher(storage.webhookRepository, storage.webhookDeliveryRepository)
+	lc.Go("webhook dispatcher", dispatcher.Run)
+
+	// Domain events are stored in the same transaction as the change that
+	// produced them and handed to the subscribers in the background.
+	eventBus := events.NewBus(storage.transactor, storage.eventOutboxRepository)
+	eventBus.Subscribe("webhooks", dispatcher.HandleEvent)
+	lc.Go("event bus", eventBus.Run)
+
+	gracePeriod, err := accounts.LoadGracePeriod()
+	if err != nil {
+		logging.Fatal("Failed to load account deletion grace period", "error", err)
+	}
+
+	purger := accounts.NewPurger(storage.userRepository, storage.refreshTokenRepository, storage.oneTimeTokenRepository, storage.groupRepository, storage.auditLogRepository, storage.mailOutboxRepository, storage.eventOutboxRepository, storage.webhookDeliveryRepository, eventBus, gracePeriod)
+	lc.Go("account purger", purger.Run)
+
+	router := NewRouter(Dependencies{
+		Authenticator:             authenticator,
+		UserRepository:            storage.userRepository,
+		RefreshTokenRepository:    storage.refreshTokenRepository,
+		SAMLRequestRepository:     storage.samlRequestRepository,
+		GroupRepository:           storage.groupRepository,
+		OneTimeTokenRepository:    storage.oneTimeTokenRepository,
+		AuditLogRepository:        storage.auditLogRepository,
+		WebhookRepository:         storage.webhookRepository,
+		WebhookDeliveryRepository: storage.webhookDeliveryRepository,
+		MailOutboxRepository:      storage.mailOutboxRepository,
+		EventOutboxRepository:     storage.eventOutboxRepository,
+		EventBus:                  eventBus,
+		MailService:               mail.NewService(outbox, mail.NewTemplates()),
+		AccountPurger:             purger,
+		OIDCProviders:             oidcProviders,
+		SAMLServiceProvider:       samlServiceProvider,
+		Draining:                  lc.Draining,
+		HealthChecks:              []HealthCheck{storage.check},
+	})
+
+	return &http.Server{
 		Addr:         ":" + port,
-		Handler:      server.RegisterRoutes(),
+		Handler:      router,
 		ReadTimeout:  10 * time.Minute,
 		WriteTimeout: 10 * time.Minute,
 	}
 }
 
-func (s *Server) RegisterRoutes() http.Handler {
-	r := gin.Default()
+// NewRouter builds the routes of the API on the given dependencies.
+func NewRouter(deps Dependencies) http.Handler {
+	r := gin.New()
+
+	r.Use(gin.Recovery(), middlewares.RequestIDMiddleware(), middlewares.TracingMiddleware(), middlewares.LoggerMiddleware(), middlewares.MetricsMiddleware())
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", middlewares.RequestIDHeader, "traceparent", "tracestate", "baggage"},
+		ExposeHeaders:    []string{middlewares.RequestIDHeader},
 		AllowCredentials: true,
 	}))
 
-	authHandler := newAuthHandler(s.userRepository, s.refreshTokenRepository)
-	userHandler := newUserHandler(s.userRepository)
+	healthHandler := newHealthHandler(deps.Draining, append(deps.HealthChecks,
+		HealthCheck{Name: "signing_keys", Check: func(context.Context) error { return auth.CheckSigningKeys() }},
+	)...)
+
+	r.GET("/healthz", healthHandler.Liveness)
+
+	r.GET("/readyz", healthHandler.Readiness)
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	emailCodes := newEmailCodes(deps.MailService, deps.OneTimeTokenRepository)
+	notifications := newSecurityNotifications(deps.MailService, deps.OneTimeTokenRepository)
+	audit := newAuditLog(deps.AuditLogRepository)
+	sessions := newSessions(deps.UserRepository, deps.RefreshTokenRepository, notifications, audit, deps.EventBus)
+
+	authHandler := newAuthHandler(deps.Authenticator, emailCodes, notifications, deps.UserRepository, deps.OneTimeTokenRepository, sessions, audit, deps.EventBus)
+	userHandler := newUserHandler(deps.UserRepository, emailCodes, deps.OneTimeTokenRepository, deps.MailService, notifications, audit, deps.EventBus)
+	emailCodeHandler := newEmailCodeHandler(emailCodes, notifications, deps.UserRepository, sessions, audit)
+	accountHandler := newAccountHandler(deps.UserRepository, deps.RefreshTokenRepository, deps.OneTimeTokenRepository, deps.GroupRepository, deps.MailOutboxRepository, deps.EventOutboxRepository, deps.WebhookDeliveryRepository, deps.MailService, emailCodes, sessions, deps.AccountPurger, audit)
+	adminHandler := newAdminHandler(audit)
+	webhookHandler := newWebhookHandler(deps.WebhookRepository, deps.WebhookDeliveryRepository)
+	magicLinkHandler := newMagicLinkHandler(deps.MailService, emailCodes, deps.UserRepository, sessions, deps.OneTimeTokenRepository)
+	oidcHandler := newOIDCHandler(deps.OIDCProviders, deps.UserRepository, sessions)
 
 	authRoutes := r.Group("/api/auth")
 	{
@@ -59,12 +173,126 @@ func (s *Server) RegisterRoutes() http.Handler {
 		authRoutes.POST("/refresh", authHandler.Refresh)
 
 		authRoutes.POST("/logout", authHandler.Logout)
+
+		authRoutes.GET("/sessions/revoke", authHandler.RevokeSessions)
+
+		authRoutes.POST("/sessions/revoke", authHandler.RevokeSessions)
+
+		authRoutes.GET("/email-change/confirm", userHandler.ConfirmEmailChange)
+
+		authRoutes.POST("/email-change/confirm", userHandler.ConfirmEmailChange)
+
+		authRoutes.GET("/account/restore", accountHandler.RestoreAccount)
+
+		authRoutes.POST("/account/restore", accountHandler.RestoreAccount)
+
+		authRoutes.POST("/email-code", emailCodeHandler.RequestLoginCode)
+
+		authRoutes.POST("/email-code/verify", emailCodeHandler.VerifyLoginCode)
+
+		authRoutes.POST("/mfa/email/verify", emailCodeHandler.VerifyMFACode)
+
+		authRoutes.POST("/mfa/email/resend", emailCodeHandler.ResendMFACode)
+
+		authRoutes.POST("/magic-link", magicLinkHandler.Request)
+
+		authRoutes.GET("/magic-link/consume", magicLinkHandler.Consume)
+
+		authRoutes.POST("/magic-link/consume", magicLinkHandler.Consume)
+
+		authRoutes.GET("/oidc/:provider/login", oidcHandler.Login)
+
+		authRoutes.GET("/oidc/:provider/callback", oidcHandler.Callback)
+	}
+
+	if deps.SAMLServiceProvider != nil {
+		samlHandler := newSAMLHandler(deps.SAMLServiceProvider, deps.SAMLRequestRepository, deps.UserRepository, sessions)
+
+		samlRoutes := r.Group("/api/auth/saml")
+		{
+			samlRoutes.GET("/metadata", samlHandler.Metadata)
+
+			samlRoutes.GET("/login", samlHandler.Login)
+
+			samlRoutes.POST("/acs", samlHandler.AssertionConsumerService)
+		}
+	}
+
+	if token := os.Getenv("SCIM_BEARER_TOKEN"); token != "" {
+		scimHandler := newSCIMHandler(os.Getenv("SCIM_BASE_URL"), deps.UserRepository, deps.GroupRepository, deps.AccountPurger, deps.EventBus)
+
+		scimRoutes := r.Group("/scim/v2")
+		scimRoutes.Use(middlewares.ProvisioningMiddleware(token), audit.middleware("scim", "scim"))
+		{
+			scimRoutes.GET("/Users", scimHandler.ListUsers)
+			scimRoutes.POST("/Users", scimHandler.CreateUser)
+			scimRoutes.GET("/Users/:id", scimHandler.GetUser)
+			scimRoutes.PUT("/Users/:id", scimHandler.ReplaceUser)
+			scimRoutes.PATCH("/Users/:id", scimHandler.PatchUser)
+			scimRoutes.DELETE("/Users/:id", scimHandler.DeleteUser)
+
+			scimRoutes.GET("/Groups", scimHandler.ListGroups)
+			scimRoutes.POST("/Groups", scimHandler.CreateGroup)
+			scimRoutes.GET("/Groups/:id", scimHandler.GetGroup)
+			scimRoutes.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+			scimRoutes.PATCH("/Groups/:id", scimHandler.PatchGroup)
+			scimRoutes.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+		}
 	}
 
 	protectedRoutes := r.Group("/api")
-	protectedRoutes.Use(middlewares.AuthMiddleware(s.userRepository))
+	protectedRoutes.Use(middlewares.AuthMiddleware(deps.UserRepository))
 	{
 		protectedRoutes.GET("/user", userHandler.GetUser)
+
+		protectedRoutes.PATCH("/user", userHandler.UpdateUser)
+
+		protectedRoutes.DELETE("/user", accountHandler.DeleteAccount)
+
+		protectedRoutes.POST("/user/deletion/code", emailCodeHandler.SendDeletionCode)
+
+		protectedRoutes.GET("/user/export", accountHandler.Export)
+
+		protectedRoutes.PUT("/user/password", authHandler.ChangePassword)
+
+		protectedRoutes.POST("/user/reauthentication/code", emailCodeHandler.SendReauthenticationCode)
+
+		protectedRoutes.GET("/user/notifications", userHandler.GetNotifications)
+
+		protectedRoutes.PUT("/user/notifications", userHandler.UpdateNotifications)
+
+		protectedRoutes.POST("/user/email/verification", emailCodeHandler.SendVerificationCode)
+
+		protectedRoutes.POST("/user/email/verification/confirm", emailCodeHandler.ConfirmVerificationCode)
+
+		protectedRoutes.POST("/user/mfa/email", emailCodeHandler.EnableMFA)
+
+		protectedRoutes.DELETE("/user/mfa/email", emailCodeHandler.DisableMFA)
+
+		protectedRoutes.GET("/user/identities", userHandler.ListIdentities)
+
+		protectedRoutes.GET("/user/identities/:provider/link", oidcHandler.Link)
+
+		protectedRoutes.DELETE("/user/identities/:provider/:subject", userHandler.UnlinkIdentity)
+	}
+
+	adminRoutes := r.Group("/api/admin")
+	adminRoutes.Use(middlewares.AuthMiddleware(deps.UserRepository), middlewares.RequireRole("admin"))
+	{
+		adminRoutes.GET("/audit", adminHandler.ListAuditEvents)
+
+		webhookRoutes := adminRoutes.Group("/webhooks")
+		webhookRoutes.Use(audit.middleware("admin", ""))
+		{
+			webhookRoutes.GET("", webhookHandler.ListWebhooks)
+			webhookRoutes.POST("", webhookHandler.CreateWebhook)
+			webhookRoutes.GET("/:id", webhookHandler.GetWebhook)
+			webhookRoutes.PUT("/:id", webhookHandler.UpdateWebhook)
+			webhookRoutes.DELETE("/:id", webhookHandler.DeleteWebhook)
+
+			webhookRoutes.GET("/deliveries", webhookHandler.ListDeliveries)
+			webhookRoutes.POST("/deliveries/:id/redeliver", webhookHandler.Redeliver)
+		}
 	}
 
 	return r
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/metrics"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errAccountDisabled     = errors.New("account is disabled")
+	errAccountDeleted      = errors.New("account is scheduled for deletion")
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// sessions issues, rotates and revokes the access/refresh token pairs of the
+// users.
+type sessions struct {
+	userRepository         repositories.UserRepositoryInterface
+	refreshTokenRepository repositories.RefreshTokenRepositoryInterface
+	notifications          *securityNotifications
+	audit                  *auditLog
+	eventBus               *events.Bus
+}
+
+func newSessions(userRepository repositories.UserRepositoryInterface, refreshTokenRepository repositories.RefreshTokenRepositoryInterface, notifications *securityNotifications, audit *auditLog, eventBus *events.Bus) *sessions {
+	return &sessions{
+		userRepository:         userRepository,
+		refreshTokenRepository: refreshTokenRepository,
+		notifications:          notifications,
+		audit:                  audit,
+		eventBus:               eventBus,
+	}
+}
+
+// issue starts a session for a user who just logged in. Every login flow ends
+// here so that all of them produce the same cookies as Logon, are audited, and
+// notify the user of logins from devices not seen before.
+func (s *sessions) issue(c *gin.Context, user *models.User) error {
+	event := &models.AuditEvent{
+		Action:   models.AuditActionLogin,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+		Details:  map[string]string{"route": c.FullPath()},
+	}
+
+	loggedIn := models.NewDomainEvent(models.EventUserLoggedIn, user, map[string]string{"route": c.FullPath()})
+	err := s.create(c, user, loggedIn)
+	metrics.Logins.WithLabelValues(loginMethod(c), sessionOutcome(err)).Inc()
+	if err != nil {
+		event.Outcome = models.AuditOutcomeFailure
+		event.Details["reason"] = err.Error()
+		s.audit.record(c, event)
+		return err
+	}
+	s.audit.record(c, event)
+
+	// Only the devices are written: the user was read before logging in and
+	// may have changed since, e.g. by a password change.
+	isNewDevice, err := s.userRepository.SeeDevice(c.Request.Context(), user.ID, models.NewDevice(deviceID(c), c.Request.UserAgent(), c.ClientIP()))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to record device", "user_id", user.ID.Hex(), "error", err)
+	}
+
+	if isNewDevice {
+		s.notifications.notify(c, user, models.SecurityEventNewDeviceLogin)
+	}
+
+	return nil
+}
+
+// refresh exchanges a refresh token for a new token pair. Refresh tokens can
+// be used once: presenting one that was already exchanged means it leaked, so
+// every session of the user is revoked.
+// It returns the owner of the token whenever it is known, even on failure.
+func (s *sessions) refresh(c *gin.Context, refreshToken string) (*models.User, error) {
+	if _, _, err := auth.ValidateAccessToken(refreshToken, os.Getenv("JWT_SECRET_REFRESH")); err != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	refreshTokenModel, err := s.refreshTokenRepository.FindByToken(c.Request.Context(), refreshToken)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshTokenModel.IsExpired() {
+		return nil, errInvalidRefreshToken
+	}
+
+	user, err := s.userRepository.FindById(c.Request.Context(), refreshTokenModel.UserID.Hex())
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := false
+	if refreshTokenModel.RevokedAt == nil {
+		revoked, err = s.refreshTokenRepository.Revoke(c.Request.Context(), refreshTokenModel.ID)
+		if err != nil {
+			return user, err
+		}
+	}
+
+	if !revoked {
+		if err := s.revokeAll(c.Request.Context(), user, nil); err != nil {
+			return user, err
+		}
+		s.notifications.notify(c, user, models.SecurityEventRefreshTokenReuse)
+		return user, errRefreshTokenReused
+	}
+
+	return user, s.create(c, user)
+}
+
+// revoke ends the session of the refresh token, deleting it. It returns the
+// owner of the token, or nil if the token doesn't belong to a session.
+func (s *sessions) revoke(ctx context.Context, refreshToken string) (*models.User, error) {
+	refreshTokenModel, err := s.refreshTokenRepository.FindByToken(ctx, refreshToken)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepository.FindById(ctx, refreshTokenModel.UserID.Hex())
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.eventBus.Commit(ctx, func(ctx context.Context) error {
+		return s.refreshTokenRepository.Delete(ctx, refreshToken)
+	}, models.NewDomainEvent(models.EventSessionRevoked, user, map[string]string{"session_id": refreshTokenModel.ID.Hex()}))
+	if errors.Is(err, repositories.ErrNotFound) {
+		// Logged out concurrently.
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// revokeAll logs the user out everywhere: refresh tokens are deleted and
+// access tokens issued before now are rejected by the auth middleware. change,
+// if not nil, makes other changes to the user saved along, and the given
+// events are committed with them.
+func (s *sessions) revokeAll(ctx context.Context, user *models.User, change func(user *models.User), domainEvents ...*models.DomainEvent) error {
+	domainEvents = append(domainEvents, models.NewDomainEvent(models.EventSessionRevoked, user, nil))
+	return s.eventBus.Commit(ctx, func(ctx context.Context) error {
+		if err := s.refreshTokenRepository.DeleteByUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repositories.UpdateUser(ctx, s.userRepository, user, func(user *models.User) error {
+			now := time.Now()
+			user.LoggedOutAt = &now
+			if change != nil {
+				change(user)
+			}
+			return nil
+		})
+	}, domainEvents...)
+}
+
+// create generates the token pair, stores the refresh token and sets the
+// session cookies. The given events are committed with the refresh token.
+func (s *sessions) create(c *gin.Context, user *models.User, domainEvents ...*models.DomainEvent) error {
+	if user.Disabled {
+		return errAccountDisabled
+	}
+
+	if user.DeletedAt != nil {
+		return errAccountDeleted
+	}
+
+	accessToken, err := auth.GenerateAccessToken(user.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := auth.GenerateRefreshToken(user.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	refreshTokenModel := models.NewRefreshToken(refreshToken, user.ID, time.Now().Add(7*24*time.Hour))
+	err = s.eventBus.Commit(c.Request.Context(), func(ctx context.Context) error {
+		return s.refreshTokenRepository.Create(ctx, refreshTokenModel)
+	}, domainEvents...)
+	if err != nil {
+		return err
+	}
+
+	setSessionCookies(c, accessToken, refreshToken)
+	return nil
+}
+
+// deviceID identifies the client by its user agent. It is coarse, but enough
+// to tell the user about a login from a browser they haven't used before.
+func deviceID(c *gin.Context) string {
+	return auth.HashToken(c.Request.UserAgent())[:16]
+}
+
+// loginMethods names the login flows ending in issue by their route.
+var loginMethods = map[string]string{
+	"/api/auth/logon":                   "password",
+	"/api/auth/email-code/verify":       "email_code",
+	"/api/auth/mfa/email/verify":        "mfa_email",
+	"/api/auth/magic-link/consume":      "magic_link",
+	"/api/auth/oidc/:provider/callback": "oidc",
+	"/api/auth/saml/acs":                "saml",
+}
+
+func loginMethod(c *gin.Context) string {
+	if method, ok := loginMethods[c.FullPath()]; ok {
+		return method
+	}
+	return "other"
+}
+
+// sessionOutcome is the metrics outcome of a session error.
+func sessionOutcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.Is(err, errAccountDisabled):
+		return "disabled"
+	case errors.Is(err, errAccountDeleted):
+		return "deleted"
+	case errors.Is(err, errInvalidRefreshToken):
+		return "invalid"
+	case errors.Is(err, errRefreshTokenReused):
+		return "reused"
+	default:
+		return "error"
+	}
+}
+
+func abortWithSessionError(c *gin.Context, err error) {
+	if errors.Is(err, errAccountDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	if errors.Is(err, errAccountDeleted) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is scheduled for deletion"})
+		return
+	}
+
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+}
+
+// refreshTokenPath scopes the refresh token cookie to the routes reading it,
+// /api/auth/refresh and /api/auth/logout.
+const refreshTokenPath = "/api/auth"
+
+func setSessionCookies(c *gin.Context, accessToken, refreshToken string) {
+	c.SetCookie(
+		"access_token",
+		accessToken,
+		15*60, // 15 minutes
+		"/",
+		"localhost", // domain
+		false,       // secure
+		true,        // httpOnly
+	)
+
+	c.SetCookie(
+		"refresh_token",
+		refreshToken,
+		7*24*60*60, // 7 days
+		refreshTokenPath,
+		"localhost", // domain
+		false,       // secure
+		true,        // httpOnly
+	)
+	clearLegacyRefreshTokenCookie(c)
+}
+
+func clearSessionCookies(c *gin.Context) {
+	c.SetCookie("access_token", "", -1, "/", "localhost", false, true)
+	c.SetCookie("refresh_token", "", -1, refreshTokenPath, "localhost", false, true)
+	clearLegacyRefreshTokenCookie(c)
+}
+
+// clearLegacyRefreshTokenCookie removes the refresh token cookie set before it
+// was sent to logout. Browsers send the cookie of the longer path first, so an
+// old token left there would be refreshed as a reused one.
+func clearLegacyRefreshTokenCookie(c *gin.Context) {
+	c.SetCookie("refresh_token", "", -1, "/api/auth/refresh", "localhost", false, true)
+}
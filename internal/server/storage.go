@@ -0,0 +1,98 @@
+package server
+
+import (
+	"authentication-jwt/internal/database"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/lifecycle"
+	"authentication-jwt/internal/logging"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/repositories/sqlstore"
+	"context"
+	"os"
+	"time"
+)
+
+// storage holds the repositories of the backend chosen with DATABASE_DRIVER:
+// MongoDB (the default), PostgreSQL or an embedded SQLite file.
+type storage struct {
+	userRepository            repositories.UserRepositoryInterface
+	refreshTokenRepository    repositories.RefreshTokenRepositoryInterface
+	samlRequestRepository     repositories.SAMLRequestRepositoryInterface
+	groupRepository           repositories.GroupRepositoryInterface
+	oneTimeTokenRepository    repositories.OneTimeTokenRepositoryInterface
+	mailOutboxRepository      repositories.MailOutboxRepositoryInterface
+	auditLogRepository        repositories.AuditLogRepositoryInterface
+	webhookRepository         repositories.WebhookRepositoryInterface
+	webhookDeliveryRepository repositories.WebhookDeliveryRepositoryInterface
+	eventOutboxRepository     repositories.EventOutboxRepositoryInterface
+	// transactor runs the transactions of the event bus.
+	transactor events.Transactor
+	// check is the readiness check of the database.
+	check HealthCheck
+}
+
+// openStorage connects to the database, applies the pending migrations and
+// registers its disconnection with lc.
+func openStorage(lc *lifecycle.Lifecycle) *storage {
+	driver := os.Getenv("DATABASE_DRIVER")
+	switch driver {
+	case "", "mongodb":
+		db := database.NewDatabase()
+		lc.OnStop("mongodb", db.Disconnect)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		if err := db.CheckTransactions(ctx); err != nil {
+			logging.Fatal("MongoDB can't run transactions", "error", err)
+		}
+
+		// Index builds and backfills can take a while on large collections;
+		// they can be run ahead of a deploy with the migrate command.
+		if err := repositories.Migrate(ctx, db); err != nil {
+			logging.Fatal("Failed to migrate the database", "error", err)
+		}
+
+		return &storage{
+			userRepository:            repositories.NewUserRepository(db),
+			refreshTokenRepository:    repositories.NewRefreshTokenRepository(db),
+			samlRequestRepository:     repositories.NewSAMLRequestRepository(db),
+			groupRepository:           repositories.NewGroupRepository(db),
+			oneTimeTokenRepository:    repositories.NewOneTimeTokenRepository(db),
+			mailOutboxRepository:      repositories.NewMailOutboxRepository(db),
+			auditLogRepository:        repositories.NewAuditLogRepository(db),
+			webhookRepository:         repositories.NewWebhookRepository(db),
+			webhookDeliveryRepository: repositories.NewWebhookDeliveryRepository(db),
+			eventOutboxRepository:     repositories.NewEventOutboxRepository(db),
+			transactor:                db,
+			check:                     HealthCheck{Name: "mongodb", Check: db.Ping},
+		}
+	case string(sqlstore.Postgres), string(sqlstore.SQLite):
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		store, err := sqlstore.Open(ctx, sqlstore.Dialect(driver), os.Getenv("SQL_DATABASE_URL"))
+		if err != nil {
+			logging.Fatal("Failed to open the SQL database", "driver", driver, "error", err)
+		}
+		lc.OnStop(driver, store.Close)
+
+		return &storage{
+			userRepository:            sqlstore.NewUserRepository(store),
+			refreshTokenRepository:    sqlstore.NewRefreshTokenRepository(store),
+			samlRequestRepository:     sqlstore.NewSAMLRequestRepository(store),
+			groupRepository:           sqlstore.NewGroupRepository(store),
+			oneTimeTokenRepository:    sqlstore.NewOneTimeTokenRepository(store),
+			mailOutboxRepository:      sqlstore.NewMailOutboxRepository(store),
+			auditLogRepository:        sqlstore.NewAuditLogRepository(store),
+			webhookRepository:         sqlstore.NewWebhookRepository(store),
+			webhookDeliveryRepository: sqlstore.NewWebhookDeliveryRepository(store),
+			eventOutboxRepository:     sqlstore.NewEventOutboxRepository(store),
+			transactor:                store,
+			check:                     HealthCheck{Name: driver, Check: store.Ping},
+		}
+	default:
+		logging.Fatal("Unknown database driver", "driver", driver)
+		return nil
+	}
+}
@@ -1,19 +1,46 @@
 package server
 
 import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/events"
+	"authentication-jwt/internal/mail"
+	"authentication-jwt/internal/models"
 	"authentication-jwt/internal/repositories"
+	"context"
+	"errors"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const emailChangeTTL = 24 * time.Hour
+
+var errEmailChangeCancelled = errors.New("email change cancelled")
+
 type UserHandler struct {
-	userRepository repositories.UserRepositoryInterface
+	userRepository         repositories.UserRepositoryInterface
+	emailCodes             *emailCodes
+	oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface
+	mailService            *mail.Service
+	notifications          *securityNotifications
+	audit                  *auditLog
+	eventBus               *events.Bus
 }
 
-func newUserHandler(userRepository repositories.UserRepositoryInterface) *UserHandler {
+func newUserHandler(userRepository repositories.UserRepositoryInterface, emailCodes *emailCodes, oneTimeTokenRepository repositories.OneTimeTokenRepositoryInterface, mailService *mail.Service, notifications *securityNotifications, audit *auditLog, eventBus *events.Bus) *UserHandler {
 	return &UserHandler{
-		userRepository: userRepository,
+		userRepository:         userRepository,
+		emailCodes:             emailCodes,
+		oneTimeTokenRepository: oneTimeTokenRepository,
+		mailService:            mailService,
+		notifications:          notifications,
+		audit:                  audit,
+		eventBus:               eventBus,
 	}
 }
 
@@ -26,17 +53,355 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 	}
 
 	user, err := h.userRepository.FindById(c, userID.(string))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user": user.ToResponse(),
+	})
+}
+
+// UpdateUser changes the username and profile of the logged in user. Fields
+// missing from the request are left alone. A new email doesn't take effect
+// right away: it waits in pending_email until confirmed from the link sent to
+// it, and the current address is told of the request. Asking for the current
+// email again cancels a pending change.
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	var req struct {
+		Username        *string `json:"username" binding:"omitnil,min=6"`
+		GivenName       *string `json:"given_name" binding:"omitnil,max=100"`
+		FamilyName      *string `json:"family_name" binding:"omitnil,max=100"`
+		Locale          *string `json:"locale"`
+		Email           *string `json:"email" binding:"omitnil,email"`
+		CurrentPassword string  `json:"current_password"`
+		Code            string  `json:"code" binding:"omitempty,len=6,numeric"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	changed := []string{}
+	if req.Username != nil && *req.Username != user.Username {
+		if err := models.CheckUsername(*req.Username); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		user.Username = *req.Username
+		changed = append(changed, "username")
+	}
+
+	if req.GivenName != nil {
+		user.GivenName = strings.TrimSpace(*req.GivenName)
+		changed = append(changed, "given_name")
+	}
+
+	if req.FamilyName != nil {
+		user.FamilyName = strings.TrimSpace(*req.FamilyName)
+		changed = append(changed, "family_name")
+	}
+
+	if req.Locale != nil {
+		user.Locale = mail.MatchLocale(*req.Locale)
+		changed = append(changed, "locale")
+	}
+
+	emailChangeRequested := false
+	if req.Email != nil {
+		email := models.NormalizeEmail(*req.Email)
+		if strings.EqualFold(email, user.Email) {
+			user.PendingEmail = ""
+		} else {
+			// Moving the account to another address takes it over, so the
+			// user authenticates again as for ChangePassword.
+			err := h.emailCodes.reauthenticate(c.Request.Context(), user, req.CurrentPassword, req.Code, models.PurposeReauthenticationCode)
+			if errors.Is(err, errReauthenticationFailed) {
+				h.audit.record(c, &models.AuditEvent{
+					Action:   models.AuditActionEmailChange,
+					Outcome:  models.AuditOutcomeFailure,
+					ActorID:  user.ID.Hex(),
+					TargetID: user.ID.Hex(),
+					Details:  map[string]string{"reason": "incorrect current password or code"},
+				})
+				abortWithReauthenticationError(c, user)
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+				return
+			}
+
+			_, err = h.userRepository.FindByEmail(c.Request.Context(), email)
+			if err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+				return
+			}
+
+			if !errors.Is(err, repositories.ErrNotFound) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+				return
+			}
+
+			user.PendingEmail = email
+			emailChangeRequested = true
+		}
+		changed = append(changed, "pending_email")
+	}
+
+	if err := h.userRepository.Update(c.Request.Context(), user); err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to update user")
+		return
+	}
+
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionProfileUpdate,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+		Details:  map[string]string{"fields": strings.Join(changed, ",")},
+	})
+
+	message := "Profile updated successfully"
+	if emailChangeRequested {
+		if err := h.sendEmailChangeLink(c.Request.Context(), user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
+			return
+		}
+
+		h.notifications.notify(c, user, models.SecurityEventEmailChangeRequested)
+		message = "Profile updated. Confirm the new email from the link sent to it"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"user":    user.ToResponse(),
+	})
+}
+
+// sendEmailChangeLink sends the confirmation link to the pending email. Links
+// of earlier requests stop working.
+func (h *UserHandler) sendEmailChangeLink(ctx context.Context, user *models.User) error {
+	if err := h.oneTimeTokenRepository.DeleteByUser(ctx, user.ID, models.PurposeEmailChange); err != nil {
+		return err
+	}
+
+	token, err := auth.RandomString(32)
+	if err != nil {
+		return err
+	}
+
+	oneTimeToken := models.NewOneTimeToken(user.ID, models.PurposeEmailChange, auth.HashToken(token), "", time.Now().Add(emailChangeTTL))
+	if err := h.oneTimeTokenRepository.Create(ctx, oneTimeToken); err != nil {
+		return err
+	}
+
+	return h.mailService.Send(ctx, user.PendingEmail, user.Locale, "email_change", map[string]any{
+		"Username":       user.Username,
+		"Link":           os.Getenv("APP_BASE_URL") + "/api/auth/email-change/confirm?token=" + url.QueryEscape(token),
+		"ExpiresInHours": int(emailChangeTTL.Hours()),
+	})
+}
+
+// ConfirmEmailChange handles the link sent to the new address: the pending
+// email becomes the email of the account, verified since the link reached it.
+// The previous address is notified.
+func (h *UserHandler) ConfirmEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if c.Request.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	oneTimeToken, err := h.oneTimeTokenRepository.Consume(c.Request.Context(), models.PurposeEmailChange, auth.HashToken(token), "")
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && oneTimeToken.IsExpired()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve link"})
+		return
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), oneTimeToken.UserID.Hex())
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
 		return
 	}
 
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found on database"})
+	// The change was cancelled since the link was sent.
+	if user.PendingEmail == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	previousEmail, pendingEmail := user.Email, user.PendingEmail
+	changed := *user
+	changed.Email = pendingEmail
+
+	emailChanged := models.NewDomainEvent(models.EventEmailChanged, &changed, map[string]string{"previous_email": previousEmail})
+	err = h.eventBus.Commit(c.Request.Context(), func(ctx context.Context) error {
+		return repositories.UpdateUser(ctx, h.userRepository, user, func(user *models.User) error {
+			if user.PendingEmail != pendingEmail {
+				return errEmailChangeCancelled
+			}
+			user.Email = user.PendingEmail
+			user.PendingEmail = ""
+			user.EmailVerified = true
+			return nil
+		})
+	}, emailChanged)
+	if errors.Is(err, errEmailChangeCancelled) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired link"})
+		return
+	}
+
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to change email")
 		return
 	}
 
+	h.audit.record(c, &models.AuditEvent{
+		Action:   models.AuditActionEmailChange,
+		Outcome:  models.AuditOutcomeSuccess,
+		ActorID:  user.ID.Hex(),
+		TargetID: user.ID.Hex(),
+		Details:  map[string]string{"previous_email": previousEmail},
+	})
+
+	h.notifications.notifyAddress(c, user, previousEmail, models.SecurityEventEmailChanged)
+
 	c.JSON(http.StatusOK, gin.H{
-		"user": user.ToResponse(),
+		"message": "Email changed successfully",
+	})
+}
+
+func (h *UserHandler) ListIdentities(c *gin.Context) {
+	user, err := h.userRepository.FindById(c, c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"has_password": user.Password != "",
+		"identities":   user.ToResponse().Identities,
+	})
+}
+
+func (h *UserHandler) UnlinkIdentity(c *gin.Context) {
+	user, err := h.userRepository.FindById(c, c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	provider, subject := c.Param("provider"), c.Param("subject")
+	if !user.HasIdentity(provider, subject) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not linked"})
+		return
+	}
+
+	if err := user.UnlinkIdentity(provider, subject); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userRepository.Update(c, user); err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to unlink identity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Identity unlinked successfully",
+		"user":    user.ToResponse(),
+	})
+}
+
+func (h *UserHandler) GetNotifications(c *gin.Context) {
+	user, err := h.userRepository.FindById(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": user.NotificationSettings(),
+	})
+}
+
+// UpdateNotifications turns security notifications on or off per event.
+// Events missing from the request keep their setting.
+func (h *UserHandler) UpdateNotifications(c *gin.Context) {
+	var req struct {
+		Notifications map[string]bool `json:"notifications" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for event, enabled := range req.Notifications {
+		if !slices.Contains(models.SecurityEvents, event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event: " + event})
+			return
+		}
+
+		if !enabled && slices.Contains(models.MandatorySecurityEvents, event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Notifications of " + event + " can't be turned off"})
+			return
+		}
+	}
+
+	user, err := h.userRepository.FindById(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to retrieve user")
+		return
+	}
+
+	err = repositories.UpdateUser(c.Request.Context(), h.userRepository, user, func(user *models.User) error {
+		if user.Notifications == nil {
+			user.Notifications = map[string]bool{}
+		}
+		for event, enabled := range req.Notifications {
+			user.Notifications[event] = enabled
+		}
+		return nil
+	})
+	if err != nil {
+		abortWithRepositoryError(c, err, "User", "Failed to update user")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": user.NotificationSettings(),
 	})
 }
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"authentication-jwt/internal/auth"
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type WebhookHandler struct {
+	webhookRepository  repositories.WebhookRepositoryInterface
+	deliveryRepository repositories.WebhookDeliveryRepositoryInterface
+}
+
+func newWebhookHandler(webhookRepository repositories.WebhookRepositoryInterface, deliveryRepository repositories.WebhookDeliveryRepositoryInterface) *WebhookHandler {
+	return &WebhookHandler{
+		webhookRepository:  webhookRepository,
+		deliveryRepository: deliveryRepository,
+	}
+}
+
+type webhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required"`
+	Active      *bool    `json:"active"`
+}
+
+// CreateWebhook registers a webhook. The signing secret is only returned here.
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := auth.RandomString(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	webhook, err := models.NewWebhook(req.URL, req.Description, "whsec_"+secret, req.Events)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+
+	if err := h.webhookRepository.Create(c.Request.Context(), webhook); err != nil {
+		abortWithRepositoryError(c, err, "Webhook", "Failed to create webhook")
+		return
+	}
+
+	c.Set("auditTargetID", webhook.ID.Hex())
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	webhooks, err := h.webhookRepository.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook})
+}
+
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	webhook.URL = req.URL
+	webhook.Description = req.Description
+	webhook.Events = req.Events
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+
+	if err := webhook.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.webhookRepository.Update(c.Request.Context(), webhook); err != nil {
+		abortWithRepositoryError(c, err, "Webhook", "Failed to update webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": webhook})
+}
+
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	if err := h.webhookRepository.Delete(c.Request.Context(), webhook.ID.Hex()); err != nil {
+		abortWithRepositoryError(c, err, "Webhook", "Failed to delete webhook")
+		return
+	}
+
+	if err := h.deliveryRepository.DeleteByWebhook(c.Request.Context(), webhook.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook deliveries"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries lists the deliveries, newest first. status=dead lists the
+// dead letters.
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	filter := repositories.WebhookDeliveryFilter{
+		Status: c.Query("status"),
+	}
+
+	if webhookID := c.Query("webhook_id"); webhookID != "" {
+		id, err := bson.ObjectIDFromHex(webhookID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+			return
+		}
+		filter.WebhookID = id
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a positive number"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	deliveries, total, err := h.deliveryRepository.List(c.Request.Context(), filter, offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"total":      total,
+	})
+}
+
+// Redeliver queues a delivery again, typically a dead letter once the
+// receiving endpoint is fixed.
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	delivery, err := h.deliveryRepository.FindById(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "Delivery", "Failed to retrieve delivery")
+		return
+	}
+
+	if err := h.deliveryRepository.Redeliver(c.Request.Context(), delivery.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeliver"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Delivery queued"})
+}
+
+func (h *WebhookHandler) findWebhook(c *gin.Context) (*models.Webhook, bool) {
+	webhook, err := h.webhookRepository.FindById(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		abortWithRepositoryError(c, err, "Webhook", "Failed to retrieve webhook")
+		return nil, false
+	}
+
+	return webhook, true
+}
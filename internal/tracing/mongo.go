@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CommandMonitor traces the MongoDB commands sent by the repositories as
+// client spans named after the command and the collection. Filters and
+// documents are never recorded.
+func CommandMonitor() *event.CommandMonitor {
+	var spans sync.Map
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			attributes := []attribute.KeyValue{
+				semconv.DBSystemNameMongoDB,
+				semconv.DBNamespace(e.DatabaseName),
+				semconv.DBOperationName(e.CommandName),
+			}
+
+			name := e.CommandName
+			if element, err := e.Command.IndexErr(0); err == nil && element.Key() == e.CommandName {
+				if collection, ok := element.Value().StringValueOK(); ok {
+					name += " " + collection
+					attributes = append(attributes, semconv.DBCollectionName(collection))
+				}
+			}
+
+			_, span := otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+			spans.Store(e.RequestID, span)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			if span, ok := spans.LoadAndDelete(e.RequestID); ok {
+				span.(trace.Span).End()
+			}
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			if span, ok := spans.LoadAndDelete(e.RequestID); ok {
+				// The failure message may quote the document, so only the
+				// command is recorded.
+				Fail(span.(trace.Span), "mongodb."+e.CommandName)
+				span.(trace.Span).End()
+			}
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	dialTimeout    = 5 * time.Second
+)
+
+// errForbiddenAddress is returned when a webhook resolves to an address of
+// the internal network.
+var errForbiddenAddress = errors.New("webhook address is not public")
+
+// nonPublicPrefixes are the ranges that aren't reachable on the internet, on
+// top of the ones publicAddress checks with the netip methods.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, and broadcast
+	netip.MustParsePrefix("fec0::/10"),      // Deprecated site-local
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+}
+
+var (
+	nat64     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour = netip.MustParsePrefix("2002::/16")
+)
+
+// publicAddress tells whether a webhook may be delivered to the address: it
+// must not be loopback, private, link-local or otherwise internal, so that
+// webhooks can't reach the services next to the server, as the cloud
+// metadata endpoint. IPv6 addresses embedding an IPv4 one are checked by it.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	bytes := addr.As16()
+	switch {
+	case nat64.Contains(addr):
+		return publicAddress(netip.AddrFrom4([4]byte(bytes[12:16])))
+	case sixToFour.Contains(addr):
+		return publicAddress(netip.AddrFrom4([4]byte(bytes[2:6])))
+	}
+	return true
+}
+
+// newClient returns the client posting to the webhooks. The address is
+// checked once resolved, right before connecting, so a host name can't be
+// pointed at an internal address after the check. Redirects aren't followed,
+// the response to the first request is the outcome of the delivery.
+func newClient(allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allowed(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errForbiddenAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	// No proxy: the check would only see the address of the proxy.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{
+		Timeout:   requestTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
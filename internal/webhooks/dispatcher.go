@@ -0,0 +1,203 @@
+// Package webhooks delivers authentication events to the external endpoints
+// registered by the administrators.
+package webhooks
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories"
+	"authentication-jwt/internal/retry"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+var deliveryPolicy = retry.Policy{
+	PollInterval: 5 * time.Second,
+	Lease:        2 * time.Minute,
+	MaxAttempts:  10,
+	BaseBackoff:  30 * time.Second,
+	MaxBackoff:   6 * time.Hour,
+}
+
+// Payload is the JSON body posted to the webhooks.
+type Payload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Dispatcher queues events for the subscribed webhooks and, with Run, delivers
+// them. Deliveries are retried with exponential backoff and moved to the dead
+// letters once out of attempts.
+type Dispatcher struct {
+	webhookRepository  repositories.WebhookRepositoryInterface
+	deliveryRepository repositories.WebhookDeliveryRepositoryInterface
+	client             *http.Client
+	policy             retry.Policy
+}
+
+func NewDispatcher(webhookRepository repositories.WebhookRepositoryInterface, deliveryRepository repositories.WebhookDeliveryRepositoryInterface) *Dispatcher {
+	return &Dispatcher{
+		webhookRepository:  webhookRepository,
+		deliveryRepository: deliveryRepository,
+		client:             newClient(publicAddress),
+		policy:             deliveryPolicy,
+	}
+}
+
+// webhookEvents maps the domain events to the events sent to webhooks.
+var webhookEvents = map[string]string{
+	models.EventUserRegistered:  models.WebhookEventUserCreated,
+	models.EventUserLoggedIn:    models.WebhookEventUserLogin,
+	models.EventUserDeleted:     models.WebhookEventUserDeleted,
+	models.EventPasswordChanged: models.WebhookEventUserPasswordChanged,
+	models.EventEmailChanged:    models.WebhookEventUserEmailChanged,
+	models.EventSessionRevoked:  models.WebhookEventSessionsRevoked,
+}
+
+// HandleEvent is the event bus subscriber queueing a delivery of the event for
+// every webhook subscribed to it. Deliveries are keyed by event, so handling
+// an event twice doesn't deliver it twice.
+func (d *Dispatcher) HandleEvent(ctx context.Context, event *models.DomainEvent) error {
+	webhookEvent, ok := webhookEvents[event.Type]
+	if !ok {
+		return nil
+	}
+
+	webhooks, err := d.webhookRepository.FindSubscribed(ctx, webhookEvent)
+	if err != nil {
+		return err
+	}
+
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	data := map[string]string{"user_id": event.UserID.Hex()}
+	for key, value := range event.Data {
+		data[key] = value
+	}
+
+	payload, err := json.Marshal(Payload{
+		ID:        event.ID.Hex(),
+		Event:     webhookEvent,
+		CreatedAt: event.OccurredAt.UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		delivery := models.NewWebhookDelivery(webhook.ID, event.UserID, event.ID.Hex(), webhookEvent, string(payload))
+		if err := d.deliveryRepository.Create(ctx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run delivers due events until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	retry.Run(ctx, d.policy, "webhook delivery", d.deliveryRepository.ClaimDue, d.deliver)
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	retry.Drain(ctx, d.policy, "webhook delivery", d.deliveryRepository.ClaimDue, d.deliver)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	webhook, err := d.webhookRepository.FindById(ctx, delivery.WebhookID.Hex())
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to retrieve webhook", "webhook_id", delivery.WebhookID.Hex(), "error", err)
+		return
+	}
+
+	if err != nil || !webhook.Active {
+		d.fail(ctx, delivery, 0, "webhook removed or disabled", true)
+		return
+	}
+
+	statusCode, err := d.post(ctx, webhook, delivery)
+	if err == nil {
+		if err := d.deliveryRepository.MarkDelivered(ctx, delivery.ID, statusCode); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark webhook delivery as delivered", "delivery_id", delivery.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	d.fail(ctx, delivery, statusCode, err.Error(), d.policy.Exhausted(delivery.Attempts))
+}
+
+func (d *Dispatcher) fail(ctx context.Context, delivery *models.WebhookDelivery, statusCode int, reason string, dead bool) {
+	if dead {
+		slog.WarnContext(ctx, "Moving webhook delivery to dead letters", "delivery_id", delivery.ID.Hex(), "reason", reason)
+		if err := d.deliveryRepository.MarkDead(ctx, delivery.ID, statusCode, reason); err != nil {
+			slog.ErrorContext(ctx, "Failed to mark webhook delivery as dead", "delivery_id", delivery.ID.Hex(), "error", err)
+		}
+		return
+	}
+
+	if err := d.deliveryRepository.MarkRetry(ctx, delivery.ID, d.policy.NextAttempt(delivery.Attempts), statusCode, reason); err != nil {
+		slog.ErrorContext(ctx, "Failed to reschedule webhook delivery", "delivery_id", delivery.ID.Hex(), "error", err)
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "authentication-jwt-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID.Hex())
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header of a payload: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret. Receivers should
+// recompute it and reject timestamps more than a few minutes old, so a
+// captured request can't be replayed.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
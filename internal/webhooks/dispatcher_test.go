@@ -0,0 +1,132 @@
+package webhooks
+
+import (
+	"authentication-jwt/internal/models"
+	"authentication-jwt/internal/repositories/memory"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPublicAddress(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.215.14":          true,
+		"2606:4700::6810:84e5":   true,
+		"::ffff:93.184.215.14":   true,
+		"64:ff9b::5db8:d70e":     true,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"0.0.0.0":                false,
+		"::":                     false,
+		"10.1.2.3":               false,
+		"172.16.0.1":             false,
+		"192.168.1.1":            false,
+		"100.64.0.1":             false,
+		"fd00:ec2::254":          false,
+		"169.254.169.254":        false,
+		"fe80::1%eth0":           false,
+		"224.0.0.1":              false,
+		"255.255.255.255":        false,
+		"::ffff:169.254.169.254": false,
+		"64:ff9b::a9fe:a9fe":     false,
+		"2002:7f00:1::1":         false,
+	} {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := publicAddress(addr); got != public {
+			t.Errorf("publicAddress(%s) = %t, want %t", address, got, public)
+		}
+	}
+}
+
+// newTestDelivery stores a webhook posting to the URL and a delivery due for
+// it.
+func newTestDelivery(t *testing.T, d *Dispatcher, url string) *models.WebhookDelivery {
+	t.Helper()
+	webhook, err := models.NewWebhook(url, "test", "secret", []string{models.WebhookEventUserLogin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.webhookRepository.Create(context.Background(), webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := models.NewWebhookDelivery(webhook.ID, bson.NewObjectID(), bson.NewObjectID().Hex(), models.WebhookEventUserLogin, `{}`)
+	if err := d.deliveryRepository.Create(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	var posted atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/hook", http.StatusTemporaryRedirect)
+		case "/hook":
+			posted.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	newDispatcher := func() *Dispatcher {
+		return NewDispatcher(memory.NewWebhookRepository(), memory.NewWebhookDeliveryRepository())
+	}
+	expectDelivery := func(t *testing.T, d *Dispatcher, delivery *models.WebhookDelivery, status string, statusCode int, lastError string) {
+		t.Helper()
+		found, err := d.deliveryRepository.FindById(ctx, delivery.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Status != status || found.LastStatusCode != statusCode || !strings.Contains(found.LastError, lastError) {
+			t.Errorf("delivery = %s, %d, %q, want %s, %d, %q", found.Status, found.LastStatusCode, found.LastError, status, statusCode, lastError)
+		}
+	}
+
+	t.Run("Public", func(t *testing.T) {
+		d := newDispatcher()
+		d.client = newClient(func(netip.Addr) bool { return true })
+		delivery := newTestDelivery(t, d, server.URL+"/hook")
+
+		d.deliverDue(ctx)
+		expectDelivery(t, d, delivery, models.WebhookDeliveryDelivered, http.StatusNoContent, "")
+	})
+
+	t.Run("InternalAddress", func(t *testing.T) {
+		before := posted.Load()
+		d := newDispatcher()
+		loopback := newTestDelivery(t, d, server.URL+"/hook")
+		localhost := newTestDelivery(t, d, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/hook")
+
+		d.deliverDue(ctx)
+		expectDelivery(t, d, loopback, models.WebhookDeliveryPending, 0, errForbiddenAddress.Error())
+		expectDelivery(t, d, localhost, models.WebhookDeliveryPending, 0, errForbiddenAddress.Error())
+		if posted.Load() != before {
+			t.Error("webhook posted to a loopback address")
+		}
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		before := posted.Load()
+		d := newDispatcher()
+		d.client = newClient(func(netip.Addr) bool { return true })
+		delivery := newTestDelivery(t, d, server.URL+"/redirect")
+
+		d.deliverDue(ctx)
+		expectDelivery(t, d, delivery, models.WebhookDeliveryPending, http.StatusTemporaryRedirect, "307")
+		if posted.Load() != before {
+			t.Error("webhook followed the redirect")
+		}
+	})
+}